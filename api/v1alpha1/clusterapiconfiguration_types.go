@@ -0,0 +1,197 @@
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ClusterAPIConfigurationName is the name of the singleton ClusterAPIConfiguration read by
+// the operator, the others are ignored.
+const ClusterAPIConfigurationName = "cluster"
+
+// LogLevel is the log verbosity of the provider managers.
+// +kubebuilder:validation:Enum=Normal;Debug;Trace;TraceAll
+type LogLevel string
+
+const (
+	Normal   LogLevel = "Normal"
+	Debug    LogLevel = "Debug"
+	Trace    LogLevel = "Trace"
+	TraceAll LogLevel = "TraceAll"
+)
+
+// ClusterAPIConfigurationSpec is the configuration the operator applies to the providers it
+// installs, changes are reconciled without redeploying the operator.
+type ClusterAPIConfigurationSpec struct {
+	// LogLevel is the log verbosity of the provider managers, Normal by default.
+	// +optional
+	LogLevel LogLevel `json:"logLevel,omitempty"`
+
+	// EnabledProviders limits the providers installed to these ones, by "<type>-<name>", e.g.
+	// "core-cluster-api" or "infrastructure-aws". All the providers of the platform are
+	// installed when empty.
+	// +optional
+	EnabledProviders []string `json:"enabledProviders,omitempty"`
+
+	// DisabledProviders excludes these providers, by "<type>-<name>", from the ones installed,
+	// e.g. "infrastructure-aws" to only run the core provider. The providers disabled once
+	// installed are removed.
+	// +optional
+	DisabledProviders []string `json:"disabledProviders,omitempty"`
+
+	// AdditionalInfrastructureProviders are infrastructure providers installed besides the one
+	// of the platform, by name, e.g. "metal3" for a hybrid fleet.
+	// +optional
+	AdditionalInfrastructureProviders []string `json:"additionalInfrastructureProviders,omitempty"`
+
+	// ResourceOverrides set the resources of the containers of the providers, over the
+	// resources of their components.
+	// +optional
+	ResourceOverrides []ResourceOverride `json:"resourceOverrides,omitempty"`
+
+	// SchedulingOverrides add node selectors, tolerations and affinity to the pods of the
+	// providers, over the ones of their components, e.g. on clusters with tainted nodes.
+	// +optional
+	SchedulingOverrides []SchedulingOverride `json:"schedulingOverrides,omitempty"`
+
+	// ContainerOverrides set arguments and environment variables of the containers of the
+	// providers, e.g. for tuning or debugging. Only the allowed ones are set, the others are
+	// ignored.
+	// +optional
+	ContainerOverrides []ContainerOverride `json:"containerOverrides,omitempty"`
+
+	// Paused stops the operator from changing the providers, e.g. during a maintenance. The
+	// status of the ClusterOperator is still reported.
+	// +optional
+	Paused bool `json:"paused,omitempty"`
+
+	// PausedProviders stops the operator from changing these providers, by "<type>-<name>",
+	// e.g. for debugging or an emergency patch. A provider is also paused by the
+	// capi.openshift.io/paused: "true" annotation on its CR. The operator isn't Upgradeable
+	// while a provider is paused.
+	// +optional
+	PausedProviders []string `json:"pausedProviders,omitempty"`
+
+	// Uninstall removes cluster api from the cluster, as disabling the feature gate does.
+	// +optional
+	Uninstall bool `json:"uninstall,omitempty"`
+
+	// RemoveCRDsOnUninstall also removes the cluster api CRDs on uninstall, only once there is
+	// no Machine and no Cluster left but the one of the OpenShift cluster itself.
+	// +optional
+	RemoveCRDsOnUninstall bool `json:"removeCRDsOnUninstall,omitempty"`
+}
+
+// ResourceOverride sets the resources of a container of a provider.
+type ResourceOverride struct {
+	// Provider is the provider, by "<type>-<name>", e.g. "infrastructure-aws".
+	Provider string `json:"provider"`
+
+	// Container is the name of the container, the manager container by default.
+	// +optional
+	Container string `json:"container,omitempty"`
+
+	// Resources are the requests and limits to set, the ones of the components of the
+	// provider not set are kept.
+	Resources corev1.ResourceRequirements `json:"resources"`
+}
+
+// SchedulingOverride adds scheduling constraints to the pods of a provider.
+type SchedulingOverride struct {
+	// Provider is the provider, by "<type>-<name>", e.g. "infrastructure-aws".
+	Provider string `json:"provider"`
+
+	// NodeSelector labels are added to the node selector of the pods, replacing the ones of
+	// the same key.
+	// +optional
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+
+	// Tolerations are added to the tolerations of the pods.
+	// +optional
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+
+	// Affinity replaces the node affinity, pod affinity or pod anti-affinity of the pods, for
+	// the ones it sets.
+	// +optional
+	Affinity *corev1.Affinity `json:"affinity,omitempty"`
+}
+
+// ContainerOverride sets arguments and environment variables of a container of a provider.
+type ContainerOverride struct {
+	// Provider is the provider, by "<type>-<name>", e.g. "infrastructure-aws".
+	Provider string `json:"provider"`
+
+	// Container is the name of the container, the manager container by default.
+	// +optional
+	Container string `json:"container,omitempty"`
+
+	// Args set the arguments of the container, by flag, e.g. "--sync-period": "5m". The
+	// allowed ones are --sync-period, --service-endpoints and the --<kind>-concurrency ones.
+	// +optional
+	Args map[string]string `json:"args,omitempty"`
+
+	// Env sets the environment variables of the container, by name. The allowed ones are the
+	// proxy ones, GOMAXPROCS and the ones prefixed with AWS_, AZURE_, GCP_ or OS_.
+	// +optional
+	Env map[string]string `json:"env,omitempty"`
+}
+
+// ClusterAPIConfigurationStatus is the status of the configuration.
+type ClusterAPIConfigurationStatus struct {
+	// ObservedGeneration is the generation of the spec last applied by the operator.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// InstalledProviders are the providers of the platform installed, by "<type>-<name>".
+	// +optional
+	InstalledProviders []string `json:"installedProviders,omitempty"`
+
+	// DisabledProviders are the providers of the platform the configuration disables, by
+	// "<type>-<name>".
+	// +optional
+	DisabledProviders []string `json:"disabledProviders,omitempty"`
+
+	// Providers are the conditions of the providers installed.
+	// +optional
+	// +listType=map
+	// +listMapKey=name
+	Providers []ProviderStatus `json:"providers,omitempty"`
+}
+
+// ProviderStatus is the status of a provider installed.
+type ProviderStatus struct {
+	// Name is the provider, by "<type>-<name>".
+	Name string `json:"name"`
+
+	// Conditions are the Available and Progressing conditions of the provider.
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:subresource:status
+
+// ClusterAPIConfiguration is the configuration of the Cluster CAPI Operator.
+type ClusterAPIConfiguration struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ClusterAPIConfigurationSpec   `json:"spec,omitempty"`
+	Status ClusterAPIConfigurationStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// ClusterAPIConfigurationList contains a list of ClusterAPIConfiguration.
+type ClusterAPIConfigurationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ClusterAPIConfiguration `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ClusterAPIConfiguration{}, &ClusterAPIConfigurationList{})
+}
@@ -2,34 +2,137 @@ package assets
 
 import (
 	"embed"
+	"fmt"
+	"io/fs"
+	"os"
 	"path"
+	"path/filepath"
+	"strings"
 
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/util/yaml"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-//go:embed capi-operator/*.yaml providers/*.yaml
-var fs embed.FS
+//go:embed capi-operator/*.yaml providers/*.yaml monitoring/*.yaml
+var embedded embed.FS
 
+// FromDir decodes the assets of dir embedded in the operator.
 func FromDir(dir string, scheme *runtime.Scheme) ([]client.Object, error) {
-	assetNames, err := fs.ReadDir(dir)
+	return FromFS(embedded, dir, scheme)
+}
+
+// FromFS decodes the yaml assets of dir in fsys, one object per file.
+func FromFS(fsys fs.FS, dir string, scheme *runtime.Scheme) ([]client.Object, error) {
+	assetNames, err := fs.ReadDir(fsys, dir)
 	if err != nil {
 		return nil, err
 	}
 
 	objs := []client.Object{}
 	for _, assetName := range assetNames {
-		b, err := fs.ReadFile(path.Join(dir, assetName.Name()))
+		if assetName.IsDir() || !strings.HasSuffix(assetName.Name(), ".yaml") {
+			continue
+		}
+		obj, err := decode(fsys, path.Join(dir, assetName.Name()), scheme)
 		if err != nil {
 			return nil, err
 		}
-		codecs := serializer.NewCodecFactory(scheme)
-		obj, _, err := codecs.UniversalDeserializer().Decode(b, nil, nil)
+		objs = append(objs, obj)
+	}
+	return objs, nil
+}
+
+// UnstructuredFromDir decodes the assets of dir embedded in the operator as unstructured
+// objects, for the kinds the scheme doesn't have, e.g. the ones of the monitoring stack.
+func UnstructuredFromDir(dir string) ([]client.Object, error) {
+	assetNames, err := fs.ReadDir(embedded, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	objs := []client.Object{}
+	for _, assetName := range assetNames {
+		if assetName.IsDir() || !strings.HasSuffix(assetName.Name(), ".yaml") {
+			continue
+		}
+		name := path.Join(dir, assetName.Name())
+		b, err := fs.ReadFile(embedded, name)
 		if err != nil {
 			return nil, err
 		}
-		objs = append(objs, obj.(client.Object))
+		obj := &unstructured.Unstructured{}
+		if err := yaml.Unmarshal(b, &obj.Object); err != nil {
+			return nil, fmt.Errorf("invalid asset %s: %v", name, err)
+		}
+		objs = append(objs, obj)
 	}
 	return objs, nil
 }
+
+// Providers returns the provider assets. The ones extracted at runtime from the provider
+// images of the release payload to extractedDir replace the embedded ones of the same file
+// name, so that the components are versioned with the images they deploy. The embedded
+// assets are used alone when extractedDir is empty or doesn't exist.
+func Providers(extractedDir string, scheme *runtime.Scheme) ([]client.Object, error) {
+	var extracted fs.FS
+	if extractedDir != "" {
+		if _, err := os.Stat(extractedDir); err == nil {
+			extracted = os.DirFS(filepath.Clean(extractedDir))
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return mergeProviders(embedded, "providers", extracted, scheme)
+}
+
+// mergeProviders decodes the providers of dir in base, the files of the root of override
+// taking precedence over the ones of the same name.
+func mergeProviders(base fs.FS, dir string, override fs.FS, scheme *runtime.Scheme) ([]client.Object, error) {
+	if override == nil {
+		return FromFS(base, dir, scheme)
+	}
+	overridden, err := fs.Glob(override, "*.yaml")
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]bool{}
+	for _, name := range overridden {
+		names[name] = true
+	}
+
+	objs, err := FromFS(override, ".", scheme)
+	if err != nil {
+		return nil, err
+	}
+	baseNames, err := fs.ReadDir(base, dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, assetName := range baseNames {
+		if names[assetName.Name()] || !strings.HasSuffix(assetName.Name(), ".yaml") {
+			continue
+		}
+		obj, err := decode(base, path.Join(dir, assetName.Name()), scheme)
+		if err != nil {
+			return nil, err
+		}
+		objs = append(objs, obj)
+	}
+	return objs, nil
+}
+
+func decode(fsys fs.FS, name string, scheme *runtime.Scheme) (client.Object, error) {
+	b, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	codecs := serializer.NewCodecFactory(scheme)
+	obj, _, err := codecs.UniversalDeserializer().Decode(b, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid asset %s: %v", name, err)
+	}
+	return obj.(client.Object), nil
+}
@@ -0,0 +1,87 @@
+package assets
+
+import (
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+)
+
+func TestMergeProviders(t *testing.T) {
+	configMap := func(name, components string) *fstest.MapFile {
+		return &fstest.MapFile{Data: []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: ` + name + `
+data:
+  components: ` + components + `
+`)}
+	}
+	base := fstest.MapFS{
+		"providers/core-cluster-api.yaml":    configMap("cluster-api", "embedded"),
+		"providers/infrastructure-aws.yaml":  configMap("aws", "embedded"),
+		"providers/README.md":                &fstest.MapFile{Data: []byte("not an asset")},
+		"capi-operator/operator-config.yaml": configMap("operator", "embedded"),
+	}
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		override fs.FS
+		want     map[string]string
+	}{
+		{
+			name: "embedded only",
+			want: map[string]string{"cluster-api": "embedded", "aws": "embedded"},
+		},
+		{
+			name:     "nothing extracted",
+			override: fstest.MapFS{},
+			want:     map[string]string{"cluster-api": "embedded", "aws": "embedded"},
+		},
+		{
+			name: "extracted replace the embedded of the same file",
+			override: fstest.MapFS{
+				"infrastructure-aws.yaml":   configMap("aws", "extracted"),
+				"infrastructure-azure.yaml": configMap("azure", "extracted"),
+			},
+			want: map[string]string{"cluster-api": "embedded", "aws": "extracted", "azure": "extracted"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objs, err := mergeProviders(base, "providers", tt.override, scheme)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := map[string]string{}
+			for _, obj := range objs {
+				got[obj.GetName()] = obj.(*corev1.ConfigMap).Data["components"]
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestUnstructuredFromDir(t *testing.T) {
+	objs, err := UnstructuredFromDir("monitoring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds := []string{}
+	for _, obj := range objs {
+		kinds = append(kinds, obj.GetObjectKind().GroupVersionKind().Kind+" "+obj.GetName())
+	}
+	if diff := cmp.Diff([]string{"PrometheusRule cluster-capi-operator"}, kinds); diff != "" {
+		t.Error(diff)
+	}
+}
@@ -6,23 +6,31 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/spf13/pflag"
+	corev1 "k8s.io/api/core/v1"
 	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/component-base/config"
 	"k8s.io/component-base/config/options"
 	"k8s.io/klog/klogr"
 	"k8s.io/klog/v2"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
 	operatorv1 "sigs.k8s.io/cluster-api/exp/operator/api/v1alpha1"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
 	configv1 "github.com/openshift/api/config/v1"
+	capiv1alpha1 "github.com/openshift/cluster-capi-operator/api/v1alpha1"
 	"github.com/openshift/cluster-capi-operator/pkg/controllers"
+	"github.com/openshift/cluster-capi-operator/pkg/metrics"
 	"github.com/openshift/cluster-capi-operator/pkg/util"
 )
 
@@ -43,6 +51,7 @@ const (
 	defaultImagesLocation         = "/etc/cluster-api-config/images.json"
 	releaseVersionEnvVariableName = "RELEASE_VERSION"
 	unknownVersionValue           = "unknown"
+	relatedImageEnvPrefix         = "RELATED_IMAGE_"
 )
 
 func init() {
@@ -50,6 +59,8 @@ func init() {
 	utilruntime.Must(configv1.AddToScheme(scheme))
 	utilruntime.Must(apiextensionsv1.AddToScheme(scheme))
 	utilruntime.Must(operatorv1.AddToScheme(scheme))
+	utilruntime.Must(clusterv1.AddToScheme(scheme))
+	utilruntime.Must(capiv1alpha1.AddToScheme(scheme))
 
 	// +kubebuilder:scaffold:scheme
 }
@@ -63,6 +74,16 @@ func main() {
 		":8080",
 		"Address for hosting metrics",
 	)
+	metricsCertFile := flag.String(
+		"metrics-tls-cert-file",
+		"",
+		"The certificate to serve the metrics with TLS, only to the clients authorized to get /metrics. The metrics are served in plain HTTP without.",
+	)
+	metricsKeyFile := flag.String(
+		"metrics-tls-private-key-file",
+		"",
+		"The private key of the metrics certificate.",
+	)
 
 	healthAddr := flag.String(
 		"health-addr",
@@ -81,6 +102,12 @@ func main() {
 		"The location of images file to use by operator for managed CAPI binaries.",
 	)
 
+	providerAssetsDir := flag.String(
+		"provider-assets-dir",
+		"",
+		"The directory where the provider assets are extracted from the payload images, replacing the embedded ones.",
+	)
+
 	// Once all the flags are regitered, switch to pflag
 	// to allow leader lection flags to be bound
 	pflag.CommandLine.AddGoFlagSet(flag.CommandLine)
@@ -89,12 +116,18 @@ func main() {
 
 	ctrl.SetLogger(klogr.New().WithName("ClusterAPIOperator"))
 
+	// the metrics served with TLS are served by the operator, not the manager
+	managerMetricsAddr := *metricsAddr
+	if *metricsCertFile != "" {
+		managerMetricsAddr = "0"
+	}
+
 	syncPeriod := 10 * time.Minute
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Namespace:               *managedNamespace,
 		Scheme:                  scheme,
 		SyncPeriod:              &syncPeriod,
-		MetricsBindAddress:      *metricsAddr,
+		MetricsBindAddress:      managerMetricsAddr,
 		Port:                    9443,
 		HealthProbeBindAddress:  *healthAddr,
 		LeaderElectionNamespace: leaderElectionConfig.ResourceNamespace,
@@ -109,30 +142,88 @@ func main() {
 		os.Exit(1)
 	}
 
-	jsonData, err := ioutil.ReadFile(filepath.Clean(*imagesFile))
+	if *metricsCertFile != "" {
+		if err := mgr.Add(&metrics.Server{
+			BindAddress: *metricsAddr,
+			CertFile:    *metricsCertFile,
+			KeyFile:     *metricsKeyFile,
+			KubeClient:  kubernetes.NewForConfigOrDie(mgr.GetConfig()),
+		}); err != nil {
+			setupLog.Error(err, "unable to set up the metrics server")
+			os.Exit(1)
+		}
+	}
+
+	containerImages, err := readImages(*imagesFile)
 	if err != nil {
-		setupLog.Error(err, "unable to read file", "name", *imagesFile)
+		setupLog.Error(err, "unable to read image names from file", "name", *imagesFile)
 		os.Exit(1)
 	}
-	containerImages := map[string]string{}
-	if err := json.Unmarshal(jsonData, &containerImages); err != nil {
-		setupLog.Error(err, "unable to unmarshal image names from file", "name", *imagesFile)
+	setImagesFromEnv(containerImages, os.Environ())
+
+	if err = (&controllers.ClusterOperatorReconciler{
+		Client:            mgr.GetClient(),
+		Scheme:            mgr.GetScheme(),
+		Recorder:          mgr.GetEventRecorderFor("cluster-capi-operator"),
+		ReleaseVersion:    getReleaseVersion(),
+		ManagedNamespace:  *managedNamespace,
+		Images:            containerImages,
+		ProviderAssetsDir: *providerAssetsDir,
+		APIReader:         mgr.GetAPIReader(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ClusterOperator")
 		os.Exit(1)
 	}
 
-	if err = (&controllers.ClusterOperatorReconciler{
+	if err = (&controllers.ClusterReconciler{
 		Client:           mgr.GetClient(),
+		APIReader:        mgr.GetAPIReader(),
 		Scheme:           mgr.GetScheme(),
 		Recorder:         mgr.GetEventRecorderFor("cluster-capi-operator"),
-		ReleaseVersion:   getReleaseVersion(),
 		ManagedNamespace: *managedNamespace,
-		Images:           containerImages,
 	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "ClusterOperator")
+		setupLog.Error(err, "unable to create controller", "controller", "Cluster")
+		os.Exit(1)
+	}
+
+	if err = (&controllers.KubeconfigReconciler{
+		Client:           mgr.GetClient(),
+		KubeClient:       kubernetes.NewForConfigOrDie(mgr.GetConfig()),
+		Recorder:         mgr.GetEventRecorderFor("cluster-capi-operator"),
+		ManagedNamespace: *managedNamespace,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Kubeconfig")
+		os.Exit(1)
+	}
+
+	if err = (&controllers.StorageVersionMigrationReconciler{
+		Client:    mgr.GetClient(),
+		APIReader: mgr.GetAPIReader(),
+		Recorder:  mgr.GetEventRecorderFor("cluster-capi-operator"),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "StorageVersionMigration")
+		os.Exit(1)
+	}
+
+	if err = (&controllers.WebhookCABundleReconciler{
+		Client:           mgr.GetClient(),
+		APIReader:        mgr.GetAPIReader(),
+		Recorder:         mgr.GetEventRecorderFor("cluster-capi-operator"),
+		ManagedNamespace: *managedNamespace,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "WebhookCABundle")
 		os.Exit(1)
 	}
 	// +kubebuilder:scaffold:builder
 
+	mgr.GetWebhookServer().Register(controllers.DeletionProtectionPath, &webhook.Admission{
+		Handler: &controllers.DeletionProtectionWebhook{
+			Client:           mgr.GetClient(),
+			APIReader:        mgr.GetAPIReader(),
+			ManagedNamespace: *managedNamespace,
+		},
+	})
+
 	if err := mgr.AddHealthzCheck("health", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
 		os.Exit(1)
@@ -157,3 +248,36 @@ func getReleaseVersion() string {
 	}
 	return releaseVersion
 }
+
+// readImages reads the images.json mounted from the images ConfigMap or, when running
+// locally, the images ConfigMap manifest itself.
+func readImages(fileName string) (map[string]string, error) {
+	jsonData, err := ioutil.ReadFile(filepath.Clean(fileName))
+	if err != nil {
+		return nil, err
+	}
+	cm := &corev1.ConfigMap{}
+	if err := utilyaml.Unmarshal(jsonData, cm); err == nil && cm.Kind == "ConfigMap" {
+		jsonData = []byte(cm.Data["images.json"])
+	}
+	containerImages := map[string]string{}
+	if err := json.Unmarshal(jsonData, &containerImages); err != nil {
+		return nil, err
+	}
+	return containerImages, nil
+}
+
+// setImagesFromEnv sets the images of the RELATED_IMAGE_... environment variables, they take
+// precedence over the ones of the images file.
+func setImagesFromEnv(containerImages map[string]string, environ []string) {
+	for _, env := range environ {
+		name, image := env, ""
+		if i := strings.Index(env, "="); i >= 0 {
+			name, image = env[:i], env[i+1:]
+		}
+		if !strings.HasPrefix(name, relatedImageEnvPrefix) || image == "" {
+			continue
+		}
+		containerImages[name] = image
+	}
+}
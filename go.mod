@@ -7,6 +7,7 @@ require (
 	github.com/google/go-cmp v0.5.6
 	github.com/openshift/api v0.0.0-20210831091943-07e756545ac1
 	github.com/openshift/library-go v0.0.0-20210914071953-94a0fd1d5849
+	github.com/prometheus/client_golang v1.11.0
 	github.com/spf13/pflag v1.0.5
 	k8s.io/api v0.22.2
 	k8s.io/apiextensions-apiserver v0.22.2
@@ -19,6 +20,7 @@ require (
 	sigs.k8s.io/cluster-api v0.4.3 // indirect
 	sigs.k8s.io/cluster-api/exp/operator v0.0.0-00010101000000-000000000000
 	sigs.k8s.io/controller-runtime v0.10.1
+	sigs.k8s.io/yaml v1.2.0
 )
 
 replace (
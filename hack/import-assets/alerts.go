@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func (p *provider) prometheusRuleManifestFile() string {
+	return path.Join(manifestsPath, strings.ToLower("0000_90_cluster-api_"+p.providerTypeName()+"-"+p.assetName()+"_prometheusrule.yaml"))
+}
+
+// writePrometheusRules writes the baseline alerts of the Deployments of the provider: the
+// controller down, from kube-state-metrics, and for the Deployments behind a scraped Service
+// the lost leader election and the high rate of reconcile errors, from the controller-runtime
+// metrics. The manifest of a previous import is removed when there is no Deployment.
+func (p *provider) writePrometheusRules(objs []unstructured.Unstructured, scraped []unstructured.Unstructured) error {
+	rules := []interface{}{}
+	for _, obj := range objs {
+		if obj.GetKind() != "Deployment" {
+			continue
+		}
+		dep := &appsv1.Deployment{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, dep); err != nil {
+			return err
+		}
+		rules = append(rules, controllerDownAlert(dep))
+		for _, obj := range scraped {
+			service, err := toService(obj)
+			if err != nil {
+				return err
+			}
+			if !selectsPods(service, dep.Spec.Template.Labels) {
+				continue
+			}
+			rules = append(rules, leaderElectionAlert(dep, service.Name), reconcileErrorsAlert(dep, service.Name))
+		}
+	}
+
+	if len(rules) == 0 {
+		if _, err := out.readFile(p.prometheusRuleManifestFile()); os.IsNotExist(err) {
+			return nil
+		}
+		return out.remove(p.prometheusRuleManifestFile())
+	}
+
+	name := "cluster-api-" + p.providerTypeName() + "-" + p.assetName()
+	rule := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "monitoring.coreos.com/v1",
+		"kind":       "PrometheusRule",
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": *targetNamespace,
+		},
+		"spec": map[string]interface{}{
+			"groups": []interface{}{
+				map[string]interface{}{
+					"name":  name,
+					"rules": rules,
+				},
+			},
+		},
+	}}
+	setOpenShiftAnnotations(*rule, false)
+	p.setProvenance(*rule)
+	b, err := canonicalYAML(rule)
+	if err != nil {
+		return err
+	}
+	return out.writeFile(p.prometheusRuleManifestFile(), b)
+}
+
+func controllerDownAlert(dep *appsv1.Deployment) map[string]interface{} {
+	return alert("ClusterAPIControllerDown", "critical", "10m",
+		fmt.Sprintf(`kube_deployment_status_replicas_available{namespace=%q,deployment=%q} == 0`, *targetNamespace, dep.Name),
+		fmt.Sprintf("The %s controller is down", dep.Name),
+		fmt.Sprintf("No pod of the %s Deployment in %s has been available for 10 minutes, the machines it manages are not reconciled.", dep.Name, *targetNamespace))
+}
+
+func leaderElectionAlert(dep *appsv1.Deployment, job string) map[string]interface{} {
+	return alert("ClusterAPIControllerLeaderElectionLost", "warning", "5m",
+		fmt.Sprintf(`max(leader_election_master_status{namespace=%q,job=%q}) == 0`, *targetNamespace, job),
+		fmt.Sprintf("The %s controller has no leader", dep.Name),
+		fmt.Sprintf("No pod of the %s Deployment in %s has held the leader election lease for 5 minutes, nothing is reconciled.", dep.Name, *targetNamespace))
+}
+
+func reconcileErrorsAlert(dep *appsv1.Deployment, job string) map[string]interface{} {
+	return alert("ClusterAPIControllerReconcileErrors", "warning", "15m",
+		fmt.Sprintf(`sum by (controller) (rate(controller_runtime_reconcile_errors_total{namespace=%q,job=%q}[15m])) / sum by (controller) (rate(controller_runtime_reconcile_total{namespace=%q,job=%q}[15m])) > 0.1`,
+			*targetNamespace, job, *targetNamespace, job),
+		fmt.Sprintf("The %s controller fails to reconcile", dep.Name),
+		fmt.Sprintf("More than 10%% of the reconciles of the {{ $labels.controller }} controller of the %s Deployment in %s failed for 15 minutes, check its logs.", dep.Name, *targetNamespace))
+}
+
+func alert(name, severity, duration, expr, summary, description string) map[string]interface{} {
+	return map[string]interface{}{
+		"alert": name,
+		"expr":  expr,
+		"for":   duration,
+		"labels": map[string]interface{}{
+			"severity": severity,
+		},
+		"annotations": map[string]interface{}{
+			"summary":     summary,
+			"description": description,
+		},
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	clusterctlv1 "sigs.k8s.io/cluster-api/cmd/clusterctl/api/v1alpha3"
+	utilyaml "sigs.k8s.io/cluster-api/util/yaml"
+)
+
+const alertsTestComponents = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: capa-controller-manager
+spec:
+  template:
+    metadata:
+      labels:
+        control-plane: capa-controller-manager
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: capa-eks-manager
+spec:
+  template:
+    metadata:
+      labels:
+        control-plane: capa-eks-manager
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: capa-metrics-service
+spec:
+  selector:
+    control-plane: capa-controller-manager
+  ports:
+  - name: https
+    port: 8443
+`
+
+func TestWritePrometheusRules(t *testing.T) {
+	testPaths(t)
+	p := &provider{name: "aws", ptype: clusterctlv1.InfrastructureProviderType, version: "v1.1.0"}
+	tests := []struct {
+		name       string
+		components string
+		scraped    bool
+		previous   bool
+		want       []string
+	}{
+		{
+			name:       "controllers down",
+			components: alertsTestComponents,
+			want:       []string{"ClusterAPIControllerDown capa-controller-manager", "ClusterAPIControllerDown capa-eks-manager"},
+		},
+		{
+			name:       "scraped",
+			components: alertsTestComponents,
+			scraped:    true,
+			want: []string{
+				"ClusterAPIControllerDown capa-controller-manager",
+				"ClusterAPIControllerLeaderElectionLost capa-controller-manager",
+				"ClusterAPIControllerReconcileErrors capa-controller-manager",
+				"ClusterAPIControllerDown capa-eks-manager",
+			},
+		},
+		{
+			name:       "previous one removed",
+			components: "apiVersion: v1\nkind: Namespace\nmetadata:\n  name: capa-system\n",
+			previous:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := map[string][]byte{}
+			if tt.previous {
+				files[p.prometheusRuleManifestFile()] = []byte("previous")
+			}
+			testOutput(t, files)
+			objs := testObjects(t, tt.components)
+			scraped := []unstructured.Unstructured{}
+			if tt.scraped {
+				scraped = objs[2:]
+			}
+			if err := p.writePrometheusRules(objs, scraped); err != nil {
+				t.Fatal(err)
+			}
+			b, exists := out.files[p.prometheusRuleManifestFile()]
+			if exists := exists && b != nil; exists != (tt.want != nil) {
+				t.Fatalf("%s written %v, want %v", p.prometheusRuleManifestFile(), exists, tt.want != nil)
+			}
+			if tt.want == nil {
+				return
+			}
+
+			rules, err := utilyaml.ToUnstructured(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if name := rules[0].GetName(); name != "cluster-api-infrastructure-aws" {
+				t.Errorf("PrometheusRule %s, want cluster-api-infrastructure-aws", name)
+			}
+			groups, _, _ := unstructured.NestedSlice(rules[0].Object, "spec", "groups")
+			got := []string{}
+			for _, r := range groups[0].(map[string]interface{})["rules"].([]interface{}) {
+				r := r.(map[string]interface{})
+				summary := r["annotations"].(map[string]interface{})["summary"].(string)
+				got = append(got, r["alert"].(string)+" "+strings.Fields(summary)[1])
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("alerts %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReconcileErrorsAlert(t *testing.T) {
+	dep := testDeployment(t, testObjects(t, alertsTestComponents)[0])
+	want := `sum by (controller) (rate(controller_runtime_reconcile_errors_total{namespace="openshift-cluster-api",job="capa-metrics-service"}[15m])) / ` +
+		`sum by (controller) (rate(controller_runtime_reconcile_total{namespace="openshift-cluster-api",job="capa-metrics-service"}[15m])) > 0.1`
+	if got := reconcileErrorsAlert(dep, "capa-metrics-service")["expr"]; got != want {
+		t.Errorf("expr %q, want %q", got, want)
+	}
+}
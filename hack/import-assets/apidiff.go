@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// crdAPI is the API of a CRD the API diff compares between the provider versions.
+type crdAPI struct {
+	kind string
+	// versions are the served versions with the paths of their required fields
+	versions map[string]map[string]bool
+}
+
+func newCRDAPI(obj unstructured.Unstructured) (crdAPI, error) {
+	crd := &apiextensionsv1.CustomResourceDefinition{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, crd); err != nil {
+		return crdAPI{}, err
+	}
+	api := crdAPI{kind: crd.Spec.Names.Kind, versions: map[string]map[string]bool{}}
+	for _, v := range crd.Spec.Versions {
+		if !v.Served {
+			continue
+		}
+		required := map[string]bool{}
+		if v.Schema != nil && v.Schema.OpenAPIV3Schema != nil {
+			requiredFields(v.Schema.OpenAPIV3Schema, "", required)
+		}
+		api.versions[v.Name] = required
+	}
+	return api, nil
+}
+
+// requiredFields adds the paths of the required fields of the schema, e.g. ".spec.region".
+// The fields of optional objects are included, they are required once the object is set.
+func requiredFields(schema *apiextensionsv1.JSONSchemaProps, prefix string, required map[string]bool) {
+	for _, name := range schema.Required {
+		required[prefix+"."+name] = true
+	}
+	for name, prop := range schema.Properties {
+		prop := prop
+		requiredFields(&prop, prefix+"."+name, required)
+	}
+	if schema.Items != nil && schema.Items.Schema != nil {
+		requiredFields(schema.Items.Schema, prefix+"[]", required)
+	}
+}
+
+// apiChanges returns the changes of the APIs of the provider CRDs, by CRD name: the kinds and
+// served versions added and removed and, for the versions in both, the fields that became
+// required or optional. A removed version or kind and a new required field break the clients.
+func apiChanges(before, after map[string]crdAPI) []string {
+	names := map[string]bool{}
+	for name := range before {
+		names[name] = true
+	}
+	for name := range after {
+		names[name] = true
+	}
+	sortedNames := []string{}
+	for name := range names {
+		sortedNames = append(sortedNames, name)
+	}
+	sort.Strings(sortedNames)
+
+	changes := []string{}
+	for _, name := range sortedNames {
+		old, hadOld := before[name]
+		api, hasNew := after[name]
+		switch {
+		case !hadOld:
+			changes = append(changes, fmt.Sprintf("new kind %s (%s)", api.kind, name))
+			continue
+		case !hasNew:
+			changes = append(changes, fmt.Sprintf("removed kind %s (%s)", old.kind, name))
+			continue
+		}
+
+		for _, version := range sortedSetKeys(old.versions, api.versions) {
+			oldRequired, hadVersion := old.versions[version]
+			required, hasVersion := api.versions[version]
+			switch {
+			case !hadVersion:
+				changes = append(changes, fmt.Sprintf("%s: new version %s", api.kind, version))
+				continue
+			case !hasVersion:
+				changes = append(changes, fmt.Sprintf("%s: removed version %s", api.kind, version))
+				continue
+			}
+			added, removed := setDelta(oldRequired, required)
+			for _, field := range added {
+				changes = append(changes, fmt.Sprintf("%s %s: new required field %s", api.kind, version, field))
+			}
+			for _, field := range removed {
+				changes = append(changes, fmt.Sprintf("%s %s: field %s is no longer required", api.kind, version, field))
+			}
+		}
+	}
+	return changes
+}
+
+func sortedSetKeys(a, b map[string]map[string]bool) []string {
+	keys := map[string]string{}
+	for k := range a {
+		keys[k] = k
+	}
+	for k := range b {
+		keys[k] = k
+	}
+	return sortedKeys(keys)
+}
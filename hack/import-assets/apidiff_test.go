@@ -0,0 +1,111 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewCRDAPI(t *testing.T) {
+	objs := testObjects(t, `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: awsmachines.infrastructure.cluster.x-k8s.io
+spec:
+  names:
+    kind: AWSMachine
+  versions:
+  - name: v1alpha4
+    served: false
+    schema:
+      openAPIV3Schema:
+        required:
+        - spec
+  - name: v1beta1
+    served: true
+    schema:
+      openAPIV3Schema:
+        properties:
+          spec:
+            required:
+            - instanceType
+            properties:
+              additionalSecurityGroups:
+                items:
+                  required:
+                  - id
+              cloudInit:
+                required:
+                - secureSecretsBackend
+  - name: v1beta2
+    served: true
+`)
+	got, err := newCRDAPI(objs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := crdAPI{
+		kind: "AWSMachine",
+		versions: map[string]map[string]bool{
+			"v1beta1": {
+				".spec.instanceType":                   true,
+				".spec.additionalSecurityGroups[].id":  true,
+				".spec.cloudInit.secureSecretsBackend": true,
+			},
+			"v1beta2": {},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("newCRDAPI() = %+v, want %+v", got, want)
+	}
+}
+
+func TestAPIChanges(t *testing.T) {
+	cluster := func(versions map[string]map[string]bool) map[string]crdAPI {
+		return map[string]crdAPI{"awsclusters.infrastructure.cluster.x-k8s.io": {kind: "AWSCluster", versions: versions}}
+	}
+	tests := []struct {
+		name   string
+		before map[string]crdAPI
+		after  map[string]crdAPI
+		want   []string
+	}{
+		{
+			name:   "unchanged",
+			before: cluster(map[string]map[string]bool{"v1beta1": {".spec.region": true}}),
+			after:  cluster(map[string]map[string]bool{"v1beta1": {".spec.region": true}}),
+			want:   []string{},
+		},
+		{
+			name:  "new kind",
+			after: cluster(map[string]map[string]bool{"v1beta1": {}}),
+			want:  []string{"new kind AWSCluster (awsclusters.infrastructure.cluster.x-k8s.io)"},
+		},
+		{
+			name:   "removed kind",
+			before: cluster(map[string]map[string]bool{"v1beta1": {}}),
+			want:   []string{"removed kind AWSCluster (awsclusters.infrastructure.cluster.x-k8s.io)"},
+		},
+		{
+			name:   "versions",
+			before: cluster(map[string]map[string]bool{"v1alpha4": {}, "v1beta1": {}}),
+			after:  cluster(map[string]map[string]bool{"v1beta1": {}, "v1beta2": {}}),
+			want:   []string{"AWSCluster: removed version v1alpha4", "AWSCluster: new version v1beta2"},
+		},
+		{
+			name:   "required fields",
+			before: cluster(map[string]map[string]bool{"v1beta1": {".spec.region": true}}),
+			after:  cluster(map[string]map[string]bool{"v1beta1": {".spec.sshKeyName": true}}),
+			want: []string{
+				"AWSCluster v1beta1: new required field .spec.sshKeyName",
+				"AWSCluster v1beta1: field .spec.region is no longer required",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := apiChanges(tt.before, tt.after); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("apiChanges() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
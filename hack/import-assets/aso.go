@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/klog/v2"
+)
+
+// The newer releases of the Azure provider bundle the Azure Service Operator (ASO) in their
+// components: a second manager Deployment with its webhooks, RBAC and settings Secret, and
+// the CRDs of the Azure resources.
+const (
+	azureServiceOperatorKeep = "keep"
+	azureServiceOperatorDrop = "drop"
+)
+
+// asoNamePrefixes are the prefixes of the names of the ASO objects, its CRDs are recognized
+// by their group.
+var asoNamePrefixes = []string{"azureserviceoperator-", "aso-"}
+
+const (
+	asoCRDGroupSuffix = ".azure.com"
+	// asoImagePrefix tells the images of the ASO containers from the ones of the provider
+	// manager, both have a manager container.
+	asoImagePrefix = "aso-"
+)
+
+func isAzureServiceOperator(obj unstructured.Unstructured) bool {
+	if isAzureServiceOperatorName(obj.GetName()) {
+		return true
+	}
+	if !isCRD(obj) {
+		return false
+	}
+	group, _, _ := unstructured.NestedString(obj.Object, "spec", "group")
+	return strings.HasSuffix(group, asoCRDGroupSuffix)
+}
+
+func isAzureServiceOperatorName(name string) bool {
+	for _, prefix := range asoNamePrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterAzureServiceOperator keeps or drops the ASO objects of the provider. They are
+// counted either way, so that a release starting to bundle ASO doesn't grow the components
+// silently.
+func filterAzureServiceOperator(p *provider, objs []unstructured.Unstructured, mode string) ([]unstructured.Unstructured, error) {
+	finalObjs := []unstructured.Unstructured{}
+	found := 0
+	for _, obj := range objs {
+		if isAzureServiceOperator(obj) {
+			found++
+			if mode == azureServiceOperatorDrop {
+				klog.V(2).Infof("dropping %s %s of the Azure Service Operator", obj.GetKind(), obj.GetName())
+				continue
+			}
+		}
+		finalObjs = append(finalObjs, obj)
+	}
+	if found == 0 {
+		return objs, nil
+	}
+	if mode == azureServiceOperatorDrop {
+		klog.Infof("%s %s: dropped the %d objects of the Azure Service Operator", p.ptype, p.assetName(), found)
+	} else {
+		klog.Infof("%s %s: keeping the %d objects of the Azure Service Operator", p.ptype, p.assetName(), found)
+	}
+	return finalObjs, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	clusterctlv1 "sigs.k8s.io/cluster-api/cmd/clusterctl/api/v1alpha3"
+)
+
+func TestFilterAzureServiceOperator(t *testing.T) {
+	const components = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: capz-controller-manager
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: azureserviceoperator-controller-manager
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: aso-controller-settings
+---
+apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: resourcegroups.resources.azure.com
+spec:
+  group: resources.azure.com
+---
+apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: azureclusters.infrastructure.cluster.x-k8s.io
+spec:
+  group: infrastructure.cluster.x-k8s.io
+`
+	azure := &provider{name: "azure", ptype: clusterctlv1.InfrastructureProviderType}
+
+	tests := []struct {
+		mode string
+		want []string
+	}{
+		{
+			mode: azureServiceOperatorKeep,
+			want: []string{
+				"capz-controller-manager",
+				"azureserviceoperator-controller-manager",
+				"aso-controller-settings",
+				"resourcegroups.resources.azure.com",
+				"azureclusters.infrastructure.cluster.x-k8s.io",
+			},
+		},
+		{
+			mode: azureServiceOperatorDrop,
+			want: []string{"capz-controller-manager", "azureclusters.infrastructure.cluster.x-k8s.io"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			objs, err := filterAzureServiceOperator(azure, testObjects(t, components), tt.mode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			names := []string{}
+			for _, obj := range objs {
+				names = append(names, obj.GetName())
+			}
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("objects %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
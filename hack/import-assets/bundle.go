@@ -0,0 +1,368 @@
+package main
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/blang/semver"
+	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog/v2"
+	utilyaml "sigs.k8s.io/cluster-api/util/yaml"
+)
+
+const (
+	bundlePackageName     = "cluster-capi-operator"
+	bundleOperatorName    = "cluster-capi-operator"
+	bundleManifestsDir    = "manifests"
+	bundleMetadataDir     = "metadata"
+	bundleAnnotationsFile = "annotations.yaml"
+)
+
+// bundleKinds are the kinds OLM installs from a bundle, the rest of the manifests (e.g. the
+// Namespace, the ClusterOperator or the CredentialsRequests) are CVO specific.
+var bundleKinds = []string{
+	"ClusterRole", "ClusterRoleBinding", "ConfigMap", "CustomResourceDefinition", "PriorityClass",
+	"PrometheusRule", "Role", "RoleBinding", "Secret", "Service", "ServiceAccount", "ServiceMonitor",
+}
+
+// releaseAnnotationPrefixes select the CVO annotations, they mean nothing to OLM.
+var releaseAnnotationPrefixes = []string{"include.release.openshift.io/", "exclude.release.openshift.io/", "release.openshift.io/"}
+
+// The subset of the OLM ClusterServiceVersion written to the bundle.
+type clusterServiceVersion struct {
+	APIVersion string      `json:"apiVersion"`
+	Kind       string      `json:"kind"`
+	Metadata   csvMetadata `json:"metadata"`
+	Spec       csvSpec     `json:"spec"`
+}
+
+type csvMetadata struct {
+	Name        string            `json:"name"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type csvSpec struct {
+	DisplayName               string            `json:"displayName"`
+	Description               string            `json:"description"`
+	Version                   string            `json:"version"`
+	Maturity                  string            `json:"maturity"`
+	Provider                  csvProvider       `json:"provider"`
+	InstallModes              []csvInstallMode  `json:"installModes"`
+	Install                   csvInstall        `json:"install"`
+	CustomResourceDefinitions *csvCRDs          `json:"customresourcedefinitions,omitempty"`
+	RelatedImages             []csvRelatedImage `json:"relatedImages,omitempty"`
+}
+
+type csvProvider struct {
+	Name string `json:"name"`
+}
+
+type csvInstallMode struct {
+	Type      string `json:"type"`
+	Supported bool   `json:"supported"`
+}
+
+type csvInstall struct {
+	Strategy string         `json:"strategy"`
+	Spec     csvInstallSpec `json:"spec"`
+}
+
+type csvInstallSpec struct {
+	Deployments        []csvDeployment `json:"deployments"`
+	Permissions        []csvPermission `json:"permissions,omitempty"`
+	ClusterPermissions []csvPermission `json:"clusterPermissions,omitempty"`
+}
+
+type csvDeployment struct {
+	Name string                `json:"name"`
+	Spec appsv1.DeploymentSpec `json:"spec"`
+}
+
+type csvPermission struct {
+	ServiceAccountName string              `json:"serviceAccountName"`
+	Rules              []rbacv1.PolicyRule `json:"rules"`
+}
+
+type csvCRDs struct {
+	Owned []csvCRD `json:"owned,omitempty"`
+}
+
+type csvCRD struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Kind    string `json:"kind"`
+}
+
+type csvRelatedImage struct {
+	Name  string `json:"name"`
+	Image string `json:"image"`
+}
+
+// writeBundle packages the manifests of the tree, the operator and the RBAC and CRDs of the
+// imported providers, into an OLM bundle in dir, for clusters without the CVO. The provider
+// components are installed by the operator from the assets embedded in its binary.
+func writeBundle(dir string) error {
+	version, err := semver.Parse(strings.TrimPrefix(*bundleVersion, "v"))
+	if err != nil {
+		return errors.Wrapf(err, "invalid --version %q", *bundleVersion)
+	}
+
+	objs, err := manifestObjects()
+	if err != nil {
+		return err
+	}
+	images, err := readImagesManifest()
+	if err != nil {
+		return err
+	}
+	// the operator image is keyed by the name of the operator in the images ConfigMap
+	operatorImage := *bundleOperatorImage
+	if operatorImage == "" {
+		operatorImage = images[bundleOperatorName]
+	}
+
+	csv := &clusterServiceVersion{
+		APIVersion: "operators.coreos.com/v1alpha1",
+		Kind:       "ClusterServiceVersion",
+		Metadata: csvMetadata{
+			Name: bundleOperatorName + ".v" + version.String(),
+			Annotations: map[string]string{
+				"capabilities":   "Basic Install",
+				"containerImage": operatorImage,
+				"operatorframework.io/suggested-namespace": *targetNamespace,
+			},
+		},
+		Spec: csvSpec{
+			DisplayName: "Cluster API",
+			Description: "Installs the cluster-api core and infrastructure providers for the platform of the cluster.",
+			Version:     version.String(),
+			Maturity:    *bundleChannel,
+			Provider:    csvProvider{Name: "Red Hat"},
+			// the operator and the providers run in a single namespace
+			InstallModes: []csvInstallMode{
+				{Type: "OwnNamespace", Supported: true},
+				{Type: "SingleNamespace", Supported: false},
+				{Type: "MultiNamespace", Supported: false},
+				{Type: "AllNamespaces", Supported: false},
+			},
+			Install: csvInstall{Strategy: "deployment"},
+		},
+	}
+	for _, name := range sortedKeys(images) {
+		csv.Spec.RelatedImages = append(csv.Spec.RelatedImages, csvRelatedImage{Name: strings.ToLower(name), Image: images[name]})
+	}
+
+	bundleObjs, err := csv.installFrom(objs, version.String(), operatorImage)
+	if err != nil {
+		return err
+	}
+	owned := []csvCRD{}
+	for _, obj := range bundleObjs {
+		if !isCRD(obj) {
+			continue
+		}
+		crd := &apiextensionsv1.CustomResourceDefinition{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, crd); err != nil {
+			return err
+		}
+		for _, v := range crd.Spec.Versions {
+			if v.Storage {
+				owned = append(owned, csvCRD{Name: crd.Name, Version: v.Name, Kind: crd.Spec.Names.Kind})
+			}
+		}
+	}
+	if len(owned) > 0 {
+		csv.Spec.CustomResourceDefinitions = &csvCRDs{Owned: owned}
+	}
+
+	return writeBundleFiles(dir, csv, bundleObjs, *bundleChannel)
+}
+
+// installFrom moves the operator deployment and the RBAC of its service account to the
+// install strategy of the CSV, OLM creates them, and returns the other objects of the bundle.
+func (csv *clusterServiceVersion) installFrom(objs []unstructured.Unstructured, version, operatorImage string) ([]unstructured.Unstructured, error) {
+	roles := map[string]rbacv1.ClusterRole{}
+	for _, obj := range objs {
+		if obj.GetKind() != "ClusterRole" && obj.GetKind() != "Role" {
+			continue
+		}
+		role := rbacv1.ClusterRole{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &role); err != nil {
+			return nil, err
+		}
+		roles[obj.GetKind()+"/"+obj.GetName()] = role
+	}
+
+	// the roles bound to the operator are replaced by the permissions of the CSV
+	consumed := map[string]bool{"ServiceAccount/" + bundleOperatorName: true}
+	for _, obj := range objs {
+		if obj.GetKind() != "ClusterRoleBinding" && obj.GetKind() != "RoleBinding" {
+			continue
+		}
+		binding := &rbacv1.RoleBinding{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, binding); err != nil {
+			return nil, err
+		}
+		if !bindsServiceAccount(binding.Subjects, bundleOperatorName) {
+			continue
+		}
+		roleKey := binding.RoleRef.Kind + "/" + binding.RoleRef.Name
+		role, ok := roles[roleKey]
+		if !ok {
+			return nil, errors.Errorf("%s %s binds the unknown %s", obj.GetKind(), obj.GetName(), roleKey)
+		}
+		permission := csvPermission{ServiceAccountName: bundleOperatorName, Rules: role.Rules}
+		if obj.GetKind() == "ClusterRoleBinding" {
+			csv.Spec.Install.Spec.ClusterPermissions = append(csv.Spec.Install.Spec.ClusterPermissions, permission)
+		} else {
+			csv.Spec.Install.Spec.Permissions = append(csv.Spec.Install.Spec.Permissions, permission)
+		}
+		consumed[obj.GetKind()+"/"+obj.GetName()] = true
+		consumed[roleKey] = true
+	}
+
+	bundleObjs := []unstructured.Unstructured{}
+	for _, obj := range objs {
+		key := obj.GetKind() + "/" + obj.GetName()
+		if obj.GetKind() == "Deployment" && obj.GetName() == bundleOperatorName {
+			deployment := &appsv1.Deployment{}
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, deployment); err != nil {
+				return nil, err
+			}
+			setOperatorDeployment(deployment, version, operatorImage)
+			csv.Spec.Install.Spec.Deployments = append(csv.Spec.Install.Spec.Deployments,
+				csvDeployment{Name: deployment.Name, Spec: deployment.Spec})
+			continue
+		}
+		if consumed[key] {
+			continue
+		}
+		if !containsString(bundleKinds, obj.GetKind()) {
+			klog.V(1).Infof("skipping %s %s, OLM doesn't install it", obj.GetKind(), obj.GetName())
+			continue
+		}
+		// OLM installs the objects in the namespace of the operator group
+		obj.SetNamespace("")
+		bundleObjs = append(bundleObjs, obj)
+	}
+	if len(csv.Spec.Install.Spec.Deployments) == 0 {
+		return nil, errors.Errorf("no %s deployment in the manifests", bundleOperatorName)
+	}
+	return bundleObjs, nil
+}
+
+func bindsServiceAccount(subjects []rbacv1.Subject, name string) bool {
+	for _, s := range subjects {
+		if s.Kind == rbacv1.ServiceAccountKind && s.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// setOperatorDeployment sets the image and the version reported by the operator, they are
+// substituted by the CVO on a payload install.
+func setOperatorDeployment(deployment *appsv1.Deployment, version, operatorImage string) {
+	for i := range deployment.Spec.Template.Spec.Containers {
+		c := &deployment.Spec.Template.Spec.Containers[i]
+		if c.Name != bundleOperatorName {
+			continue
+		}
+		if operatorImage != "" {
+			c.Image = operatorImage
+		}
+		for j := range c.Env {
+			if c.Env[j].Name == "RELEASE_VERSION" {
+				c.Env[j].Value = version
+			}
+		}
+	}
+}
+
+// manifestObjects returns the objects of the manifests without the CVO annotations.
+func manifestObjects() ([]unstructured.Unstructured, error) {
+	files, err := out.glob(path.Join(manifestsPath, "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	objs := []unstructured.Unstructured{}
+	for _, f := range files {
+		b, err := out.readFile(f)
+		if err != nil {
+			return nil, err
+		}
+		fileObjs, err := utilyaml.ToUnstructured(b)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid %s", f)
+		}
+		for _, obj := range fileObjs {
+			anns := obj.GetAnnotations()
+			for k := range anns {
+				if hasAnyPrefix(k, releaseAnnotationPrefixes) {
+					delete(anns, k)
+				}
+			}
+			obj.SetAnnotations(anns)
+			objs = append(objs, obj)
+		}
+	}
+	return objs, nil
+}
+
+// writeBundleFiles writes the bundle in the registry+v1 format, the manifests of the previous
+// run are removed first.
+func writeBundleFiles(dir string, csv *clusterServiceVersion, objs []unstructured.Unstructured, channel string) error {
+	manifestsDir := path.Join(dir, bundleManifestsDir)
+	oldFiles, err := out.glob(path.Join(manifestsDir, "*.yaml"))
+	if err != nil {
+		return err
+	}
+	for _, f := range oldFiles {
+		if err := out.remove(f); err != nil {
+			return err
+		}
+	}
+
+	b, err := canonicalYAML(csv)
+	if err != nil {
+		return err
+	}
+	if err := out.writeFile(path.Join(manifestsDir, bundlePackageName+".clusterserviceversion.yaml"), b); err != nil {
+		return err
+	}
+	sort.SliceStable(objs, func(i, j int) bool {
+		return operatorAssetFileName(objs[i]) < operatorAssetFileName(objs[j])
+	})
+	for _, obj := range objs {
+		b, err := canonicalYAML(obj)
+		if err != nil {
+			return err
+		}
+		if err := out.writeFile(path.Join(manifestsDir, operatorAssetFileName(obj)), b); err != nil {
+			return err
+		}
+	}
+
+	b, err = canonicalYAML(&bundleAnnotations{Annotations: map[string]string{
+		"operators.operatorframework.io.bundle.mediatype.v1":       "registry+v1",
+		"operators.operatorframework.io.bundle.manifests.v1":       bundleManifestsDir + "/",
+		"operators.operatorframework.io.bundle.metadata.v1":        bundleMetadataDir + "/",
+		"operators.operatorframework.io.bundle.package.v1":         bundlePackageName,
+		"operators.operatorframework.io.bundle.channels.v1":        channel,
+		"operators.operatorframework.io.bundle.channel.default.v1": channel,
+	}})
+	if err != nil {
+		return err
+	}
+	return out.writeFile(path.Join(dir, bundleMetadataDir, bundleAnnotationsFile), b)
+}
+
+type bundleAnnotations struct {
+	Annotations map[string]string `json:"annotations"`
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"path"
+	"reflect"
+	"sort"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+)
+
+const bundleTestManifests = `apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: cluster-capi-operator
+  namespace: openshift-cluster-api
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: cluster-capi-operator
+rules:
+- apiGroups: [config.openshift.io]
+  resources: [clusteroperators]
+  verbs: [get]
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: cluster-capi-operator
+roleRef:
+  kind: ClusterRole
+  name: cluster-capi-operator
+subjects:
+- kind: ServiceAccount
+  name: cluster-capi-operator
+  namespace: openshift-cluster-api
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: Role
+metadata:
+  name: cluster-capi-operator
+  namespace: openshift-cluster-api
+rules:
+- apiGroups: [""]
+  resources: [configmaps]
+  verbs: [get]
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata:
+  name: cluster-capi-operator
+  namespace: openshift-cluster-api
+roleRef:
+  kind: Role
+  name: cluster-capi-operator
+subjects:
+- kind: ServiceAccount
+  name: cluster-capi-operator
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: openshift-cluster-api-capa-manager-role
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: cluster-capi-operator
+  namespace: openshift-cluster-api
+spec:
+  template:
+    spec:
+      containers:
+      - name: cluster-capi-operator
+        image: placeholder
+        env:
+        - name: RELEASE_VERSION
+          value: 0.0.1-snapshot
+---
+apiVersion: config.openshift.io/v1
+kind: ClusterOperator
+metadata:
+  name: cluster-api
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: cluster-capi-operator-webhook-service
+  namespace: openshift-cluster-api
+`
+
+func TestInstallFrom(t *testing.T) {
+	csv := &clusterServiceVersion{}
+	bundleObjs, err := csv.installFrom(testObjects(t, bundleTestManifests), "4.11.0", "quay.io/openshift/cluster-capi-operator:4.11")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := []string{}
+	for _, obj := range bundleObjs {
+		if obj.GetNamespace() != "" {
+			t.Errorf("%s %s in namespace %s, OLM sets it", obj.GetKind(), obj.GetName(), obj.GetNamespace())
+		}
+		got = append(got, obj.GetKind()+"/"+obj.GetName())
+	}
+	// the operator RBAC and deployment are in the CSV, the ClusterOperator isn't installed by OLM
+	want := []string{"ClusterRole/openshift-cluster-api-capa-manager-role", "Service/cluster-capi-operator-webhook-service"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("bundle objects %v, want %v", got, want)
+	}
+
+	wantClusterPermissions := []csvPermission{{ServiceAccountName: bundleOperatorName, Rules: []rbacv1.PolicyRule{
+		{APIGroups: []string{"config.openshift.io"}, Resources: []string{"clusteroperators"}, Verbs: []string{"get"}},
+	}}}
+	if got := csv.Spec.Install.Spec.ClusterPermissions; !reflect.DeepEqual(got, wantClusterPermissions) {
+		t.Errorf("clusterPermissions %+v, want %+v", got, wantClusterPermissions)
+	}
+	wantPermissions := []csvPermission{{ServiceAccountName: bundleOperatorName, Rules: []rbacv1.PolicyRule{
+		{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: []string{"get"}},
+	}}}
+	if got := csv.Spec.Install.Spec.Permissions; !reflect.DeepEqual(got, wantPermissions) {
+		t.Errorf("permissions %+v, want %+v", got, wantPermissions)
+	}
+
+	deployments := csv.Spec.Install.Spec.Deployments
+	if len(deployments) != 1 {
+		t.Fatalf("deployments %+v, want the operator", deployments)
+	}
+	operator := deployments[0].Spec.Template.Spec.Containers[0]
+	if operator.Image != "quay.io/openshift/cluster-capi-operator:4.11" {
+		t.Errorf("operator image %q, want the bundle one", operator.Image)
+	}
+	if want := []corev1.EnvVar{{Name: "RELEASE_VERSION", Value: "4.11.0"}}; !reflect.DeepEqual(operator.Env, want) {
+		t.Errorf("operator env %v, want %v", operator.Env, want)
+	}
+}
+
+func TestInstallFromErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		manifests string
+	}{
+		{
+			name: "unknown role",
+			manifests: `apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: cluster-capi-operator
+roleRef:
+  kind: ClusterRole
+  name: cluster-capi-operator
+subjects:
+- kind: ServiceAccount
+  name: cluster-capi-operator
+`,
+		},
+		{
+			name: "no operator deployment",
+			manifests: `apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: cluster-capi-operator
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			csv := &clusterServiceVersion{}
+			if _, err := csv.installFrom(testObjects(t, tt.manifests), "4.11.0", ""); err == nil {
+				t.Errorf("installFrom() error = %v, wantErr %v", err, true)
+			}
+		})
+	}
+}
+
+func TestSetOperatorDeployment(t *testing.T) {
+	tests := []struct {
+		name          string
+		operatorImage string
+		wantImage     string
+	}{
+		{name: "image kept", wantImage: "placeholder"},
+		{name: "image set", operatorImage: "quay.io/openshift/cluster-capi-operator:4.11", wantImage: "quay.io/openshift/cluster-capi-operator:4.11"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deployment := testDeployment(t, testObjects(t, bundleTestManifests)[6])
+			deployment.Spec.Template.Spec.Containers = append(deployment.Spec.Template.Spec.Containers,
+				corev1.Container{Name: "kube-rbac-proxy", Image: "kube-rbac-proxy", Env: []corev1.EnvVar{{Name: "RELEASE_VERSION"}}})
+			setOperatorDeployment(deployment, "4.11.0", tt.operatorImage)
+
+			containers := deployment.Spec.Template.Spec.Containers
+			if containers[0].Image != tt.wantImage || containers[0].Env[0].Value != "4.11.0" {
+				t.Errorf("operator container %+v, want image %s and version 4.11.0", containers[0], tt.wantImage)
+			}
+			// the other containers are left alone
+			if containers[1].Image != "kube-rbac-proxy" || containers[1].Env[0].Value != "" {
+				t.Errorf("kube-rbac-proxy container %+v changed", containers[1])
+			}
+		})
+	}
+}
+
+func TestManifestObjects(t *testing.T) {
+	testPaths(t)
+	testOutput(t, map[string][]byte{
+		path.Join(manifestsPath, "0000_30_cluster-api_00_namespace.yaml"): []byte(`apiVersion: v1
+kind: Namespace
+metadata:
+  name: openshift-cluster-api
+  annotations:
+    include.release.openshift.io/self-managed-high-availability: "true"
+    release.openshift.io/feature-set: TechPreviewNoUpgrade
+    openshift.io/node-selector: ""
+`),
+	})
+	objs, err := manifestObjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objs) != 1 {
+		t.Fatalf("manifestObjects() = %v, want the Namespace", objs)
+	}
+	if got, want := objs[0].GetAnnotations(), map[string]string{"openshift.io/node-selector": ""}; !reflect.DeepEqual(got, want) {
+		t.Errorf("annotations %v, want %v", got, want)
+	}
+}
+
+func TestWriteBundleFiles(t *testing.T) {
+	dir := t.TempDir()
+	stale := path.Join(dir, bundleManifestsDir, "stale.yaml")
+	testOutput(t, map[string][]byte{stale: []byte("stale")})
+
+	csv := &clusterServiceVersion{Spec: csvSpec{Install: csvInstall{Spec: csvInstallSpec{Deployments: []csvDeployment{{Name: bundleOperatorName, Spec: appsv1.DeploymentSpec{}}}}}}}
+	objs := testObjects(t, bundleTestManifests)[7:]
+	if err := writeBundleFiles(dir, csv, objs, "stable"); err != nil {
+		t.Fatal(err)
+	}
+
+	got := []string{}
+	for name, b := range out.files {
+		if b != nil {
+			got = append(got, name)
+		}
+	}
+	sort.Strings(got)
+	want := []string{
+		path.Join(dir, bundleManifestsDir, operatorAssetFileName(objs[0])),
+		path.Join(dir, bundleManifestsDir, operatorAssetFileName(objs[1])),
+		path.Join(dir, bundleManifestsDir, "cluster-capi-operator.clusterserviceversion.yaml"),
+		path.Join(dir, bundleMetadataDir, bundleAnnotationsFile),
+	}
+	sort.Strings(want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("bundle files %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"k8s.io/klog/v2"
+	configclient "sigs.k8s.io/cluster-api/cmd/clusterctl/client/config"
+	"sigs.k8s.io/cluster-api/cmd/clusterctl/client/repository"
+)
+
+var warnedGitHubToken bool
+
+// warnMissingGitHubToken points out that unauthenticated GitHub API requests are rate limited
+// to 60 per hour, clusterctl picks the token up from the GITHUB_TOKEN environment variable.
+func warnMissingGitHubToken(configClient configclient.Client) {
+	if warnedGitHubToken {
+		return
+	}
+	if _, err := configClient.Variables().Get(configclient.GitHubTokenVariable); err != nil {
+		klog.Warning("GITHUB_TOKEN is not set, GitHub API requests will be rate limited")
+	}
+	warnedGitHubToken = true
+}
+
+// cachedRepository keeps the release files of a provider on disk, keyed by version, using
+// the same layout as the download command so a cache dir can also be used with --offline.
+// The upstream repository is only created on a cache miss, as creating a GitHub repository
+// for a "latest" url already costs an API request.
+type cachedRepository struct {
+	dir            string
+	componentsPath string
+	newUpstream    func() (repository.Repository, error)
+	upstream       repository.Repository
+}
+
+var _ repository.Repository = &cachedRepository{}
+
+func (r *cachedRepository) getUpstream() (repository.Repository, error) {
+	if r.upstream == nil {
+		upstream, err := r.newUpstream()
+		if err != nil {
+			return nil, err
+		}
+		r.upstream = upstream
+	}
+	return r.upstream, nil
+}
+
+func (r *cachedRepository) DefaultVersion() string {
+	upstream, err := r.getUpstream()
+	if err != nil {
+		return ""
+	}
+	return upstream.DefaultVersion()
+}
+
+func (r *cachedRepository) RootPath() string {
+	return ""
+}
+
+func (r *cachedRepository) ComponentsPath() string {
+	return r.componentsPath
+}
+
+// GetVersions is never cached, it is used to find new releases.
+func (r *cachedRepository) GetVersions() ([]string, error) {
+	upstream, err := r.getUpstream()
+	if err != nil {
+		return nil, err
+	}
+	return upstream.GetVersions()
+}
+
+func (r *cachedRepository) GetFile(version, fileName string) ([]byte, error) {
+	cacheFile := filepath.Join(r.dir, version, fileName)
+	if b, err := ioutil.ReadFile(filepath.Clean(cacheFile)); err == nil {
+		return b, nil
+	}
+
+	upstream, err := r.getUpstream()
+	if err != nil {
+		return nil, err
+	}
+	b, err := upstream.GetFile(version, fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(cacheFile), 0750); err != nil {
+		return nil, err
+	}
+	return b, os.WriteFile(cacheFile, b, 0600)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/cluster-api/cmd/clusterctl/client/repository"
+)
+
+// fakeRepository serves the release files of files, by version and name, and counts the calls.
+type fakeRepository struct {
+	files    map[string]map[string][]byte
+	versions []string
+	calls    int
+}
+
+func (r *fakeRepository) DefaultVersion() string { return "" }
+func (r *fakeRepository) RootPath() string       { return "" }
+func (r *fakeRepository) ComponentsPath() string { return "infrastructure-components.yaml" }
+
+func (r *fakeRepository) GetFile(version, fileName string) ([]byte, error) {
+	r.calls++
+	b, ok := r.files[version][fileName]
+	if !ok {
+		return nil, errors.Errorf("no %s in release %s", fileName, version)
+	}
+	return b, nil
+}
+
+func (r *fakeRepository) GetVersions() ([]string, error) {
+	r.calls++
+	return r.versions, nil
+}
+
+func TestCachedRepository(t *testing.T) {
+	tests := []struct {
+		name         string
+		cached       map[string]string
+		upstream     map[string]map[string][]byte
+		want         string
+		wantErr      bool
+		wantCalls    int
+		wantUpstream bool
+		wantCached   map[string]string
+	}{
+		{
+			name:       "hit",
+			cached:     map[string]string{"v1.0.0/metadata.yaml": "cached"},
+			want:       "cached",
+			wantCached: map[string]string{"v1.0.0/metadata.yaml": "cached"},
+		},
+		{
+			name:         "miss",
+			upstream:     map[string]map[string][]byte{"v1.0.0": {"metadata.yaml": []byte("upstream")}},
+			want:         "upstream",
+			wantCalls:    1,
+			wantUpstream: true,
+			wantCached:   map[string]string{"v1.0.0/metadata.yaml": "upstream"},
+		},
+		{
+			name:         "other version cached",
+			cached:       map[string]string{"v0.9.0/metadata.yaml": "cached"},
+			upstream:     map[string]map[string][]byte{"v1.0.0": {"metadata.yaml": []byte("upstream")}},
+			want:         "upstream",
+			wantCalls:    1,
+			wantUpstream: true,
+			wantCached:   map[string]string{"v0.9.0/metadata.yaml": "cached", "v1.0.0/metadata.yaml": "upstream"},
+		},
+		{
+			name:         "upstream failure",
+			wantErr:      true,
+			wantCalls:    1,
+			wantUpstream: true,
+			wantCached:   map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.cached {
+				if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0750); err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			upstream := &fakeRepository{files: tt.upstream}
+			created := false
+			repo := &cachedRepository{dir: dir, newUpstream: func() (repository.Repository, error) {
+				created = true
+				return upstream, nil
+			}}
+
+			b, err := repo.GetFile("v1.0.0", "metadata.yaml")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(b) != tt.want {
+				t.Errorf("GetFile() = %q, want %q", b, tt.want)
+			}
+			// the upstream repository is only created on a miss
+			if created != tt.wantUpstream || upstream.calls != tt.wantCalls {
+				t.Errorf("upstream created %v with %d calls, want %v with %d", created, upstream.calls, tt.wantUpstream, tt.wantCalls)
+			}
+			if got := readCacheDir(t, dir); !reflect.DeepEqual(got, tt.wantCached) {
+				t.Errorf("cache %v, want %v", got, tt.wantCached)
+			}
+		})
+	}
+}
+
+func TestCachedRepositoryVersions(t *testing.T) {
+	upstream := &fakeRepository{versions: []string{"v1.0.0", "v1.1.0"}}
+	repo := &cachedRepository{dir: t.TempDir(), newUpstream: func() (repository.Repository, error) { return upstream, nil }}
+	for i := 1; i <= 2; i++ {
+		versions, err := repo.GetVersions()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(versions, upstream.versions) {
+			t.Errorf("GetVersions() = %v, want %v", versions, upstream.versions)
+		}
+		// the versions are never cached
+		if upstream.calls != i {
+			t.Errorf("%d upstream calls, want %d", upstream.calls, i)
+		}
+	}
+}
+
+// readCacheDir returns the content of the files of a cache dir, by path.
+func readCacheDir(t *testing.T, dir string) map[string]string {
+	files := map[string]string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		b, err := ioutil.ReadFile(filepath.Clean(path))
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		files[filepath.ToSlash(rel)] = string(b)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+)
+
+// canonicalYAML marshals the objects, typed or unstructured, to a multi document YAML with
+// sorted keys and a two spaces indentation, without the fields only the API server sets
+// (creationTimestamp and status), so rerunning the import gives byte identical files.
+func canonicalYAML(objs ...interface{}) ([]byte, error) {
+	docs := [][]byte{}
+	for _, obj := range objs {
+		var content map[string]interface{}
+		switch o := obj.(type) {
+		case unstructured.Unstructured:
+			content = o.DeepCopy().Object
+		case *unstructured.Unstructured:
+			content = o.DeepCopy().Object
+		default:
+			var err error
+			if content, err = runtime.DefaultUnstructuredConverter.ToUnstructured(obj); err != nil {
+				return nil, err
+			}
+		}
+		delete(content, "status")
+		stripCreationTimestamps(content)
+
+		b, err := yaml.Marshal(content)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, bytes.TrimRight(b, "\n"))
+	}
+	return ensureNewLine(bytes.Join(docs, []byte("\n---\n"))), nil
+}
+
+// stripCreationTimestamps removes the null creationTimestamp of the object and of the
+// embedded objects, e.g. the pod templates.
+func stripCreationTimestamps(v interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, value := range v {
+			if k == "creationTimestamp" && value == nil {
+				delete(v, k)
+				continue
+			}
+			stripCreationTimestamps(value)
+		}
+	case []interface{}:
+		for _, value := range v {
+			stripCreationTimestamps(value)
+		}
+	}
+}
+
+func canonicalObjectsYAML(objs []unstructured.Unstructured) ([]byte, error) {
+	items := make([]interface{}, 0, len(objs))
+	for _, obj := range objs {
+		items = append(items, obj)
+	}
+	return canonicalYAML(items...)
+}
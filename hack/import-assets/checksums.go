@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"sigs.k8s.io/cluster-api/cmd/clusterctl/client/repository"
+)
+
+const providerChecksumsFileName = "provider-versions.lock"
+
+// providerChecksums records the sha256 of every release file imported for a provider version,
+// and the commit of the release tag for the GitHub releases.
+type providerChecksums struct {
+	Version string            `json:"version"`
+	Commit  string            `json:"commit,omitempty"`
+	SHA256  map[string]string `json:"sha256"`
+}
+
+func loadChecksums() (map[string]providerChecksums, error) {
+	checksums := map[string]providerChecksums{}
+	jsonData, err := out.readFile(providerChecksumsFileName)
+	if os.IsNotExist(err) {
+		return checksums, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return checksums, json.Unmarshal(jsonData, &checksums)
+}
+
+func saveChecksums(checksums map[string]providerChecksums) error {
+	jsonData, err := json.MarshalIndent(&checksums, "", "  ")
+	if err != nil {
+		return err
+	}
+	return out.writeFile(providerChecksumsFileName, ensureNewLine(jsonData))
+}
+
+// getFile reads a release file, verifies its signature and checks it against the checksum pinned for the version,
+// so that a release that was re-tagged upstream fails the import instead of silently
+// changing the assets. Files of versions that are not pinned yet get pinned.
+func (p *provider) getFile(repo repository.Repository, fileName string) ([]byte, error) {
+	b, err := repo.GetFile(p.version, fileName)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.verifySignature(repo, fileName, b); err != nil {
+		return nil, err
+	}
+
+	checksums, err := loadChecksums()
+	if err != nil {
+		return nil, err
+	}
+	key := p.providerTypeName() + "-" + p.assetName()
+	sum := fmt.Sprintf("%x", sha256.Sum256(b))
+
+	pinned, ok := checksums[key]
+	if !ok || pinned.Version != p.version {
+		pinned = providerChecksums{Version: p.version, SHA256: map[string]string{}}
+	}
+	if pinnedSum, ok := pinned.SHA256[fileName]; ok {
+		if pinnedSum != sum {
+			return nil, fmt.Errorf("%s %s %s has sha256 %s but %s is pinned in %s, the upstream release has changed",
+				p.assetName(), p.version, fileName, sum, pinnedSum, providerChecksumsFileName)
+		}
+		return b, nil
+	}
+
+	pinned.SHA256[fileName] = sum
+	checksums[key] = pinned
+	return b, saveChecksums(checksums)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+
+	clusterctlv1 "sigs.k8s.io/cluster-api/cmd/clusterctl/api/v1alpha3"
+)
+
+// testOutput replaces the output with a dry run one holding files, for the duration of the test.
+func testOutput(t *testing.T, files map[string][]byte) {
+	previous := out
+	out = &output{dryRun: true, files: files, written: map[string]bool{}}
+	t.Cleanup(func() { out = previous })
+}
+
+func TestGetFile(t *testing.T) {
+	sum := func(s string) string {
+		return fmt.Sprintf("%x", sha256.Sum256([]byte(s)))
+	}
+	upstream := &fakeRepository{files: map[string]map[string][]byte{
+		"v1.0.0": {"metadata.yaml": []byte("metadata")},
+	}}
+	aws := provider{name: "aws", ptype: clusterctlv1.InfrastructureProviderType, version: "v1.0.0"}
+
+	tests := []struct {
+		name     string
+		pinned   map[string]providerChecksums
+		fileName string
+		want     map[string]providerChecksums
+		wantErr  bool
+	}{
+		{
+			name:     "pinned on the first import",
+			pinned:   map[string]providerChecksums{},
+			fileName: "metadata.yaml",
+			want: map[string]providerChecksums{
+				"infrastructure-aws": {Version: "v1.0.0", SHA256: map[string]string{"metadata.yaml": sum("metadata")}},
+			},
+		},
+		{
+			name: "unchanged",
+			pinned: map[string]providerChecksums{
+				"infrastructure-aws": {Version: "v1.0.0", Commit: "abc", SHA256: map[string]string{"metadata.yaml": sum("metadata")}},
+			},
+			fileName: "metadata.yaml",
+			want: map[string]providerChecksums{
+				"infrastructure-aws": {Version: "v1.0.0", Commit: "abc", SHA256: map[string]string{"metadata.yaml": sum("metadata")}},
+			},
+		},
+		{
+			name: "changed upstream",
+			pinned: map[string]providerChecksums{
+				"infrastructure-aws": {Version: "v1.0.0", SHA256: map[string]string{"metadata.yaml": sum("retagged")}},
+			},
+			fileName: "metadata.yaml",
+			wantErr:  true,
+		},
+		{
+			name: "new version",
+			pinned: map[string]providerChecksums{
+				"infrastructure-aws": {Version: "v0.9.0", SHA256: map[string]string{"metadata.yaml": sum("retagged")}},
+				"infrastructure-gcp": {Version: "v0.4.0", SHA256: map[string]string{"metadata.yaml": sum("gcp")}},
+			},
+			fileName: "metadata.yaml",
+			want: map[string]providerChecksums{
+				"infrastructure-aws": {Version: "v1.0.0", SHA256: map[string]string{"metadata.yaml": sum("metadata")}},
+				"infrastructure-gcp": {Version: "v0.4.0", SHA256: map[string]string{"metadata.yaml": sum("gcp")}},
+			},
+		},
+		{
+			name:     "missing file",
+			pinned:   map[string]providerChecksums{},
+			fileName: "infrastructure-components.yaml",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := json.Marshal(tt.pinned)
+			if err != nil {
+				t.Fatal(err)
+			}
+			testOutput(t, map[string][]byte{providerChecksumsFileName: b})
+
+			got, err := aws.getFile(upstream, tt.fileName)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if string(got) != "metadata" {
+				t.Errorf("getFile() = %q, want the release file", got)
+			}
+			checksums, err := loadChecksums()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(checksums, tt.want) {
+				t.Errorf("checksums %+v, want %+v", checksums, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"k8s.io/klog/v2"
+)
+
+var (
+	rootCmd = &cobra.Command{
+		Use:   "import-assets",
+		Short: "Import the cluster-api providers and the upstream operator into the assets and manifests",
+		Long: `Import the release components of the cluster-api providers and of the upstream
+cluster-api-operator, transform them for OpenShift and write them to assets/ and manifests/.`,
+		SilenceUsage: true,
+	}
+
+	providerPatterns = rootCmd.PersistentFlags().StringSlice("providers", nil, "Only import the providers matching these comma separated globs or /regular expressions/, by name, asset name or <type>-<name>, e.g. \"aws,gcp\" or \"ibmcloud*\", optional providers are only imported this way.")
+	skipProviders    = rootCmd.PersistentFlags().StringSlice("skip-providers", nil, "Don't import the providers matching these comma separated globs or /regular expressions/.")
+	providerFilter   = rootCmd.PersistentFlags().String("provider", "", "Only import the provider with this name or asset name.")
+	targetNamespace  = rootCmd.PersistentFlags().String("namespace", "openshift-cluster-api", "Namespace the providers and the upstream operator are generated for.")
+	importConfigFile = rootCmd.PersistentFlags().String("config", "import-config.yaml", "Per provider customizations of the imported components.")
+	variablesFile    = rootCmd.PersistentFlags().String("variables", "provider-variables.yaml", "Values of the clusterctl variables baked into the components of the providers listed, keyed by <type>-<name>.")
+	patchesDir       = rootCmd.PersistentFlags().String("patches-dir", "patches", "Directory with the patches applied to each provider, in <type>-<name>/*.yaml.")
+	certMode         = rootCmd.PersistentFlags().String("cert-mode", certModeServiceCA, "How the webhook and metrics certificates are issued, \""+certModeServiceCA+"\" or \""+certModeCertManager+"\" to keep the upstream cert-manager objects.")
+	offline          = rootCmd.PersistentFlags().String("offline", "", "Read the provider release files from a directory or .tar.gz created by the download command instead of the network.")
+	cacheDir         = rootCmd.PersistentFlags().String("cache-dir", defaultCacheDir(), "Directory to cache the downloaded release files in, set to \"\" to disable.")
+	retryAttempts    = rootCmd.PersistentFlags().Int("retry-attempts", 5, "Number of attempts for fetching release files on transient (5xx, rate limit, network) failures.")
+	retryDelay       = rootCmd.PersistentFlags().Duration("retry-delay", retryDelayDefault, "Delay before the first retry, doubled on each further attempt.")
+	caBundle         = rootCmd.PersistentFlags().String("ca-bundle", "", "PEM file with additional CA certificates to trust, e.g. for a proxy or an internal mirror.")
+
+	// importFlags are shared by the commands generating the assets
+	importFlags           = pflag.NewFlagSet("import", pflag.ExitOnError)
+	latest                = importFlags.Bool("latest", false, "Import the newest upstream release of each provider and update provider-versions.json.")
+	latestRange           = importFlags.String("latest-range", "", "Optional semver range the releases resolved by --latest must match, e.g. \"<1.0.0\".")
+	allowContractMismatch = importFlags.Bool("allow-contract-mismatch", false, "Only warn when a provider implements another cluster-api contract than the core provider.")
+	reportFile            = importFlags.String("report", "", "Write a summary of the changes of each imported provider to this file, as JSON if it ends with .json, as markdown otherwise.")
+	noPrune               = importFlags.Bool("no-prune", false, "Keep the generated files and images of the providers that are no longer imported.")
+	outputMode            = importFlags.String("output", outputConfigMaps, "How the provider components are generated, \""+outputConfigMaps+"\" for the ConfigMaps read by the upstream operator, \""+outputEmbed+"\" for a Go package embedding them in --registry-dir or \""+outputKustomize+"\" for a kustomize base and per platform overlays in --kustomize-dir.")
+	kustomizePath         = importFlags.String("kustomize-dir", path.Join(projDir, "assets", "kustomize"), "Directory of the kustomize base and overlays generated with --output="+outputKustomize+".")
+	registryPath          = importFlags.String("registry-dir", path.Join(projDir, "assets", "registry"), "Directory of the Go package generated with --output="+outputEmbed+".")
+
+	// bundleFlags are the flags of the bundle command
+	bundleFlags         = pflag.NewFlagSet("bundle", pflag.ExitOnError)
+	bundleVersion       = bundleFlags.String("version", "", "Semantic version of the bundle, e.g. \"4.10.0\".")
+	bundleChannel       = bundleFlags.String("channel", "alpha", "Channel of the bundle, also its maturity.")
+	bundleOperatorImage = bundleFlags.String("operator-image", "", "Image of the operator, defaults to the one of the images ConfigMap.")
+
+	// selection are the providers selected with --providers and --skip-providers
+	selection *providerSelector
+)
+
+// The log verbosity set with --v:
+//   - 0: the progress and summary of the import, the files pruned and the warnings,
+//   - 1: the release files downloaded and verified,
+//   - 2: the files written and the decisions on single objects.
+func init() {
+	klogFlags := flag.NewFlagSet("klog", flag.ExitOnError)
+	klog.InitFlags(klogFlags)
+	verbosity := klogFlags.Lookup("v")
+	verbosity.Usage = "Log verbosity, 1 and 2 add details on the downloads and the files written."
+	rootCmd.PersistentFlags().AddGoFlag(verbosity)
+	rootCmd.PersistentPreRunE = setup
+	if err := rootCmd.PersistentFlags().MarkDeprecated("provider", "use --providers"); err != nil {
+		panic(err)
+	}
+
+	importCmd := &cobra.Command{
+		Use:   "import",
+		Short: "Import the upstream operator, its RBAC and the providers, or only the --providers ones",
+		Args:  cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			return importAll()
+		},
+	}
+	importCmd.PersistentFlags().AddFlagSet(importFlags)
+	importCmd.AddCommand(
+		&cobra.Command{
+			Use:   "operator",
+			Short: "Import the upstream cluster-api-operator into assets/capi-operator",
+			Args:  cobra.NoArgs,
+			RunE: func(_ *cobra.Command, _ []string) error {
+				return importOperator()
+			},
+		},
+		&cobra.Command{
+			Use:   "rbac",
+			Short: "Move the RBAC of the imported upstream operator to the manifests",
+			Args:  cobra.NoArgs,
+			RunE: func(_ *cobra.Command, _ []string) error {
+				return moveRBACToManifests()
+			},
+		},
+		&cobra.Command{
+			Use:   "providers",
+			Short: "Import the providers into assets/providers and their RBAC and CRDs into the manifests",
+			Args:  cobra.NoArgs,
+			RunE: func(_ *cobra.Command, _ []string) error {
+				return importProviders(selection)
+			},
+		},
+	)
+
+	diffCmd := &cobra.Command{
+		Use:   "diff",
+		Short: "Print the diff of what import would generate against the files in the tree, without writing",
+		Args:  cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			out.dryRun = true
+			if err := importAll(); err != nil {
+				return err
+			}
+			_, err := out.printDiff(os.Stdout)
+			return err
+		},
+	}
+	diffCmd.Flags().AddFlagSet(importFlags)
+
+	verifyCmd := &cobra.Command{
+		Use:   "verify",
+		Short: "Fail if the files in the tree differ from what the pinned versions generate",
+		Args:  cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			return verify()
+		},
+	}
+	verifyCmd.Flags().AddFlagSet(importFlags)
+
+	renderCmd := &cobra.Command{
+		Use:   "render <provider>",
+		Short: "Print the transformed components of a provider",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			return renderProvider(args[0], os.Stdout)
+		},
+	}
+	renderCmd.Flags().AddFlagSet(importFlags)
+
+	bundleCmd := &cobra.Command{
+		Use:   "bundle <dir>",
+		Short: "Package the operator and the manifests into an OLM bundle, for clusters without the CVO",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			return writeBundle(args[0])
+		},
+	}
+	bundleCmd.Flags().AddFlagSet(bundleFlags)
+	if err := bundleCmd.MarkFlagRequired("version"); err != nil {
+		panic(err)
+	}
+
+	rootCmd.AddCommand(
+		importCmd,
+		bundleCmd,
+		diffCmd,
+		verifyCmd,
+		renderCmd,
+		&cobra.Command{
+			Use:   "list",
+			Short: "List the providers with their pinned versions",
+			Args:  cobra.NoArgs,
+			RunE: func(_ *cobra.Command, _ []string) error {
+				return listProviders(os.Stdout)
+			},
+		},
+		&cobra.Command{
+			Use:   "download <dir>",
+			Short: "Save the release files of all the providers to import them later with --offline",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(_ *cobra.Command, args []string) error {
+				return downloadProviders(args[0])
+			},
+		},
+	)
+}
+
+func setup(_ *cobra.Command, _ []string) error {
+	if *certMode != certModeServiceCA && *certMode != certModeCertManager {
+		return errors.Errorf("invalid --cert-mode %q", *certMode)
+	}
+	switch *outputMode {
+	case outputConfigMaps, outputEmbed, outputKustomize:
+	default:
+		return errors.Errorf("invalid --output %q", *outputMode)
+	}
+	include := *providerPatterns
+	if *providerFilter != "" {
+		include = append(include, *providerFilter)
+	}
+	var err error
+	if selection, err = newProviderSelector(include, *skipProviders); err != nil {
+		return err
+	}
+	return configureTransport(*caBundle)
+}
+
+// importAll runs the whole import, or only the one of the providers selected with
+// --providers and --skip-providers.
+func importAll() error {
+	if selection.partial() {
+		return importProviders(selection)
+	}
+	for _, step := range []func() error{
+		importOperator,
+		moveRBACToManifests,
+		func() error { return importProviders(selection) },
+	} {
+		if err := step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verify regenerates everything from the pinned versions in memory and fails if the result
+// differs from the tree, e.g. because of manual edits that the next import would lose.
+func verify() error {
+	if *latest {
+		return errors.New("--latest can't be used with verify")
+	}
+	if selection.partial() {
+		return errors.New("--providers and --skip-providers can't be used with verify")
+	}
+	out.dryRun = true
+	if err := importAll(); err != nil {
+		return err
+	}
+	changed, err := out.printDiff(os.Stdout)
+	if err != nil {
+		return err
+	}
+	if changed {
+		return errors.New("the generated files differ from the tree, edit the import instead of the files and run make import-assets")
+	}
+	return nil
+}
+
+func listProviders(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "TYPE\tNAME\tVERSION\tOPTIONAL")
+	for _, p := range append([]provider{capiOperator}, providers...) {
+		if err := p.loadVersion(); err != nil {
+			return err
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%t\n", p.providerTypeName(), p.assetName(), p.version, p.optional)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/klog/v2"
+	clusterctlv1 "sigs.k8s.io/cluster-api/cmd/clusterctl/api/v1alpha3"
+	"sigs.k8s.io/yaml"
+)
+
+// contract returns the cluster-api contract, e.g. "v1beta1", implemented by the release
+// series of the imported version, as declared in the metadata.yaml of the release.
+func (p *provider) contract() (string, error) {
+	metadata := &clusterctlv1.Metadata{}
+	if err := yaml.Unmarshal(p.metadata, metadata); err != nil {
+		return "", errors.Wrapf(err, "invalid metadata.yaml of %s %s", p.assetName(), p.version)
+	}
+	v, err := version.ParseSemantic(p.version)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid version of %s", p.assetName())
+	}
+	releaseSeries := metadata.GetReleaseSeriesForVersion(v)
+	if releaseSeries == nil || releaseSeries.Contract == "" {
+		return "", errors.Errorf("metadata.yaml of %s %s has no contract for the %d.%d release series", p.assetName(), p.version, v.Major(), v.Minor())
+	}
+	return releaseSeries.Contract, nil
+}
+
+// coreContract returns the contract of the core provider version pinned for the import.
+func coreContract() (string, error) {
+	for _, p := range providers {
+		if p.ptype != clusterctlv1.CoreProviderType {
+			continue
+		}
+		if err := p.loadComponents(); err != nil {
+			return "", err
+		}
+		return p.contract()
+	}
+	return "", errors.New("no core provider to import")
+}
+
+// checkContract fails the import of a provider that implements another contract than the
+// core provider, the controllers wouldn't understand each other's objects at runtime.
+func (p *provider) checkContract(coreContract string) error {
+	contract, err := p.contract()
+	if err != nil {
+		return err
+	}
+	if contract == coreContract {
+		return nil
+	}
+	err = errors.Errorf("%s %s %s implements the %s contract, the core provider implements %s", p.ptype, p.assetName(), p.version, contract, coreContract)
+	if *allowContractMismatch {
+		klog.Warning(err)
+		return nil
+	}
+	return err
+}
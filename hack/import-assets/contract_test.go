@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+
+	clusterctlv1 "sigs.k8s.io/cluster-api/cmd/clusterctl/api/v1alpha3"
+)
+
+const contractTestMetadata = `apiVersion: clusterctl.cluster.x-k8s.io/v1alpha3
+kind: Metadata
+releaseSeries:
+- major: 1
+  minor: 0
+  contract: v1alpha4
+- major: 1
+  minor: 1
+  contract: v1beta1
+`
+
+func TestContract(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata string
+		version  string
+		want     string
+		wantErr  bool
+	}{
+		{
+			name:     "release series",
+			metadata: contractTestMetadata,
+			version:  "v1.1.3",
+			want:     "v1beta1",
+		},
+		{
+			name:     "older release series",
+			metadata: contractTestMetadata,
+			version:  "v1.0.5",
+			want:     "v1alpha4",
+		},
+		{
+			name:     "unknown release series",
+			metadata: contractTestMetadata,
+			version:  "v1.2.0",
+			wantErr:  true,
+		},
+		{
+			name:     "invalid version",
+			metadata: contractTestMetadata,
+			version:  "latest",
+			wantErr:  true,
+		},
+		{
+			name:     "invalid metadata",
+			metadata: "releaseSeries: v1beta1",
+			version:  "v1.1.3",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &provider{name: "aws", version: tt.version, metadata: []byte(tt.metadata)}
+			got, err := p.contract()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("contract() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("contract() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckContract(t *testing.T) {
+	defer func(allow bool) { *allowContractMismatch = allow }(*allowContractMismatch)
+
+	tests := []struct {
+		name         string
+		coreContract string
+		allow        bool
+		wantErr      bool
+	}{
+		{name: "same contract", coreContract: "v1beta1"},
+		{name: "other contract", coreContract: "v1alpha4", wantErr: true},
+		{name: "other contract allowed", coreContract: "v1alpha4", allow: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			*allowContractMismatch = tt.allow
+			p := &provider{name: "aws", ptype: clusterctlv1.InfrastructureProviderType, version: "v1.1.3", metadata: []byte(contractTestMetadata)}
+			if err := p.checkContract(tt.coreContract); (err != nil) != tt.wantErr {
+				t.Errorf("checkContract() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
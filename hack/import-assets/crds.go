@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"os"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+// Some CRDs are shipped by several providers, e.g. the ipam.cluster.x-k8s.io ones of the IPAM
+// contract, and CVO can't apply two manifests of the same CRD. The canonical copy is the one
+// of the provider first in the providers list, the core provider before the infrastructure
+// ones, the others skip the CRD.
+
+// sharedCRDOwner returns the provider before p in the providers list that has a manifest of
+// the CRD, nil when p owns the CRD. A different copy is only warned about, the owner's wins.
+func (p *provider) sharedCRDOwner(crd unstructured.Unstructured) (*provider, error) {
+	for i := range providers {
+		owner := &providers[i]
+		if owner.ptype == p.ptype && owner.assetName() == p.assetName() {
+			return nil, nil
+		}
+		b, err := out.readFile(owner.crdManifestFile(crd.GetName()))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		ownerCRD := map[string]interface{}{}
+		if err := yaml.Unmarshal(b, &ownerCRD); err != nil {
+			return nil, err
+		}
+		klog.V(2).Infof("the CRD %s of %s is shipped by %s-%s", crd.GetName(), p.assetName(), owner.providerTypeName(), owner.assetName())
+		// compare the marshalled specs, the numbers of the parsed manifest are float64
+		spec, err := yaml.Marshal(crd.Object["spec"])
+		if err != nil {
+			return nil, err
+		}
+		ownerSpec, err := yaml.Marshal(ownerCRD["spec"])
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(spec, ownerSpec) {
+			klog.Warningf("the CRD %s of %s differs from the one of %s-%s, which is kept", crd.GetName(), p.assetName(), owner.providerTypeName(), owner.assetName())
+		}
+		return owner, nil
+	}
+	return nil, nil
+}
+
+// takeCRDOwnership removes the manifests of the CRD of the providers after p in the providers
+// list, written before p shipped the CRD.
+func (p *provider) takeCRDOwnership(name string) error {
+	after := false
+	for i := range providers {
+		other := &providers[i]
+		if other.ptype == p.ptype && other.assetName() == p.assetName() {
+			after = true
+			continue
+		}
+		if !after {
+			continue
+		}
+		if _, err := out.readFile(other.crdManifestFile(name)); os.IsNotExist(err) {
+			continue
+		}
+		klog.Infof("the CRD %s moves from %s-%s to %s-%s", name, other.providerTypeName(), other.assetName(), p.providerTypeName(), p.assetName())
+		if err := out.remove(other.crdManifestFile(name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	clusterctlv1 "sigs.k8s.io/cluster-api/cmd/clusterctl/api/v1alpha3"
+)
+
+// credentialsRequestNamespace is where the cloud-credential-operator watches the CredentialsRequests.
+const credentialsRequestNamespace = "openshift-cloud-credential-operator"
+
+func (p *provider) credentialsRequestManifestFile() string {
+	return path.Join(manifestsPath, strings.ToLower("0000_30_cluster-api_"+p.providerTypeName()+"-"+p.assetName()+"_00_credentials-request.yaml"))
+}
+
+// writeCredentialsRequest writes the CredentialsRequest of an infrastructure provider with the
+// cloud permissions of its import config, the one of a previous import is removed when the
+// config has none.
+func (p *provider) writeCredentialsRequest(config providerConfig) error {
+	if p.ptype != clusterctlv1.InfrastructureProviderType || config.CredentialsRequest == nil {
+		if _, err := out.readFile(p.credentialsRequestManifestFile()); os.IsNotExist(err) {
+			return nil
+		}
+		return out.remove(p.credentialsRequestManifestFile())
+	}
+	cr := config.CredentialsRequest
+	if cr.SecretName == "" || len(cr.ProviderSpec) == 0 {
+		return errors.Errorf("the credentialsRequest of %s needs a secretName and a providerSpec", p.assetName())
+	}
+
+	obj := unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "cloudcredential.openshift.io/v1",
+		"kind":       "CredentialsRequest",
+		"metadata": map[string]interface{}{
+			"name":      *targetNamespace + "-" + p.assetName(),
+			"namespace": credentialsRequestNamespace,
+		},
+		"spec": map[string]interface{}{
+			"secretRef": map[string]interface{}{
+				"name":      cr.SecretName,
+				"namespace": *targetNamespace,
+			},
+			"providerSpec": cr.ProviderSpec,
+		},
+	}}
+	setOpenShiftAnnotations(obj, false)
+	// the CredentialsRequests were never included in the single node developer profile
+	anno := obj.GetAnnotations()
+	delete(anno, "include.release.openshift.io/single-node-developer")
+	obj.SetAnnotations(anno)
+	p.setProvenance(obj)
+	b, err := canonicalYAML(obj)
+	if err != nil {
+		return err
+	}
+	return out.writeFile(p.credentialsRequestManifestFile(), b)
+}
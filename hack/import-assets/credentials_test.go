@@ -0,0 +1,107 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	clusterctlv1 "sigs.k8s.io/cluster-api/cmd/clusterctl/api/v1alpha3"
+	utilyaml "sigs.k8s.io/cluster-api/util/yaml"
+)
+
+func TestWriteCredentialsRequest(t *testing.T) {
+	testPaths(t)
+	aws := &provider{name: "aws", ptype: clusterctlv1.InfrastructureProviderType, version: "v1.1.0"}
+	providerSpec := map[string]interface{}{
+		"apiVersion": "cloudcredential.openshift.io/v1",
+		"kind":       "AWSProviderSpec",
+	}
+
+	tests := []struct {
+		name      string
+		provider  *provider
+		config    providerConfig
+		previous  bool
+		wantWrite bool
+		wantErr   bool
+	}{
+		{
+			name:      "written",
+			provider:  aws,
+			config:    providerConfig{CredentialsRequest: &credentialsRequestConfig{SecretName: "capa-manager-bootstrap-credentials", ProviderSpec: providerSpec}},
+			wantWrite: true,
+		},
+		{
+			name:     "no secret name",
+			provider: aws,
+			config:   providerConfig{CredentialsRequest: &credentialsRequestConfig{ProviderSpec: providerSpec}},
+			wantErr:  true,
+		},
+		{
+			name:     "no provider spec",
+			provider: aws,
+			config:   providerConfig{CredentialsRequest: &credentialsRequestConfig{SecretName: "capa-manager-bootstrap-credentials"}},
+			wantErr:  true,
+		},
+		{
+			name:     "none",
+			provider: aws,
+		},
+		{
+			name:     "previous one removed",
+			provider: aws,
+			previous: true,
+		},
+		{
+			name:     "not an infrastructure provider",
+			provider: &provider{name: "cluster-api", ptype: clusterctlv1.CoreProviderType},
+			config:   providerConfig{CredentialsRequest: &credentialsRequestConfig{SecretName: "capi", ProviderSpec: providerSpec}},
+			previous: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := map[string][]byte{}
+			if tt.previous {
+				files[tt.provider.credentialsRequestManifestFile()] = []byte("previous")
+			}
+			testOutput(t, files)
+			err := tt.provider.writeCredentialsRequest(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("writeCredentialsRequest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			b, exists := out.files[tt.provider.credentialsRequestManifestFile()]
+			if exists := exists && b != nil; exists != tt.wantWrite {
+				t.Fatalf("%s written %v, want %v", tt.provider.credentialsRequestManifestFile(), exists, tt.wantWrite)
+			}
+			if !tt.wantWrite {
+				return
+			}
+
+			objs, err := utilyaml.ToUnstructured(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cr := objs[0]
+			if cr.GetName() != "openshift-cluster-api-aws" || cr.GetNamespace() != credentialsRequestNamespace {
+				t.Errorf("CredentialsRequest %s/%s, want %s/openshift-cluster-api-aws", cr.GetNamespace(), cr.GetName(), credentialsRequestNamespace)
+			}
+			if got, want := cr.Object["spec"], map[string]interface{}{
+				"secretRef":    map[string]interface{}{"name": "capa-manager-bootstrap-credentials", "namespace": "openshift-cluster-api"},
+				"providerSpec": providerSpec,
+			}; !reflect.DeepEqual(got, want) {
+				t.Errorf("spec %v, want %v", got, want)
+			}
+			anns := cr.GetAnnotations()
+			if _, ok := anns["include.release.openshift.io/single-node-developer"]; ok {
+				t.Errorf("annotations %v, want the CredentialsRequest out of the single node developer profile", anns)
+			}
+			if anns["include.release.openshift.io/self-managed-high-availability"] != "true" {
+				t.Errorf("annotations %v, want the CredentialsRequest in the payload", anns)
+			}
+			// the annotations of the other manifests are left alone
+			if _, ok := annotations["include.release.openshift.io/single-node-developer"]; !ok {
+				t.Errorf("the single node developer annotation was removed from the manifests annotations")
+			}
+		})
+	}
+}
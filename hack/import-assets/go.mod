@@ -3,12 +3,22 @@ module github.com/openshift/cluster-capi-operator/hack/import-assets
 go 1.16
 
 require (
+	github.com/blang/semver v3.5.1+incompatible
+	github.com/evanphx/json-patch v4.11.0+incompatible
+	github.com/google/go-containerregistry v0.6.0
+	github.com/google/go-github/v33 v33.0.0
 	github.com/jetstack/cert-manager v1.5.4
 	github.com/pkg/errors v0.9.1
+	github.com/pmezard/go-difflib v1.0.0
+	github.com/spf13/cobra v1.2.1
+	github.com/spf13/pflag v1.0.5
 	k8s.io/api v0.22.2
 	k8s.io/apiextensions-apiserver v0.22.2
 	k8s.io/apimachinery v0.22.2
 	k8s.io/client-go v0.22.2
+	k8s.io/klog/v2 v2.9.0
+	k8s.io/kube-openapi v0.0.0-20210527164424-3c818078ee3d
+	k8s.io/utils v0.0.0-20210819203725-bdf08cb9a70a
 	sigs.k8s.io/cluster-api v1.0.0
 	sigs.k8s.io/cluster-api/exp/operator v0.0.0-00010101000000-000000000000
 	sigs.k8s.io/controller-runtime v0.10.1
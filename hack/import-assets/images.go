@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	relatedImagePrefix = "RELATED_IMAGE_"
+	imagesManifestKey  = "images.json"
+)
+
+// imagesManifestFileName is the ConfigMap with the images the operator substitutes for the
+// RELATED_IMAGE placeholders, the payload images replace the upstream ones in it.
+var imagesManifestFileName = path.Join(projDir, "manifests", "0000_30_cluster-api_capi-operator_01_images.configmap.yaml")
+
+// relatedImageName returns the name of the image of a provider container, e.g.
+// RELATED_IMAGE_INFRASTRUCTURE_AWS_MANAGER.
+func (p *provider) relatedImageName(container string) string {
+	name := p.providerTypeName() + "_" + p.assetName() + "_" + container
+	return relatedImagePrefix + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(name))
+}
+
+// setRelatedImages replaces the images of the Deployment containers with ${RELATED_IMAGE_...}
+// placeholders substituted by the operator, the upstream images are returned by name, or the
+// payload images of payloadImages, by related image name or container name.
+func (p *provider) setRelatedImages(objs []unstructured.Unstructured, payloadImages map[string]string) ([]unstructured.Unstructured, map[string]string, error) {
+	images := map[string]string{}
+	upstream := map[string]string{}
+	var conflict error
+	objs, err := updateDeployments(objs, func(dep *appsv1.Deployment) {
+		setImages := func(containers []corev1.Container) {
+			for i, c := range containers {
+				name := p.relatedImageName(c.Name)
+				if isAzureServiceOperatorName(dep.Name) {
+					name = p.relatedImageName(asoImagePrefix + c.Name)
+				}
+				if image, ok := upstream[name]; ok && image != c.Image {
+					conflict = errors.Errorf("containers named %s have different images (%s, %s), rename one with a patch", c.Name, image, c.Image)
+				}
+				upstream[name] = c.Image
+				images[name] = c.Image
+				if image, ok := payloadImages[c.Name]; ok {
+					images[name] = image
+				}
+				if image, ok := payloadImages[name]; ok {
+					images[name] = image
+				}
+				containers[i].Image = "${" + name + "}"
+			}
+		}
+		setImages(dep.Spec.Template.Spec.InitContainers)
+		setImages(dep.Spec.Template.Spec.Containers)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if conflict != nil {
+		return nil, nil, conflict
+	}
+	return objs, images, nil
+}
+
+// readImagesManifest returns the images of the images ConfigMap manifest by name.
+func readImagesManifest() (map[string]string, error) {
+	b, err := out.readFile(imagesManifestFileName)
+	if err != nil {
+		return nil, err
+	}
+	cm := &corev1.ConfigMap{}
+	if err := yaml.Unmarshal(b, cm); err != nil {
+		return nil, errors.Wrapf(err, "invalid %s", imagesManifestFileName)
+	}
+	images := map[string]string{}
+	if err := json.Unmarshal([]byte(cm.Data[imagesManifestKey]), &images); err != nil {
+		return nil, errors.Wrapf(err, "invalid %s in %s", imagesManifestKey, imagesManifestFileName)
+	}
+	return images, nil
+}
+
+// writeImagesManifest updates the images of the images ConfigMap manifest, the other entries
+// (e.g. the operator image) are kept.
+func writeImagesManifest(images map[string]string) error {
+	b, err := out.readFile(imagesManifestFileName)
+	if err != nil {
+		return err
+	}
+	cm := &corev1.ConfigMap{}
+	if err := yaml.Unmarshal(b, cm); err != nil {
+		return errors.Wrapf(err, "invalid %s", imagesManifestFileName)
+	}
+	allImages := map[string]string{}
+	if err := json.Unmarshal([]byte(cm.Data[imagesManifestKey]), &allImages); err != nil {
+		return errors.Wrapf(err, "invalid %s in %s", imagesManifestKey, imagesManifestFileName)
+	}
+	for k, v := range images {
+		allImages[k] = v
+	}
+
+	jsonData, err := json.MarshalIndent(&allImages, "", "  ")
+	if err != nil {
+		return err
+	}
+	cm.Data[imagesManifestKey] = string(ensureNewLine(jsonData))
+	b, err = canonicalYAML(cm)
+	if err != nil {
+		return err
+	}
+	return out.writeFile(imagesManifestFileName, b)
+}
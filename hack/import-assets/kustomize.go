@@ -0,0 +1,177 @@
+package main
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	clusterctlv1 "sigs.k8s.io/cluster-api/cmd/clusterctl/api/v1alpha3"
+	"sigs.k8s.io/yaml"
+)
+
+// The layout of --output=kustomize in --kustomize-dir:
+//   - providers/<type>-<name>/ has the components of each provider,
+//   - base/ includes the providers that don't depend on the platform, e.g. the core provider,
+//   - overlays/<name>/ adds the infrastructure provider of a platform to the base,
+//   - components/single-replica/ is a kustomize component shaping the deployments for single
+//     node clusters.
+const (
+	kustomizationFileName       = "kustomization.yaml"
+	kustomizeComponentsFileName = "components.yaml"
+	kustomizeProvidersDir       = "providers"
+	kustomizeBaseDir            = "base"
+	kustomizeOverlaysDir        = "overlays"
+	singleReplicaComponentDir   = "components/single-replica"
+)
+
+type kustomization struct {
+	APIVersion string                 `json:"apiVersion"`
+	Kind       string                 `json:"kind"`
+	Metadata   *kustomizationMetadata `json:"metadata,omitempty"`
+	Resources  []string               `json:"resources,omitempty"`
+	Patches    []kustomizePatch       `json:"patches,omitempty"`
+}
+
+type kustomizationMetadata struct {
+	Name        string            `json:"name,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type kustomizePatch struct {
+	Target kustomizePatchTarget `json:"target"`
+	Patch  string               `json:"patch"`
+}
+
+type kustomizePatchTarget struct {
+	Kind string `json:"kind"`
+}
+
+func newKustomization(resources ...string) *kustomization {
+	return &kustomization{
+		APIVersion: "kustomize.config.k8s.io/v1beta1",
+		Kind:       "Kustomization",
+		Resources:  resources,
+	}
+}
+
+// kustomizeProviderDir is the directory of the components of the provider.
+func (p *provider) kustomizeProviderDir() string {
+	return path.Join(*kustomizePath, kustomizeProvidersDir, strings.ToLower(p.providerTypeName()+"-"+p.assetName()))
+}
+
+// kustomizeOverlayDir is the overlay of the platform of an infrastructure provider.
+func (p *provider) kustomizeOverlayDir() string {
+	return path.Join(*kustomizePath, kustomizeOverlaysDir, strings.ToLower(p.assetName()))
+}
+
+// writeKustomizeComponents writes the components of the provider with their kustomization,
+// and the overlay of the platform for an infrastructure provider.
+func (p *provider) writeKustomizeComponents(objs []unstructured.Unstructured) error {
+	combined, err := canonicalObjectsYAML(objs)
+	if err != nil {
+		return err
+	}
+	if err := out.writeFile(path.Join(p.kustomizeProviderDir(), kustomizeComponentsFileName), combined); err != nil {
+		return err
+	}
+
+	k := newKustomization(kustomizeComponentsFileName)
+	k.Metadata = &kustomizationMetadata{
+		Name:        p.providerTypeName() + "-" + p.assetName(),
+		Annotations: p.provenanceAnnotations(),
+	}
+	k.Metadata.Annotations["provider.cluster.x-k8s.io/version"] = p.version
+	if err := writeKustomization(p.kustomizeProviderDir(), k); err != nil {
+		return err
+	}
+
+	if p.ptype != clusterctlv1.InfrastructureProviderType {
+		return nil
+	}
+	providerDir, err := filepath.Rel(p.kustomizeOverlayDir(), p.kustomizeProviderDir())
+	if err != nil {
+		return err
+	}
+	baseDir, err := filepath.Rel(p.kustomizeOverlayDir(), path.Join(*kustomizePath, kustomizeBaseDir))
+	if err != nil {
+		return err
+	}
+	return writeKustomization(p.kustomizeOverlayDir(), newKustomization(baseDir, providerDir))
+}
+
+// readKustomizeVersion returns the version of the provider in its kustomization, for the
+// change report.
+func (p *provider) readKustomizeVersion() (string, error) {
+	b, err := out.readFile(path.Join(p.kustomizeProviderDir(), kustomizationFileName))
+	if err != nil {
+		return "", err
+	}
+	k := &kustomization{}
+	if err := yaml.Unmarshal(b, k); err != nil {
+		return "", err
+	}
+	if k.Metadata == nil {
+		return "", nil
+	}
+	return k.Metadata.Annotations["provider.cluster.x-k8s.io/version"], nil
+}
+
+// writeKustomizeBase writes the base with the providers that are not infrastructure ones,
+// found in the generated files so that an import of a single provider keeps the others, and
+// the single replica component.
+func writeKustomizeBase() error {
+	kustomizations, err := out.glob(path.Join(*kustomizePath, kustomizeProvidersDir, "*", kustomizationFileName))
+	if err != nil {
+		return err
+	}
+	baseDir := path.Join(*kustomizePath, kustomizeBaseDir)
+	resources := []string{}
+	for _, f := range kustomizations {
+		providerDir := filepath.Dir(f)
+		if strings.HasPrefix(filepath.Base(providerDir), "infrastructure-") {
+			continue
+		}
+		rel, err := filepath.Rel(baseDir, providerDir)
+		if err != nil {
+			return err
+		}
+		resources = append(resources, rel)
+	}
+	if err := writeKustomization(baseDir, newKustomization(resources...)); err != nil {
+		return err
+	}
+
+	return writeKustomization(path.Join(*kustomizePath, singleReplicaComponentDir), &kustomization{
+		APIVersion: "kustomize.config.k8s.io/v1alpha1",
+		Kind:       "Component",
+		Patches: []kustomizePatch{{
+			Target: kustomizePatchTarget{Kind: "Deployment"},
+			Patch:  singleReplicaPatch,
+		}},
+	})
+}
+
+// singleReplicaPatch does what singleReplicaVariant does to the deployments, the strategy
+// of a deployment retains only the keys of the patch.
+const singleReplicaPatch = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: all
+spec:
+  replicas: 1
+  strategy:
+    type: Recreate
+  template:
+    spec:
+      affinity:
+        podAntiAffinity: null
+`
+
+func writeKustomization(dir string, k *kustomization) error {
+	b, err := canonicalYAML(k)
+	if err != nil {
+		return err
+	}
+	return out.writeFile(path.Join(dir, kustomizationFileName), b)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"path"
+	"reflect"
+	"sort"
+	"testing"
+
+	clusterctlv1 "sigs.k8s.io/cluster-api/cmd/clusterctl/api/v1alpha3"
+	"sigs.k8s.io/yaml"
+)
+
+// readKustomization returns the kustomization written to dir.
+func readKustomization(t *testing.T, dir string) *kustomization {
+	b, err := out.readFile(path.Join(dir, kustomizationFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := &kustomization{}
+	if err := yaml.Unmarshal(b, k); err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestWriteKustomizeComponents(t *testing.T) {
+	testPaths(t)
+	tests := []struct {
+		name         string
+		provider     provider
+		want         []string
+		wantOverlay  []string
+		wantMetadata string
+	}{
+		{
+			name:         "core",
+			provider:     provider{name: "cluster-api", ptype: clusterctlv1.CoreProviderType, version: "v1.1.0"},
+			want:         []string{"providers/core-cluster-api/components.yaml", "providers/core-cluster-api/kustomization.yaml"},
+			wantMetadata: "core-cluster-api",
+		},
+		{
+			name:     "infrastructure",
+			provider: provider{name: "ibmcloud", flavor: "powervs", ptype: clusterctlv1.InfrastructureProviderType, version: "v0.2.0"},
+			want: []string{
+				"overlays/ibmcloud-powervs/kustomization.yaml",
+				"providers/infrastructure-ibmcloud-powervs/components.yaml",
+				"providers/infrastructure-ibmcloud-powervs/kustomization.yaml",
+			},
+			wantOverlay:  []string{"../../base", "../../providers/infrastructure-ibmcloud-powervs"},
+			wantMetadata: "infrastructure-ibmcloud-powervs",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testOutput(t, map[string][]byte{})
+			objs := testObjects(t, "apiVersion: v1\nkind: Namespace\nmetadata:\n  name: capi-system\n")
+			if err := tt.provider.writeKustomizeComponents(objs); err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for name := range out.written {
+				got = append(got, name)
+			}
+			sort.Strings(got)
+			want := []string{}
+			for _, name := range tt.want {
+				want = append(want, path.Join(*kustomizePath, name))
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("written %v, want %v", got, want)
+			}
+
+			k := readKustomization(t, tt.provider.kustomizeProviderDir())
+			if k.Metadata == nil || k.Metadata.Name != tt.wantMetadata || !reflect.DeepEqual(k.Resources, []string{kustomizeComponentsFileName}) {
+				t.Errorf("kustomization %+v, want %s with the components", k, tt.wantMetadata)
+			}
+			if version, err := tt.provider.readKustomizeVersion(); err != nil || version != tt.provider.version {
+				t.Errorf("readKustomizeVersion() = %q, %v, want %q", version, err, tt.provider.version)
+			}
+			if tt.wantOverlay != nil {
+				if k := readKustomization(t, tt.provider.kustomizeOverlayDir()); !reflect.DeepEqual(k.Resources, tt.wantOverlay) {
+					t.Errorf("overlay resources %v, want %v", k.Resources, tt.wantOverlay)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteKustomizeBase(t *testing.T) {
+	testPaths(t)
+	testOutput(t, map[string][]byte{})
+	// kept from a previous import of the providers
+	for _, p := range []provider{
+		{name: "cluster-api", ptype: clusterctlv1.CoreProviderType},
+		{name: "kubeadm", ptype: clusterctlv1.BootstrapProviderType},
+		{name: "aws", ptype: clusterctlv1.InfrastructureProviderType},
+	} {
+		if err := writeKustomization(p.kustomizeProviderDir(), newKustomization(kustomizeComponentsFileName)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := writeKustomizeBase(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"../providers/bootstrap-kubeadm", "../providers/core-cluster-api"}
+	if k := readKustomization(t, path.Join(*kustomizePath, kustomizeBaseDir)); !reflect.DeepEqual(k.Resources, want) {
+		t.Errorf("base resources %v, want %v", k.Resources, want)
+	}
+	component := readKustomization(t, path.Join(*kustomizePath, singleReplicaComponentDir))
+	if component.Kind != "Component" || len(component.Patches) != 1 || component.Patches[0].Target.Kind != "Deployment" {
+		t.Errorf("single replica component %+v, want a Deployment patch", component)
+	}
+}
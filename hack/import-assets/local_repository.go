@@ -0,0 +1,70 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/cluster-api/cmd/clusterctl/client/repository"
+)
+
+const fileScheme = "file://"
+
+// localRepository reads provider release files from disk, the url has the shape
+// file://<dir>/<components file>. The files are read from <dir>/<version>/ when that
+// exists (the clusterctl local repository layout), otherwise straight from <dir> so
+// that the output of a local provider build can be used directly.
+type localRepository struct {
+	basePath       string
+	componentsPath string
+}
+
+var _ repository.Repository = &localRepository{}
+
+func newLocalRepository(url string) (*localRepository, error) {
+	componentsFile := filepath.Clean(strings.TrimPrefix(url, fileScheme))
+	if !filepath.IsAbs(componentsFile) {
+		return nil, errors.Errorf("invalid local repository %q, the path must be absolute", url)
+	}
+	basePath := filepath.Dir(componentsFile)
+	if _, err := os.Stat(basePath); err != nil {
+		return nil, errors.Wrapf(err, "invalid local repository %q", url)
+	}
+	return &localRepository{basePath: basePath, componentsPath: filepath.Base(componentsFile)}, nil
+}
+
+func (r *localRepository) DefaultVersion() string {
+	return "latest"
+}
+
+func (r *localRepository) RootPath() string {
+	return ""
+}
+
+func (r *localRepository) ComponentsPath() string {
+	return r.componentsPath
+}
+
+func (r *localRepository) GetVersions() ([]string, error) {
+	fileInfo, err := ioutil.ReadDir(r.basePath)
+	if err != nil {
+		return nil, err
+	}
+	versions := []string{}
+	for _, fi := range fileInfo {
+		if fi.IsDir() {
+			versions = append(versions, fi.Name())
+		}
+	}
+	return versions, nil
+}
+
+func (r *localRepository) GetFile(version, fileName string) ([]byte, error) {
+	versionedFile := filepath.Join(r.basePath, version, fileName)
+	if _, err := os.Stat(versionedFile); err == nil {
+		return ioutil.ReadFile(filepath.Clean(versionedFile))
+	}
+	return ioutil.ReadFile(filepath.Clean(filepath.Join(r.basePath, fileName)))
+}
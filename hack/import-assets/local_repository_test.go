@@ -0,0 +1,108 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestNewLocalRepository(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name           string
+		url            string
+		wantBasePath   string
+		wantComponents string
+		wantErr        bool
+	}{
+		{
+			name:           "absolute",
+			url:            fileScheme + filepath.Join(dir, "infrastructure-components.yaml"),
+			wantBasePath:   dir,
+			wantComponents: "infrastructure-components.yaml",
+		},
+		{
+			name:    "relative",
+			url:     fileScheme + "out/infrastructure-components.yaml",
+			wantErr: true,
+		},
+		{
+			name:    "missing dir",
+			url:     fileScheme + filepath.Join(dir, "missing", "infrastructure-components.yaml"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, err := newLocalRepository(tt.url)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newLocalRepository() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if repo.basePath != tt.wantBasePath || repo.ComponentsPath() != tt.wantComponents {
+				t.Errorf("repository %s %s, want %s %s", repo.basePath, repo.ComponentsPath(), tt.wantBasePath, tt.wantComponents)
+			}
+		})
+	}
+}
+
+func TestLocalRepository(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		// the clusterctl local repository layout
+		"v1.0.0/metadata.yaml":                  "metadata v1.0.0",
+		"v1.0.0/infrastructure-components.yaml": "components v1.0.0",
+		"v1.1.0/metadata.yaml":                  "metadata v1.1.0",
+		// the output of a provider build
+		"metadata.yaml":                  "metadata",
+		"infrastructure-components.yaml": "components",
+	}
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0750); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	repo, err := newLocalRepository(fileScheme + filepath.Join(dir, "infrastructure-components.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	versions, err := repo.GetVersions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"v1.0.0", "v1.1.0"}; !reflect.DeepEqual(versions, want) {
+		t.Errorf("GetVersions() = %v, want %v", versions, want)
+	}
+
+	tests := []struct {
+		version  string
+		fileName string
+		want     string
+		wantErr  bool
+	}{
+		{version: "v1.0.0", fileName: "infrastructure-components.yaml", want: "components v1.0.0"},
+		{version: "v1.1.0", fileName: "metadata.yaml", want: "metadata v1.1.0"},
+		// missing from the version dir
+		{version: "v1.1.0", fileName: "infrastructure-components.yaml", want: "components"},
+		{version: repo.DefaultVersion(), fileName: "metadata.yaml", want: "metadata"},
+		{version: "v1.0.0", fileName: "cluster-template.yaml", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version+" "+tt.fileName, func(t *testing.T) {
+			b, err := repo.GetFile(tt.version, tt.fileName)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(b) != tt.want {
+				t.Errorf("GetFile() = %q, want %q", b, tt.want)
+			}
+		})
+	}
+}
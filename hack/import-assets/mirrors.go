@@ -0,0 +1,71 @@
+package main
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// mirrorRegistryPlaceholder is substituted with the mirror registry of a disconnected install.
+const mirrorRegistryPlaceholder = "${MIRROR_REGISTRY}"
+
+// mirrorSetFileName is a template, not a manifest, the CVO must not apply the placeholders.
+var mirrorSetFileName = path.Join(projDir, "assets", "image-digest-mirror-set.yaml")
+
+// writeMirrorSet writes the ImageDigestMirrorSet mirroring the repositories of the provider
+// images to the same path on the mirror registry, as oc mirror lays them out. It only
+// applies to the images pulled by digest, as the payload does.
+func writeMirrorSet() error {
+	images, err := readImagesManifest()
+	if err != nil {
+		return err
+	}
+	repositories := map[string]bool{}
+	for imageName, image := range images {
+		if !strings.HasPrefix(imageName, relatedImagePrefix) {
+			continue
+		}
+		ref, err := name.ParseReference(image)
+		if err != nil {
+			return errors.Wrapf(err, "invalid image %s of %s", image, imageName)
+		}
+		repositories[ref.Context().Name()] = true
+	}
+	sources := make([]string, 0, len(repositories))
+	for repository := range repositories {
+		sources = append(sources, repository)
+	}
+	sort.Strings(sources)
+
+	mirrors := []interface{}{}
+	for _, source := range sources {
+		ref, err := name.NewRepository(source)
+		if err != nil {
+			return err
+		}
+		mirrors = append(mirrors, map[string]interface{}{
+			"source":  source,
+			"mirrors": []interface{}{mirrorRegistryPlaceholder + "/" + ref.RepositoryStr()},
+		})
+	}
+
+	mirrorSet := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "config.openshift.io/v1",
+		"kind":       "ImageDigestMirrorSet",
+		"metadata": map[string]interface{}{
+			"name": "cluster-api",
+		},
+		"spec": map[string]interface{}{
+			"imageDigestMirrors": mirrors,
+		},
+	}}
+	b, err := canonicalYAML(mirrorSet)
+	if err != nil {
+		return err
+	}
+	return out.writeFile(mirrorSetFileName, b)
+}
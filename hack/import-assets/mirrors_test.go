@@ -0,0 +1,76 @@
+package main
+
+import (
+	"path"
+	"reflect"
+	"testing"
+
+	"sigs.k8s.io/yaml"
+)
+
+func TestWriteMirrorSet(t *testing.T) {
+	testPaths(t)
+	defer func(previous string) { mirrorSetFileName = previous }(mirrorSetFileName)
+	mirrorSetFileName = path.Join(t.TempDir(), "image-digest-mirror-set.yaml")
+
+	tests := []struct {
+		name    string
+		images  map[string]string
+		want    []interface{}
+		wantErr bool
+	}{
+		{
+			name:   "no provider image",
+			images: map[string]string{"cluster-capi-operator": "quay.io/openshift/cluster-capi-operator@sha256:0000000000000000000000000000000000000000000000000000000000000000"},
+			want:   []interface{}{},
+		},
+		{
+			name: "repositories sorted and deduplicated",
+			images: map[string]string{
+				"RELATED_IMAGE_INFRASTRUCTURE_AWS_MANAGER": "registry.k8s.io/cluster-api-aws/cluster-api-aws-controller:v1.1.0",
+				"RELATED_IMAGE_CORE_CLUSTER_API_MANAGER":   "quay.io/openshift/origin-cluster-api@sha256:1111111111111111111111111111111111111111111111111111111111111111",
+				"RELATED_IMAGE_BOOTSTRAP_KUBEADM_MANAGER":  "quay.io/openshift/origin-cluster-api@sha256:2222222222222222222222222222222222222222222222222222222222222222",
+				"RELATED_IMAGE_INFRASTRUCTURE_AWS_EKS":     "registry.k8s.io/cluster-api-aws/cluster-api-aws-controller:v1.1.0",
+				"cluster-capi-operator":                    "quay.io/openshift/cluster-capi-operator:latest",
+			},
+			want: []interface{}{
+				map[string]interface{}{
+					"source":  "quay.io/openshift/origin-cluster-api",
+					"mirrors": []interface{}{"${MIRROR_REGISTRY}/openshift/origin-cluster-api"},
+				},
+				map[string]interface{}{
+					"source":  "registry.k8s.io/cluster-api-aws/cluster-api-aws-controller",
+					"mirrors": []interface{}{"${MIRROR_REGISTRY}/cluster-api-aws/cluster-api-aws-controller"},
+				},
+			},
+		},
+		{
+			name:    "invalid image",
+			images:  map[string]string{"RELATED_IMAGE_INFRASTRUCTURE_AWS_MANAGER": "Not An Image"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testOutput(t, map[string][]byte{imagesManifestFileName: testImagesManifest(t, tt.images)})
+			err := writeMirrorSet()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("writeMirrorSet() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			mirrorSet := map[string]interface{}{}
+			if err := yaml.Unmarshal(out.files[mirrorSetFileName], &mirrorSet); err != nil {
+				t.Fatal(err)
+			}
+			if kind := mirrorSet["kind"]; kind != "ImageDigestMirrorSet" {
+				t.Errorf("kind %v, want ImageDigestMirrorSet", kind)
+			}
+			got := mirrorSet["spec"].(map[string]interface{})["imageDigestMirrors"]
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("imageDigestMirrors %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"os"
+	"path"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/klog/v2"
+)
+
+const (
+	servingCertAnnotation = "service.beta.openshift.io/serving-cert-secret-name"
+	// serviceCABundleFile is where cluster monitoring mounts the service CA bundle in prometheus.
+	serviceCABundleFile = "/etc/prometheus/configmaps/serving-certs-ca-bundle/service-ca.crt"
+	serviceAccountToken = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+)
+
+// metricsPortNames are the names of the Service ports serving metrics.
+var metricsPortNames = []string{"https", "metrics", "https-metrics"}
+
+// serviceMonitorManifestFile runs after the monitoring stack created the ServiceMonitor CRD.
+func (p *provider) serviceMonitorManifestFile() string {
+	return path.Join(manifestsPath, strings.ToLower("0000_90_cluster-api_"+p.providerTypeName()+"-"+p.assetName()+"_servicemonitor.yaml"))
+}
+
+// writeServiceMonitors writes a ServiceMonitor for each of the scraped Services of the
+// provider, the manifest of a previous import is removed when there are none.
+func (p *provider) writeServiceMonitors(services []unstructured.Unstructured) error {
+	monitors := []interface{}{}
+	for _, obj := range services {
+		monitor := serviceMonitor(obj, metricsPort(obj))
+		p.setProvenance(*monitor)
+		monitors = append(monitors, monitor)
+	}
+
+	if len(monitors) == 0 {
+		if _, err := out.readFile(p.serviceMonitorManifestFile()); os.IsNotExist(err) {
+			return nil
+		}
+		return out.remove(p.serviceMonitorManifestFile())
+	}
+	b, err := canonicalYAML(monitors...)
+	if err != nil {
+		return err
+	}
+	return out.writeFile(p.serviceMonitorManifestFile(), b)
+}
+
+// scrapedServices returns the metrics Services of the provider, the ones with a metrics port
+// and a serving certificate of the service CA, that cluster monitoring scrapes with TLS.
+// Without the service CA (--cert-mode cert-manager) there is no cluster monitoring to wire.
+func scrapedServices(objs []unstructured.Unstructured) []unstructured.Unstructured {
+	services := []unstructured.Unstructured{}
+	if *certMode != certModeServiceCA {
+		return services
+	}
+	for _, obj := range objs {
+		if obj.GetKind() != "Service" {
+			continue
+		}
+		if metricsPort(obj) == "" || obj.GetAnnotations()[servingCertAnnotation] == "" {
+			continue
+		}
+		if len(obj.GetLabels()) == 0 {
+			klog.Warningf("no ServiceMonitor for the metrics Service %s, it has no labels to select it", obj.GetName())
+			continue
+		}
+		services = append(services, obj)
+	}
+	return services
+}
+
+func metricsPort(service unstructured.Unstructured) string {
+	ports, _, _ := unstructured.NestedSlice(service.Object, "spec", "ports")
+	for _, port := range ports {
+		name, _, _ := unstructured.NestedString(port.(map[string]interface{}), "name")
+		if containsString(metricsPortNames, name) {
+			return name
+		}
+	}
+	return ""
+}
+
+func serviceMonitor(service unstructured.Unstructured, port string) *unstructured.Unstructured {
+	selector := map[string]interface{}{}
+	for k, v := range service.GetLabels() {
+		selector[k] = v
+	}
+	monitor := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "monitoring.coreos.com/v1",
+		"kind":       "ServiceMonitor",
+		"metadata": map[string]interface{}{
+			"name":      service.GetName(),
+			"namespace": *targetNamespace,
+		},
+		"spec": map[string]interface{}{
+			"endpoints": []interface{}{
+				map[string]interface{}{
+					"port":            port,
+					"scheme":          "https",
+					"interval":        "30s",
+					"bearerTokenFile": serviceAccountToken,
+					"tlsConfig": map[string]interface{}{
+						"caFile":     serviceCABundleFile,
+						"serverName": service.GetName() + "." + *targetNamespace + ".svc",
+					},
+				},
+			},
+			"namespaceSelector": map[string]interface{}{
+				"matchNames": []interface{}{*targetNamespace},
+			},
+			"selector": map[string]interface{}{
+				"matchLabels": selector,
+			},
+		},
+	}}
+	setOpenShiftAnnotations(*monitor, false)
+	return monitor
+}
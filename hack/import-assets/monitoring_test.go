@@ -0,0 +1,159 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	clusterctlv1 "sigs.k8s.io/cluster-api/cmd/clusterctl/api/v1alpha3"
+	utilyaml "sigs.k8s.io/cluster-api/util/yaml"
+)
+
+const monitoringTestComponents = `apiVersion: v1
+kind: Service
+metadata:
+  name: capa-metrics-service
+  labels:
+    control-plane: capa-controller-manager
+  annotations:
+    service.beta.openshift.io/serving-cert-secret-name: capa-metrics-service-cert
+spec:
+  ports:
+  - name: https
+    port: 8443
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: capa-webhook-service
+  annotations:
+    service.beta.openshift.io/serving-cert-secret-name: capa-webhook-service-cert
+spec:
+  ports:
+  - port: 443
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: capa-plain-metrics-service
+  labels:
+    control-plane: capa-controller-manager
+spec:
+  ports:
+  - name: metrics
+    port: 8080
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: capa-unlabeled-metrics-service
+  annotations:
+    service.beta.openshift.io/serving-cert-secret-name: capa-unlabeled-metrics-service-cert
+spec:
+  ports:
+  - name: https-metrics
+    port: 8443
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: capa-controller-manager
+`
+
+func TestScrapedServices(t *testing.T) {
+	defer func(mode string) { *certMode = mode }(*certMode)
+	tests := []struct {
+		certMode string
+		want     []string
+	}{
+		{certMode: certModeServiceCA, want: []string{"capa-metrics-service"}},
+		{certMode: certModeCertManager, want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.certMode, func(t *testing.T) {
+			*certMode = tt.certMode
+			got := []string{}
+			for _, obj := range scrapedServices(testObjects(t, monitoringTestComponents)) {
+				got = append(got, obj.GetName())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("scrapedServices() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetricsPort(t *testing.T) {
+	want := []string{"https", "", "metrics", "https-metrics", ""}
+	for i, obj := range testObjects(t, monitoringTestComponents) {
+		t.Run(obj.GetName(), func(t *testing.T) {
+			if got := metricsPort(obj); got != want[i] {
+				t.Errorf("metricsPort() = %q, want %q", got, want[i])
+			}
+		})
+	}
+}
+
+func TestWriteServiceMonitors(t *testing.T) {
+	testPaths(t)
+	p := &provider{name: "aws", ptype: clusterctlv1.InfrastructureProviderType, version: "v1.1.0"}
+	tests := []struct {
+		name      string
+		services  int
+		previous  bool
+		wantWrite bool
+	}{
+		{name: "written", services: 1, wantWrite: true},
+		{name: "none"},
+		{name: "previous one removed", previous: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := map[string][]byte{}
+			if tt.previous {
+				files[p.serviceMonitorManifestFile()] = []byte("previous")
+			}
+			testOutput(t, files)
+			if err := p.writeServiceMonitors(testObjects(t, monitoringTestComponents)[:tt.services]); err != nil {
+				t.Fatal(err)
+			}
+			b, exists := out.files[p.serviceMonitorManifestFile()]
+			if exists := exists && b != nil; exists != tt.wantWrite {
+				t.Fatalf("%s written %v, want %v", p.serviceMonitorManifestFile(), exists, tt.wantWrite)
+			}
+			if !tt.wantWrite {
+				return
+			}
+
+			objs, err := utilyaml.ToUnstructured(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			monitor := objs[0]
+			if monitor.GetKind() != "ServiceMonitor" || monitor.GetName() != "capa-metrics-service" || monitor.GetNamespace() != "openshift-cluster-api" {
+				t.Errorf("%s %s/%s, want the ServiceMonitor openshift-cluster-api/capa-metrics-service", monitor.GetKind(), monitor.GetNamespace(), monitor.GetName())
+			}
+			want := map[string]interface{}{
+				"endpoints": []interface{}{
+					map[string]interface{}{
+						"port":            "https",
+						"scheme":          "https",
+						"interval":        "30s",
+						"bearerTokenFile": serviceAccountToken,
+						"tlsConfig": map[string]interface{}{
+							"caFile":     serviceCABundleFile,
+							"serverName": "capa-metrics-service.openshift-cluster-api.svc",
+						},
+					},
+				},
+				"namespaceSelector": map[string]interface{}{"matchNames": []interface{}{"openshift-cluster-api"}},
+				"selector":          map[string]interface{}{"matchLabels": map[string]interface{}{"control-plane": "capa-controller-manager"}},
+			}
+			if got := monitor.Object["spec"]; !reflect.DeepEqual(got, want) {
+				t.Errorf("spec %v, want %v", got, want)
+			}
+			if monitor.GetAnnotations()[upstreamVersionAnnotation] != "v1.1.0" {
+				t.Errorf("annotations %v, want the provenance of the provider", monitor.GetAnnotations())
+			}
+		})
+	}
+}
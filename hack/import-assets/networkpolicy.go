@@ -0,0 +1,271 @@
+package main
+
+import (
+	"os"
+	"path"
+	"strings"
+
+	admissionregistration "k8s.io/api/admissionregistration/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	clusterctlv1 "sigs.k8s.io/cluster-api/cmd/clusterctl/api/v1alpha3"
+)
+
+const (
+	// apiServerPort is where the pods reach the kube-apiserver, on the host network of the
+	// control plane nodes, so it can't be selected by namespace.
+	apiServerPort = 6443
+	// dnsPort is where the openshift-dns pods serve DNS.
+	dnsPort = 5353
+	// defaultCloudAPIPort is the egress of the infrastructure providers without cloudAPIPorts.
+	defaultCloudAPIPort = 443
+)
+
+// The allowed traffic is from these namespaces, by their kubernetes.io/metadata.name label.
+const (
+	monitoringNamespace = "openshift-monitoring"
+	dnsNamespace        = "openshift-dns"
+)
+
+// networkPolicyManifestFile follows the RBAC of the provider, the namespace default deny is
+// manifests/0000_30_cluster-api_capi-operator_05_networkpolicy.yaml.
+func (p *provider) networkPolicyManifestFile() string {
+	return path.Join(manifestsPath, strings.ToLower("0000_30_cluster-api_"+p.providerTypeName()+"-"+p.assetName()+"_04_networkpolicy.yaml"))
+}
+
+// writeNetworkPolicies writes a NetworkPolicy for each Deployment of the provider, allowing
+// on top of the default deny of the namespace:
+//   - the ingress to the webhooks of the provider, from the kube-apiserver,
+//   - the ingress to the metrics of the scraped Services, from cluster monitoring,
+//   - the egress to the kube-apiserver and DNS, and to the cloud APIs for an infrastructure
+//     provider.
+//
+// The manifest of a previous import is removed when there is no Deployment.
+func (p *provider) writeNetworkPolicies(objs []unstructured.Unstructured, scraped []unstructured.Unstructured, config providerConfig) error {
+	cloudAPIPorts := []int32{}
+	if p.ptype == clusterctlv1.InfrastructureProviderType {
+		cloudAPIPorts = config.CloudAPIPorts
+		if len(cloudAPIPorts) == 0 {
+			cloudAPIPorts = []int32{defaultCloudAPIPort}
+		}
+	}
+	webhookServices, err := webhookServicePorts(objs)
+	if err != nil {
+		return err
+	}
+
+	policies := []interface{}{}
+	for _, obj := range objs {
+		if obj.GetKind() != "Deployment" {
+			continue
+		}
+		dep := &appsv1.Deployment{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, dep); err != nil {
+			return err
+		}
+
+		ingress := []interface{}{}
+		for _, obj := range objs {
+			if obj.GetKind() != "Service" {
+				continue
+			}
+			service, err := toService(obj)
+			if err != nil {
+				return err
+			}
+			if !selectsPods(service, dep.Spec.Template.Labels) {
+				continue
+			}
+			for _, port := range service.Spec.Ports {
+				if !containsInt32(webhookServices[service.Name], port.Port) {
+					continue
+				}
+				// the kube-apiserver is on the host network, it can't be selected
+				ingress = append(ingress, map[string]interface{}{
+					"ports": []interface{}{networkPolicyPort(targetPort(service, port))},
+				})
+			}
+		}
+		for _, obj := range scraped {
+			service, err := toService(obj)
+			if err != nil {
+				return err
+			}
+			if !selectsPods(service, dep.Spec.Template.Labels) {
+				continue
+			}
+			for _, port := range service.Spec.Ports {
+				if port.Name != metricsPort(obj) {
+					continue
+				}
+				ingress = append(ingress, map[string]interface{}{
+					"from":  []interface{}{namespacePeer(monitoringNamespace)},
+					"ports": []interface{}{networkPolicyPort(targetPort(service, port))},
+				})
+			}
+		}
+
+		egress := []interface{}{
+			map[string]interface{}{
+				"ports": []interface{}{networkPolicyPort(int64(apiServerPort))},
+			},
+			map[string]interface{}{
+				"to": []interface{}{namespacePeer(dnsNamespace)},
+				"ports": []interface{}{
+					networkPolicyPort(int64(dnsPort)),
+					map[string]interface{}{"protocol": "UDP", "port": int64(dnsPort)},
+				},
+			},
+		}
+		for _, port := range cloudAPIPorts {
+			egress = append(egress, map[string]interface{}{
+				"ports": []interface{}{networkPolicyPort(int64(port))},
+			})
+		}
+
+		spec := map[string]interface{}{
+			"podSelector": map[string]interface{}{
+				"matchLabels": stringMapToInterface(dep.Spec.Selector.MatchLabels),
+			},
+			"policyTypes": []interface{}{"Ingress", "Egress"},
+			"egress":      egress,
+		}
+		if len(ingress) > 0 {
+			spec["ingress"] = ingress
+		}
+		policy := unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "networking.k8s.io/v1",
+			"kind":       "NetworkPolicy",
+			"metadata": map[string]interface{}{
+				"name":      dep.Name,
+				"namespace": *targetNamespace,
+			},
+			"spec": spec,
+		}}
+		setOpenShiftAnnotations(policy, false)
+		p.setProvenance(policy)
+		policies = append(policies, policy)
+	}
+
+	if len(policies) == 0 {
+		if _, err := out.readFile(p.networkPolicyManifestFile()); os.IsNotExist(err) {
+			return nil
+		}
+		return out.remove(p.networkPolicyManifestFile())
+	}
+	b, err := canonicalYAML(policies...)
+	if err != nil {
+		return err
+	}
+	return out.writeFile(p.networkPolicyManifestFile(), b)
+}
+
+// webhookServicePorts returns the ports of the Services called by the admission and the CRD
+// conversion webhooks, by Service name.
+func webhookServicePorts(objs []unstructured.Unstructured) (map[string][]int32, error) {
+	ports := map[string][]int32{}
+	add := func(name string, port *int32) {
+		number := int32(443)
+		if port != nil {
+			number = *port
+		}
+		for _, p := range ports[name] {
+			if p == number {
+				return
+			}
+		}
+		ports[name] = append(ports[name], number)
+	}
+
+	for _, obj := range objs {
+		switch obj.GetKind() {
+		case "MutatingWebhookConfiguration":
+			webhooks := &admissionregistration.MutatingWebhookConfiguration{}
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, webhooks); err != nil {
+				return nil, err
+			}
+			for _, webhook := range webhooks.Webhooks {
+				if service := webhook.ClientConfig.Service; service != nil {
+					add(service.Name, service.Port)
+				}
+			}
+		case "ValidatingWebhookConfiguration":
+			webhooks := &admissionregistration.ValidatingWebhookConfiguration{}
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, webhooks); err != nil {
+				return nil, err
+			}
+			for _, webhook := range webhooks.Webhooks {
+				if service := webhook.ClientConfig.Service; service != nil {
+					add(service.Name, service.Port)
+				}
+			}
+		case "CustomResourceDefinition":
+			crd := &apiextensionsv1.CustomResourceDefinition{}
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, crd); err != nil {
+				return nil, err
+			}
+			conversion := crd.Spec.Conversion
+			if conversion != nil && conversion.Webhook != nil && conversion.Webhook.ClientConfig != nil && conversion.Webhook.ClientConfig.Service != nil {
+				add(conversion.Webhook.ClientConfig.Service.Name, conversion.Webhook.ClientConfig.Service.Port)
+			}
+		}
+	}
+	return ports, nil
+}
+
+func selectsPods(service *corev1.Service, podLabels map[string]string) bool {
+	return len(service.Spec.Selector) > 0 && labels.SelectorFromSet(service.Spec.Selector).Matches(labels.Set(podLabels))
+}
+
+// targetPort returns the container port behind the Service port, a number or a port name.
+func targetPort(service *corev1.Service, port corev1.ServicePort) interface{} {
+	switch {
+	case port.TargetPort.Type == intstr.String:
+		return port.TargetPort.StrVal
+	case port.TargetPort.IntVal != 0:
+		return int64(port.TargetPort.IntVal)
+	}
+	return int64(port.Port)
+}
+
+func networkPolicyPort(port interface{}) map[string]interface{} {
+	return map[string]interface{}{"protocol": "TCP", "port": port}
+}
+
+func namespacePeer(namespace string) map[string]interface{} {
+	return map[string]interface{}{
+		"namespaceSelector": map[string]interface{}{
+			"matchLabels": map[string]interface{}{"kubernetes.io/metadata.name": namespace},
+		},
+	}
+}
+
+func toService(obj unstructured.Unstructured) (*corev1.Service, error) {
+	service := &corev1.Service{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, service); err != nil {
+		return nil, err
+	}
+	return service, nil
+}
+
+func stringMapToInterface(m map[string]string) map[string]interface{} {
+	result := map[string]interface{}{}
+	for k, v := range m {
+		result[k] = v
+	}
+	return result
+}
+
+func containsInt32(list []int32, i int32) bool {
+	for _, item := range list {
+		if item == i {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	clusterctlv1 "sigs.k8s.io/cluster-api/cmd/clusterctl/api/v1alpha3"
+	utilyaml "sigs.k8s.io/cluster-api/util/yaml"
+	"sigs.k8s.io/yaml"
+)
+
+const networkPolicyTestComponents = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: capa-controller-manager
+spec:
+  selector:
+    matchLabels:
+      control-plane: capa-controller-manager
+  template:
+    metadata:
+      labels:
+        control-plane: capa-controller-manager
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: capa-webhook-service
+spec:
+  selector:
+    control-plane: capa-controller-manager
+  ports:
+  - port: 443
+    targetPort: webhook-server
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: capa-metrics-service
+spec:
+  selector:
+    control-plane: capa-controller-manager
+  ports:
+  - name: https
+    port: 8443
+---
+apiVersion: admissionregistration.k8s.io/v1
+kind: ValidatingWebhookConfiguration
+metadata:
+  name: capa-validating-webhook-configuration
+webhooks:
+- name: validation.awscluster.infrastructure.cluster.x-k8s.io
+  clientConfig:
+    service:
+      name: capa-webhook-service
+      namespace: capa-system
+---
+apiVersion: admissionregistration.k8s.io/v1
+kind: MutatingWebhookConfiguration
+metadata:
+  name: capa-mutating-webhook-configuration
+webhooks:
+- name: default.awscluster.infrastructure.cluster.x-k8s.io
+  clientConfig:
+    service:
+      name: capa-other-webhook-service
+      namespace: capa-system
+      port: 9443
+---
+apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: awsclusters.infrastructure.cluster.x-k8s.io
+spec:
+  conversion:
+    strategy: Webhook
+    webhook:
+      clientConfig:
+        service:
+          name: capa-webhook-service
+          namespace: capa-system
+          port: 443
+`
+
+func TestWebhookServicePorts(t *testing.T) {
+	got, err := webhookServicePorts(testObjects(t, networkPolicyTestComponents))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]int32{"capa-webhook-service": {443}, "capa-other-webhook-service": {9443}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("webhookServicePorts() = %v, want %v", got, want)
+	}
+}
+
+func TestTargetPort(t *testing.T) {
+	tests := []struct {
+		name string
+		port corev1.ServicePort
+		want interface{}
+	}{
+		{name: "service port", port: corev1.ServicePort{Port: 443}, want: int64(443)},
+		{name: "number", port: corev1.ServicePort{Port: 443, TargetPort: intstr.FromInt(9443)}, want: int64(9443)},
+		{name: "name", port: corev1.ServicePort{Port: 443, TargetPort: intstr.FromString("webhook-server")}, want: "webhook-server"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := targetPort(&corev1.Service{}, tt.port); got != tt.want {
+				t.Errorf("targetPort() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteNetworkPolicies(t *testing.T) {
+	testPaths(t)
+	aws := &provider{name: "aws", ptype: clusterctlv1.InfrastructureProviderType, version: "v1.1.0"}
+	apiServer := map[string]interface{}{"ports": []interface{}{map[string]interface{}{"protocol": "TCP", "port": int64(6443)}}}
+	dns := map[string]interface{}{
+		"to": []interface{}{map[string]interface{}{"namespaceSelector": map[string]interface{}{"matchLabels": map[string]interface{}{"kubernetes.io/metadata.name": "openshift-dns"}}}},
+		"ports": []interface{}{
+			map[string]interface{}{"protocol": "TCP", "port": int64(5353)},
+			map[string]interface{}{"protocol": "UDP", "port": int64(5353)},
+		},
+	}
+	cloudAPI := func(port int64) map[string]interface{} {
+		return map[string]interface{}{"ports": []interface{}{map[string]interface{}{"protocol": "TCP", "port": port}}}
+	}
+	webhooks := map[string]interface{}{"ports": []interface{}{map[string]interface{}{"protocol": "TCP", "port": "webhook-server"}}}
+	metrics := map[string]interface{}{
+		"from":  []interface{}{map[string]interface{}{"namespaceSelector": map[string]interface{}{"matchLabels": map[string]interface{}{"kubernetes.io/metadata.name": "openshift-monitoring"}}}},
+		"ports": []interface{}{map[string]interface{}{"protocol": "TCP", "port": int64(8443)}},
+	}
+
+	tests := []struct {
+		name        string
+		provider    *provider
+		components  string
+		scraped     bool
+		config      providerConfig
+		previous    bool
+		wantIngress []interface{}
+		wantEgress  []interface{}
+	}{
+		{
+			name:        "infrastructure",
+			provider:    aws,
+			components:  networkPolicyTestComponents,
+			wantIngress: []interface{}{webhooks},
+			wantEgress:  []interface{}{apiServer, dns, cloudAPI(443)},
+		},
+		{
+			name:        "scraped",
+			provider:    aws,
+			components:  networkPolicyTestComponents,
+			scraped:     true,
+			wantIngress: []interface{}{webhooks, metrics},
+			wantEgress:  []interface{}{apiServer, dns, cloudAPI(443)},
+		},
+		{
+			name:        "cloud API ports",
+			provider:    aws,
+			components:  networkPolicyTestComponents,
+			config:      providerConfig{CloudAPIPorts: []int32{443, 8443}},
+			wantIngress: []interface{}{webhooks},
+			wantEgress:  []interface{}{apiServer, dns, cloudAPI(443), cloudAPI(8443)},
+		},
+		{
+			name:        "core",
+			provider:    &provider{name: "cluster-api", ptype: clusterctlv1.CoreProviderType},
+			components:  networkPolicyTestComponents,
+			config:      providerConfig{CloudAPIPorts: []int32{8443}},
+			wantIngress: []interface{}{webhooks},
+			wantEgress:  []interface{}{apiServer, dns},
+		},
+		{
+			name:       "no ingress",
+			provider:   aws,
+			components: networkPolicyTestComponents[:strings.Index(networkPolicyTestComponents, "---")],
+			wantEgress: []interface{}{apiServer, dns, cloudAPI(443)},
+		},
+		{
+			name:       "previous one removed",
+			provider:   aws,
+			components: "apiVersion: v1\nkind: Namespace\nmetadata:\n  name: capa-system\n",
+			previous:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := map[string][]byte{}
+			if tt.previous {
+				files[tt.provider.networkPolicyManifestFile()] = []byte("previous")
+			}
+			testOutput(t, files)
+			objs := testObjects(t, tt.components)
+			scraped := []unstructured.Unstructured{}
+			if tt.scraped {
+				scraped = objs[2:3]
+			}
+			if err := tt.provider.writeNetworkPolicies(objs, scraped, tt.config); err != nil {
+				t.Fatal(err)
+			}
+			b, exists := out.files[tt.provider.networkPolicyManifestFile()]
+			if exists := exists && b != nil; exists != (tt.wantEgress != nil) {
+				t.Fatalf("%s written %v, want %v", tt.provider.networkPolicyManifestFile(), exists, tt.wantEgress != nil)
+			}
+			if tt.wantEgress == nil {
+				return
+			}
+
+			policies, err := utilyaml.ToUnstructured(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			policy := policies[0]
+			if policy.GetName() != "capa-controller-manager" || policy.GetNamespace() != "openshift-cluster-api" {
+				t.Errorf("NetworkPolicy %s/%s, want openshift-cluster-api/capa-controller-manager", policy.GetNamespace(), policy.GetName())
+			}
+			// the ports are parsed back as float64, compare them as YAML
+			want := map[string]interface{}{
+				"podSelector": map[string]interface{}{"matchLabels": map[string]interface{}{"control-plane": "capa-controller-manager"}},
+				"policyTypes": []interface{}{"Ingress", "Egress"},
+				"egress":      tt.wantEgress,
+			}
+			if tt.wantIngress != nil {
+				want["ingress"] = tt.wantIngress
+			}
+			wantYAML, err := yaml.Marshal(want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotYAML, err := yaml.Marshal(policy.Object["spec"])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(gotYAML) != string(wantYAML) {
+				t.Errorf("spec\n%s\nwant\n%s", gotYAML, wantYAML)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/pkg/errors"
+	"sigs.k8s.io/cluster-api/cmd/clusterctl/client/repository"
+)
+
+const (
+	ociScheme = "oci://"
+	// ociTitleAnnotation is set by oras on each layer to the name of the pushed file.
+	ociTitleAnnotation = "org.opencontainers.image.title"
+)
+
+// ociRepository reads provider release files from an OCI artifact, the url has the same
+// shape as the GitHub ones: oci://<registry>/<repository>/<components file>, with one
+// artifact tagged per release containing components and metadata.yaml as layers.
+type ociRepository struct {
+	repository     name.Repository
+	componentsPath string
+}
+
+var _ repository.Repository = &ociRepository{}
+
+func newOCIRepository(url string) (*ociRepository, error) {
+	ref := strings.TrimPrefix(url, ociScheme)
+	repo, err := name.NewRepository(path.Dir(ref))
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid OCI repository %q", url)
+	}
+	return &ociRepository{repository: repo, componentsPath: path.Base(ref)}, nil
+}
+
+func (r *ociRepository) DefaultVersion() string {
+	return "latest"
+}
+
+func (r *ociRepository) RootPath() string {
+	return ""
+}
+
+func (r *ociRepository) ComponentsPath() string {
+	return r.componentsPath
+}
+
+func (r *ociRepository) GetVersions() ([]string, error) {
+	return remote.List(r.repository, remote.WithAuthFromKeychain(authn.DefaultKeychain))
+}
+
+func (r *ociRepository) GetFile(version, fileName string) ([]byte, error) {
+	ref := r.repository.Tag(version)
+	desc, err := remote.Get(ref, remote.WithAuthFromKeychain(authn.DefaultKeychain))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get %s", ref)
+	}
+	manifest, err := v1.ParseManifest(bytes.NewReader(desc.Manifest))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse manifest of %s", ref)
+	}
+
+	for _, l := range manifest.Layers {
+		if l.Annotations[ociTitleAnnotation] != fileName {
+			continue
+		}
+		layer, err := remote.Layer(r.repository.Digest(l.Digest.String()), remote.WithAuthFromKeychain(authn.DefaultKeychain))
+		if err != nil {
+			return nil, err
+		}
+		rc, err := layer.Compressed()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return ioutil.ReadAll(rc)
+	}
+	return nil, fmt.Errorf("%s has no layer titled %q", ref, fileName)
+}
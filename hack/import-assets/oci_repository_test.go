@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// rawLayer is a layer stored as is, like the files oras pushes.
+type rawLayer []byte
+
+func (l rawLayer) Digest() (v1.Hash, error) {
+	h, _, err := v1.SHA256(bytes.NewReader(l))
+	return h, err
+}
+func (l rawLayer) DiffID() (v1.Hash, error) { return l.Digest() }
+func (l rawLayer) Compressed() (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewReader(l)), nil
+}
+func (l rawLayer) Uncompressed() (io.ReadCloser, error) { return l.Compressed() }
+func (l rawLayer) Size() (int64, error)                 { return int64(len(l)), nil }
+func (l rawLayer) MediaType() (types.MediaType, error) {
+	return "application/vnd.cncf.cluster-api.file", nil
+}
+
+// pushArtifact pushes the files as an artifact tagged ref, with the layer titles oras sets.
+func pushArtifact(t *testing.T, ref string, files map[string]string) {
+	img := empty.Image
+	for fileName, content := range files {
+		var err error
+		img, err = mutate.Append(img, mutate.Addendum{
+			Layer:       rawLayer(content),
+			Annotations: map[string]string{ociTitleAnnotation: fileName},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	tag, err := name.NewTag(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Write(tag, img); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewOCIRepository(t *testing.T) {
+	tests := []struct {
+		url            string
+		wantRepository string
+		wantComponents string
+		wantErr        bool
+	}{
+		{
+			url:            "oci://quay.io/openshift/capa/infrastructure-components.yaml",
+			wantRepository: "quay.io/openshift/capa",
+			wantComponents: "infrastructure-components.yaml",
+		},
+		{
+			url:            "oci://localhost:5000/capa/infrastructure-components-powervs.yaml",
+			wantRepository: "localhost:5000/capa",
+			wantComponents: "infrastructure-components-powervs.yaml",
+		},
+		{
+			url:     "oci://quay.io/Openshift/capa/infrastructure-components.yaml",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			repo, err := newOCIRepository(tt.url)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newOCIRepository() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := repo.repository.Name(); got != tt.wantRepository {
+				t.Errorf("repository %q, want %q", got, tt.wantRepository)
+			}
+			if got := repo.ComponentsPath(); got != tt.wantComponents {
+				t.Errorf("ComponentsPath() = %q, want %q", got, tt.wantComponents)
+			}
+		})
+	}
+}
+
+func TestOCIRepository(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+	pushArtifact(t, host+"/capa:v1.0.0", map[string]string{
+		"metadata.yaml":                  "metadata",
+		"infrastructure-components.yaml": "components",
+	})
+	pushArtifact(t, host+"/capa:v1.1.0", map[string]string{"metadata.yaml": "metadata v1.1.0"})
+
+	repo, err := newOCIRepository(ociScheme + host + "/capa/infrastructure-components.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	versions, err := repo.GetVersions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"v1.0.0", "v1.1.0"}; !reflect.DeepEqual(versions, want) {
+		t.Errorf("GetVersions() = %v, want %v", versions, want)
+	}
+
+	tests := []struct {
+		version  string
+		fileName string
+		want     string
+		wantErr  bool
+	}{
+		{version: "v1.0.0", fileName: "infrastructure-components.yaml", want: "components"},
+		{version: "v1.0.0", fileName: "metadata.yaml", want: "metadata"},
+		{version: "v1.1.0", fileName: "metadata.yaml", want: "metadata v1.1.0"},
+		{version: "v1.1.0", fileName: "infrastructure-components.yaml", wantErr: true},
+		{version: "v2.0.0", fileName: "metadata.yaml", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version+" "+tt.fileName, func(t *testing.T) {
+			b, err := repo.GetFile(tt.version, tt.fileName)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(b) != tt.want {
+				t.Errorf("GetFile() = %q, want %q", b, tt.want)
+			}
+		})
+	}
+}
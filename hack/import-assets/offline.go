@@ -0,0 +1,182 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+	configclient "sigs.k8s.io/cluster-api/cmd/clusterctl/client/config"
+	"sigs.k8s.io/cluster-api/cmd/clusterctl/client/repository"
+)
+
+// offlineDir is where the --offline release files are read from, once any tarball has been extracted.
+var offlineDir string
+
+// extractedOfflineDir is the temporary directory the --offline tarball was extracted to.
+var extractedOfflineDir string
+
+// offlineProviderDir is the directory of a provider in the download layout:
+// <dir>/<type>-<name>/<version>/{components,metadata.yaml}
+func (p *provider) offlineProviderDir(dir string) string {
+	return filepath.Join(dir, p.providerTypeName()+"-"+p.assetName())
+}
+
+func (p *provider) offlineRepository(providerConfig configclient.Provider) (repository.Repository, error) {
+	if offlineDir == "" {
+		dir, err := extractOffline(*offline)
+		if err != nil {
+			return nil, err
+		}
+		offlineDir = dir
+	}
+
+	// keep the upstream components file name, the flavor is added by componentsPath
+	componentsFile := path.Base(providerConfig.URL())
+	return newLocalRepository(fileScheme + filepath.Join(p.offlineProviderDir(offlineDir), componentsFile))
+}
+
+// extractOffline returns the directory to read from, extracting src first when it is a tarball
+// to a temporary directory removed by cleanupOffline.
+func extractOffline(src string) (string, error) {
+	src, err := filepath.Abs(src)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasSuffix(src, ".tar.gz") && !strings.HasSuffix(src, ".tgz") {
+		return src, nil
+	}
+
+	dir, err := ioutil.TempDir("", "import-assets-")
+	if err != nil {
+		return "", err
+	}
+	if err := extractTarball(src, dir); err != nil {
+		if err := os.RemoveAll(dir); err != nil {
+			klog.Warningf("failed to remove %s: %v", dir, err)
+		}
+		return "", err
+	}
+	extractedOfflineDir = dir
+	return dir, nil
+}
+
+func extractTarball(src, dir string) error {
+	f, err := os.Open(filepath.Clean(src))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read %s", src)
+	}
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to read %s", src)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		target := filepath.Join(dir, filepath.Clean("/"+hdr.Name)) // no escaping dir
+		if err := os.MkdirAll(filepath.Dir(target), 0750); err != nil {
+			return err
+		}
+		b, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(target, b, 0600); err != nil {
+			return err
+		}
+	}
+}
+
+// cleanupOffline removes the directory the --offline tarball was extracted to, if any.
+func cleanupOffline() {
+	if extractedOfflineDir == "" {
+		return
+	}
+	if err := os.RemoveAll(extractedOfflineDir); err != nil {
+		klog.Warningf("failed to remove %s: %v", extractedOfflineDir, err)
+	}
+	extractedOfflineDir = ""
+}
+
+// download saves the release files of the provider into dir using the layout read by --offline.
+func (p *provider) download(dir string) error {
+	configClient, err := configclient.New("")
+	if err != nil {
+		return err
+	}
+
+	_, repo, err := p.openRepository(configClient)
+	if err != nil {
+		return err
+	}
+
+	versionDir := filepath.Join(p.offlineProviderDir(dir), p.version)
+	if err := os.MkdirAll(versionDir, 0750); err != nil {
+		return err
+	}
+	policy, err := p.loadSignaturePolicy()
+	if err != nil {
+		return err
+	}
+	for _, fileName := range []string{"metadata.yaml", p.componentsPath(repo)} {
+		b, err := p.getFile(repo, fileName)
+		if err != nil {
+			return errors.Wrapf(err, "failed to download %s %s", p.assetName(), fileName)
+		}
+		if err := os.WriteFile(filepath.Join(versionDir, fileName), b, 0600); err != nil {
+			return err
+		}
+
+		// keep the signatures so they can be verified again offline
+		if policy == nil {
+			continue
+		}
+		sigFiles := []string{fileName + ".sig"}
+		if policy.Key == "" {
+			sigFiles = append(sigFiles, fileName+".pem")
+		}
+		for _, sigFile := range sigFiles {
+			b, err := repo.GetFile(p.version, sigFile)
+			if err != nil {
+				return errors.Wrapf(err, "failed to download %s %s", p.assetName(), sigFile)
+			}
+			if err := os.WriteFile(filepath.Join(versionDir, sigFile), b, 0600); err != nil {
+				return err
+			}
+		}
+	}
+	klog.V(1).Infof("downloaded %s %s %s", p.ptype, p.assetName(), p.version)
+	return nil
+}
+
+// downloadProviders saves the release files of all the providers and the upstream operator,
+// so that the import can be run in a restricted network with --offline.
+func downloadProviders(dir string) error {
+	if *offline != "" {
+		return errors.New("--offline can't be used with download")
+	}
+	all := append([]provider{capiOperator}, providers...)
+	for i := range all {
+		if err := all[i].download(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	clusterctlv1 "sigs.k8s.io/cluster-api/cmd/clusterctl/api/v1alpha3"
+	configclient "sigs.k8s.io/cluster-api/cmd/clusterctl/client/config"
+)
+
+// writeTarball writes a .tar.gz with the entries, regular files by name, to dir.
+func writeTarball(t *testing.T, dir, name string, entries []*tar.Header, content map[string]string) string {
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gz)
+	for _, hdr := range entries {
+		if hdr.Typeflag == tar.TypeReg {
+			hdr.Size = int64(len(content[hdr.Name]))
+			hdr.Mode = 0600
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content[hdr.Name])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	tarball := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(tarball, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return tarball
+}
+
+func TestExtractOffline(t *testing.T) {
+	content := map[string]string{
+		"infrastructure-aws/v1.0.0/metadata.yaml": "metadata",
+		"../escape.yaml": "escape",
+	}
+	tests := []struct {
+		name      string
+		src       func(dir string) string
+		extracted bool
+		want      map[string]string
+		wantErr   bool
+	}{
+		{
+			name: "directory",
+			src:  func(dir string) string { return dir },
+		},
+		{
+			name: "tarball",
+			src: func(dir string) string {
+				return writeTarball(t, dir, "providers.tar.gz", []*tar.Header{
+					{Name: "infrastructure-aws/", Typeflag: tar.TypeDir},
+					{Name: "infrastructure-aws/v1.0.0/metadata.yaml", Typeflag: tar.TypeReg},
+					{Name: "infrastructure-aws/latest", Typeflag: tar.TypeSymlink, Linkname: "v1.0.0"},
+				}, content)
+			},
+			extracted: true,
+			want:      map[string]string{"infrastructure-aws/v1.0.0/metadata.yaml": "metadata"},
+		},
+		{
+			name: "entry out of the tarball dir",
+			src: func(dir string) string {
+				return writeTarball(t, dir, "providers.tgz", []*tar.Header{
+					{Name: "../escape.yaml", Typeflag: tar.TypeReg},
+				}, content)
+			},
+			extracted: true,
+			want:      map[string]string{"escape.yaml": "escape"},
+		},
+		{
+			name: "invalid tarball",
+			src: func(dir string) string {
+				tarball := filepath.Join(dir, "providers.tar.gz")
+				if err := ioutil.WriteFile(tarball, []byte("not gzip"), 0600); err != nil {
+					t.Fatal(err)
+				}
+				return tarball
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer cleanupOffline()
+			src := tt.src(t.TempDir())
+			dir, err := extractOffline(src)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("extractOffline() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if extractedOfflineDir != "" {
+					t.Errorf("the extracted dir %s is kept after a failure", extractedOfflineDir)
+				}
+				return
+			}
+			if !tt.extracted {
+				if dir != src {
+					t.Errorf("extractOffline() = %s, want the directory %s", dir, src)
+				}
+				return
+			}
+			if dir != extractedOfflineDir {
+				t.Errorf("extractOffline() = %s, not removed by cleanupOffline", dir)
+			}
+			if got := readCacheDir(t, dir); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("extracted %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOfflineRepository(t *testing.T) {
+	defer func(dir string) { offlineDir = dir }(offlineDir)
+	offlineDir = t.TempDir()
+	versionDir := filepath.Join(offlineDir, "infrastructure-ibmcloud-powervs", "v0.2.0")
+	files := map[string]string{
+		"metadata.yaml":                          "metadata",
+		"infrastructure-components-powervs.yaml": "components",
+	}
+	if err := os.MkdirAll(versionDir, 0750); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(versionDir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	powervs := &provider{name: "ibmcloud", flavor: "powervs", ptype: clusterctlv1.InfrastructureProviderType}
+	providerConfig := configclient.NewProvider("ibmcloud", "https://github.com/kubernetes-sigs/cluster-api-provider-ibmcloud/releases/latest/infrastructure-components.yaml", clusterctlv1.InfrastructureProviderType)
+	repo, err := powervs.offlineRepository(providerConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range files {
+		if name != "metadata.yaml" {
+			name = powervs.componentsPath(repo)
+		}
+		b, err := repo.GetFile("v0.2.0", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != want {
+			t.Errorf("%s = %q, want %q", name, b, want)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"path"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/klog/v2"
+	clusterctlv1 "sigs.k8s.io/cluster-api/cmd/clusterctl/api/v1alpha3"
+)
+
+// capiOperator is the upstream cluster-api-operator, it is not a clusterctl provider but its
+// release components are loaded the same way, at the version of provider-versions.json. A
+// development build can be imported from a fork with the "core-cluster-api-operator"
+// repository override.
+var capiOperator = provider{
+	name:  "cluster-api-operator",
+	url:   "https://github.com/kubernetes-sigs/cluster-api-operator/releases/latest/operator-components.yaml",
+	ptype: clusterctlv1.CoreProviderType,
+}
+
+const (
+	// operatorNamePrefix and operatorLabel are the namePrefix and commonLabels of the kustomize
+	// build the operator assets were generated with before the release components: the
+	// manifests refer to the prefixed names and the operator prunes its operands by the label.
+	operatorNamePrefix = "capi-operator-"
+	operatorLabel      = "clusterctl.cluster.x-k8s.io/core"
+	operatorLabelValue = "capi-operator"
+)
+
+// operatorAssetFileName follows the kustomize naming, e.g. "apps_v1_deployment_capi-operator-controller-manager.yaml"
+func operatorAssetFileName(obj unstructured.Unstructured) string {
+	gv := strings.ReplaceAll(obj.GetAPIVersion(), "/", "_")
+	return strings.ToLower(gv + "_" + obj.GetKind() + "_" + obj.GetName() + ".yaml")
+}
+
+func writeOperatorAssets(objs []unstructured.Unstructured) error {
+	// the directory is fully generated, so remove what is there from the previous import
+	oldAssets, err := out.glob(path.Join(assetsDir, "*.yaml"))
+	if err != nil {
+		return err
+	}
+	for _, oldAsset := range oldAssets {
+		if err := out.remove(oldAsset); err != nil {
+			return err
+		}
+	}
+
+	for _, obj := range objs {
+		b, err := canonicalYAML(obj)
+		if err != nil {
+			return err
+		}
+		fName := operatorAssetFileName(obj)
+		if err := out.writeFile(path.Join(assetsDir, fName), b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// kustomizeOperator prefixes the names of the operator components with operatorNamePrefix,
+// but the Namespace and the CRDs, and labels them with operatorLabel, as the kustomize build
+// did. The references to the renamed objects are updated, the names already prefixed are
+// kept. The auth proxy patch of the kustomize build isn't applied, the metrics are served as
+// configured by the metrics of import-config.yaml.
+func kustomizeOperator(objs []unstructured.Unstructured) ([]unstructured.Unstructured, error) {
+	renamed := map[string]map[string]string{}
+	for i := range objs {
+		obj := &objs[i]
+		labels := obj.GetLabels()
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		labels[operatorLabel] = operatorLabelValue
+		obj.SetLabels(labels)
+
+		switch obj.GetKind() {
+		case "Namespace", "CustomResourceDefinition":
+			continue
+		}
+		if strings.HasPrefix(obj.GetName(), operatorNamePrefix) {
+			continue
+		}
+		if renamed[obj.GetKind()] == nil {
+			renamed[obj.GetKind()] = map[string]string{}
+		}
+		renamed[obj.GetKind()][obj.GetName()] = operatorNamePrefix + obj.GetName()
+		obj.SetName(operatorNamePrefix + obj.GetName())
+	}
+
+	for i := range objs {
+		obj := &objs[i]
+		var err error
+		switch obj.GetKind() {
+		case "Deployment":
+			err = renameField(obj.Object, renamed["ServiceAccount"], "spec", "template", "spec", "serviceAccountName")
+			for _, fields := range [][]string{{"spec", "selector", "matchLabels"}, {"spec", "template", "metadata", "labels"}} {
+				if err == nil {
+					err = unstructured.SetNestedField(obj.Object, operatorLabelValue, append(fields, operatorLabel)...)
+				}
+			}
+		case "RoleBinding", "ClusterRoleBinding":
+			kind, _, _ := unstructured.NestedString(obj.Object, "roleRef", "kind")
+			err = renameField(obj.Object, renamed[kind], "roleRef", "name")
+			if err == nil {
+				err = updateListItems(obj, "subjects", func(subject map[string]interface{}) error {
+					if subject["kind"] != "ServiceAccount" {
+						return nil
+					}
+					return renameField(subject, renamed["ServiceAccount"], "name")
+				})
+			}
+		case "MutatingWebhookConfiguration", "ValidatingWebhookConfiguration":
+			err = updateListItems(obj, "webhooks", func(webhook map[string]interface{}) error {
+				return renameField(webhook, renamed["Service"], "clientConfig", "service", "name")
+			})
+		case "CustomResourceDefinition":
+			err = renameField(obj.Object, renamed["Service"], "spec", "conversion", "webhook", "clientConfig", "service", "name")
+		case "Certificate":
+			err = renameDNSNames(obj, renamed["Service"])
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return objs, nil
+}
+
+func importOperator() error {
+	config, err := loadImportConfig(*importConfigFile)
+	if err != nil {
+		return err
+	}
+
+	p := capiOperator
+	if err := p.loadComponents(); err != nil {
+		return err
+	}
+	klog.Infof("importing %s %s", p.name, p.version)
+
+	objs, err := kustomizeOperator(p.components.Objs())
+	if err != nil {
+		return err
+	}
+	objs, err = convertCertificates(objs)
+	if err != nil {
+		return err
+	}
+	objs, err = stripKubeRBACProxy(objs, config.metricsFor(&p))
+	if err != nil {
+		return err
+	}
+	objs, err = setPodAnnotations(objs, config.PodAnnotations)
+	if err != nil {
+		return err
+	}
+	objs, err = restrictPodSecurity(objs, config.RestrictedPodSecurity)
+	if err != nil {
+		return err
+	}
+	if err := validateObjects(objs); err != nil {
+		return err
+	}
+	p.setProvenance(objs...)
+	objs, images, err := p.setRelatedImages(objs, config.PayloadImages)
+	if err != nil {
+		return err
+	}
+	if err := writeImagesManifest(images); err != nil {
+		return err
+	}
+	if err := p.writeNetworkPolicies(objs, scrapedServices(objs), providerConfig{}); err != nil {
+		return err
+	}
+	return writeOperatorAssets(objs)
+}
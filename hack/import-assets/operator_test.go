@@ -0,0 +1,154 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	utilyaml "sigs.k8s.io/cluster-api/util/yaml"
+)
+
+const operatorTestComponents = `apiVersion: v1
+kind: Namespace
+metadata:
+  name: openshift-cluster-api
+---
+apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: coreproviders.operator.cluster.x-k8s.io
+spec:
+  conversion:
+    webhook:
+      clientConfig:
+        service:
+          name: webhook-service
+---
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: controller-manager
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: manager-role
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: manager-rolebinding
+roleRef:
+  kind: ClusterRole
+  name: manager-role
+subjects:
+- kind: ServiceAccount
+  name: controller-manager
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: webhook-service
+---
+apiVersion: admissionregistration.k8s.io/v1
+kind: ValidatingWebhookConfiguration
+metadata:
+  name: validating-webhook-configuration
+webhooks:
+- clientConfig:
+    service:
+      name: webhook-service
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: controller-manager
+spec:
+  selector:
+    matchLabels:
+      control-plane: controller-manager
+  template:
+    metadata:
+      labels:
+        control-plane: controller-manager
+    spec:
+      serviceAccountName: controller-manager
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: capi-operator-metrics-service
+`
+
+func TestKustomizeOperator(t *testing.T) {
+	objs, err := utilyaml.ToUnstructured([]byte(operatorTestComponents))
+	if err != nil {
+		t.Fatal(err)
+	}
+	objs, err = kustomizeOperator(objs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		kind   string
+		fields []string
+		want   string
+	}{
+		{kind: "Namespace", fields: []string{"metadata", "name"}, want: "openshift-cluster-api"},
+		{kind: "CustomResourceDefinition", fields: []string{"metadata", "name"}, want: "coreproviders.operator.cluster.x-k8s.io"},
+		{kind: "CustomResourceDefinition", fields: []string{"spec", "conversion", "webhook", "clientConfig", "service", "name"}, want: "capi-operator-webhook-service"},
+		{kind: "ServiceAccount", fields: []string{"metadata", "name"}, want: "capi-operator-controller-manager"},
+		{kind: "ClusterRole", fields: []string{"metadata", "name"}, want: "capi-operator-manager-role"},
+		{kind: "ClusterRoleBinding", fields: []string{"roleRef", "name"}, want: "capi-operator-manager-role"},
+		{kind: "Deployment", fields: []string{"metadata", "name"}, want: "capi-operator-controller-manager"},
+		{kind: "Deployment", fields: []string{"spec", "template", "spec", "serviceAccountName"}, want: "capi-operator-controller-manager"},
+		{kind: "Deployment", fields: []string{"spec", "selector", "matchLabels", operatorLabel}, want: operatorLabelValue},
+		{kind: "Deployment", fields: []string{"spec", "template", "metadata", "labels", operatorLabel}, want: operatorLabelValue},
+	}
+	for _, tt := range tests {
+		obj := findKind(t, objs, tt.kind)
+		got, _, err := unstructured.NestedString(obj.Object, tt.fields...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%s %v = %q, want %q", tt.kind, tt.fields, got, tt.want)
+		}
+	}
+
+	binding := findKind(t, objs, "ClusterRoleBinding")
+	subjects, _, _ := unstructured.NestedSlice(binding.Object, "subjects")
+	if name := subjects[0].(map[string]interface{})["name"]; name != "capi-operator-controller-manager" {
+		t.Errorf("ClusterRoleBinding subject %v, want capi-operator-controller-manager", name)
+	}
+	webhook := findKind(t, objs, "ValidatingWebhookConfiguration")
+	webhooks, _, _ := unstructured.NestedSlice(webhook.Object, "webhooks")
+	if name, _, _ := unstructured.NestedString(webhooks[0].(map[string]interface{}), "clientConfig", "service", "name"); name != "capi-operator-webhook-service" {
+		t.Errorf("webhook service %v, want capi-operator-webhook-service", name)
+	}
+
+	names := []string{}
+	for _, obj := range objs {
+		if obj.GetKind() == "Service" {
+			names = append(names, obj.GetName())
+		}
+		if obj.GetLabels()[operatorLabel] != operatorLabelValue {
+			t.Errorf("%s %s isn't labelled %s", obj.GetKind(), obj.GetName(), operatorLabel)
+		}
+	}
+	// the names already prefixed are kept
+	if want := []string{"capi-operator-webhook-service", "capi-operator-metrics-service"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Services %v, want %v", names, want)
+	}
+}
+
+func findKind(t *testing.T, objs []unstructured.Unstructured, kind string) unstructured.Unstructured {
+	for _, obj := range objs {
+		if obj.GetKind() == kind {
+			return obj
+		}
+	}
+	t.Fatalf("no %s", kind)
+	return unstructured.Unstructured{}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"k8s.io/klog/v2"
+)
+
+// The output modes of the provider components.
+const (
+	// outputConfigMaps writes the components ConfigMaps and the provider objects read by the
+	// upstream operator to assets/providers.
+	outputConfigMaps = "configmaps"
+	// outputEmbed writes the components to a Go package that embeds them, see registryGoFile.
+	outputEmbed = "embed"
+	// outputKustomize writes the components as a kustomize base and per platform overlays.
+	outputKustomize = "kustomize"
+)
+
+// output is where the generated files are written. With the diff command they are kept in memory,
+// read back from there by the later steps, and diffed against the files on disk at the end.
+type output struct {
+	dryRun bool
+	// files are the generated files by path, nil when the file is removed
+	files map[string][]byte
+	// written are the files written by this run, dry or not
+	written map[string]bool
+}
+
+var out = &output{files: map[string][]byte{}, written: map[string]bool{}}
+
+func (o *output) writeFile(name string, b []byte) error {
+	klog.V(2).Infof("writing %s", name)
+	o.written[filepath.Clean(name)] = true
+	if !o.dryRun {
+		if err := os.MkdirAll(filepath.Dir(name), 0750); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(name, b, 0600)
+	}
+	o.files[filepath.Clean(name)] = b
+	return nil
+}
+
+func (o *output) readFile(name string) ([]byte, error) {
+	if b, ok := o.files[filepath.Clean(name)]; ok {
+		if b == nil {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+		}
+		return b, nil
+	}
+	return ioutil.ReadFile(filepath.Clean(name))
+}
+
+func (o *output) remove(name string) error {
+	delete(o.written, filepath.Clean(name))
+	if !o.dryRun {
+		return os.Remove(name)
+	}
+	o.files[filepath.Clean(name)] = nil
+	return nil
+}
+
+// glob returns the files matching the pattern, including the ones generated in memory.
+func (o *output) glob(pattern string) ([]string, error) {
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	files := map[string]bool{}
+	for _, m := range matches {
+		files[filepath.Clean(m)] = true
+	}
+	for name, b := range o.files {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			files[name] = b != nil
+		}
+	}
+	result := []string{}
+	for name, exists := range files {
+		if exists {
+			result = append(result, name)
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// printDiff writes the unified diff of the generated files against the ones on disk and
+// returns whether there is any difference.
+func (o *output) printDiff(w io.Writer) (bool, error) {
+	names := make([]string, 0, len(o.files))
+	for name := range o.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	changed := 0
+	for _, name := range names {
+		current, err := ioutil.ReadFile(filepath.Clean(name))
+		if err != nil && !os.IsNotExist(err) {
+			return false, err
+		}
+		generated := o.files[name]
+		if string(current) == string(generated) {
+			continue
+		}
+		changed++
+
+		displayName := name
+		if rel, err := filepath.Rel(projDir, name); err == nil {
+			displayName = rel
+		}
+		fromFile, toFile := "a/"+displayName, "b/"+displayName
+		if current == nil {
+			fromFile = "/dev/null"
+		}
+		if generated == nil {
+			toFile = "/dev/null"
+		}
+		diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        splitLines(current),
+			B:        splitLines(generated),
+			FromFile: fromFile,
+			ToFile:   toFile,
+			Context:  3,
+		})
+		if err != nil {
+			return false, err
+		}
+		fmt.Fprint(w, diff)
+	}
+	if changed == 0 {
+		fmt.Fprintln(w, "no changes")
+	}
+	return changed > 0, nil
+}
+
+func splitLines(b []byte) []string {
+	if len(b) == 0 {
+		return nil
+	}
+	return difflib.SplitLines(string(b))
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	utilyaml "sigs.k8s.io/cluster-api/util/yaml"
+	"sigs.k8s.io/yaml"
+)
+
+// json6902Suffix marks the patch files holding RFC 6902 operations instead of a strategic merge patch.
+const json6902Suffix = ".json6902.yaml"
+
+// json6902Patch is an ordered list of RFC 6902 operations applied to the object selected by target.
+type json6902Patch struct {
+	Target json6902Target    `json:"target"`
+	Patch  []json.RawMessage `json:"patch"`
+}
+
+type json6902Target struct {
+	Group string `json:"group,omitempty"`
+	Kind  string `json:"kind"`
+	Name  string `json:"name"`
+}
+
+func (t json6902Target) matches(obj unstructured.Unstructured) bool {
+	return t.Group == obj.GroupVersionKind().Group && t.Kind == obj.GetKind() && t.Name == obj.GetName()
+}
+
+// patchFiles returns the patch files of the provider, <patches-dir>/<type>-<name>/*.yaml,
+// sorted so they are always applied in the same order.
+func (p *provider) patchFiles() ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(*patchesDir, p.providerTypeName()+"-"+p.assetName(), "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// applyPatches applies the provider patches to the matching objects, so downstream changes
+// survive the regeneration of the assets. Files ending with .json6902.yaml hold RFC 6902
+// operations, the others strategic merge patches identifying the object they apply to with
+// their apiVersion, kind and metadata.name. A patch that doesn't match anything is an
+// error, as it most likely means that upstream renamed the object.
+func (p *provider) applyPatches(objs []unstructured.Unstructured) ([]unstructured.Unstructured, error) {
+	files, err := p.patchFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range files {
+		b, err := ioutil.ReadFile(filepath.Clean(f))
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasSuffix(f, json6902Suffix) {
+			err = p.applyJSON6902Patch(objs, b)
+		} else {
+			err = p.applyStrategicMergePatches(objs, b)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid patch %s", f)
+		}
+	}
+	return objs, nil
+}
+
+func (p *provider) applyStrategicMergePatches(objs []unstructured.Unstructured, b []byte) error {
+	patches, err := utilyaml.ToUnstructured(b)
+	if err != nil {
+		return err
+	}
+
+	for _, patch := range patches {
+		applied := false
+		for i := range objs {
+			if patch.GetAPIVersion() != objs[i].GetAPIVersion() || patch.GetKind() != objs[i].GetKind() || patch.GetName() != objs[i].GetName() {
+				continue
+			}
+			patched, err := strategicMergePatch(objs[i], patch)
+			if err != nil {
+				return errors.Wrapf(err, "failed to patch %s %s", patch.GetKind(), patch.GetName())
+			}
+			objs[i] = patched
+			applied = true
+		}
+		if !applied {
+			return errors.Errorf("%s: patch for %s %s doesn't match any object", p.assetName(), patch.GetKind(), patch.GetName())
+		}
+	}
+	return nil
+}
+
+func (p *provider) applyJSON6902Patch(objs []unstructured.Unstructured, b []byte) error {
+	patch := json6902Patch{}
+	if err := yaml.UnmarshalStrict(b, &patch); err != nil {
+		return err
+	}
+	ops, err := json.Marshal(patch.Patch)
+	if err != nil {
+		return err
+	}
+	decoded, err := jsonpatch.DecodePatch(ops)
+	if err != nil {
+		return err
+	}
+
+	applied := false
+	for i := range objs {
+		if !patch.Target.matches(objs[i]) {
+			continue
+		}
+		original, err := json.Marshal(objs[i].Object)
+		if err != nil {
+			return err
+		}
+		patched, err := decoded.Apply(original)
+		if err != nil {
+			return errors.Wrapf(err, "failed to patch %s %s", patch.Target.Kind, patch.Target.Name)
+		}
+		result := unstructured.Unstructured{}
+		if err := json.Unmarshal(patched, &result.Object); err != nil {
+			return err
+		}
+		objs[i] = result
+		applied = true
+	}
+	if !applied {
+		return errors.Errorf("%s: patch for %s %s doesn't match any object", p.assetName(), patch.Target.Kind, patch.Target.Name)
+	}
+	return nil
+}
+
+// strategicMergePatch uses a strategic merge patch for the types known to the scheme and
+// falls back to a JSON merge patch for the others (e.g. CRs).
+func strategicMergePatch(obj, patch unstructured.Unstructured) (unstructured.Unstructured, error) {
+	original, err := json.Marshal(obj.Object)
+	if err != nil {
+		return obj, err
+	}
+	patchJSON, err := json.Marshal(patch.Object)
+	if err != nil {
+		return obj, err
+	}
+
+	var patched []byte
+	if typed, err := scheme.New(obj.GroupVersionKind()); err == nil {
+		patched, err = strategicpatch.StrategicMergePatch(original, patchJSON, typed)
+		if err != nil {
+			return obj, err
+		}
+	} else {
+		patched, err = jsonpatch.MergePatch(original, patchJSON)
+		if err != nil {
+			return obj, err
+		}
+	}
+
+	result := unstructured.Unstructured{}
+	return result, json.Unmarshal(patched, &result.Object)
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	clusterctlv1 "sigs.k8s.io/cluster-api/cmd/clusterctl/api/v1alpha3"
+)
+
+const patchesTestComponents = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: capa-controller-manager
+spec:
+  template:
+    spec:
+      containers:
+      - name: manager
+        args:
+        - --leader-elect
+      - name: kube-rbac-proxy
+        args:
+        - --v=10
+---
+apiVersion: infrastructure.cluster.x-k8s.io/v1beta1
+kind: AWSClusterControllerIdentity
+metadata:
+  name: default
+spec:
+  allowedNamespaces:
+    list:
+    - default
+`
+
+// applyTestPatches applies the patch files to the patches test components.
+func applyTestPatches(t *testing.T, files map[string]string) ([]unstructured.Unstructured, error) {
+	defer func(dir string) { *patchesDir = dir }(*patchesDir)
+	*patchesDir = t.TempDir()
+	aws := &provider{name: "aws", ptype: clusterctlv1.InfrastructureProviderType}
+	dir := filepath.Join(*patchesDir, "infrastructure-aws")
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return aws.applyPatches(testObjects(t, patchesTestComponents))
+}
+
+func TestApplyStrategicMergePatches(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		fields  []string
+		index   int
+		want    interface{}
+		wantErr bool
+	}{
+		{
+			name: "container merged by name",
+			files: map[string]string{"manager.yaml": `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: capa-controller-manager
+spec:
+  template:
+    spec:
+      containers:
+      - name: kube-rbac-proxy
+        args:
+        - --v=2
+`},
+			fields: []string{"spec", "template", "spec", "containers"},
+			want: []interface{}{
+				map[string]interface{}{"name": "manager", "args": []interface{}{"--leader-elect"}},
+				map[string]interface{}{"name": "kube-rbac-proxy", "args": []interface{}{"--v=2"}},
+			},
+		},
+		{
+			name: "custom resource merged",
+			files: map[string]string{"identity.yaml": `apiVersion: infrastructure.cluster.x-k8s.io/v1beta1
+kind: AWSClusterControllerIdentity
+metadata:
+  name: default
+spec:
+  allowedNamespaces:
+    list: null
+`},
+			index:  1,
+			fields: []string{"spec", "allowedNamespaces"},
+			want:   map[string]interface{}{},
+		},
+		{
+			name: "applied in the order of the files",
+			files: map[string]string{
+				"10-identity.yaml": `apiVersion: infrastructure.cluster.x-k8s.io/v1beta1
+kind: AWSClusterControllerIdentity
+metadata:
+  name: default
+spec:
+  allowedNamespaces:
+    list:
+    - openshift-cluster-api
+`,
+				"20-identity.yaml": `apiVersion: infrastructure.cluster.x-k8s.io/v1beta1
+kind: AWSClusterControllerIdentity
+metadata:
+  name: default
+spec:
+  allowedNamespaces:
+    list:
+    - openshift-machine-api
+`,
+			},
+			index:  1,
+			fields: []string{"spec", "allowedNamespaces", "list"},
+			want:   []interface{}{"openshift-machine-api"},
+		},
+		{
+			name: "no match",
+			files: map[string]string{"renamed.yaml": `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: capa-manager
+`},
+			wantErr: true,
+		},
+		{
+			name: "other API version",
+			files: map[string]string{"identity.yaml": `apiVersion: infrastructure.cluster.x-k8s.io/v1alpha4
+kind: AWSClusterControllerIdentity
+metadata:
+  name: default
+`},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objs, err := applyTestPatches(t, tt.files)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("applyPatches() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			got, _, err := unstructured.NestedFieldNoCopy(objs[tt.index].Object, tt.fields...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%v = %v, want %v", tt.fields, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyJSON6902Patch(t *testing.T) {
+	tests := []struct {
+		name    string
+		patch   string
+		fields  []string
+		index   int
+		want    interface{}
+		wantErr bool
+	}{
+		{
+			name: "operations in order",
+			patch: `target:
+  group: apps
+  kind: Deployment
+  name: capa-controller-manager
+patch:
+- op: add
+  path: /spec/template/spec/containers/0/args/-
+  value: --v=2
+- op: remove
+  path: /spec/template/spec/containers/1
+`,
+			fields: []string{"spec", "template", "spec", "containers"},
+			want: []interface{}{
+				map[string]interface{}{"name": "manager", "args": []interface{}{"--leader-elect", "--v=2"}},
+			},
+		},
+		{
+			name: "custom resource",
+			patch: `target:
+  group: infrastructure.cluster.x-k8s.io
+  kind: AWSClusterControllerIdentity
+  name: default
+patch:
+- op: replace
+  path: /spec/allowedNamespaces/list/0
+  value: openshift-cluster-api
+`,
+			index:  1,
+			fields: []string{"spec", "allowedNamespaces", "list"},
+			want:   []interface{}{"openshift-cluster-api"},
+		},
+		{
+			name: "other group",
+			patch: `target:
+  kind: Deployment
+  name: capa-controller-manager
+patch:
+- op: remove
+  path: /spec/template/spec/containers/1
+`,
+			wantErr: true,
+		},
+		{
+			name: "failed test operation",
+			patch: `target:
+  group: apps
+  kind: Deployment
+  name: capa-controller-manager
+patch:
+- op: test
+  path: /spec/template/spec/containers/1/name
+  value: manager
+`,
+			wantErr: true,
+		},
+		{
+			name: "unknown field",
+			patch: `target:
+  group: apps
+  kind: Deployment
+  name: capa-controller-manager
+patches:
+- op: remove
+  path: /spec/template/spec/containers/1
+`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objs, err := applyTestPatches(t, map[string]string{"deployment" + json6902Suffix: tt.patch})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("applyPatches() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			got, _, err := unstructured.NestedFieldNoCopy(objs[tt.index].Object, tt.fields...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%v = %v, want %v", tt.fields, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/google/go-github/v33/github"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	configclient "sigs.k8s.io/cluster-api/cmd/clusterctl/client/config"
+)
+
+// importAssetsVersion is the version of the importer recorded on the generated objects, bump
+// it with the changes to the importer that change the generated files.
+const importAssetsVersion = "1.0.0"
+
+// The provenance annotations trace a generated object back to the upstream release.
+const (
+	upstreamRepositoryAnnotation  = "provider.cluster.x-k8s.io/upstream-repository"
+	upstreamVersionAnnotation     = "provider.cluster.x-k8s.io/upstream-version"
+	upstreamCommitAnnotation      = "provider.cluster.x-k8s.io/upstream-commit"
+	importAssetsVersionAnnotation = "provider.cluster.x-k8s.io/import-assets-version"
+)
+
+const githubHost = "github.com"
+
+// provenanceAnnotations returns the annotations recording the upstream release of the
+// provider, the commit is only known for GitHub releases.
+func (p *provider) provenanceAnnotations() map[string]string {
+	anns := map[string]string{
+		upstreamRepositoryAnnotation:  p.repository,
+		upstreamVersionAnnotation:     p.version,
+		importAssetsVersionAnnotation: importAssetsVersion,
+	}
+	if p.commit != "" {
+		anns[upstreamCommitAnnotation] = p.commit
+	}
+	return anns
+}
+
+// setProvenance adds the provenance annotations to the objects.
+func (p *provider) setProvenance(objs ...unstructured.Unstructured) {
+	for _, obj := range objs {
+		anns := obj.GetAnnotations()
+		if anns == nil {
+			anns = map[string]string{}
+		}
+		for k, v := range p.provenanceAnnotations() {
+			anns[k] = v
+		}
+		obj.SetAnnotations(anns)
+	}
+}
+
+// githubRepository returns the owner and name of the repository of a GitHub release url,
+// e.g. https://github.com/kubernetes-sigs/cluster-api/releases/latest/core-components.yaml.
+func githubRepository(releaseURL string) (string, string, bool) {
+	u, err := url.Parse(releaseURL)
+	if err != nil || u.Host != githubHost {
+		return "", "", false
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) < 2 {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// repositoryURL returns the upstream repository of the provider release url, the url of the
+// directory of the components for the repositories that are not on GitHub.
+func repositoryURL(releaseURL string) string {
+	if owner, name, ok := githubRepository(releaseURL); ok {
+		return "https://" + path.Join(githubHost, owner, name)
+	}
+	return strings.TrimSuffix(releaseURL, "/"+path.Base(releaseURL))
+}
+
+// resolveCommit sets the commit of the release tag of a GitHub release. It is pinned in
+// provider-versions.lock with the checksums of the release files, so the later imports of the
+// version, offline ones included, don't call the GitHub API.
+func (p *provider) resolveCommit(providerConfig configclient.Provider, configClient configclient.Client) error {
+	p.repository = repositoryURL(providerConfig.URL())
+	owner, name, ok := githubRepository(providerConfig.URL())
+	if !ok {
+		return nil
+	}
+
+	checksums, err := loadChecksums()
+	if err != nil {
+		return err
+	}
+	key := p.providerTypeName() + "-" + p.assetName()
+	pinned, ok := checksums[key]
+	if !ok || pinned.Version != p.version {
+		pinned = providerChecksums{Version: p.version, SHA256: map[string]string{}}
+	}
+	if pinned.Commit != "" || *offline != "" {
+		p.commit = pinned.Commit
+		return nil
+	}
+
+	client := github.NewClient(nil)
+	if token, err := configClient.Variables().Get(configclient.GitHubTokenVariable); err == nil {
+		client = github.NewClient(&http.Client{Transport: &tokenTransport{token: token}})
+	}
+	err = withRetry("resolving the commit of "+p.assetName()+" "+p.version, func() error {
+		var err error
+		p.commit, _, err = client.Repositories.GetCommitSHA1(context.Background(), owner, name, p.version, "")
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	pinned.Commit = p.commit
+	checksums[key] = pinned
+	return saveChecksums(checksums)
+}
+
+// tokenTransport authenticates the GitHub API requests with the GITHUB_TOKEN.
+type tokenTransport struct {
+	token string
+}
+
+func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "token "+t.token)
+	return http.DefaultTransport.RoundTrip(req)
+}
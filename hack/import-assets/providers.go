@@ -4,52 +4,93 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path"
-	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/blang/semver"
 	certmangerv1 "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1"
 	"github.com/pkg/errors"
 	admissionregistration "k8s.io/api/admissionregistration/v1"
-	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/klog/v2"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/yaml"
 
 	clusterctlv1 "sigs.k8s.io/cluster-api/cmd/clusterctl/api/v1alpha3"
 	configclient "sigs.k8s.io/cluster-api/cmd/clusterctl/client/config"
 	"sigs.k8s.io/cluster-api/cmd/clusterctl/client/repository"
 	"sigs.k8s.io/cluster-api/cmd/clusterctl/client/yamlprocessor"
 	operatorv1 "sigs.k8s.io/cluster-api/exp/operator/api/v1alpha1"
-	utilyaml "sigs.k8s.io/cluster-api/util/yaml"
 )
 
 type provider struct {
-	name       string
-	version    string
-	ptype      clusterctlv1.ProviderType
+	name     string
+	url      string // only required for providers that clusterctl doesn't know about
+	flavor   string // optional, selects an alternate components file from the same release
+	optional bool   // only imported when explicitly requested with the provider filter
+	version  string
+	ptype    clusterctlv1.ProviderType
+	// repository and commit are the provenance of the release, see resolveCommit
+	repository string
+	commit     string
 	components repository.Components
 	metadata   []byte
 }
 
 const (
-	sampleImageFileName      = "../sample-images.json"
-	providerVersionsFileName = "provider-versions.json"
+	providerVersionsFileName     = "provider-versions.json"
+	providerRepositoriesFileName = "provider-repositories.json"
 )
 
 var (
 	providers = []provider{
 		{name: "cluster-api", ptype: clusterctlv1.CoreProviderType},
+		{name: "kubeadm", ptype: clusterctlv1.BootstrapProviderType, optional: true},
+		{name: "kubeadm", ptype: clusterctlv1.ControlPlaneProviderType, optional: true},
 		{name: "aws", ptype: clusterctlv1.InfrastructureProviderType},
 		{name: "azure", ptype: clusterctlv1.InfrastructureProviderType},
 		{name: "metal3", ptype: clusterctlv1.InfrastructureProviderType},
 		{name: "gcp", ptype: clusterctlv1.InfrastructureProviderType},
 		{name: "openstack", ptype: clusterctlv1.InfrastructureProviderType},
+		{name: "vsphere", ptype: clusterctlv1.InfrastructureProviderType},
+		// the providers below aren't pinned yet, the first import of one needs --latest
+		{
+			name:     "ibmcloud",
+			url:      "https://github.com/kubernetes-sigs/cluster-api-provider-ibmcloud/releases/latest/infrastructure-components.yaml",
+			ptype:    clusterctlv1.InfrastructureProviderType,
+			optional: true,
+		},
+		{
+			name:     "ibmcloud",
+			flavor:   "powervs",
+			url:      "https://github.com/kubernetes-sigs/cluster-api-provider-ibmcloud/releases/latest/infrastructure-components.yaml",
+			ptype:    clusterctlv1.InfrastructureProviderType,
+			optional: true,
+		},
+		{
+			name:     "nutanix",
+			url:      "https://github.com/nutanix-cloud-native/cluster-api-provider-nutanix/releases/latest/infrastructure-components.yaml",
+			ptype:    clusterctlv1.InfrastructureProviderType,
+			optional: true,
+		},
+		{
+			name:     "oci",
+			url:      "https://github.com/oracle/cluster-api-provider-oci/releases/latest/infrastructure-components.yaml",
+			ptype:    clusterctlv1.InfrastructureProviderType,
+			optional: true,
+		},
+		{
+			name:     "alibabacloud",
+			url:      "https://github.com/kubernetes-sigs/cluster-api-provider-alibabacloud/releases/latest/infrastructure-components.yaml",
+			ptype:    clusterctlv1.InfrastructureProviderType,
+			optional: true,
+		},
 	}
 	providersPath = path.Join(projDir, "assets", "providers")
 	manifestsPath = path.Join(projDir, "manifests")
@@ -61,35 +102,33 @@ func (p *provider) loadComponents() error {
 		return err
 	}
 
-	providerConfig, err := configClient.Providers().Get(p.name, p.ptype)
-	if err != nil {
-		return err
-	}
-
-	repo, err := repository.NewGitHubRepository(providerConfig, configClient.Variables())
+	providerConfig, repo, err := p.openRepository(configClient)
 	if err != nil {
 		return err
 	}
 
-	err = p.loadVersion()
+	p.metadata, err = p.getFile(repo, "metadata.yaml")
 	if err != nil {
 		return err
 	}
-
-	p.metadata, err = repo.GetFile(p.version, "metadata.yaml")
-	if err != nil {
+	if err := p.resolveCommit(providerConfig, configClient); err != nil {
 		return err
 	}
 
 	options := repository.ComponentsOptions{
-		TargetNamespace:     "openshift-cluster-api",
+		TargetNamespace:     *targetNamespace,
 		SkipTemplateProcess: true,
 		Version:             p.version,
 	}
 
-	componentsFile, err := repo.GetFile(options.Version, repo.ComponentsPath())
+	componentsPath := p.componentsPath(repo)
+	componentsFile, err := p.getFile(repo, componentsPath)
 	if err != nil {
-		return errors.Wrapf(err, "failed to read %q from provider's repository %q", repo.ComponentsPath(), providerConfig.ManifestLabel())
+		return errors.Wrapf(err, "failed to read %q from provider's repository %q", componentsPath, providerConfig.ManifestLabel())
+	}
+	componentsFile, err = p.processVariables(componentsFile)
+	if err != nil {
+		return err
 	}
 
 	ci := repository.ComponentsInput{
@@ -103,43 +142,201 @@ func (p *provider) loadComponents() error {
 	return err
 }
 
+// openRepository resolves the provider version and the repository to read its release files from.
+func (p *provider) openRepository(configClient configclient.Client) (configclient.Provider, repository.Repository, error) {
+	err := p.loadRepositoryOverride()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	providerConfig, err := p.providerConfig(configClient)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	repo, err := p.newRepository(providerConfig, configClient)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = p.loadVersion()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if *latest {
+		err = p.resolveLatestVersion(repo)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if p.version == "" {
+		return nil, nil, errors.Errorf("%s %s has no version in %s, import it with --latest to pin its newest release", p.ptype, p.assetName(), providerVersionsFileName)
+	}
+	return providerConfig, repo, nil
+}
+
+// providerConfig returns the clusterctl configuration for the provider, falling back
+// to the explicit url for providers that are not in the clusterctl defaults.
+func (p *provider) providerConfig(configClient configclient.Client) (configclient.Provider, error) {
+	if p.url != "" {
+		return configclient.NewProvider(p.name, p.url, p.ptype), nil
+	}
+	return configClient.Providers().Get(p.name, p.ptype)
+}
+
+// newRepository returns the repository to read the provider release files from,
+// GitHub releases unless the url points to an OCI artifact or a local directory.
+// Remote repositories are only contacted for files that are not in the cache.
+func (p *provider) newRepository(providerConfig configclient.Provider, configClient configclient.Client) (repository.Repository, error) {
+	if *offline != "" {
+		return p.offlineRepository(providerConfig)
+	}
+	if strings.HasPrefix(providerConfig.URL(), fileScheme) {
+		return newLocalRepository(providerConfig.URL())
+	}
+
+	newUpstream := func() (repository.Repository, error) {
+		var upstream repository.Repository
+		err := withRetry("opening "+p.assetName()+" repository", func() error {
+			var err error
+			if strings.HasPrefix(providerConfig.URL(), ociScheme) {
+				upstream, err = newOCIRepository(providerConfig.URL())
+				return err
+			}
+			warnMissingGitHubToken(configClient)
+			upstream, err = repository.NewGitHubRepository(providerConfig, configClient.Variables())
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &retryingRepository{Repository: upstream, name: p.assetName()}, nil
+	}
+	if *cacheDir == "" {
+		return newUpstream()
+	}
+	return &cachedRepository{
+		dir:            p.offlineProviderDir(*cacheDir),
+		componentsPath: path.Base(providerConfig.URL()),
+		newUpstream:    newUpstream,
+	}, nil
+}
+
+// assetName is used for the generated file names, labels and image keys. It includes
+// the flavor so that variants of the same provider don't overwrite each other.
+func (p *provider) assetName() string {
+	if p.flavor == "" {
+		return p.name
+	}
+	return p.name + "-" + p.flavor
+}
+
+// componentsPath returns the components file to use from the release,
+// e.g. "infrastructure-components-powervs.yaml" for the powervs flavor.
+func (p *provider) componentsPath(repo repository.Repository) string {
+	if p.flavor == "" {
+		return repo.ComponentsPath()
+	}
+	ext := path.Ext(repo.ComponentsPath())
+	return strings.TrimSuffix(repo.ComponentsPath(), ext) + "-" + p.flavor + ext
+}
+
 func (p *provider) providerTypeName() string {
 	return strings.ReplaceAll(strings.ToLower(string(p.ptype)), "provider", "")
 }
 
-func (p *provider) writeProviderComponents(objs []unstructured.Unstructured) error {
-	combined, err := utilyaml.FromUnstructured(objs)
+// writeProviderComponents writes the components ConfigMap read by the upstream operator, the
+// topology is empty for the default components and singleReplicaTopology for the single node variant.
+func (p *provider) writeProviderComponents(objs []unstructured.Unstructured, topology string) error {
+	combined, err := canonicalObjectsYAML(objs)
 	if err != nil {
 		return err
 	}
 
+	suffix := ""
+	labels := map[string]string{
+		"provider.cluster.x-k8s.io/name":    p.assetName(),
+		"provider.cluster.x-k8s.io/type":    p.providerTypeName(),
+		"provider.cluster.x-k8s.io/version": p.version,
+	}
+	if topology != "" {
+		suffix = singleReplicaSuffix
+		labels[topologyLabel] = topology
+	}
+
 	cm := &corev1.ConfigMap{
 		TypeMeta: metav1.TypeMeta{
 			Kind:       "ConfigMap",
 			APIVersion: "v1",
 		},
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      p.name + "-" + p.version,
-			Namespace: "openshift-cluster-api",
-			Labels: map[string]string{
-				"provider.cluster.x-k8s.io/name":    p.name,
-				"provider.cluster.x-k8s.io/type":    p.providerTypeName(),
-				"provider.cluster.x-k8s.io/version": p.version,
-			},
+			Name:        p.assetName() + "-" + p.version + suffix,
+			Namespace:   *targetNamespace,
+			Labels:      labels,
+			Annotations: p.provenanceAnnotations(),
 		},
 		Data: map[string]string{
-			"metadata":   string(p.metadata),
-			"components": string(combined),
+			"metadata":    string(p.metadata),
+			componentsKey: string(combined),
 		},
 	}
+	shards, err := shardComponents(cm)
+	if err != nil {
+		return err
+	}
+
+	cmYaml, err := canonicalYAML(cm)
+	if err != nil {
+		return err
+	}
+	if err := out.writeFile(p.componentsFile(suffix), cmYaml); err != nil {
+		return err
+	}
 
-	cmYaml, err := yaml.Marshal(cm)
+	// the shards of the previous import, there may be fewer now
+	oldShards, err := out.glob(p.componentsFile(suffix + "-crds-*"))
 	if err != nil {
 		return err
 	}
+	for _, f := range oldShards {
+		if err := out.remove(f); err != nil {
+			return err
+		}
+	}
+	for i, shard := range shards {
+		shardYaml, err := canonicalYAML(shard)
+		if err != nil {
+			return err
+		}
+		if err := out.writeFile(p.componentsFile(fmt.Sprintf("%s-crds-%d", suffix, i+1)), shardYaml); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// componentsFile is the path of the components ConfigMap, the suffix is set for the variants.
+func (p *provider) componentsFile(suffix string) string {
+	return path.Join(providersPath, strings.ToLower(p.providerTypeName()+"-"+p.assetName()+suffix+".yaml"))
+}
+
+// providerFile is the path of the provider object of the upstream operator.
+func (p *provider) providerFile() string {
+	return path.Join(providersPath, strings.ToLower(p.providerTypeName()+"-"+p.assetName()+"-provider.yaml"))
+}
+
+func (p *provider) rbacManifestFile() string {
+	return path.Join(manifestsPath, strings.ToLower("0000_30_cluster-api_"+p.providerTypeName()+"-"+p.assetName()+"_03_rbac.yaml"))
+}
+
+// crdManifestPrefix is the path of the CRD manifests without the CRD name and extension.
+func (p *provider) crdManifestPrefix() string {
+	return path.Join(manifestsPath, strings.ToLower("0000_30_cluster-api_"+p.providerTypeName()+"-"+p.assetName()+"_02_crd_"))
+}
 
-	fName := strings.ToLower(p.providerTypeName() + "-" + p.name + ".yaml")
-	return os.WriteFile(path.Join(providersPath, fName), ensureNewLine(cmYaml), 0600)
+func (p *provider) crdManifestFile(name string) string {
+	return p.crdManifestPrefix() + strings.ToLower(name) + ".yaml"
 }
 
 // ensureNewLine makes sure that there is one new line at the end of the file for git
@@ -148,13 +345,52 @@ func ensureNewLine(b []byte) []byte {
 }
 
 func (p *provider) writeRBACComponentsToManifests(objs []unstructured.Unstructured) error {
-	combined, err := utilyaml.FromUnstructured(objs)
+	combined, err := canonicalObjectsYAML(objs)
 	if err != nil {
 		return err
 	}
 
-	fName := strings.ToLower("0000_30_cluster-api_" + p.providerTypeName() + "-" + p.name + "_03_rbac.yaml")
-	return os.WriteFile(path.Join(manifestsPath, fName), ensureNewLine(combined), 0600)
+	return out.writeFile(p.rbacManifestFile(), combined)
+}
+
+// writeCRDsToManifests writes one manifest per CRD, the ones of the previous import are
+// removed first as CRDs can be dropped or renamed between provider releases. The CRDs shared
+// with other providers are written once, see sharedCRDOwner, and are returned as
+// "<crd> (<owner>)" for the ones this provider doesn't write.
+func (p *provider) writeCRDsToManifests(objs []unstructured.Unstructured) ([]string, error) {
+	oldCRDs, err := out.glob(p.crdManifestPrefix() + "*.yaml")
+	if err != nil {
+		return nil, err
+	}
+	for _, oldCRD := range oldCRDs {
+		if err := out.remove(oldCRD); err != nil {
+			return nil, err
+		}
+	}
+
+	shared := []string{}
+	for _, obj := range objs {
+		owner, err := p.sharedCRDOwner(obj)
+		if err != nil {
+			return nil, err
+		}
+		if owner != nil {
+			shared = append(shared, fmt.Sprintf("%s (%s-%s)", obj.GetName(), owner.providerTypeName(), owner.assetName()))
+			continue
+		}
+		if err := p.takeCRDOwnership(obj.GetName()); err != nil {
+			return nil, err
+		}
+
+		b, err := canonicalYAML(obj)
+		if err != nil {
+			return nil, err
+		}
+		if err := out.writeFile(p.crdManifestFile(obj.GetName()), b); err != nil {
+			return nil, err
+		}
+	}
+	return shared, nil
 }
 
 func (p *provider) writeProviders() error {
@@ -180,17 +416,18 @@ func (p *provider) writeProviders() error {
 			TypeMeta: metav1.TypeMeta{Kind: "InfrastructureProvider", APIVersion: "operator.cluster.x-k8s.io/v1alpha1"},
 			Spec:     operatorv1.InfrastructureProviderSpec{ProviderSpec: p.providerSpec()},
 		}
+	default:
+		return fmt.Errorf("unsupported provider type %q for %s", p.ptype, p.assetName())
 	}
-	obj.SetName(p.name)
-	obj.SetNamespace("openshift-cluster-api")
+	obj.SetName(p.assetName())
+	obj.SetNamespace(*targetNamespace)
 
-	cmYaml, err := yaml.Marshal(obj)
+	cmYaml, err := canonicalYAML(obj)
 	if err != nil {
 		return err
 	}
 
-	fName := strings.ToLower(p.providerTypeName() + "-" + p.name + "-provider.yaml")
-	return os.WriteFile(path.Join(providersPath, fName), ensureNewLine(cmYaml), 0600)
+	return out.writeFile(p.providerFile(), cmYaml)
 }
 
 func (p *provider) providerSpec() operatorv1.ProviderSpec {
@@ -199,25 +436,35 @@ func (p *provider) providerSpec() operatorv1.ProviderSpec {
 		FetchConfig: &operatorv1.FetchConfiguration{
 			Selector: &metav1.LabelSelector{
 				MatchLabels: map[string]string{
-					"provider.cluster.x-k8s.io/name": p.name,
+					"provider.cluster.x-k8s.io/name": p.assetName(),
 					"provider.cluster.x-k8s.io/type": p.providerTypeName(),
 				},
+				// the operator selects the single node variant instead on SNO
+				MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: topologyLabel, Operator: metav1.LabelSelectorOpDoesNotExist},
+				},
 			},
 		},
 	}
 }
 
-func findWebhookServiceSecretName(objs []unstructured.Unstructured) map[string]string {
+// findWebhookServiceSecretName returns the secret of the serving certificate of the webhook
+// Services, by Service name, following the cert-manager CA injection annotations to the
+// Certificates.
+func findWebhookServiceSecretName(objs []unstructured.Unstructured) (map[string]string, error) {
 	serviceSecretNames := map[string]string{}
 	certSecretNames := map[string]string{}
 
-	secretFromCertNN := func(certNN string) (string, bool) {
-		certName := strings.Split(certNN, "/")[1]
-		secretName, ok := certSecretNames[certName]
-		if !ok || secretName == "" {
-			return "", false
+	secretFromCertNN := func(obj unstructured.Unstructured, certNN string) (string, error) {
+		parts := strings.Split(certNN, "/")
+		if len(parts) != 2 {
+			return "", errors.Errorf("%s %s: invalid cert-manager.io/inject-ca-from %q, expected <namespace>/<certificate>", obj.GetKind(), obj.GetName(), certNN)
+		}
+		secretName := certSecretNames[parts[1]]
+		if secretName == "" {
+			return "", errors.Errorf("%s %s: no secret for the certificate %s", obj.GetKind(), obj.GetName(), certNN)
 		}
-		return secretName, true
+		return secretName, nil
 	}
 	// find service, then cert, then secret
 	// return map[certName] = secretName
@@ -226,7 +473,7 @@ func findWebhookServiceSecretName(objs []unstructured.Unstructured) map[string]s
 		case "Certificate":
 			cert := &certmangerv1.Certificate{}
 			if err := scheme.Convert(&objs[i], cert, nil); err != nil {
-				panic(err)
+				return nil, errors.Wrapf(err, "converting Certificate %s", obj.GetName())
 			}
 			certSecretNames[cert.Name] = cert.Spec.SecretName
 		}
@@ -236,12 +483,17 @@ func findWebhookServiceSecretName(objs []unstructured.Unstructured) map[string]s
 		case "CustomResourceDefinition":
 			crd := &apiextensionsv1.CustomResourceDefinition{}
 			if err := scheme.Convert(&obj, crd, nil); err != nil {
-				panic(err)
+				return nil, errors.Wrapf(err, "converting CustomResourceDefinition %s", obj.GetName())
 			}
 			if certNN, ok := crd.Annotations["cert-manager.io/inject-ca-from"]; ok {
-				secretName, ok := secretFromCertNN(certNN)
-				if !ok {
-					panic("can't find secret from cert " + certNN)
+				secretName, err := secretFromCertNN(obj, certNN)
+				if err != nil {
+					return nil, err
+				}
+				// some providers (e.g. vsphere) annotate CRDs that don't use a conversion webhook
+				if crd.Spec.Conversion == nil || crd.Spec.Conversion.Webhook == nil ||
+					crd.Spec.Conversion.Webhook.ClientConfig == nil || crd.Spec.Conversion.Webhook.ClientConfig.Service == nil {
+					continue
 				}
 				serviceSecretNames[crd.Spec.Conversion.Webhook.ClientConfig.Service.Name] = secretName
 			}
@@ -249,66 +501,101 @@ func findWebhookServiceSecretName(objs []unstructured.Unstructured) map[string]s
 		case "MutatingWebhookConfiguration":
 			mwc := &admissionregistration.MutatingWebhookConfiguration{}
 			if err := scheme.Convert(&obj, mwc, nil); err != nil {
-				panic(err)
+				return nil, errors.Wrapf(err, "converting MutatingWebhookConfiguration %s", obj.GetName())
 			}
 			if certNN, ok := mwc.Annotations["cert-manager.io/inject-ca-from"]; ok {
-				secretName, ok := secretFromCertNN(certNN)
-				if !ok {
-					panic("can't find secret from cert " + certNN)
+				secretName, err := secretFromCertNN(obj, certNN)
+				if err != nil {
+					return nil, err
+				}
+				for _, wh := range mwc.Webhooks {
+					if wh.ClientConfig.Service != nil {
+						serviceSecretNames[wh.ClientConfig.Service.Name] = secretName
+					}
 				}
-				serviceSecretNames[mwc.Webhooks[0].ClientConfig.Service.Name] = secretName
 			}
 
 		case "ValidatingWebhookConfiguration":
 			vwc := &admissionregistration.ValidatingWebhookConfiguration{}
 			if err := scheme.Convert(&obj, vwc, nil); err != nil {
-				panic(err)
+				return nil, errors.Wrapf(err, "converting ValidatingWebhookConfiguration %s", obj.GetName())
 			}
 			if certNN, ok := vwc.Annotations["cert-manager.io/inject-ca-from"]; ok {
-				secretName, ok := secretFromCertNN(certNN)
-				if !ok {
-					panic("can't find secret from cert " + certNN)
+				secretName, err := secretFromCertNN(obj, certNN)
+				if err != nil {
+					return nil, err
+				}
+				for _, wh := range vwc.Webhooks {
+					if wh.ClientConfig.Service != nil {
+						serviceSecretNames[wh.ClientConfig.Service.Name] = secretName
+					}
 				}
-				serviceSecretNames[vwc.Webhooks[0].ClientConfig.Service.Name] = secretName
 			}
 		}
 	}
-	return serviceSecretNames
+	return serviceSecretNames, nil
 }
 
-func (p *provider) updateImages(objs []unstructured.Unstructured) error {
-	jsonData, err := ioutil.ReadFile(filepath.Clean(sampleImageFileName))
+// loadVersion reads the version of the provider pinned in provider-versions.json. It is keyed
+// by "<type>-<name>", with the flavor, e.g. "infrastructure-ibmcloud-powervs", so that the
+// flavors of a provider and the kubeadm bootstrap and control plane providers are pinned apart.
+func (p *provider) loadVersion() error {
+	jsonData, err := out.readFile(providerVersionsFileName)
 	if err != nil {
 		return err
 	}
-	containerImages := map[string]string{}
-	if err := json.Unmarshal(jsonData, &containerImages); err != nil {
+	providerVersions := map[string]string{}
+	if err := json.Unmarshal(jsonData, &providerVersions); err != nil {
 		return err
 	}
 
-	for i, obj := range objs {
-		switch obj.GetKind() {
-		case "Deployment":
-			dep := &appsv1.Deployment{}
-			if err := scheme.Convert(&objs[i], dep, nil); err != nil {
-				return err
-			}
-			for _, c := range dep.Spec.Template.Spec.Containers {
-				containerImages[p.imageToKey(c.Image)] = c.Image
-			}
+	p.version = providerVersions[p.providerTypeName()+"-"+p.assetName()]
+	return nil
+}
+
+// resolveLatestVersion sets the version to the newest non pre-release upstream release
+// that matches --latest-range and records it in provider-versions.json.
+func (p *provider) resolveLatestVersion(repo repository.Repository) error {
+	versionRange := func(semver.Version) bool { return true }
+	if *latestRange != "" {
+		var err error
+		versionRange, err = semver.ParseRange(*latestRange)
+		if err != nil {
+			return errors.Wrapf(err, "invalid --latest-range %q", *latestRange)
 		}
 	}
 
-	jsonData, err = json.MarshalIndent(&containerImages, "", "  ")
+	versions, err := repo.GetVersions()
 	if err != nil {
 		return err
 	}
 
-	return ioutil.WriteFile(sampleImageFileName, ensureNewLine(jsonData), 0600)
+	var newest *semver.Version
+	for _, v := range versions {
+		sv, err := semver.ParseTolerant(v)
+		if err != nil {
+			continue // not a release tag
+		}
+		if len(sv.Pre) > 0 || !versionRange(sv) {
+			continue
+		}
+		if newest == nil || sv.GT(*newest) {
+			newest = &sv
+		}
+	}
+	if newest == nil {
+		return fmt.Errorf("no release of %s matches %q", p.assetName(), *latestRange)
+	}
+
+	if p.version != "v"+newest.String() {
+		klog.Infof("%s: %s -> v%s", p.assetName(), p.version, newest)
+	}
+	p.version = "v" + newest.String()
+	return p.saveVersion()
 }
 
-func (p *provider) loadVersion() error {
-	jsonData, err := ioutil.ReadFile(providerVersionsFileName)
+func (p *provider) saveVersion() error {
+	jsonData, err := out.readFile(providerVersionsFileName)
 	if err != nil {
 		return err
 	}
@@ -317,124 +604,286 @@ func (p *provider) loadVersion() error {
 		return err
 	}
 
-	p.version = providerVersions[p.name]
-	return nil
-}
-
-func (p *provider) imageToKey(fullImage string) string {
-	//k8s.gcr.io/cluster-api/kubeadm-bootstrap-controller:v0.4.3
-	frag := strings.Split(fullImage, "/")
-	nameVer := frag[len(frag)-1]
-	name := strings.Split(nameVer, ":")[0]
-
-	switch name {
-	case "kube-rbac-proxy":
-		return "kube-rbac-proxy"
-	case "ip-address-manager": //special case
-		return p.providerTypeName() + "-" + p.name + ":" + name
-	default:
-		return p.providerTypeName() + "-" + p.name + ":manager"
+	providerVersions[p.providerTypeName()+"-"+p.assetName()] = p.version
+	jsonData, err = json.MarshalIndent(&providerVersions, "", "  ")
+	if err != nil {
+		return err
 	}
+	return out.writeFile(providerVersionsFileName, ensureNewLine(jsonData))
 }
 
-func certManagerToServiceCA(objs []unstructured.Unstructured) []unstructured.Unstructured {
-	serviceSecretNames := findWebhookServiceSecretName(objs)
+// loadRepositoryOverride replaces the provider url with the one from provider-repositories.json,
+// this allows importing from forks (e.g. github.com/openshift/cluster-api-provider-aws).
+// The file is keyed by "<type>-<name>", e.g. "infrastructure-aws".
+func (p *provider) loadRepositoryOverride() error {
+	jsonData, err := ioutil.ReadFile(providerRepositoriesFileName)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	providerRepositories := map[string]string{}
+	if err := json.Unmarshal(jsonData, &providerRepositories); err != nil {
+		return err
+	}
 
-	finalObjs := []unstructured.Unstructured{}
-	for _, obj := range objs {
-		switch obj.GetKind() {
-		case "CustomResourceDefinition", "MutatingWebhookConfiguration", "ValidatingWebhookConfiguration":
-			anns := obj.GetAnnotations()
-			if anns == nil {
-				anns = map[string]string{}
-			}
-			if _, ok := anns["cert-manager.io/inject-ca-from"]; ok {
-				anns["service.beta.openshift.io/inject-cabundle"] = "true"
-				delete(anns, "cert-manager.io/inject-ca-from")
-				obj.SetAnnotations(anns)
-			}
-			finalObjs = append(finalObjs, obj)
-		case "Service":
-			anns := obj.GetAnnotations()
-			if anns == nil {
-				anns = map[string]string{}
-			}
-			if name, ok := serviceSecretNames[obj.GetName()]; ok {
-				fmt.Println(obj.GetKind(), obj.GetName(), name)
-				anns["service.beta.openshift.io/serving-cert-secret-name"] = name
-				obj.SetAnnotations(anns)
-			}
-			finalObjs = append(finalObjs, obj)
-		case "Certificate", "Issuer", "Namespace": // skip
-		default:
-			finalObjs = append(finalObjs, obj)
-		}
+	if url, ok := providerRepositories[p.providerTypeName()+"-"+p.name]; ok {
+		p.url = url
 	}
-	return finalObjs
+	return nil
 }
 
-func splitRBACOut(objs []unstructured.Unstructured) ([]unstructured.Unstructured, []unstructured.Unstructured) {
-	finalObjs := []unstructured.Unstructured{}
-	rbacObjs := []unstructured.Unstructured{}
-	for _, obj := range objs {
-		switch obj.GetKind() {
-		case "ClusterRole", "Role", "ClusterRoleBinding", "RoleBinding", "ServiceAccount":
-			setOpenShiftAnnotations(obj, false)
-			rbacObjs = append(rbacObjs, obj)
-		default:
-			finalObjs = append(finalObjs, obj)
+// renderProvider writes the transformed components of a provider, before they are split
+// into the assets and the manifests.
+func renderProvider(name string, w io.Writer) error {
+	config, err := loadImportConfig(*importConfigFile)
+	if err != nil {
+		return err
+	}
+	for _, p := range providers {
+		if p.name != name && p.assetName() != name {
+			continue
 		}
+		if err := p.loadComponents(); err != nil {
+			return err
+		}
+		objs, err := newProviderPipeline(config).run(&p, p.components.Objs())
+		if err != nil {
+			return errors.Wrapf(err, "%s %s", p.ptype, p.assetName())
+		}
+		if err := validateObjects(objs); err != nil {
+			return err
+		}
+		b, err := canonicalObjectsYAML(objs)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(b)
+		return err
 	}
-	return finalObjs, rbacObjs
+	return errors.Errorf("unknown provider %q", name)
 }
 
-func filterOutIPAM(objs []unstructured.Unstructured) []unstructured.Unstructured {
-	finalObjs := []unstructured.Unstructured{}
-	for _, obj := range objs {
-		if obj.GetKind() == "CustomResourceDefinition" || !strings.Contains(strings.ToLower(obj.GetName()), "ipam") {
-			finalObjs = append(finalObjs, obj)
+func importProviders(selection *providerSelector) error {
+	config, err := loadImportConfig(*importConfigFile)
+	if err != nil {
+		return err
+	}
+	rbacPolicy, err := loadRBACPolicy(rbacPolicyFileName)
+	if err != nil {
+		return err
+	}
+
+	selected := []provider{}
+	for i := range providers {
+		if selection.selects(&providers[i]) {
+			selected = append(selected, providers[i])
 		}
 	}
-	return finalObjs
-}
+	if len(selected) == 0 {
+		return errors.New("no provider matches --providers and --skip-providers, see the list command")
+	}
 
-func importProviders(providerFilter string) error {
-	for _, p := range providers {
-		if providerFilter != "" && p.name != providerFilter {
+	start := time.Now()
+	run := &importRun{config: config, rbacPolicy: rbacPolicy, relatedImages: map[string]string{}}
+	reports := []providerReport{}
+	imported := map[string]bool{}
+	failures := []string{}
+	for i := range selected {
+		p := &selected[i]
+		progress := fmt.Sprintf("[%d/%d]", i+1, len(selected))
+		report, err := run.importProvider(p, progress)
+		if err != nil {
+			// the other providers are still imported, so one bad release doesn't hide their results
+			err = errors.Wrapf(err, "%s %s", p.ptype, p.assetName())
+			klog.Errorf("%s %v", progress, err)
+			failures = append(failures, err.Error())
 			continue
 		}
+		reports = append(reports, *report)
+		imported[p.providerTypeName()+"-"+p.assetName()] = true
+	}
+	klog.Infof("imported %d of %d providers in %s", len(imported), len(selected), time.Since(start).Round(time.Millisecond))
 
-		err := p.loadComponents()
-		if err != nil {
+	if *outputMode == outputEmbed {
+		if err := writeRegistryPackage(); err != nil {
 			return err
 		}
-		fmt.Println(p.ptype, p.name)
-
-		finalObjs, rbacObjs := splitRBACOut(certManagerToServiceCA(p.components.Objs()))
+	}
 
-		if p.name == "metal3" {
-			finalObjs = filterOutIPAM(finalObjs)
+	// only a full import knows which files are still generated
+	if !selection.partial() && !*noPrune {
+		if err := pruneStaleAssets(imported, run.relatedImages); err != nil {
+			return err
 		}
+	}
 
-		err = p.writeRBACComponentsToManifests(rbacObjs)
-		if err != nil {
+	// the mirrors cover the images left after the pruning
+	if err := writeMirrorSet(); err != nil {
+		return err
+	}
+
+	// the base includes the providers left after the pruning
+	if *outputMode == outputKustomize {
+		if err := writeKustomizeBase(); err != nil {
 			return err
 		}
+	}
 
-		err = p.updateImages(finalObjs)
-		if err != nil {
+	if *reportFile != "" {
+		if err := writeReport(*reportFile, reports); err != nil {
 			return err
 		}
+	}
+
+	if len(failures) > 0 {
+		return errors.Errorf("failed to import %d of %d providers:\n  %s", len(failures), len(selected), strings.Join(failures, "\n  "))
+	}
+	return nil
+}
+
+// importRun is the state shared by the providers imported by a run.
+type importRun struct {
+	config     *importConfig
+	rbacPolicy *rbacPolicy
+	// contract is the cluster-api contract of the core provider, found with the first provider
+	contract string
+	// relatedImages are the images of the imported providers, by RELATED_IMAGE name
+	relatedImages map[string]string
+}
 
-		err = p.writeProviderComponents(finalObjs)
+// importProvider writes the generated files of the provider and returns its change report,
+// progress prefixes the log lines.
+func (r *importRun) importProvider(p *provider, progress string) (*providerReport, error) {
+	providerStart := time.Now()
+	err := p.loadComponents()
+	if err != nil {
+		return nil, err
+	}
+	klog.Infof("%s importing %s %s %s", progress, p.ptype, p.assetName(), p.version)
+
+	// the core provider comes first, unless it is filtered out
+	if r.contract == "" {
+		if p.ptype == clusterctlv1.CoreProviderType {
+			r.contract, err = p.contract()
+		} else {
+			r.contract, err = coreContract()
+		}
 		if err != nil {
-			return err
+			return nil, err
 		}
+	}
+	if err := p.checkContract(r.contract); err != nil {
+		return nil, err
+	}
+	before, err := p.snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	objs, err := newProviderPipeline(r.config).run(p, p.components.Objs())
+	if err != nil {
+		return nil, err
+	}
+	p.setProvenance(objs...)
+	if err := validateObjects(objs); err != nil {
+		return nil, err
+	}
+	finalObjs, rbacObjs := splitRBACOut(objs)
+
+	err = lintRBAC(rbacObjs, r.rbacPolicy)
+	if err != nil {
+		return nil, err
+	}
+	err = p.writeRBACComponentsToManifests(rbacObjs)
+	if err != nil {
+		return nil, err
+	}
+
+	finalObjs, crdObjs := splitCRDsOut(finalObjs)
+	sharedCRDs, err := p.writeCRDsToManifests(crdObjs)
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.writeCredentialsRequest(r.config.forProvider(p))
+	if err != nil {
+		return nil, err
+	}
 
+	scraped := scrapedServices(finalObjs)
+	err = p.writeServiceMonitors(scraped)
+	if err != nil {
+		return nil, err
+	}
+	err = p.writePrometheusRules(finalObjs, scraped)
+	if err != nil {
+		return nil, err
+	}
+	err = p.writeNetworkPolicies(finalObjs, scraped, r.config.forProvider(p))
+	if err != nil {
+		return nil, err
+	}
+
+	finalObjs, images, err := p.setRelatedImages(finalObjs, r.config.PayloadImages)
+	if err != nil {
+		return nil, err
+	}
+	err = writeImagesManifest(images)
+	if err != nil {
+		return nil, err
+	}
+	for name, image := range images {
+		r.relatedImages[name] = image
+	}
+
+	snoObjs, err := singleReplicaVariant(finalObjs)
+	if err != nil {
+		return nil, err
+	}
+	switch *outputMode {
+	case outputEmbed:
+		err = p.writeRegistryComponents(finalObjs, "")
+		if err != nil {
+			return nil, err
+		}
+		err = p.writeRegistryComponents(snoObjs, singleReplicaTopology)
+		if err != nil {
+			return nil, err
+		}
+	case outputKustomize:
+		// the single node variant is the single replica component
+		err = p.writeKustomizeComponents(finalObjs)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		err = p.writeProviderComponents(finalObjs, "")
+		if err != nil {
+			return nil, err
+		}
+		err = p.writeProviderComponents(snoObjs, singleReplicaTopology)
+		if err != nil {
+			return nil, err
+		}
 		err = p.writeProviders()
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
-	return nil
+
+	after, err := p.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	report := p.changeReport(before, after)
+	report.CRDsShared = sharedCRDs
+	for _, change := range report.APIChanges {
+		klog.Infof("%s %s API change: %s", progress, p.assetName(), change)
+	}
+	klog.Infof("%s imported %s %s in %s: %d objects, %d CRDs, %d RBAC objects, %d images changed",
+		progress, p.assetName(), versionDelta(report.OldVersion, report.NewVersion),
+		time.Since(providerStart).Round(time.Millisecond), len(finalObjs), len(crdObjs), len(rbacObjs), len(report.ImagesChanged))
+	return &report, nil
 }
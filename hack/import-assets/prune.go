@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+// pruneStaleAssets removes the generated files and images of the providers that are no longer
+// imported, e.g. dropped from the providers list, after a full import. The files of the
+// providers that weren't imported by this run, like the optional ones, are kept.
+func pruneStaleAssets(imported map[string]bool, relatedImages map[string]string) error {
+	keep := map[string]bool{}
+	for name := range out.written {
+		keep[name] = true
+	}
+	// the operator images and NetworkPolicies come from import operator
+	keepImagePrefixes := []string{capiOperator.relatedImageName("")}
+	keep[path.Clean(capiOperator.networkPolicyManifestFile())] = true
+	for i := range providers {
+		p := &providers[i]
+		if imported[p.providerTypeName()+"-"+p.assetName()] {
+			continue
+		}
+		keepImagePrefixes = append(keepImagePrefixes, p.relatedImageName(""))
+		files := []string{p.componentsFile(""), p.componentsFile(singleReplicaSuffix), p.providerFile(), p.rbacManifestFile(), p.credentialsRequestManifestFile(),
+			p.serviceMonitorManifestFile(), p.prometheusRuleManifestFile(), p.networkPolicyManifestFile()}
+		crds, err := out.glob(p.crdManifestPrefix() + "*.yaml")
+		if err != nil {
+			return err
+		}
+		for _, dir := range []string{p.registryDir(), p.kustomizeProviderDir(), p.kustomizeOverlayDir()} {
+			dirFiles, err := out.glob(path.Join(dir, "*.yaml"))
+			if err != nil {
+				return err
+			}
+			files = append(files, dirFiles...)
+		}
+		for _, f := range append(files, crds...) {
+			keep[path.Clean(f)] = true
+		}
+	}
+
+	// only the files of the current --output are pruned, the ones of the other mode are left alone
+	componentsPatterns := []string{path.Join(providersPath, "*.yaml")}
+	switch *outputMode {
+	case outputEmbed:
+		componentsPatterns = []string{path.Join(*registryPath, "*", "*.yaml")}
+	case outputKustomize:
+		componentsPatterns = []string{
+			path.Join(*kustomizePath, kustomizeProvidersDir, "*", "*.yaml"),
+			path.Join(*kustomizePath, kustomizeOverlaysDir, "*", "*.yaml"),
+		}
+	}
+	candidates := []string{}
+	for _, pattern := range append(componentsPatterns,
+		path.Join(manifestsPath, "0000_30_cluster-api_*_03_rbac.yaml"),
+		path.Join(manifestsPath, "0000_30_cluster-api_*_02_crd_*.yaml"),
+		path.Join(manifestsPath, "0000_30_cluster-api_*_00_credentials-request.yaml"),
+		path.Join(manifestsPath, "0000_90_cluster-api_*_servicemonitor.yaml"),
+		path.Join(manifestsPath, "0000_90_cluster-api_*_prometheusrule.yaml"),
+		path.Join(manifestsPath, "0000_30_cluster-api_*_04_networkpolicy.yaml"),
+	) {
+		matches, err := out.glob(pattern)
+		if err != nil {
+			return err
+		}
+		candidates = append(candidates, matches...)
+	}
+	for _, f := range candidates {
+		if keep[path.Clean(f)] {
+			continue
+		}
+		klog.Infof("pruning %s", f)
+		if err := out.remove(f); err != nil {
+			return err
+		}
+	}
+
+	return pruneRelatedImages(relatedImages, keepImagePrefixes)
+}
+
+// pruneRelatedImages removes the RELATED_IMAGE entries that weren't written by this run,
+// unless they start with one of the prefixes kept.
+func pruneRelatedImages(relatedImages map[string]string, keepPrefixes []string) error {
+	b, err := out.readFile(imagesManifestFileName)
+	if err != nil {
+		return err
+	}
+	cm := &corev1.ConfigMap{}
+	if err := yaml.Unmarshal(b, cm); err != nil {
+		return errors.Wrapf(err, "invalid %s", imagesManifestFileName)
+	}
+	allImages := map[string]string{}
+	if err := json.Unmarshal([]byte(cm.Data[imagesManifestKey]), &allImages); err != nil {
+		return errors.Wrapf(err, "invalid %s in %s", imagesManifestKey, imagesManifestFileName)
+	}
+
+	pruned := false
+	for name := range allImages {
+		if !strings.HasPrefix(name, relatedImagePrefix) {
+			continue
+		}
+		if _, ok := relatedImages[name]; ok || hasAnyPrefix(name, keepPrefixes) {
+			continue
+		}
+		klog.Infof("pruning image %s", name)
+		delete(allImages, name)
+		pruned = true
+	}
+	if !pruned {
+		return nil
+	}
+
+	jsonData, err := json.MarshalIndent(&allImages, "", "  ")
+	if err != nil {
+		return err
+	}
+	cm.Data[imagesManifestKey] = string(ensureNewLine(jsonData))
+	b, err = canonicalYAML(cm)
+	if err != nil {
+		return err
+	}
+	return out.writeFile(imagesManifestFileName, b)
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"path"
+	"reflect"
+	"sort"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	clusterctlv1 "sigs.k8s.io/cluster-api/cmd/clusterctl/api/v1alpha3"
+	"sigs.k8s.io/yaml"
+)
+
+// testPaths points the generated files at an empty directory, for the duration of the test.
+func testPaths(t *testing.T) {
+	dir := t.TempDir()
+	previousProviders, previousManifests, previousImages := providersPath, manifestsPath, imagesManifestFileName
+	previousRegistry, previousKustomize := *registryPath, *kustomizePath
+	providersPath = path.Join(dir, "assets", "providers")
+	manifestsPath = path.Join(dir, "manifests")
+	imagesManifestFileName = path.Join(manifestsPath, "0000_30_cluster-api_capi-operator_01_images.configmap.yaml")
+	*registryPath = path.Join(dir, "assets", "registry")
+	*kustomizePath = path.Join(dir, "assets", "kustomize")
+	t.Cleanup(func() {
+		providersPath, manifestsPath, imagesManifestFileName = previousProviders, previousManifests, previousImages
+		*registryPath, *kustomizePath = previousRegistry, previousKustomize
+	})
+}
+
+// testImagesManifest returns the images ConfigMap holding images.
+func testImagesManifest(t *testing.T, images map[string]string) []byte {
+	jsonData, err := json.Marshal(images)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := canonicalYAML(&corev1.ConfigMap{Data: map[string]string{imagesManifestKey: string(jsonData)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestPruneStaleAssets(t *testing.T) {
+	defer func(previous []provider) { providers = previous }(providers)
+	defer func(mode string) { *outputMode = mode }(*outputMode)
+	testPaths(t)
+
+	aws := provider{name: "aws", ptype: clusterctlv1.InfrastructureProviderType}
+	azure := provider{name: "azure", ptype: clusterctlv1.InfrastructureProviderType, optional: true}
+	openstack := provider{name: "openstack", ptype: clusterctlv1.InfrastructureProviderType}
+	providers = []provider{aws, azure}
+
+	componentsFiles := map[string]func(p *provider) string{
+		outputConfigMaps: func(p *provider) string { return p.componentsFile("") },
+		outputEmbed:      func(p *provider) string { return path.Join(p.registryDir(), "components.yaml") },
+		outputKustomize:  func(p *provider) string { return path.Join(p.kustomizeProviderDir(), "components.yaml") },
+	}
+	for _, mode := range []string{outputConfigMaps, outputEmbed, outputKustomize} {
+		t.Run(mode, func(t *testing.T) {
+			*outputMode = mode
+			otherMode := outputEmbed
+			if mode == outputEmbed {
+				otherMode = outputConfigMaps
+			}
+			files := map[string][]byte{
+				componentsFiles[mode](&azure):                            []byte("azure"),
+				componentsFiles[mode](&openstack):                        []byte("openstack"),
+				componentsFiles[otherMode](&openstack):                   []byte("openstack"),
+				azure.crdManifestPrefix() + "azureclusters.yaml":         []byte("azure"),
+				openstack.crdManifestPrefix() + "openstackclusters.yaml": []byte("openstack"),
+				openstack.rbacManifestFile():                             []byte("openstack"),
+				capiOperator.networkPolicyManifestFile():                 []byte("operator"),
+				imagesManifestFileName: testImagesManifest(t, map[string]string{
+					capiOperator.relatedImageName("manager"):     "operator",
+					"RELATED_IMAGE_INFRASTRUCTURE_AWS_MANAGER":   "aws",
+					"RELATED_IMAGE_INFRASTRUCTURE_AZURE_MANAGER": "azure",
+					"RELATED_IMAGE_INFRASTRUCTURE_GCP_MANAGER":   "gcp",
+					"cluster-capi-operator":                      "operator",
+				}),
+			}
+			testOutput(t, files)
+			if err := out.writeFile(componentsFiles[mode](&aws), []byte("aws")); err != nil {
+				t.Fatal(err)
+			}
+
+			imported := map[string]bool{"infrastructure-aws": true}
+			relatedImages := map[string]string{"RELATED_IMAGE_INFRASTRUCTURE_AWS_MANAGER": "aws"}
+			if err := pruneStaleAssets(imported, relatedImages); err != nil {
+				t.Fatal(err)
+			}
+
+			remaining := []string{}
+			for name, b := range out.files {
+				if b != nil && name != imagesManifestFileName {
+					remaining = append(remaining, name)
+				}
+			}
+			sort.Strings(remaining)
+			want := []string{
+				path.Clean(componentsFiles[mode](&aws)),
+				path.Clean(componentsFiles[mode](&azure)),
+				path.Clean(componentsFiles[otherMode](&openstack)),
+				azure.crdManifestPrefix() + "azureclusters.yaml",
+				capiOperator.networkPolicyManifestFile(),
+			}
+			sort.Strings(want)
+			if !reflect.DeepEqual(remaining, want) {
+				t.Errorf("remaining files %v, want %v", remaining, want)
+			}
+
+			cm := &corev1.ConfigMap{}
+			if err := yaml.Unmarshal(out.files[imagesManifestFileName], cm); err != nil {
+				t.Fatal(err)
+			}
+			images := map[string]string{}
+			if err := json.Unmarshal([]byte(cm.Data[imagesManifestKey]), &images); err != nil {
+				t.Fatal(err)
+			}
+			wantImages := map[string]string{
+				capiOperator.relatedImageName("manager"):     "operator",
+				"RELATED_IMAGE_INFRASTRUCTURE_AWS_MANAGER":   "aws",
+				"RELATED_IMAGE_INFRASTRUCTURE_AZURE_MANAGER": "azure",
+				"cluster-capi-operator":                      "operator",
+			}
+			if !reflect.DeepEqual(images, wantImages) {
+				t.Errorf("images %v, want %v", images, wantImages)
+			}
+		})
+	}
+}
+
+func TestPruneRelatedImages(t *testing.T) {
+	testPaths(t)
+	images := map[string]string{"RELATED_IMAGE_CORE_CLUSTER_API_MANAGER": "capi", "cluster-capi-operator": "operator"}
+	testOutput(t, map[string][]byte{imagesManifestFileName: testImagesManifest(t, images)})
+	before := out.files[imagesManifestFileName]
+
+	// nothing to prune, the file isn't rewritten
+	if err := pruneRelatedImages(map[string]string{"RELATED_IMAGE_CORE_CLUSTER_API_MANAGER": "capi"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if out.written[imagesManifestFileName] || !reflect.DeepEqual(out.files[imagesManifestFileName], before) {
+		t.Errorf("%s rewritten without any image pruned", imagesManifestFileName)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+const rbacPolicyFileName = "rbac-policy.yaml"
+
+// The checks run over the rules of the generated roles.
+const (
+	checkWildcardVerbs      = "wildcardVerbs"
+	checkWildcardResources  = "wildcardResources"
+	checkEscalateOrBind     = "escalateOrBind"
+	checkClusterSecretsRead = "clusterSecretsRead"
+)
+
+const (
+	rbacSeverityError  = "error"
+	rbacSeverityWarn   = "warn"
+	rbacSeverityIgnore = "ignore"
+)
+
+var rbacChecks = []string{checkWildcardVerbs, checkWildcardResources, checkEscalateOrBind, checkClusterSecretsRead}
+
+// rbacPolicy sets how the findings of the RBAC linter are handled.
+type rbacPolicy struct {
+	// Checks maps each check to error (the default), warn or ignore.
+	Checks map[string]string `json:"checks,omitempty"`
+	// Allow accepts the findings of some checks for a role.
+	Allow []rbacAllowance `json:"allow,omitempty"`
+}
+
+type rbacAllowance struct {
+	// Role is matched against the role name without the namespace prefix added by clusterctl,
+	// it can be a path.Match pattern, e.g. "capz-*".
+	Role   string   `json:"role"`
+	Checks []string `json:"checks"`
+	// Reason documents why the access is needed.
+	Reason string `json:"reason"`
+}
+
+func loadRBACPolicy(fileName string) (*rbacPolicy, error) {
+	policy := &rbacPolicy{}
+	b, err := ioutil.ReadFile(filepath.Clean(fileName))
+	if os.IsNotExist(err) {
+		return policy, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.UnmarshalStrict(b, policy); err != nil {
+		return nil, errors.Wrapf(err, "invalid %s", fileName)
+	}
+	for check, severity := range policy.Checks {
+		if !isRBACCheck(check) {
+			return nil, errors.Errorf("invalid %s: unknown check %q", fileName, check)
+		}
+		switch severity {
+		case rbacSeverityError, rbacSeverityWarn, rbacSeverityIgnore:
+		default:
+			return nil, errors.Errorf("invalid %s: unknown severity %q for %s", fileName, severity, check)
+		}
+	}
+	for _, allowance := range policy.Allow {
+		if _, err := path.Match(allowance.Role, ""); err != nil {
+			return nil, errors.Wrapf(err, "invalid %s: role %q", fileName, allowance.Role)
+		}
+		for _, check := range allowance.Checks {
+			if !isRBACCheck(check) {
+				return nil, errors.Errorf("invalid %s: unknown check %q for %s", fileName, check, allowance.Role)
+			}
+		}
+	}
+	return policy, nil
+}
+
+func isRBACCheck(check string) bool {
+	return containsString(rbacChecks, check)
+}
+
+func (p *rbacPolicy) severity(check string) string {
+	if severity, ok := p.Checks[check]; ok {
+		return severity
+	}
+	return rbacSeverityError
+}
+
+func (p *rbacPolicy) allowed(role, check string) bool {
+	role = strings.TrimPrefix(role, *targetNamespace+"-")
+	for _, allowance := range p.Allow {
+		if ok, _ := path.Match(allowance.Role, role); !ok {
+			continue
+		}
+		if containsString(allowance.Checks, check) {
+			return true
+		}
+	}
+	return false
+}
+
+// lintRBAC flags the overly broad rules of the ClusterRoles and Roles, the warnings are
+// printed and the errors fail the import, unless the policy allows them for the role.
+func lintRBAC(objs []unstructured.Unstructured, policy *rbacPolicy) error {
+	errs := []string{}
+	for _, obj := range objs {
+		if obj.GetKind() != "ClusterRole" && obj.GetKind() != "Role" {
+			continue
+		}
+		role := &rbacv1.ClusterRole{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, role); err != nil {
+			return err
+		}
+		for i, rule := range role.Rules {
+			for _, check := range ruleFindings(obj.GetKind(), rule) {
+				severity := policy.severity(check)
+				if severity == rbacSeverityIgnore || policy.allowed(obj.GetName(), check) {
+					continue
+				}
+				msg := fmt.Sprintf("%s %s rules[%d] %s: %s", obj.GetKind(), obj.GetName(), i, check, describeRule(rule))
+				if severity == rbacSeverityWarn {
+					klog.Warning(msg)
+					continue
+				}
+				errs = append(errs, msg)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("RBAC not allowed by %s:\n  %s", rbacPolicyFileName, strings.Join(errs, "\n  "))
+	}
+	return nil
+}
+
+// ruleFindings returns the checks the rule fails, the rules of a ClusterRole apply to all
+// namespaces.
+func ruleFindings(kind string, rule rbacv1.PolicyRule) []string {
+	findings := []string{}
+	if containsString(rule.Verbs, rbacv1.VerbAll) {
+		findings = append(findings, checkWildcardVerbs)
+	}
+	if containsString(rule.Resources, rbacv1.ResourceAll) {
+		findings = append(findings, checkWildcardResources)
+	}
+	if containsString(rule.Verbs, "escalate") || containsString(rule.Verbs, "bind") {
+		findings = append(findings, checkEscalateOrBind)
+	}
+	if kind == "ClusterRole" && containsString(rule.APIGroups, "") && containsString(rule.Resources, "secrets") &&
+		(containsString(rule.Verbs, "get") || containsString(rule.Verbs, "list") || containsString(rule.Verbs, "watch") || containsString(rule.Verbs, rbacv1.VerbAll)) {
+		findings = append(findings, checkClusterSecretsRead)
+	}
+	return findings
+}
+
+func describeRule(rule rbacv1.PolicyRule) string {
+	return fmt.Sprintf("apiGroups=%q resources=%q verbs=%q", rule.APIGroups, rule.Resources, rule.Verbs)
+}
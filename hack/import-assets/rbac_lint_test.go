@@ -0,0 +1,194 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestRuleFindings(t *testing.T) {
+	tests := []struct {
+		name string
+		kind string
+		rule rbacv1.PolicyRule
+		want []string
+	}{
+		{
+			name: "narrow",
+			kind: "ClusterRole",
+			rule: rbacv1.PolicyRule{APIGroups: []string{"infrastructure.cluster.x-k8s.io"}, Resources: []string{"awsclusters"}, Verbs: []string{"get", "list", "watch"}},
+			want: []string{},
+		},
+		{
+			name: "wildcards",
+			kind: "Role",
+			rule: rbacv1.PolicyRule{APIGroups: []string{"*"}, Resources: []string{"*"}, Verbs: []string{"*"}},
+			want: []string{checkWildcardVerbs, checkWildcardResources},
+		},
+		{
+			name: "escalate",
+			kind: "ClusterRole",
+			rule: rbacv1.PolicyRule{APIGroups: []string{"rbac.authorization.k8s.io"}, Resources: []string{"clusterroles"}, Verbs: []string{"escalate"}},
+			want: []string{checkEscalateOrBind},
+		},
+		{
+			name: "bind",
+			kind: "Role",
+			rule: rbacv1.PolicyRule{APIGroups: []string{"rbac.authorization.k8s.io"}, Resources: []string{"roles"}, Verbs: []string{"bind"}},
+			want: []string{checkEscalateOrBind},
+		},
+		{
+			name: "cluster secrets",
+			kind: "ClusterRole",
+			rule: rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"secrets"}, Verbs: []string{"list"}},
+			want: []string{checkClusterSecretsRead},
+		},
+		{
+			name: "cluster secrets written only",
+			kind: "ClusterRole",
+			rule: rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"secrets"}, Verbs: []string{"create", "delete"}},
+			want: []string{},
+		},
+		{
+			name: "namespace secrets",
+			kind: "Role",
+			rule: rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"secrets"}, Verbs: []string{"get"}},
+			want: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ruleFindings(tt.kind, tt.rule); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ruleFindings() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLintRBAC(t *testing.T) {
+	role := func(kind, name string, rules ...rbacv1.PolicyRule) unstructured.Unstructured {
+		obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&rbacv1.ClusterRole{Rules: rules})
+		if err != nil {
+			t.Fatal(err)
+		}
+		u := unstructured.Unstructured{Object: obj}
+		u.SetAPIVersion("rbac.authorization.k8s.io/v1")
+		u.SetKind(kind)
+		u.SetName(name)
+		return u
+	}
+	secrets := rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"secrets"}, Verbs: []string{"get"}}
+	wildcard := rbacv1.PolicyRule{APIGroups: []string{"*"}, Resources: []string{"*"}, Verbs: []string{"get"}}
+
+	tests := []struct {
+		name    string
+		objs    []unstructured.Unstructured
+		policy  rbacPolicy
+		wantErr bool
+	}{
+		{
+			name:    "error by default",
+			objs:    []unstructured.Unstructured{role("ClusterRole", "openshift-cluster-api-capz-manager-role", secrets)},
+			wantErr: true,
+		},
+		{
+			name:   "warning",
+			objs:   []unstructured.Unstructured{role("ClusterRole", "openshift-cluster-api-capz-manager-role", secrets)},
+			policy: rbacPolicy{Checks: map[string]string{checkClusterSecretsRead: rbacSeverityWarn}},
+		},
+		{
+			name:   "ignored",
+			objs:   []unstructured.Unstructured{role("ClusterRole", "openshift-cluster-api-capz-manager-role", secrets)},
+			policy: rbacPolicy{Checks: map[string]string{checkClusterSecretsRead: rbacSeverityIgnore}},
+		},
+		{
+			name:   "allowed for the role without the namespace prefix",
+			objs:   []unstructured.Unstructured{role("ClusterRole", "openshift-cluster-api-capz-manager-role", secrets)},
+			policy: rbacPolicy{Allow: []rbacAllowance{{Role: "capz-*", Checks: []string{checkClusterSecretsRead}}}},
+		},
+		{
+			name:    "allowed for another check",
+			objs:    []unstructured.Unstructured{role("ClusterRole", "openshift-cluster-api-capz-manager-role", secrets, wildcard)},
+			policy:  rbacPolicy{Allow: []rbacAllowance{{Role: "capz-*", Checks: []string{checkClusterSecretsRead}}}},
+			wantErr: true,
+		},
+		{
+			name:    "allowed for another role",
+			objs:    []unstructured.Unstructured{role("ClusterRole", "openshift-cluster-api-capa-manager-role", secrets)},
+			policy:  rbacPolicy{Allow: []rbacAllowance{{Role: "capz-*", Checks: []string{checkClusterSecretsRead}}}},
+			wantErr: true,
+		},
+		{
+			name: "bindings aren't linted",
+			objs: []unstructured.Unstructured{role("ClusterRoleBinding", "openshift-cluster-api-capz-manager-rolebinding")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := lintRBAC(tt.objs, &tt.policy); (err != nil) != tt.wantErr {
+				t.Errorf("lintRBAC() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadRBACPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  string
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			policy: `checks:
+  wildcardVerbs: warn
+allow:
+- role: capz-*
+  checks:
+  - clusterSecretsRead
+  reason: reads the identity secrets of the clusters
+`,
+		},
+		{
+			name:    "unknown check",
+			policy:  "checks:\n  wildcards: warn\n",
+			wantErr: true,
+		},
+		{
+			name:    "unknown severity",
+			policy:  "checks:\n  wildcardVerbs: fatal\n",
+			wantErr: true,
+		},
+		{
+			name:    "unknown allowed check",
+			policy:  "allow:\n- role: capz-*\n  checks:\n  - wildcards\n",
+			wantErr: true,
+		},
+		{
+			name:    "invalid role pattern",
+			policy:  "allow:\n- role: capz-[\n  checks:\n  - wildcardVerbs\n",
+			wantErr: true,
+		},
+		{
+			name:    "unknown field",
+			policy:  "allowed:\n- role: capz-*\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fileName := filepath.Join(t.TempDir(), rbacPolicyFileName)
+			if err := ioutil.WriteFile(fileName, []byte(tt.policy), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := loadRBACPolicy(fileName); (err != nil) != tt.wantErr {
+				t.Errorf("loadRBACPolicy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -1,14 +1,13 @@
 package main
 
 import (
-	"fmt"
-	"io/ioutil"
-	"os"
 	"path"
 
+	"github.com/pkg/errors"
 	rbacv1 "k8s.io/api/rbac/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/klog/v2"
 	utilyaml "sigs.k8s.io/cluster-api/util/yaml"
 )
 
@@ -19,10 +18,12 @@ var (
 		"exclude.release.openshift.io/internal-openshift-hosted":      "true",
 		"include.release.openshift.io/self-managed-high-availability": "true",
 		"include.release.openshift.io/single-node-developer":          "true",
+		// the cluster-api components are only installed on tech preview clusters
+		"release.openshift.io/feature-gate": "TechPreviewNoUpgrade",
 	}
 )
 
-func upstreamOperatorRoles() []unstructured.Unstructured {
+func upstreamOperatorRoles() ([]unstructured.Unstructured, error) {
 	writeVerbs := []string{"create", "delete", "get", "list", "patch", "update", "watch"}
 	capiOperatorManagerRole := &rbacv1.ClusterRole{
 		TypeMeta: metav1.TypeMeta{
@@ -77,21 +78,21 @@ func upstreamOperatorRoles() []unstructured.Unstructured {
 
 	obj := unstructured.Unstructured{}
 	if err := scheme.Convert(capiOperatorManagerRole, &obj, nil); err != nil {
-		panic(err)
+		return nil, errors.Wrapf(err, "converting ClusterRole %s", capiOperatorManagerRole.Name)
 	}
 	setOpenShiftAnnotations(obj, false)
-	return []unstructured.Unstructured{obj}
+	return []unstructured.Unstructured{obj}, nil
 }
 
 func rbacObjects() ([]unstructured.Unstructured, error) {
-	fileInfo, err := ioutil.ReadDir(assetsDir)
+	assets, err := out.glob(path.Join(assetsDir, "*.yaml"))
 	if err != nil {
 		return nil, err
 	}
 
 	roles := []unstructured.Unstructured{}
-	for _, fi := range fileInfo {
-		b, err := os.ReadFile(path.Join(assetsDir, fi.Name()))
+	for _, asset := range assets {
+		b, err := out.readFile(asset)
 		if err != nil {
 			return nil, err
 		}
@@ -106,16 +107,20 @@ func rbacObjects() ([]unstructured.Unstructured, error) {
 		case "ClusterRole", "Role", "ClusterRoleBinding", "RoleBinding", "ServiceAccount":
 			if obj.GetName() == "capi-operator-manager-role" && obj.GetKind() == "ClusterRole" {
 				// ignore this, and insert our own roles below.
-				fmt.Println("skipping capi-operator-manager-role ", obj.GetKind())
+				klog.V(2).Infof("skipping %s %s", obj.GetKind(), obj.GetName())
 				continue
 			}
-			fmt.Println("moving ", obj.GetName(), " ", obj.GetKind())
+			klog.V(2).Infof("moving %s %s to the manifests", obj.GetKind(), obj.GetName())
 			setOpenShiftAnnotations(obj, true)
 			roles = append(roles, obj)
 		default:
 		}
 	}
-	return append(roles, upstreamOperatorRoles()...), nil
+	upstreamRoles, err := upstreamOperatorRoles()
+	if err != nil {
+		return nil, err
+	}
+	return append(roles, upstreamRoles...), nil
 }
 
 func setOpenShiftAnnotations(obj unstructured.Unstructured, merge bool) {
@@ -135,9 +140,16 @@ func moveRBACToManifests() error {
 	if err != nil {
 		return err
 	}
-	b, err := utilyaml.FromUnstructured(roles)
+	rbacPolicy, err := loadRBACPolicy(rbacPolicyFileName)
+	if err != nil {
+		return err
+	}
+	if err := lintRBAC(roles, rbacPolicy); err != nil {
+		return err
+	}
+	b, err := canonicalObjectsYAML(roles)
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(outFile, ensureNewLine(b), 0600)
+	return out.writeFile(outFile, b)
 }
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"path"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	registryGoFileName       = "registry.go"
+	registryProviderFileName = "provider.yaml"
+	registryMetadataFileName = "metadata.yaml"
+	// the components are in components.yaml, and components-sno.yaml for the single node variant
+	registryComponentsName = "components"
+)
+
+// registryProvider is the provider.yaml of a provider in the registry package.
+type registryProvider struct {
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// registryDir is the directory of the provider in the registry package.
+func (p *provider) registryDir() string {
+	return path.Join(*registryPath, strings.ToLower(p.providerTypeName()+"-"+p.assetName()))
+}
+
+func (p *provider) registryComponentsFile(suffix string) string {
+	return path.Join(p.registryDir(), registryComponentsName+suffix+".yaml")
+}
+
+// writeRegistryComponents writes the components, metadata.yaml and the provider.yaml of the
+// provider to the registry package, the topology is set for the single node variant.
+func (p *provider) writeRegistryComponents(objs []unstructured.Unstructured, topology string) error {
+	combined, err := canonicalObjectsYAML(objs)
+	if err != nil {
+		return err
+	}
+	suffix := ""
+	if topology != "" {
+		suffix = singleReplicaSuffix
+	}
+	if err := out.writeFile(p.registryComponentsFile(suffix), combined); err != nil {
+		return err
+	}
+	if topology != "" {
+		return nil
+	}
+
+	if err := out.writeFile(path.Join(p.registryDir(), registryMetadataFileName), ensureNewLine(p.metadata)); err != nil {
+		return err
+	}
+	b, err := canonicalYAML(&registryProvider{Type: p.providerTypeName(), Name: p.assetName(), Version: p.version})
+	if err != nil {
+		return err
+	}
+	return out.writeFile(path.Join(p.registryDir(), registryProviderFileName), b)
+}
+
+// readRegistryProvider returns the provider.yaml of the provider, for the change report.
+func (p *provider) readRegistryProvider() (*registryProvider, error) {
+	b, err := out.readFile(path.Join(p.registryDir(), registryProviderFileName))
+	if err != nil {
+		return nil, err
+	}
+	rp := &registryProvider{}
+	if err := yaml.Unmarshal(b, rp); err != nil {
+		return nil, err
+	}
+	return rp, nil
+}
+
+// writeRegistryPackage writes the Go source of the registry package, the providers are found
+// in the embedded files at runtime so an import of a single provider keeps the others.
+func writeRegistryPackage() error {
+	return out.writeFile(path.Join(*registryPath, registryGoFileName), []byte(registryGoFile))
+}
+
+// registryGoFile is the accessor API of the registry package.
+const registryGoFile = `// Code generated by hack/import-assets. DO NOT EDIT.
+
+// Package registry embeds the components of the imported cluster-api providers, transformed
+// for OpenShift, so that they can be installed without the components ConfigMaps.
+package registry
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+//go:embed */*.yaml
+var files embed.FS
+
+// Provider is an imported provider.
+type Provider struct {
+	// Type is the provider type, e.g. "core" or "infrastructure".
+	Type string ` + "`json:\"type\"`" + `
+	// Name is the name of the provider, including the flavor, e.g. "aws" or "ibmcloud-powervs".
+	Name string ` + "`json:\"name\"`" + `
+	// Version is the imported release, e.g. "v1.0.0".
+	Version string ` + "`json:\"version\"`" + `
+
+	dir string
+}
+
+// Providers returns the imported providers sorted by type and name.
+func Providers() ([]Provider, error) {
+	entries, err := fs.ReadDir(files, ".")
+	if err != nil {
+		return nil, err
+	}
+	providers := []Provider{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		b, err := files.ReadFile(path.Join(entry.Name(), "` + registryProviderFileName + `"))
+		if err != nil {
+			return nil, err
+		}
+		p := Provider{dir: entry.Name()}
+		if err := yaml.Unmarshal(b, &p); err != nil {
+			return nil, fmt.Errorf("invalid provider %s: %v", entry.Name(), err)
+		}
+		providers = append(providers, p)
+	}
+	sort.Slice(providers, func(i, j int) bool {
+		if providers[i].Type != providers[j].Type {
+			return providers[i].Type < providers[j].Type
+		}
+		return providers[i].Name < providers[j].Name
+	})
+	return providers, nil
+}
+
+// Get returns the provider with the type and name.
+func Get(providerType, name string) (Provider, error) {
+	providers, err := Providers()
+	if err != nil {
+		return Provider{}, err
+	}
+	for _, p := range providers {
+		if p.Type == providerType && p.Name == name {
+			return p, nil
+		}
+	}
+	return Provider{}, fmt.Errorf("no %s provider %q", providerType, name)
+}
+
+// Components returns the multi document YAML of the components of the provider, with the
+// clusterctl variables and the RELATED_IMAGE placeholders still to be substituted.
+func (p Provider) Components() ([]byte, error) {
+	return files.ReadFile(path.Join(p.dir, "` + registryComponentsName + `.yaml"))
+}
+
+// SingleReplicaComponents returns the components of the provider for single node clusters.
+func (p Provider) SingleReplicaComponents() ([]byte, error) {
+	return files.ReadFile(path.Join(p.dir, "` + registryComponentsName + singleReplicaSuffix + `.yaml"))
+}
+
+// Metadata returns the clusterctl metadata.yaml of the provider release.
+func (p Provider) Metadata() ([]byte, error) {
+	return files.ReadFile(path.Join(p.dir, "` + registryMetadataFileName + `"))
+}
+`
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"go/format"
+	"path"
+	"reflect"
+	"sort"
+	"testing"
+
+	clusterctlv1 "sigs.k8s.io/cluster-api/cmd/clusterctl/api/v1alpha3"
+)
+
+func TestWriteRegistryComponents(t *testing.T) {
+	testPaths(t)
+	p := &provider{name: "ibmcloud", flavor: "powervs", ptype: clusterctlv1.InfrastructureProviderType, version: "v0.2.0", metadata: []byte("apiVersion: clusterctl.cluster.x-k8s.io/v1alpha3")}
+	dir := path.Join(*registryPath, "infrastructure-ibmcloud-powervs")
+
+	tests := []struct {
+		name     string
+		topology string
+		want     []string
+	}{
+		{
+			name: "default",
+			want: []string{path.Join(dir, "components.yaml"), path.Join(dir, "metadata.yaml"), path.Join(dir, "provider.yaml")},
+		},
+		{
+			name:     "single replica",
+			topology: singleReplicaTopology,
+			want:     []string{path.Join(dir, "components-sno.yaml")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testOutput(t, map[string][]byte{})
+			objs := testObjects(t, "apiVersion: v1\nkind: Namespace\nmetadata:\n  name: capi-ibmcloud-system\n")
+			if err := p.writeRegistryComponents(objs, tt.topology); err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for name := range out.written {
+				got = append(got, name)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("written %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("provider.yaml", func(t *testing.T) {
+		testOutput(t, map[string][]byte{})
+		if err := p.writeRegistryComponents(nil, ""); err != nil {
+			t.Fatal(err)
+		}
+		got, err := p.readRegistryProvider()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := &registryProvider{Type: "infrastructure", Name: "ibmcloud-powervs", Version: "v0.2.0"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("readRegistryProvider() = %+v, want %+v", got, want)
+		}
+	})
+}
+
+func TestRegistryGoFile(t *testing.T) {
+	// the generated package is checked in, it has to build and stay gofmt clean
+	b, err := format.Source([]byte(registryGoFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != registryGoFile {
+		t.Errorf("registry.go isn't gofmt clean:\n%s", b)
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilyaml "sigs.k8s.io/cluster-api/util/yaml"
+	"sigs.k8s.io/yaml"
+)
+
+// providerSnapshot is what the report compares before and after the import of a provider.
+type providerSnapshot struct {
+	version string
+	crds    map[string]bool
+	apis    map[string]crdAPI
+	images  map[string]string
+	rules   map[string]bool
+}
+
+type imageChange struct {
+	Name string `json:"name"`
+	Old  string `json:"old,omitempty"`
+	New  string `json:"new,omitempty"`
+}
+
+// providerReport is the change of a provider, for the description of a bump PR.
+type providerReport struct {
+	Provider         string        `json:"provider"`
+	OldVersion       string        `json:"oldVersion,omitempty"`
+	NewVersion       string        `json:"newVersion"`
+	CRDsAdded        []string      `json:"crdsAdded,omitempty"`
+	CRDsRemoved      []string      `json:"crdsRemoved,omitempty"`
+	CRDsShared       []string      `json:"crdsShared,omitempty"`
+	APIChanges       []string      `json:"apiChanges,omitempty"`
+	ImagesChanged    []imageChange `json:"imagesChanged,omitempty"`
+	RBACRulesAdded   []string      `json:"rbacRulesAdded,omitempty"`
+	RBACRulesRemoved []string      `json:"rbacRulesRemoved,omitempty"`
+}
+
+// snapshot reads the generated files of the provider, through out so it sees a dry run too.
+func (p *provider) snapshot() (*providerSnapshot, error) {
+	s := &providerSnapshot{crds: map[string]bool{}, apis: map[string]crdAPI{}, images: map[string]string{}, rules: map[string]bool{}}
+
+	switch *outputMode {
+	case outputEmbed:
+		rp, err := p.readRegistryProvider()
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err == nil {
+			s.version = rp.Version
+		}
+	case outputKustomize:
+		version, err := p.readKustomizeVersion()
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		s.version = version
+	}
+
+	b, err := out.readFile(p.componentsFile(""))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil && *outputMode == outputConfigMaps {
+		cm := &corev1.ConfigMap{}
+		if err := yaml.Unmarshal(b, cm); err != nil {
+			return nil, err
+		}
+		s.version = cm.Labels["provider.cluster.x-k8s.io/version"]
+		// the CRDs used to be part of the components, or of their shards
+		objs, err := utilyaml.ToUnstructured([]byte(cm.Data[componentsKey]))
+		if err != nil {
+			return nil, err
+		}
+		shardFiles, err := out.glob(p.componentsFile("-crds-*"))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range shardFiles {
+			b, err := out.readFile(f)
+			if err != nil {
+				return nil, err
+			}
+			shard := &corev1.ConfigMap{}
+			if err := yaml.Unmarshal(b, shard); err != nil {
+				return nil, err
+			}
+			shardObjs, err := utilyaml.ToUnstructured([]byte(shard.Data[crdsKey]))
+			if err != nil {
+				return nil, err
+			}
+			objs = append(objs, shardObjs...)
+		}
+		for _, obj := range objs {
+			if isCRD(obj) {
+				s.crds[obj.GetName()] = true
+				if s.apis[obj.GetName()], err = newCRDAPI(obj); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	crdFiles, err := out.glob(p.crdManifestPrefix() + "*.yaml")
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range crdFiles {
+		name := strings.TrimSuffix(strings.TrimPrefix(f, filepath.Clean(p.crdManifestPrefix())), ".yaml")
+		s.crds[name] = true
+		b, err := out.readFile(f)
+		if err != nil {
+			return nil, err
+		}
+		objs, err := utilyaml.ToUnstructured(b)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range objs {
+			if s.apis[obj.GetName()], err = newCRDAPI(obj); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	b, err = out.readFile(p.rbacManifestFile())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		objs, err := utilyaml.ToUnstructured(b)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range objs {
+			if obj.GetKind() != "ClusterRole" && obj.GetKind() != "Role" {
+				continue
+			}
+			role := &rbacv1.ClusterRole{}
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, role); err != nil {
+				return nil, err
+			}
+			name := strings.TrimPrefix(obj.GetName(), *targetNamespace+"-")
+			for _, rule := range role.Rules {
+				s.rules[fmt.Sprintf("%s %s %s", obj.GetKind(), name, describeRule(rule))] = true
+			}
+		}
+	}
+
+	b, err = out.readFile(imagesManifestFileName)
+	if err != nil {
+		return nil, err
+	}
+	cm := &corev1.ConfigMap{}
+	if err := yaml.Unmarshal(b, cm); err != nil {
+		return nil, err
+	}
+	images := map[string]string{}
+	if err := json.Unmarshal([]byte(cm.Data[imagesManifestKey]), &images); err != nil {
+		return nil, err
+	}
+	for name, image := range images {
+		if strings.HasPrefix(name, p.relatedImageName("")) {
+			s.images[name] = image
+		}
+	}
+	return s, nil
+}
+
+func (p *provider) changeReport(before, after *providerSnapshot) providerReport {
+	r := providerReport{
+		Provider:   p.providerTypeName() + "-" + p.assetName(),
+		OldVersion: before.version,
+		NewVersion: after.version,
+	}
+	r.CRDsAdded, r.CRDsRemoved = setDelta(before.crds, after.crds)
+	r.RBACRulesAdded, r.RBACRulesRemoved = setDelta(before.rules, after.rules)
+	r.APIChanges = apiChanges(before.apis, after.apis)
+
+	names := map[string]string{}
+	for name, image := range before.images {
+		names[name] = image
+	}
+	for name, image := range after.images {
+		names[name] = image
+	}
+	for _, name := range sortedKeys(names) {
+		if before.images[name] != after.images[name] {
+			r.ImagesChanged = append(r.ImagesChanged, imageChange{Name: name, Old: before.images[name], New: after.images[name]})
+		}
+	}
+	return r
+}
+
+// setDelta returns the sorted items only in after and only in before.
+func setDelta(before, after map[string]bool) ([]string, []string) {
+	added, removed := []string{}, []string{}
+	for item := range after {
+		if !before[item] {
+			added = append(added, item)
+		}
+	}
+	for item := range before {
+		if !after[item] {
+			removed = append(removed, item)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+// writeReport writes the reports as JSON when the file name ends with .json, as markdown otherwise.
+func writeReport(fileName string, reports []providerReport) error {
+	if filepath.Ext(fileName) == ".json" {
+		b, err := json.MarshalIndent(reports, "", "  ")
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(fileName, ensureNewLine(b), 0600)
+	}
+
+	var b strings.Builder
+	for _, r := range reports {
+		fmt.Fprintf(&b, "### %s %s\n\n", r.Provider, versionDelta(r.OldVersion, r.NewVersion))
+		writeMarkdownList(&b, "CRDs added", r.CRDsAdded)
+		writeMarkdownList(&b, "CRDs removed", r.CRDsRemoved)
+		writeMarkdownList(&b, "CRDs shipped by another provider", r.CRDsShared)
+		writeMarkdownList(&b, "API changes", r.APIChanges)
+		images := []string{}
+		for _, c := range r.ImagesChanged {
+			images = append(images, fmt.Sprintf("%s: %s", c.Name, versionDelta(c.Old, c.New)))
+		}
+		writeMarkdownList(&b, "Images changed", images)
+		writeMarkdownList(&b, "RBAC rules added", r.RBACRulesAdded)
+		writeMarkdownList(&b, "RBAC rules removed", r.RBACRulesRemoved)
+	}
+	return ioutil.WriteFile(fileName, []byte(b.String()), 0600)
+}
+
+func versionDelta(old, new string) string {
+	switch {
+	case old == new:
+		return new
+	case old == "":
+		return new + " (new)"
+	case new == "":
+		return old + " (removed)"
+	}
+	return old + " → " + new
+}
+
+func writeMarkdownList(b *strings.Builder, title string, items []string) {
+	if len(items) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "%s:\n", title)
+	for _, item := range items {
+		fmt.Fprintf(b, "- `%s`\n", item)
+	}
+	fmt.Fprintln(b)
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	clusterctlv1 "sigs.k8s.io/cluster-api/cmd/clusterctl/api/v1alpha3"
+)
+
+func TestSetDelta(t *testing.T) {
+	tests := []struct {
+		name        string
+		before      map[string]bool
+		after       map[string]bool
+		wantAdded   []string
+		wantRemoved []string
+	}{
+		{
+			name:        "empty",
+			wantAdded:   []string{},
+			wantRemoved: []string{},
+		},
+		{
+			name:        "unchanged",
+			before:      map[string]bool{"a": true},
+			after:       map[string]bool{"a": true},
+			wantAdded:   []string{},
+			wantRemoved: []string{},
+		},
+		{
+			name:        "added and removed, sorted",
+			before:      map[string]bool{"a": true, "d": true, "c": true},
+			after:       map[string]bool{"a": true, "e": true, "b": true},
+			wantAdded:   []string{"b", "e"},
+			wantRemoved: []string{"c", "d"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			added, removed := setDelta(tt.before, tt.after)
+			if !reflect.DeepEqual(added, tt.wantAdded) {
+				t.Errorf("setDelta() added = %v, want %v", added, tt.wantAdded)
+			}
+			if !reflect.DeepEqual(removed, tt.wantRemoved) {
+				t.Errorf("setDelta() removed = %v, want %v", removed, tt.wantRemoved)
+			}
+		})
+	}
+}
+
+func TestVersionDelta(t *testing.T) {
+	tests := []struct {
+		old  string
+		new  string
+		want string
+	}{
+		{old: "v1.0.0", new: "v1.0.0", want: "v1.0.0"},
+		{old: "", new: "v1.0.0", want: "v1.0.0 (new)"},
+		{old: "v1.0.0", new: "", want: "v1.0.0 (removed)"},
+		{old: "v1.0.0", new: "v1.1.0", want: "v1.0.0 → v1.1.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.old+" "+tt.new, func(t *testing.T) {
+			if got := versionDelta(tt.old, tt.new); got != tt.want {
+				t.Errorf("versionDelta() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChangeReport(t *testing.T) {
+	p := &provider{name: "aws", ptype: clusterctlv1.InfrastructureProviderType}
+	before := &providerSnapshot{
+		version: "v1.0.0",
+		crds:    map[string]bool{"awsclusters.infrastructure.cluster.x-k8s.io": true, "awsmachinepools.infrastructure.cluster.x-k8s.io": true},
+		apis:    map[string]crdAPI{},
+		images: map[string]string{
+			"RELATED_IMAGE_INFRASTRUCTURE_AWS_MANAGER":    "capa:v1.0.0",
+			"RELATED_IMAGE_INFRASTRUCTURE_AWS_RBAC_PROXY": "kube-rbac-proxy:v0.8.0",
+		},
+		rules: map[string]bool{"ClusterRole manager-role get secrets": true},
+	}
+	after := &providerSnapshot{
+		version: "v1.1.0",
+		crds:    map[string]bool{"awsclusters.infrastructure.cluster.x-k8s.io": true, "awsmanagedclusters.infrastructure.cluster.x-k8s.io": true},
+		apis:    map[string]crdAPI{},
+		images: map[string]string{
+			"RELATED_IMAGE_INFRASTRUCTURE_AWS_MANAGER":    "capa:v1.1.0",
+			"RELATED_IMAGE_INFRASTRUCTURE_AWS_RBAC_PROXY": "kube-rbac-proxy:v0.8.0",
+			"RELATED_IMAGE_INFRASTRUCTURE_AWS_EKS":        "eks:v1.1.0",
+		},
+		rules: map[string]bool{"ClusterRole manager-role get secrets": true, "ClusterRole manager-role list secrets": true},
+	}
+	want := providerReport{
+		Provider:         "infrastructure-aws",
+		OldVersion:       "v1.0.0",
+		NewVersion:       "v1.1.0",
+		CRDsAdded:        []string{"awsmanagedclusters.infrastructure.cluster.x-k8s.io"},
+		CRDsRemoved:      []string{"awsmachinepools.infrastructure.cluster.x-k8s.io"},
+		APIChanges:       []string{},
+		RBACRulesAdded:   []string{"ClusterRole manager-role list secrets"},
+		RBACRulesRemoved: []string{},
+		ImagesChanged: []imageChange{
+			{Name: "RELATED_IMAGE_INFRASTRUCTURE_AWS_EKS", New: "eks:v1.1.0"},
+			{Name: "RELATED_IMAGE_INFRASTRUCTURE_AWS_MANAGER", Old: "capa:v1.0.0", New: "capa:v1.1.0"},
+		},
+	}
+	if got := p.changeReport(before, after); !reflect.DeepEqual(got, want) {
+		t.Errorf("changeReport() = %+v, want %+v", got, want)
+	}
+}
+
+func TestWriteReport(t *testing.T) {
+	reports := []providerReport{
+		{
+			Provider:      "infrastructure-aws",
+			OldVersion:    "v1.0.0",
+			NewVersion:    "v1.1.0",
+			CRDsAdded:     []string{"awsmanagedclusters.infrastructure.cluster.x-k8s.io"},
+			ImagesChanged: []imageChange{{Name: "RELATED_IMAGE_INFRASTRUCTURE_AWS_EKS", New: "eks:v1.1.0"}},
+		},
+		{
+			Provider:   "infrastructure-azure",
+			NewVersion: "v1.2.0",
+		},
+	}
+	dir := t.TempDir()
+
+	t.Run("markdown", func(t *testing.T) {
+		fileName := filepath.Join(dir, "report.md")
+		if err := writeReport(fileName, reports); err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadFile(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := "### infrastructure-aws v1.0.0 → v1.1.0\n\n" +
+			"CRDs added:\n- `awsmanagedclusters.infrastructure.cluster.x-k8s.io`\n\n" +
+			"Images changed:\n- `RELATED_IMAGE_INFRASTRUCTURE_AWS_EKS: eks:v1.1.0 (new)`\n\n" +
+			"### infrastructure-azure v1.2.0 (new)\n\n"
+		if string(b) != want {
+			t.Errorf("report %q, want %q", b, want)
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		fileName := filepath.Join(dir, "report.json")
+		if err := writeReport(fileName, reports); err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadFile(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []providerReport{}
+		if err := json.Unmarshal(b, &got); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, reports) {
+			t.Errorf("report %+v, want %+v", got, reports)
+		}
+	})
+}
+
+func TestSnapshot(t *testing.T) {
+	defer func(mode string) { *outputMode = mode }(*outputMode)
+	*outputMode = outputConfigMaps
+	testPaths(t)
+
+	p := &provider{name: "aws", ptype: clusterctlv1.InfrastructureProviderType}
+	testOutput(t, map[string][]byte{
+		p.componentsFile(""): []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: aws
+  labels:
+    provider.cluster.x-k8s.io/version: v1.1.0
+data:
+  components: ""
+`),
+		p.crdManifestPrefix() + "awsclusters.infrastructure.cluster.x-k8s.io.yaml": []byte(`apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: awsclusters.infrastructure.cluster.x-k8s.io
+spec:
+  names:
+    kind: AWSCluster
+  versions:
+  - name: v1beta1
+    served: true
+    schema:
+      openAPIV3Schema:
+        properties:
+          spec:
+            required:
+            - region
+`),
+		p.rbacManifestFile(): []byte(`apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: openshift-cluster-api-capa-manager-role
+rules:
+- apiGroups: [""]
+  resources: [secrets]
+  verbs: [get]
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: openshift-cluster-api-capa-manager-rolebinding
+`),
+		imagesManifestFileName: testImagesManifest(t, map[string]string{
+			"RELATED_IMAGE_INFRASTRUCTURE_AWS_MANAGER":   "capa:v1.1.0",
+			"RELATED_IMAGE_INFRASTRUCTURE_AZURE_MANAGER": "capz:v1.2.0",
+		}),
+	})
+
+	got, err := p.snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &providerSnapshot{
+		version: "v1.1.0",
+		crds:    map[string]bool{"awsclusters.infrastructure.cluster.x-k8s.io": true},
+		apis: map[string]crdAPI{
+			"awsclusters.infrastructure.cluster.x-k8s.io": {kind: "AWSCluster", versions: map[string]map[string]bool{"v1beta1": {".spec.region": true}}},
+		},
+		images: map[string]string{"RELATED_IMAGE_INFRASTRUCTURE_AWS_MANAGER": "capa:v1.1.0"},
+		rules:  map[string]bool{`ClusterRole capa-manager-role apiGroups=[""] resources=["secrets"] verbs=["get"]`: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("snapshot() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/google/go-github/v33/github"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/cluster-api/cmd/clusterctl/client/repository"
+)
+
+// retryDelayDefault is the first backoff delay, doubled on each retry.
+const retryDelayDefault = 2 * time.Second
+
+// isTransient returns true for errors worth retrying: server errors, rate limits and network
+// failures. Anything else, like a missing release or file (404), fails straight away.
+func isTransient(err error) bool {
+	var ghErr *github.ErrorResponse
+	if errors.As(err, &ghErr) && ghErr.Response != nil {
+		return isTransientStatus(ghErr.Response.StatusCode)
+	}
+	var rateLimitErr *github.RateLimitError
+	var abuseErr *github.AbuseRateLimitError
+	if errors.As(err, &rateLimitErr) || errors.As(err, &abuseErr) {
+		return true
+	}
+	var registryErr *transport.Error
+	if errors.As(err, &registryErr) {
+		return isTransientStatus(registryErr.StatusCode)
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	// clusterctl replaces the github rate limit error with a plain one
+	return strings.Contains(err.Error(), "rate limit")
+}
+
+func isTransientStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= http.StatusInternalServerError
+}
+
+// withRetry calls fn until it succeeds, fails with a permanent error or --retry-attempts is reached,
+// backing off exponentially between the attempts.
+func withRetry(what string, fn func() error) error {
+	backoff := wait.Backoff{
+		Duration: *retryDelay,
+		Factor:   2,
+		Jitter:   0.1,
+		Steps:    *retryAttempts,
+	}
+	if backoff.Steps < 1 {
+		backoff.Steps = 1
+	}
+	var lastErr error
+	err := wait.ExponentialBackoff(backoff, func() (bool, error) {
+		lastErr = fn()
+		if lastErr == nil {
+			return true, nil
+		}
+		if !isTransient(lastErr) {
+			return false, lastErr
+		}
+		klog.Warningf("%s failed, retrying: %v", what, lastErr)
+		return false, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return errors.Wrapf(lastErr, "%s failed after %d attempts", what, backoff.Steps)
+	}
+	return err
+}
+
+// retryingRepository retries the remote calls of a repository on transient failures.
+type retryingRepository struct {
+	repository.Repository
+	name string
+}
+
+func (r *retryingRepository) GetVersions() ([]string, error) {
+	var versions []string
+	err := withRetry("listing "+r.name+" versions", func() error {
+		var err error
+		versions, err = r.Repository.GetVersions()
+		return err
+	})
+	return versions, err
+}
+
+func (r *retryingRepository) GetFile(version, fileName string) ([]byte, error) {
+	var b []byte
+	err := withRetry(fmt.Sprintf("getting %s %s %s", r.name, version, fileName), func() error {
+		var err error
+		b, err = r.Repository.GetFile(version, fileName)
+		return err
+	})
+	return b, err
+}
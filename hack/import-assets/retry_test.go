@@ -0,0 +1,114 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/google/go-github/v33/github"
+	"github.com/pkg/errors"
+)
+
+func TestIsTransient(t *testing.T) {
+	githubErr := func(code int) error {
+		return &github.ErrorResponse{Response: &http.Response{StatusCode: code}}
+	}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "github server error", err: githubErr(http.StatusBadGateway), want: true},
+		{name: "github too many requests", err: githubErr(http.StatusTooManyRequests), want: true},
+		{name: "github not found", err: githubErr(http.StatusNotFound)},
+		{name: "github rate limit", err: &github.RateLimitError{}, want: true},
+		{name: "github abuse rate limit", err: &github.AbuseRateLimitError{}, want: true},
+		{name: "registry server error", err: &transport.Error{StatusCode: http.StatusServiceUnavailable}, want: true},
+		{name: "registry unauthorized", err: &transport.Error{StatusCode: http.StatusUnauthorized}},
+		{name: "network", err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}, want: true},
+		{name: "unexpected EOF", err: io.ErrUnexpectedEOF, want: true},
+		{name: "wrapped", err: errors.Wrap(githubErr(http.StatusInternalServerError), "failed to get file"), want: true},
+		{name: "clusterctl rate limit", err: errors.New("rate limit for github api has been reached. Please wait one hour or get a personal API token"), want: true},
+		{name: "permanent", err: errors.New("failed to read metadata.yaml")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTransient(tt.err); got != tt.want {
+				t.Errorf("isTransient() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithRetry(t *testing.T) {
+	transient := &transport.Error{StatusCode: http.StatusBadGateway}
+	permanent := errors.New("not found")
+
+	tests := []struct {
+		name         string
+		errs         []error
+		attempts     int
+		wantAttempts int
+		wantErr      bool
+	}{
+		{
+			name:         "success",
+			attempts:     3,
+			wantAttempts: 1,
+		},
+		{
+			name:         "transient failures",
+			errs:         []error{transient, transient},
+			attempts:     3,
+			wantAttempts: 3,
+		},
+		{
+			name:         "too many transient failures",
+			errs:         []error{transient, transient, transient},
+			attempts:     3,
+			wantAttempts: 3,
+			wantErr:      true,
+		},
+		{
+			name:         "permanent failure",
+			errs:         []error{permanent},
+			attempts:     3,
+			wantAttempts: 1,
+			wantErr:      true,
+		},
+		{
+			name:         "no retry",
+			errs:         []error{transient},
+			attempts:     0,
+			wantAttempts: 1,
+			wantErr:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(attempts int, delay time.Duration) { *retryAttempts, *retryDelay = attempts, delay }(*retryAttempts, *retryDelay)
+			*retryAttempts, *retryDelay = tt.attempts, time.Millisecond
+
+			attempts := 0
+			err := withRetry("test", func() error {
+				attempts++
+				if attempts <= len(tt.errs) {
+					return tt.errs[attempts-1]
+				}
+				return nil
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("withRetry() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if attempts != tt.wantAttempts {
+				t.Errorf("%d attempts, want %d", attempts, tt.wantAttempts)
+			}
+			if tt.wantErr && !errors.Is(err, tt.errs[len(tt.errs)-1]) {
+				t.Errorf("withRetry() error = %v, want the last error of the attempts", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// providerSelector selects the providers of an import from the --providers and
+// --skip-providers patterns. A pattern is a glob, e.g. "ibmcloud*", or a regular expression
+// between slashes, e.g. "/^(aws|gcp)$/", matched against the name, the asset name and the
+// <type>-<name> of the providers.
+type providerSelector struct {
+	include []string
+	exclude []string
+}
+
+func newProviderSelector(include, exclude []string) (*providerSelector, error) {
+	for _, pattern := range append(append([]string{}, include...), exclude...) {
+		if err := validateProviderPattern(pattern); err != nil {
+			return nil, err
+		}
+	}
+	return &providerSelector{include: include, exclude: exclude}, nil
+}
+
+func validateProviderPattern(pattern string) error {
+	if re, ok := regexpPattern(pattern); ok {
+		if _, err := regexp.Compile(re); err != nil {
+			return errors.Wrapf(err, "invalid provider pattern %q", pattern)
+		}
+		return nil
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return errors.Wrapf(err, "invalid provider pattern %q", pattern)
+	}
+	return nil
+}
+
+// partial is true when the selection isn't the default one of a full import.
+func (s *providerSelector) partial() bool {
+	return len(s.include) > 0 || len(s.exclude) > 0
+}
+
+// selects returns whether the provider is imported. Without --providers all the providers
+// but the optional ones are, the optional ones are only imported when a pattern matches them.
+func (s *providerSelector) selects(p *provider) bool {
+	if len(s.include) == 0 && p.optional {
+		return false
+	}
+	if len(s.include) > 0 && !p.matchesAny(s.include) {
+		return false
+	}
+	return !p.matchesAny(s.exclude)
+}
+
+func (p *provider) matchesAny(patterns []string) bool {
+	names := []string{p.name, p.assetName(), p.providerTypeName() + "-" + p.assetName()}
+	for _, pattern := range patterns {
+		for _, name := range names {
+			if matchProviderPattern(pattern, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchProviderPattern matches a validated pattern.
+func matchProviderPattern(pattern, name string) bool {
+	if re, ok := regexpPattern(pattern); ok {
+		return regexp.MustCompile(re).MatchString(name)
+	}
+	matched, _ := path.Match(pattern, name)
+	return matched
+}
+
+func regexpPattern(pattern string) (string, bool) {
+	if len(pattern) < 2 || !strings.HasPrefix(pattern, "/") || !strings.HasSuffix(pattern, "/") {
+		return "", false
+	}
+	return pattern[1 : len(pattern)-1], true
+}
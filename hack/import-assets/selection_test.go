@@ -0,0 +1,136 @@
+package main
+
+import (
+	"testing"
+
+	clusterctlv1 "sigs.k8s.io/cluster-api/cmd/clusterctl/api/v1alpha3"
+)
+
+func TestProviderSelector(t *testing.T) {
+	aws := &provider{name: "aws", ptype: clusterctlv1.InfrastructureProviderType}
+	kubeadm := &provider{name: "kubeadm", ptype: clusterctlv1.BootstrapProviderType, optional: true}
+	powervs := &provider{name: "ibmcloud", flavor: "powervs", ptype: clusterctlv1.InfrastructureProviderType, optional: true}
+
+	tests := []struct {
+		name     string
+		include  []string
+		exclude  []string
+		provider *provider
+		want     bool
+		partial  bool
+	}{
+		{
+			name:     "default",
+			provider: aws,
+			want:     true,
+		},
+		{
+			name:     "default skips the optional providers",
+			provider: kubeadm,
+		},
+		{
+			name:     "name",
+			include:  []string{"aws"},
+			provider: aws,
+			want:     true,
+			partial:  true,
+		},
+		{
+			name:     "other name",
+			include:  []string{"gcp"},
+			provider: aws,
+			partial:  true,
+		},
+		{
+			name:     "optional provider by name",
+			include:  []string{"kubeadm"},
+			provider: kubeadm,
+			want:     true,
+			partial:  true,
+		},
+		{
+			name:     "type and name",
+			include:  []string{"bootstrap-kubeadm"},
+			provider: kubeadm,
+			want:     true,
+			partial:  true,
+		},
+		{
+			name:     "glob on the asset name",
+			include:  []string{"ibmcloud-*"},
+			provider: powervs,
+			want:     true,
+			partial:  true,
+		},
+		{
+			name:     "glob on the type",
+			include:  []string{"infrastructure-*"},
+			provider: aws,
+			want:     true,
+			partial:  true,
+		},
+		{
+			name:     "regular expression",
+			include:  []string{"/^(aws|gcp)$/"},
+			provider: aws,
+			want:     true,
+			partial:  true,
+		},
+		{
+			name:     "regular expression not anchored",
+			include:  []string{"/power/"},
+			provider: powervs,
+			want:     true,
+			partial:  true,
+		},
+		{
+			name:     "skipped",
+			exclude:  []string{"aws"},
+			provider: aws,
+			partial:  true,
+		},
+		{
+			name:     "skip wins",
+			include:  []string{"infrastructure-*"},
+			exclude:  []string{"/^ibm/"},
+			provider: powervs,
+			partial:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := newProviderSelector(tt.include, tt.exclude)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.selects(tt.provider); got != tt.want {
+				t.Errorf("selects() = %v, want %v", got, tt.want)
+			}
+			if got := s.partial(); got != tt.partial {
+				t.Errorf("partial() = %v, want %v", got, tt.partial)
+			}
+		})
+	}
+}
+
+func TestValidateProviderPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		wantErr bool
+	}{
+		{pattern: "aws"},
+		{pattern: "ibmcloud*"},
+		{pattern: "/^(aws|gcp)$/"},
+		// a single slash is a glob
+		{pattern: "/"},
+		{pattern: "[", wantErr: true},
+		{pattern: "/(aws/", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			if err := validateProviderPattern(tt.pattern); (err != nil) != tt.wantErr {
+				t.Errorf("validateProviderPattern() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
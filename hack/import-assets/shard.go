@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apiyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	componentsKey = "components"
+	// crdsKey holds the CRDs of a shard ConfigMap.
+	crdsKey = "crds"
+	// shardsAnnotation is set on the components ConfigMaps over maxConfigMapSize to the number
+	// of shard ConfigMaps their CRDs are moved to, see shardName.
+	shardsAnnotation = "provider.cluster.x-k8s.io/shards"
+	// shardOfLabel is set on the shard ConfigMaps to the name of their components ConfigMap,
+	// they don't have the provider labels the upstream operator selects the components on.
+	shardOfLabel = "provider.cluster.x-k8s.io/shard-of"
+	// maxConfigMapSize is the size of the ConfigMap data above which the components are
+	// sharded, below the 1MiB etcd object limit to leave room for the rest of the object.
+	maxConfigMapSize = 900 * 1024
+)
+
+// shardName is the name of the n-th shard, from 1, of the components ConfigMap.
+func shardName(name string, n int) string {
+	return fmt.Sprintf("%s-crds-%d", name, n)
+}
+
+// shardComponents moves the CRDs of the components of a ConfigMap over maxConfigMapSize to
+// shard ConfigMaps under it, in the order of the components, and returns them. The upstream
+// operator only reads the components of the ConfigMaps it selects, the CAPI operator applies
+// the CRDs of the shards itself. It fails when the ConfigMap is still too big without its
+// CRDs, or a CRD doesn't fit in a shard.
+func shardComponents(cm *corev1.ConfigMap) ([]*corev1.ConfigMap, error) {
+	size := 0
+	for _, v := range cm.Data {
+		size += len(v)
+	}
+	if size <= maxConfigMapSize {
+		return nil, nil
+	}
+
+	reader := apiyaml.NewYAMLReader(bufio.NewReader(strings.NewReader(cm.Data[componentsKey])))
+	kept := []string{}
+	shardDocs := [][]string{}
+	shardSize := 0
+	for {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, errors.Wrapf(err, "invalid components in ConfigMap %s", cm.Name)
+		}
+		obj := map[string]interface{}{}
+		if err := yaml.Unmarshal(doc, &obj); err != nil {
+			return nil, errors.Wrapf(err, "invalid components in ConfigMap %s", cm.Name)
+		}
+		if len(obj) == 0 {
+			continue
+		}
+		if obj["kind"] != "CustomResourceDefinition" {
+			kept = append(kept, string(doc))
+			continue
+		}
+		if len(doc) > maxConfigMapSize {
+			return nil, errors.Errorf("CRD of %d bytes in ConfigMap %s, over %d", len(doc), cm.Name, maxConfigMapSize)
+		}
+		if len(shardDocs) == 0 || shardSize+len(doc) > maxConfigMapSize {
+			shardDocs = append(shardDocs, nil)
+			shardSize = 0
+		}
+		shardDocs[len(shardDocs)-1] = append(shardDocs[len(shardDocs)-1], string(doc))
+		shardSize += len(doc) + len("---\n")
+	}
+
+	components := strings.Join(kept, "---\n")
+	if size-len(cm.Data[componentsKey])+len(components) > maxConfigMapSize {
+		return nil, errors.Errorf("ConfigMap %s is over %d bytes even without its CRDs", cm.Name, maxConfigMapSize)
+	}
+	cm.Data[componentsKey] = components
+	if cm.Annotations == nil {
+		cm.Annotations = map[string]string{}
+	}
+	cm.Annotations[shardsAnnotation] = strconv.Itoa(len(shardDocs))
+
+	shards := []*corev1.ConfigMap{}
+	for i, docs := range shardDocs {
+		shard := &corev1.ConfigMap{
+			TypeMeta: cm.TypeMeta,
+		}
+		shard.Name = shardName(cm.Name, i+1)
+		shard.Namespace = cm.Namespace
+		shard.Labels = map[string]string{shardOfLabel: cm.Name}
+		shard.Data = map[string]string{crdsKey: strings.Join(docs, "---\n")}
+		shards = append(shards, shard)
+	}
+	return shards, nil
+}
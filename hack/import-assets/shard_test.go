@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	clusterctlv1 "sigs.k8s.io/cluster-api/cmd/clusterctl/api/v1alpha3"
+	configclient "sigs.k8s.io/cluster-api/cmd/clusterctl/client/config"
+	"sigs.k8s.io/cluster-api/cmd/clusterctl/client/repository"
+	"sigs.k8s.io/cluster-api/cmd/clusterctl/client/yamlprocessor"
+	utilyaml "sigs.k8s.io/cluster-api/util/yaml"
+)
+
+const shardTestCRD = `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: crd%d.infrastructure.cluster.x-k8s.io
+spec:
+  group: infrastructure.cluster.x-k8s.io
+  names:
+    kind: CRD%d
+    plural: crd%d
+  scope: Namespaced
+  versions:
+  - name: v1beta1
+    schema:
+      openAPIV3Schema:
+        properties:
+          spec:
+            properties:
+              replicas:
+                description: Replicas is the number of replicas.
+                maximum: 9223372036854775807
+                type: integer
+            type: object
+        type: object
+    served: true
+    storage: true
+`
+
+// fetchComponents reads the components of the ConfigMap as the upstream operator does: from
+// data only, through a clusterctl memory repository and the clusterctl components parsing.
+func fetchComponents(t *testing.T, cm *corev1.ConfigMap) []unstructured.Unstructured {
+	version := cm.Labels["provider.cluster.x-k8s.io/version"]
+	components, ok := cm.Data[componentsKey]
+	if !ok {
+		t.Fatalf("ConfigMap %s has no components", cm.Name)
+	}
+	repo := repository.NewMemoryRepository().
+		WithPaths("", "components.yaml").
+		WithFile(version, "metadata.yaml", []byte(cm.Data["metadata"])).
+		WithFile(version, "components.yaml", []byte(components))
+
+	configClient, err := configclient.New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	providerConfig, err := configClient.Providers().Get("aws", clusterctlv1.InfrastructureProviderType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	componentsFile, err := repo.GetFile(version, repo.ComponentsPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetched, err := repository.NewComponents(repository.ComponentsInput{
+		Provider:     providerConfig,
+		ConfigClient: configClient,
+		Processor:    yamlprocessor.NewSimpleProcessor(),
+		RawYaml:      componentsFile,
+		Options: repository.ComponentsOptions{
+			TargetNamespace: "openshift-cluster-api",
+			Version:         version,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fetched.Objs()
+}
+
+func TestShardComponents(t *testing.T) {
+	crds := func(n int) string {
+		docs := []string{}
+		for i := 0; i < n; i++ {
+			docs = append(docs, fmt.Sprintf(shardTestCRD, i, i, i))
+		}
+		return strings.Join(docs, "---\n")
+	}
+	// the number of CRDs filling a shard
+	perShard := 0
+	for size := 0; size <= maxConfigMapSize; perShard++ {
+		size += len(fmt.Sprintf(shardTestCRD, perShard, perShard, perShard)) + len("---\n")
+	}
+	perShard--
+	const deployment = "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: capa-controller-manager\n  namespace: capa-system\n"
+	secret := func(size int) string {
+		return "apiVersion: v1\nkind: Secret\nmetadata:\n  name: capa-manager-bootstrap-credentials\n  namespace: capa-system\ndata:\n  x: " + strings.Repeat("x", size) + "\n"
+	}
+
+	tests := []struct {
+		name       string
+		components string
+		shards     int
+		wantErr    bool
+	}{
+		{
+			name:       "small",
+			components: crds(2) + "---\n" + deployment,
+		},
+		{
+			name:       "one shard",
+			components: crds(perShard*2/3) + "---\n" + secret(maxConfigMapSize/2),
+			shards:     1,
+		},
+		{
+			name:       "two shards",
+			components: deployment + "---\n" + crds(perShard+1),
+			shards:     2,
+		},
+		{
+			name:       "too big without the CRDs",
+			components: crds(1) + "---\n" + secret(maxConfigMapSize),
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cm := &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "aws-v0.7.0",
+					Labels: map[string]string{"provider.cluster.x-k8s.io/version": "v0.7.0"},
+				},
+				Data: map[string]string{
+					"metadata":    "apiVersion: clusterctl.cluster.x-k8s.io/v1alpha3\nkind: Metadata\n",
+					componentsKey: tt.components,
+				},
+			}
+			shards, err := shardComponents(cm)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(shards) != tt.shards {
+				t.Fatalf("%d shards, want %d", len(shards), tt.shards)
+			}
+			if tt.shards == 0 {
+				if cm.Data[componentsKey] != tt.components || cm.Annotations[shardsAnnotation] != "" {
+					t.Fatal("components sharded under the limit")
+				}
+				return
+			}
+			if got := cm.Annotations[shardsAnnotation]; got != fmt.Sprint(tt.shards) {
+				t.Errorf("%s annotation %q, want %d", shardsAnnotation, got, tt.shards)
+			}
+
+			want, err := utilyaml.ToUnstructured([]byte(tt.components))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the components fetched by the upstream operator have no CRD left, the target
+			// Namespace is added
+			gotCRDs := map[string]interface{}{}
+			for _, obj := range fetchComponents(t, cm) {
+				if isCRD(obj) {
+					t.Errorf("CRD %s left in the components", obj.GetName())
+				}
+			}
+			for i, shard := range shards {
+				if name := shardName(cm.Name, i+1); shard.Name != name {
+					t.Errorf("shard %q, want %q", shard.Name, name)
+				}
+				if shard.Labels[shardOfLabel] != cm.Name || shard.Labels["provider.cluster.x-k8s.io/version"] != "" {
+					t.Errorf("shard %s labels %v", shard.Name, shard.Labels)
+				}
+				if size := len(shard.Data[crdsKey]); size > maxConfigMapSize {
+					t.Errorf("shard %s of %d bytes, over %d", shard.Name, size, maxConfigMapSize)
+				}
+				objs, err := utilyaml.ToUnstructured([]byte(shard.Data[crdsKey]))
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, obj := range objs {
+					gotCRDs[obj.GetName()] = obj.Object["spec"]
+				}
+			}
+			crdCount := 0
+			for _, obj := range want {
+				if !isCRD(obj) {
+					continue
+				}
+				crdCount++
+				if !reflect.DeepEqual(obj.Object["spec"], gotCRDs[obj.GetName()]) {
+					t.Errorf("%s: sharded spec %v, want %v", obj.GetName(), gotCRDs[obj.GetName()], obj.Object["spec"])
+				}
+			}
+			if len(gotCRDs) != crdCount {
+				t.Errorf("%d CRDs sharded, want %d", len(gotCRDs), crdCount)
+			}
+		})
+	}
+}
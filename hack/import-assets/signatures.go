@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/cluster-api/cmd/clusterctl/client/repository"
+)
+
+const providerSignaturesFileName = "provider-signatures.json"
+
+// signaturePolicy describes how the release files of a provider are signed, either with a
+// key (Key is a path or url understood by cosign) or keyless, in which case the signing
+// certificate must match the Identity and Issuer.
+type signaturePolicy struct {
+	Key      string `json:"key,omitempty"`
+	Identity string `json:"identity,omitempty"`
+	Issuer   string `json:"issuer,omitempty"`
+}
+
+var (
+	// signaturePolicies are the signature policies of provider-signatures.json by
+	// "<type>-<name>", the file is read by the first release file verified.
+	signaturePolicies     map[string]signaturePolicy
+	signaturePoliciesErr  error
+	signaturePoliciesOnce sync.Once
+)
+
+func loadSignaturePolicies() (map[string]signaturePolicy, error) {
+	signaturePoliciesOnce.Do(func() {
+		jsonData, err := ioutil.ReadFile(providerSignaturesFileName)
+		if os.IsNotExist(err) {
+			return
+		}
+		if err != nil {
+			signaturePoliciesErr = err
+			return
+		}
+		signaturePoliciesErr = json.Unmarshal(jsonData, &signaturePolicies)
+	})
+	return signaturePolicies, signaturePoliciesErr
+}
+
+func (p *provider) loadSignaturePolicy() (*signaturePolicy, error) {
+	policies, err := loadSignaturePolicies()
+	if err != nil {
+		return nil, err
+	}
+	policy, ok := policies[p.providerTypeName()+"-"+p.assetName()]
+	if !ok {
+		return nil, nil
+	}
+	if policy.Key == "" && (policy.Identity == "" || policy.Issuer == "") {
+		return nil, fmt.Errorf("%s: %s needs either a key or an identity and issuer", providerSignaturesFileName, p.assetName())
+	}
+	return &policy, nil
+}
+
+// verifySignature checks the cosign signature (<file>.sig, plus <file>.pem for keyless) published
+// next to the release file, for the providers listed in provider-signatures.json.
+func (p *provider) verifySignature(repo repository.Repository, fileName string, b []byte) error {
+	policy, err := p.loadSignaturePolicy()
+	if err != nil || policy == nil {
+		return err
+	}
+	cosign, err := exec.LookPath("cosign")
+	if err != nil {
+		return errors.Wrapf(err, "%s %s is signed according to %s, verifying it needs cosign in the PATH", p.assetName(), p.version, providerSignaturesFileName)
+	}
+
+	dir, err := ioutil.TempDir("", "import-assets-cosign-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	blob := filepath.Join(dir, filepath.Base(fileName))
+	if err := os.WriteFile(blob, b, 0600); err != nil {
+		return err
+	}
+	args := []string{"verify-blob"}
+
+	sig, err := repo.GetFile(p.version, fileName+".sig")
+	if err != nil {
+		return errors.Wrapf(err, "failed to get the signature of %s %s", p.assetName(), fileName)
+	}
+	if err := os.WriteFile(blob+".sig", sig, 0600); err != nil {
+		return err
+	}
+	args = append(args, "--signature", blob+".sig")
+
+	if policy.Key != "" {
+		args = append(args, "--key", policy.Key)
+	} else {
+		cert, err := repo.GetFile(p.version, fileName+".pem")
+		if err != nil {
+			return errors.Wrapf(err, "failed to get the signing certificate of %s %s", p.assetName(), fileName)
+		}
+		if err := os.WriteFile(blob+".pem", cert, 0600); err != nil {
+			return err
+		}
+		args = append(args, "--certificate", blob+".pem",
+			"--certificate-identity", policy.Identity,
+			"--certificate-oidc-issuer", policy.Issuer)
+	}
+
+	output, err := exec.Command(cosign, append(args, blob)...).CombinedOutput() // #nosec G204
+	if err != nil {
+		return errors.Wrapf(err, "signature verification of %s %s %s failed: %s", p.assetName(), p.version, fileName, output)
+	}
+	klog.V(1).Infof("verified signature of %s %s %s", p.assetName(), p.version, fileName)
+	return nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	// topologyLabel is set on the components of the single node variant, the providers
+	// select it at runtime based on the control plane topology of the cluster.
+	topologyLabel = "provider.cluster.x-k8s.io/topology"
+	// singleReplicaTopology matches the configv1.SingleReplicaTopologyMode of the Infrastructure.
+	singleReplicaTopology = "SingleReplica"
+	singleReplicaSuffix   = "-sno"
+)
+
+// singleReplicaVariant returns a copy of the components shaped for single node clusters:
+// one replica and the Recreate strategy, as a rolling update can't make progress when
+// the new pod can't be scheduled next to the old one, and no pod anti affinity.
+func singleReplicaVariant(objs []unstructured.Unstructured) ([]unstructured.Unstructured, error) {
+	variant := make([]unstructured.Unstructured, 0, len(objs))
+	for _, obj := range objs {
+		variant = append(variant, *obj.DeepCopy())
+	}
+	return updateDeployments(variant, func(dep *appsv1.Deployment) {
+		replicas := int32(1)
+		dep.Spec.Replicas = &replicas
+		dep.Spec.Strategy = appsv1.DeploymentStrategy{Type: appsv1.RecreateDeploymentStrategyType}
+		if affinity := dep.Spec.Template.Spec.Affinity; affinity != nil {
+			affinity.PodAntiAffinity = nil
+			if affinity.NodeAffinity == nil && affinity.PodAffinity == nil {
+				dep.Spec.Template.Spec.Affinity = nil
+			}
+		}
+	})
+}
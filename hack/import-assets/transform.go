@@ -0,0 +1,217 @@
+package main
+
+import (
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/klog/v2"
+)
+
+// transformer is a step turning the upstream release components of a provider into the
+// assets shipped with the operator.
+type transformer interface {
+	name() string
+	transform(p *provider, objs []unstructured.Unstructured) ([]unstructured.Unstructured, error)
+}
+
+// transformFunc adapts a function to a transformer.
+type transformFunc struct {
+	id string
+	fn func(p *provider, objs []unstructured.Unstructured) ([]unstructured.Unstructured, error)
+}
+
+func (t transformFunc) name() string {
+	return t.id
+}
+
+func (t transformFunc) transform(p *provider, objs []unstructured.Unstructured) ([]unstructured.Unstructured, error) {
+	return t.fn(p, objs)
+}
+
+// pipeline is an ordered list of transformers, each one gets the output of the previous one.
+type pipeline []transformer
+
+func (pl pipeline) run(p *provider, objs []unstructured.Unstructured) ([]unstructured.Unstructured, error) {
+	for _, t := range pl {
+		var err error
+		objs, err = t.transform(p, objs)
+		if err != nil {
+			return nil, errors.Wrap(err, t.name())
+		}
+	}
+	return objs, nil
+}
+
+// newProviderPipeline returns the pipeline run over the components of every provider. The
+// downstream customizations (patches and import-config.yaml) run after the generic
+// transforms so they have the last word, the provider specific ones are in import-config.yaml.
+func newProviderPipeline(config *importConfig) pipeline {
+	return pipeline{
+		transformFunc{"service-ca", func(_ *provider, objs []unstructured.Unstructured) ([]unstructured.Unstructured, error) {
+			return convertCertificates(objs)
+		}},
+		transformFunc{"azure-service-operator", func(p *provider, objs []unstructured.Unstructured) ([]unstructured.Unstructured, error) {
+			return filterAzureServiceOperator(p, objs, config.forProvider(p).AzureServiceOperator)
+		}},
+		transformFunc{"crd-versions", func(p *provider, objs []unstructured.Unstructured) ([]unstructured.Unstructured, error) {
+			return pruneCRDVersions(p, objs, config.DropUnservedCRDVersions, config.forProvider(p).MinimumCRDVersion)
+		}},
+		transformFunc{"rbac-annotations", func(_ *provider, objs []unstructured.Unstructured) ([]unstructured.Unstructured, error) {
+			return annotateRBAC(objs), nil
+		}},
+		transformFunc{"kube-rbac-proxy", func(p *provider, objs []unstructured.Unstructured) ([]unstructured.Unstructured, error) {
+			return stripKubeRBACProxy(objs, config.metricsFor(p))
+		}},
+		transformFunc{"webhook-ports", func(_ *provider, objs []unstructured.Unstructured) ([]unstructured.Unstructured, error) {
+			return normalizeWebhookPorts(objs, config.WebhookPorts)
+		}},
+		transformFunc{"leader-election", func(_ *provider, objs []unstructured.Unstructured) ([]unstructured.Unstructured, error) {
+			return normalizeLeaderElection(objs, config.LeaderElection)
+		}},
+		transformFunc{"feature-gates", func(p *provider, objs []unstructured.Unstructured) ([]unstructured.Unstructured, error) {
+			return setFeatureGates(objs, config.FeatureGates, config.forProvider(p).FeatureGates)
+		}},
+		transformFunc{"pod-annotations", func(_ *provider, objs []unstructured.Unstructured) ([]unstructured.Unstructured, error) {
+			return setPodAnnotations(objs, config.PodAnnotations)
+		}},
+		transformFunc{"pod-security", func(_ *provider, objs []unstructured.Unstructured) ([]unstructured.Unstructured, error) {
+			return restrictPodSecurity(objs, config.RestrictedPodSecurity)
+		}},
+		transformFunc{"container-env", func(_ *provider, objs []unstructured.Unstructured) ([]unstructured.Unstructured, error) {
+			return addContainerEnv(objs, config.ContainerEnv)
+		}},
+		transformFunc{"trusted-ca", func(_ *provider, objs []unstructured.Unstructured) ([]unstructured.Unstructured, error) {
+			return mountTrustedCA(objs, config.TrustedCA)
+		}},
+		transformFunc{"resources", func(p *provider, objs []unstructured.Unstructured) ([]unstructured.Unstructured, error) {
+			return setResources(objs, config.resourcesFor(p))
+		}},
+		transformFunc{"priority-class", func(_ *provider, objs []unstructured.Unstructured) ([]unstructured.Unstructured, error) {
+			return setPriorityClassName(objs, config.PriorityClassName)
+		}},
+		transformFunc{"scheduling", func(_ *provider, objs []unstructured.Unstructured) ([]unstructured.Unstructured, error) {
+			return setScheduling(objs, config.NodeSelector, config.Tolerations)
+		}},
+		transformFunc{"patches", func(p *provider, objs []unstructured.Unstructured) ([]unstructured.Unstructured, error) {
+			return p.applyPatches(objs)
+		}},
+		transformFunc{"import-config", func(p *provider, objs []unstructured.Unstructured) ([]unstructured.Unstructured, error) {
+			return config.forProvider(p).apply(objs)
+		}},
+		// after the patches and the import config, which target the upstream names
+		transformFunc{"names", func(p *provider, objs []unstructured.Unstructured) ([]unstructured.Unstructured, error) {
+			return normalizeNames(p, objs, config.NormalizeNames)
+		}},
+	}
+}
+
+const (
+	certModeServiceCA   = "service-ca"
+	certModeCertManager = "cert-manager"
+)
+
+// convertCertificates replaces the cert-manager objects with service-ca annotations, unless
+// --cert-mode keeps cert-manager for environments without the service CA.
+func convertCertificates(objs []unstructured.Unstructured) ([]unstructured.Unstructured, error) {
+	if *certMode == certModeServiceCA {
+		return certManagerToServiceCA(objs)
+	}
+
+	finalObjs := []unstructured.Unstructured{}
+	for _, obj := range objs {
+		if obj.GetKind() != "Namespace" {
+			finalObjs = append(finalObjs, obj)
+		}
+	}
+	return finalObjs, nil
+}
+
+func certManagerToServiceCA(objs []unstructured.Unstructured) ([]unstructured.Unstructured, error) {
+	serviceSecretNames, err := findWebhookServiceSecretName(objs)
+	if err != nil {
+		return nil, err
+	}
+
+	finalObjs := []unstructured.Unstructured{}
+	for _, obj := range objs {
+		switch obj.GetKind() {
+		case "CustomResourceDefinition", "MutatingWebhookConfiguration", "ValidatingWebhookConfiguration":
+			anns := obj.GetAnnotations()
+			if anns == nil {
+				anns = map[string]string{}
+			}
+			if _, ok := anns["cert-manager.io/inject-ca-from"]; ok {
+				anns["service.beta.openshift.io/inject-cabundle"] = "true"
+				delete(anns, "cert-manager.io/inject-ca-from")
+				obj.SetAnnotations(anns)
+			}
+			finalObjs = append(finalObjs, obj)
+		case "Service":
+			anns := obj.GetAnnotations()
+			if anns == nil {
+				anns = map[string]string{}
+			}
+			if name, ok := serviceSecretNames[obj.GetName()]; ok {
+				klog.V(2).Infof("%s %s serves the certificate of secret %s", obj.GetKind(), obj.GetName(), name)
+				anns["service.beta.openshift.io/serving-cert-secret-name"] = name
+				obj.SetAnnotations(anns)
+			}
+			finalObjs = append(finalObjs, obj)
+		case "Certificate", "Issuer", "Namespace": // skip
+		default:
+			finalObjs = append(finalObjs, obj)
+		}
+	}
+	return finalObjs, nil
+}
+
+func isRBAC(obj unstructured.Unstructured) bool {
+	switch obj.GetKind() {
+	case "ClusterRole", "Role", "ClusterRoleBinding", "RoleBinding", "ServiceAccount":
+		return true
+	}
+	return false
+}
+
+func annotateRBAC(objs []unstructured.Unstructured) []unstructured.Unstructured {
+	for _, obj := range objs {
+		if isRBAC(obj) {
+			setOpenShiftAnnotations(obj, false)
+		}
+	}
+	return objs
+}
+
+// splitRBACOut separates the RBAC objects, written to the manifests, from the provider components.
+func splitRBACOut(objs []unstructured.Unstructured) ([]unstructured.Unstructured, []unstructured.Unstructured) {
+	finalObjs := []unstructured.Unstructured{}
+	rbacObjs := []unstructured.Unstructured{}
+	for _, obj := range objs {
+		if isRBAC(obj) {
+			rbacObjs = append(rbacObjs, obj)
+		} else {
+			finalObjs = append(finalObjs, obj)
+		}
+	}
+	return finalObjs, rbacObjs
+}
+
+func isCRD(obj unstructured.Unstructured) bool {
+	return obj.GetKind() == "CustomResourceDefinition"
+}
+
+// splitCRDsOut separates the CustomResourceDefinitions from the provider components, they are
+// written to the manifests so CVO applies them early instead of the operator bootstrapping them.
+func splitCRDsOut(objs []unstructured.Unstructured) ([]unstructured.Unstructured, []unstructured.Unstructured) {
+	finalObjs := []unstructured.Unstructured{}
+	crdObjs := []unstructured.Unstructured{}
+	for _, obj := range objs {
+		if isCRD(obj) {
+			// keep the CA injection annotations of the conversion webhooks
+			setOpenShiftAnnotations(obj, true)
+			crdObjs = append(crdObjs, obj)
+		} else {
+			finalObjs = append(finalObjs, obj)
+		}
+	}
+	return finalObjs, crdObjs
+}
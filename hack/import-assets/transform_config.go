@@ -0,0 +1,300 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+// importConfig holds the per provider customizations applied to the imported components,
+// keyed by "<type>-<name>", e.g. "infrastructure-metal3".
+type importConfig struct {
+	// Resources are the default resources of the Deployment containers of every provider.
+	Resources []resourcesConfig `json:"resources,omitempty"`
+	// PriorityClassName is set on the Deployments of every provider.
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+	// NodeSelector and Tolerations are set on the Deployments of every provider.
+	NodeSelector map[string]string   `json:"nodeSelector,omitempty"`
+	Tolerations  []corev1.Toleration `json:"tolerations,omitempty"`
+	// Metrics replaces the kube-rbac-proxy sidecars of every provider, see providerConfig.Metrics.
+	Metrics metricsConfig `json:"metrics,omitempty"`
+	// PayloadImages are the payload images of the containers, by container name or related
+	// image name, written to the images manifest in place of the upstream images.
+	PayloadImages map[string]string `json:"payloadImages,omitempty"`
+	// DropUnservedCRDVersions removes the CRD versions that are no longer served.
+	DropUnservedCRDVersions bool `json:"dropUnservedCRDVersions,omitempty"`
+	// WebhookPorts are the ports of the webhook Services and servers of every provider.
+	WebhookPorts webhookPortsConfig `json:"webhookPorts,omitempty"`
+	// LeaderElection are the lease timings of every manager.
+	LeaderElection leaderElectionConfig `json:"leaderElection,omitempty"`
+	// FeatureGates are forced on the managers that have them.
+	FeatureGates map[string]bool `json:"featureGates,omitempty"`
+	// PodAnnotations are added to the pod template of every Deployment.
+	PodAnnotations map[string]string `json:"podAnnotations,omitempty"`
+	// RestrictedPodSecurity hardens the Deployments to pass the restricted pod security admission.
+	RestrictedPodSecurity bool `json:"restrictedPodSecurity,omitempty"`
+	// ContainerEnv is added to the containers of every Deployment.
+	ContainerEnv []corev1.EnvVar `json:"containerEnv,omitempty"`
+	// TrustedCA is mounted in the containers of every Deployment.
+	TrustedCA trustedCAConfig `json:"trustedCA,omitempty"`
+	// NormalizeNames renames the manager Deployment of every provider to
+	// <provider>-controller-manager, with its ServiceAccount and Services.
+	NormalizeNames bool                      `json:"normalizeNames,omitempty"`
+	Providers      map[string]providerConfig `json:"providers,omitempty"`
+}
+
+type providerConfig struct {
+	// Drop removes the objects matching any of the rules.
+	Drop []objectMatcher `json:"drop,omitempty"`
+	// Annotations are added to the objects matching the rule.
+	Annotations []annotationsConfig `json:"annotations,omitempty"`
+	// Args rewrites the arguments of Deployment containers.
+	Args []argsConfig `json:"args,omitempty"`
+	// Resources override the default resources of the Deployment containers.
+	Resources []resourcesConfig `json:"resources,omitempty"`
+	// FeatureGates are forced on the managers of the provider, added when missing.
+	FeatureGates map[string]bool `json:"featureGates,omitempty"`
+	// CredentialsRequest asks the cloud-credential-operator for the cloud credentials of an
+	// infrastructure provider.
+	CredentialsRequest *credentialsRequestConfig `json:"credentialsRequest,omitempty"`
+	// MinimumCRDVersion removes the older versions of the CRDs, e.g. "v1alpha4", except the
+	// storage version.
+	MinimumCRDVersion string `json:"minimumCRDVersion,omitempty"`
+	// AzureServiceOperator is "keep", the default, or "drop" for the Azure Service Operator
+	// bundled with the Azure provider.
+	AzureServiceOperator string `json:"azureServiceOperator,omitempty"`
+	// CloudAPIPorts are the ports an infrastructure provider calls its cloud APIs on, allowed
+	// by its NetworkPolicies, 443 when unset.
+	CloudAPIPorts []int32 `json:"cloudAPIPorts,omitempty"`
+	// Metrics replaces the kube-rbac-proxy sidecars of the provider, overriding the default
+	// one for the managers that serve their metrics with TLS.
+	Metrics *metricsConfig `json:"metrics,omitempty"`
+}
+
+type credentialsRequestConfig struct {
+	// SecretName is the Secret the credentials are written to, in the provider namespace.
+	SecretName string `json:"secretName"`
+	// ProviderSpec has the cloud permissions, e.g. an AWSProviderSpec.
+	ProviderSpec map[string]interface{} `json:"providerSpec"`
+}
+
+// objectMatcher matches objects on all the fields that are set.
+type objectMatcher struct {
+	Kinds        []string `json:"kinds,omitempty"`
+	ExceptKinds  []string `json:"exceptKinds,omitempty"`
+	NameContains string   `json:"nameContains,omitempty"`
+	// Names are glob patterns, e.g. "ipam-*", the name matches any of them.
+	Names []string `json:"names,omitempty"`
+	// Labels must all be set on the object with the same values.
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+type annotationsConfig struct {
+	objectMatcher `json:",inline"`
+	Annotations   map[string]string `json:"annotations"`
+}
+
+type argsConfig struct {
+	// Container is the name of the container, empty for all the containers.
+	Container string `json:"container,omitempty"`
+	// Set replaces the value of a flag, or adds the flag when missing. An empty value
+	// results in a flag without a value, e.g. "--leader-elect".
+	Set map[string]string `json:"set,omitempty"`
+	// Remove drops flags.
+	Remove []string `json:"remove,omitempty"`
+}
+
+type resourcesConfig struct {
+	// Container is the name of the container, empty for all the containers.
+	Container                   string `json:"container,omitempty"`
+	corev1.ResourceRequirements `json:",inline"`
+}
+
+func loadImportConfig(fileName string) (*importConfig, error) {
+	config := &importConfig{}
+	b, err := ioutil.ReadFile(filepath.Clean(fileName))
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.UnmarshalStrict(b, config); err != nil {
+		return nil, errors.Wrapf(err, "invalid %s", fileName)
+	}
+	for name, p := range config.Providers {
+		if p.MinimumCRDVersion != "" && !kubeVersionPattern.MatchString(p.MinimumCRDVersion) {
+			return nil, errors.Errorf("invalid %s: %s minimumCRDVersion %q", fileName, name, p.MinimumCRDVersion)
+		}
+		switch p.AzureServiceOperator {
+		case "", azureServiceOperatorKeep, azureServiceOperatorDrop:
+		default:
+			return nil, errors.Errorf("invalid %s: %s azureServiceOperator %q", fileName, name, p.AzureServiceOperator)
+		}
+		for _, m := range append(append([]objectMatcher{}, p.Drop...), annotationMatchers(p.Annotations)...) {
+			for _, pattern := range m.Names {
+				if _, err := path.Match(pattern, ""); err != nil {
+					return nil, errors.Wrapf(err, "invalid %s: %s name %q", fileName, name, pattern)
+				}
+			}
+		}
+	}
+	return config, nil
+}
+
+func annotationMatchers(configs []annotationsConfig) []objectMatcher {
+	matchers := []objectMatcher{}
+	for _, c := range configs {
+		matchers = append(matchers, c.objectMatcher)
+	}
+	return matchers
+}
+
+func (c *importConfig) forProvider(p *provider) providerConfig {
+	return c.Providers[p.providerTypeName()+"-"+p.assetName()]
+}
+
+// metricsFor returns the metrics config of the provider, the default one when the provider
+// doesn't have one. It is empty with --cert-mode cert-manager as the serving certificates come
+// from the service CA: the kube-rbac-proxy sidecars are kept instead.
+func (c *importConfig) metricsFor(p *provider) metricsConfig {
+	if *certMode != certModeServiceCA {
+		return metricsConfig{}
+	}
+	if metrics := c.forProvider(p).Metrics; metrics != nil {
+		return *metrics
+	}
+	return c.Metrics
+}
+
+// resourcesFor returns the resources configs of the provider, applied after the defaults.
+func (c *importConfig) resourcesFor(p *provider) []resourcesConfig {
+	return append(append([]resourcesConfig{}, c.Resources...), c.forProvider(p).Resources...)
+}
+
+func (m objectMatcher) matches(obj unstructured.Unstructured) bool {
+	if len(m.Kinds) > 0 && !containsString(m.Kinds, obj.GetKind()) {
+		return false
+	}
+	if containsString(m.ExceptKinds, obj.GetKind()) {
+		return false
+	}
+	if m.NameContains != "" && !strings.Contains(strings.ToLower(obj.GetName()), strings.ToLower(m.NameContains)) {
+		return false
+	}
+	if len(m.Names) > 0 && !matchesAnyName(m.Names, obj.GetName()) {
+		return false
+	}
+	for k, v := range m.Labels {
+		if value, ok := obj.GetLabels()[k]; !ok || value != v {
+			return false
+		}
+	}
+	return true
+}
+
+func matchesAnyName(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		// the patterns are checked when the config is loaded
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// apply runs the configured customizations over the objects.
+func (c providerConfig) apply(objs []unstructured.Unstructured) ([]unstructured.Unstructured, error) {
+	finalObjs := []unstructured.Unstructured{}
+	for _, obj := range objs {
+		dropped := false
+		for _, m := range c.Drop {
+			if m.matches(obj) {
+				klog.V(2).Infof("dropping %s %s", obj.GetKind(), obj.GetName())
+				dropped = true
+				break
+			}
+		}
+		if dropped {
+			continue
+		}
+
+		for _, a := range c.Annotations {
+			if !a.matches(obj) {
+				continue
+			}
+			anns := obj.GetAnnotations()
+			if anns == nil {
+				anns = map[string]string{}
+			}
+			for k, v := range a.Annotations {
+				anns[k] = v
+			}
+			obj.SetAnnotations(anns)
+		}
+
+		if obj.GetKind() == "Deployment" && len(c.Args) > 0 {
+			var err error
+			obj, err = rewriteDeploymentArgs(obj, c.Args)
+			if err != nil {
+				return nil, err
+			}
+		}
+		finalObjs = append(finalObjs, obj)
+	}
+	return finalObjs, nil
+}
+
+func rewriteDeploymentArgs(obj unstructured.Unstructured, argsConfigs []argsConfig) (unstructured.Unstructured, error) {
+	return updateDeployment(obj, func(dep *appsv1.Deployment) {
+		for i, c := range dep.Spec.Template.Spec.Containers {
+			for _, ac := range argsConfigs {
+				if ac.Container == "" || ac.Container == c.Name {
+					dep.Spec.Template.Spec.Containers[i].Args = rewriteArgs(dep.Spec.Template.Spec.Containers[i].Args, ac)
+				}
+			}
+		}
+	})
+}
+
+func argName(arg string) string {
+	return strings.SplitN(arg, "=", 2)[0]
+}
+
+func rewriteArgs(args []string, ac argsConfig) []string {
+	finalArgs := []string{}
+	seen := map[string]bool{}
+	for _, arg := range args {
+		name := argName(arg)
+		if containsString(ac.Remove, name) {
+			continue
+		}
+		if value, ok := ac.Set[name]; ok {
+			arg = formatArg(name, value)
+			seen[name] = true
+		}
+		finalArgs = append(finalArgs, arg)
+	}
+	for _, name := range sortedKeys(ac.Set) {
+		if !seen[name] {
+			finalArgs = append(finalArgs, formatArg(name, ac.Set[name]))
+		}
+	}
+	return finalArgs
+}
+
+func formatArg(name, value string) string {
+	if value == "" {
+		return name
+	}
+	return name + "=" + value
+}
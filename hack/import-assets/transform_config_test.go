@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	clusterctlv1 "sigs.k8s.io/cluster-api/cmd/clusterctl/api/v1alpha3"
+)
+
+func TestVSphereDrop(t *testing.T) {
+	config, err := loadImportConfig("import-config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vsphere := &provider{name: "vsphere", ptype: clusterctlv1.InfrastructureProviderType}
+
+	object := func(kind, name string) unstructured.Unstructured {
+		obj := unstructured.Unstructured{}
+		obj.SetKind(kind)
+		obj.SetName(name)
+		return obj
+	}
+	tests := []struct {
+		kind    string
+		name    string
+		dropped bool
+	}{
+		{kind: "CSIDriver", name: "csi.vsphere.vmware.com", dropped: true},
+		{kind: "CustomResourceDefinition", name: "cnsvspherevolumemigrations.cns.vmware.com", dropped: true},
+		{kind: "DaemonSet", name: "vsphere-csi-node", dropped: true},
+		{kind: "Deployment", name: "vsphere-csi-controller", dropped: true},
+		{kind: "ClusterRole", name: "vsphere-csi-controller-role", dropped: true},
+		{kind: "DaemonSet", name: "vsphere-cloud-controller-manager", dropped: true},
+		{kind: "ConfigMap", name: "vsphere-cloud-config", dropped: true},
+		{kind: "ClusterRole", name: "system:cloud-controller-manager", dropped: true},
+		// the objects of the provider are kept, whatever their names contain
+		{kind: "Deployment", name: "capv-controller-manager"},
+		{kind: "CustomResourceDefinition", name: "vsphereclusters.infrastructure.cluster.x-k8s.io"},
+		{kind: "ClusterRole", name: "capv-manager-role"},
+		{kind: "Secret", name: "capv-manager-bootstrap-credentials"},
+		{kind: "ConfigMap", name: "capv-cpi-settings"},
+		{kind: "Service", name: "capv-webhook-service"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.kind+" "+tt.name, func(t *testing.T) {
+			objs, err := config.forProvider(vsphere).apply([]unstructured.Unstructured{object(tt.kind, tt.name)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dropped := len(objs) == 0; dropped != tt.dropped {
+				t.Errorf("dropped %t, want %t", dropped, tt.dropped)
+			}
+		})
+	}
+}
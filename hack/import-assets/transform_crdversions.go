@@ -0,0 +1,76 @@
+package main
+
+import (
+	"regexp"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/klog/v2"
+)
+
+// kubeVersionPattern matches the API versions of the CRDs, e.g. "v1beta1".
+var kubeVersionPattern = regexp.MustCompile(`^v[1-9][0-9]*((alpha|beta)[1-9][0-9]*)?$`)
+
+// pruneCRDVersions drops the versions of the CRDs that are no longer served, with
+// dropUnserved, and the ones older than minimum, e.g. "v1alpha4". The storage version is
+// always kept, the clusters still have objects stored in it. The conversion webhook of a CRD
+// left with a single version is removed, there is nothing to convert.
+//
+// A version can only be removed from a CRD once it is no longer in the storedVersions of its
+// status on the clusters upgraded, or the update of the CRD is rejected.
+func pruneCRDVersions(p *provider, objs []unstructured.Unstructured, dropUnserved bool, minimum string) ([]unstructured.Unstructured, error) {
+	if !dropUnserved && minimum == "" {
+		return objs, nil
+	}
+	for i := range objs {
+		obj := &objs[i]
+		if !isCRD(*obj) {
+			continue
+		}
+		versions, _, err := unstructured.NestedSlice(obj.Object, "spec", "versions")
+		if err != nil {
+			return nil, err
+		}
+		kept := []interface{}{}
+		for _, v := range versions {
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			name, _ := m["name"].(string)
+			served, _ := m["served"].(bool)
+			storage, _ := m["storage"].(bool)
+			tooOld := minimum != "" && version.CompareKubeAwareVersionStrings(name, minimum) < 0
+			switch {
+			case !tooOld && (served || !dropUnserved):
+				kept = append(kept, v)
+			case storage:
+				klog.Warningf("%s %s: keeping the storage version %s of %s", p.ptype, p.assetName(), name, obj.GetName())
+				kept = append(kept, v)
+			default:
+				klog.V(2).Infof("dropping version %s of %s", name, obj.GetName())
+			}
+		}
+		if len(kept) == len(versions) {
+			continue
+		}
+		if err := unstructured.SetNestedSlice(obj.Object, kept, "spec", "versions"); err != nil {
+			return nil, err
+		}
+
+		if _, found, _ := unstructured.NestedMap(obj.Object, "spec", "conversion", "webhook"); found && len(kept) == 1 {
+			klog.V(2).Infof("dropping the conversion webhook of %s", obj.GetName())
+			if err := unstructured.SetNestedMap(obj.Object, map[string]interface{}{"strategy": "None"}, "spec", "conversion"); err != nil {
+				return nil, err
+			}
+			anns := obj.GetAnnotations()
+			delete(anns, "service.beta.openshift.io/inject-cabundle")
+			delete(anns, "cert-manager.io/inject-ca-from")
+			if len(anns) == 0 {
+				anns = nil
+			}
+			obj.SetAnnotations(anns)
+		}
+	}
+	return objs, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	clusterctlv1 "sigs.k8s.io/cluster-api/cmd/clusterctl/api/v1alpha3"
+)
+
+func TestPruneCRDVersions(t *testing.T) {
+	const components = `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: awsclusters.infrastructure.cluster.x-k8s.io
+  annotations:
+    cert-manager.io/inject-ca-from: capa-system/capa-serving-cert
+spec:
+  conversion:
+    strategy: Webhook
+    webhook:
+      clientConfig:
+        service:
+          name: capa-webhook-service
+  versions:
+  - name: v1alpha3
+    served: false
+    storage: false
+  - name: v1alpha4
+    served: true
+    storage: false
+  - name: v1beta1
+    served: true
+    storage: true
+`
+	aws := &provider{name: "aws", ptype: clusterctlv1.InfrastructureProviderType}
+
+	tests := []struct {
+		name           string
+		dropUnserved   bool
+		minimum        string
+		want           []string
+		wantConversion string
+	}{
+		{
+			name:           "off",
+			want:           []string{"v1alpha3", "v1alpha4", "v1beta1"},
+			wantConversion: "Webhook",
+		},
+		{
+			name:           "unserved",
+			dropUnserved:   true,
+			want:           []string{"v1alpha4", "v1beta1"},
+			wantConversion: "Webhook",
+		},
+		{
+			name:           "minimum",
+			minimum:        "v1alpha4",
+			want:           []string{"v1alpha4", "v1beta1"},
+			wantConversion: "Webhook",
+		},
+		{
+			name:           "single version",
+			dropUnserved:   true,
+			minimum:        "v1beta1",
+			want:           []string{"v1beta1"},
+			wantConversion: "None",
+		},
+		{
+			name:           "storage version kept",
+			minimum:        "v1beta2",
+			want:           []string{"v1beta1"},
+			wantConversion: "None",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objs, err := pruneCRDVersions(aws, testObjects(t, components), tt.dropUnserved, tt.minimum)
+			if err != nil {
+				t.Fatal(err)
+			}
+			crd := objs[0]
+			versions, _, _ := unstructured.NestedSlice(crd.Object, "spec", "versions")
+			names := []string{}
+			for _, v := range versions {
+				names = append(names, v.(map[string]interface{})["name"].(string))
+			}
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("versions %v, want %v", names, tt.want)
+			}
+			if got, _, _ := unstructured.NestedString(crd.Object, "spec", "conversion", "strategy"); got != tt.wantConversion {
+				t.Errorf("conversion strategy %q, want %q", got, tt.wantConversion)
+			}
+			// the CA injected in the conversion webhook goes with it
+			if _, injected := crd.GetAnnotations()["cert-manager.io/inject-ca-from"]; injected != (tt.wantConversion == "Webhook") {
+				t.Errorf("annotations %v with the conversion strategy %s", crd.GetAnnotations(), tt.wantConversion)
+			}
+		})
+	}
+}
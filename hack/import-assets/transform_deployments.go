@@ -0,0 +1,147 @@
+package main
+
+import (
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// updateDeployment runs fn over the typed Deployment in obj.
+func updateDeployment(obj unstructured.Unstructured, fn func(dep *appsv1.Deployment)) (unstructured.Unstructured, error) {
+	dep := &appsv1.Deployment{}
+	if err := scheme.Convert(&obj, dep, nil); err != nil {
+		return obj, err
+	}
+	fn(dep)
+	rawMap, err := runtime.DefaultUnstructuredConverter.ToUnstructured(dep)
+	if err != nil {
+		return obj, err
+	}
+	// drop the empty fields the conversion adds, they are noise in the assets
+	unstructured.RemoveNestedField(rawMap, "status")
+	unstructured.RemoveNestedField(rawMap, "metadata", "creationTimestamp")
+	unstructured.RemoveNestedField(rawMap, "spec", "template", "metadata", "creationTimestamp")
+	updated := unstructured.Unstructured{Object: rawMap}
+	updated.SetGroupVersionKind(obj.GroupVersionKind())
+	return updated, nil
+}
+
+// updateDeployments runs fn over all the Deployments in objs.
+func updateDeployments(objs []unstructured.Unstructured, fn func(dep *appsv1.Deployment)) ([]unstructured.Unstructured, error) {
+	for i := range objs {
+		if objs[i].GetKind() != "Deployment" {
+			continue
+		}
+		var err error
+		objs[i], err = updateDeployment(objs[i], fn)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return objs, nil
+}
+
+// setResources sets the resources of the Deployment containers, the upstream manifests
+// often have no requests but OpenShift requires them on every container.
+func setResources(objs []unstructured.Unstructured, resourcesConfigs []resourcesConfig) ([]unstructured.Unstructured, error) {
+	if len(resourcesConfigs) == 0 {
+		return objs, nil
+	}
+	return updateDeployments(objs, func(dep *appsv1.Deployment) {
+		for i, c := range dep.Spec.Template.Spec.Containers {
+			for _, rc := range resourcesConfigs {
+				if rc.Container == "" || rc.Container == c.Name {
+					dep.Spec.Template.Spec.Containers[i].Resources = *rc.ResourceRequirements.DeepCopy()
+				}
+			}
+		}
+	})
+}
+
+// setPriorityClassName keeps the controllers from being evicted before the user workloads
+// under node pressure.
+func setPriorityClassName(objs []unstructured.Unstructured, priorityClassName string) ([]unstructured.Unstructured, error) {
+	if priorityClassName == "" {
+		return objs, nil
+	}
+	return updateDeployments(objs, func(dep *appsv1.Deployment) {
+		dep.Spec.Template.Spec.PriorityClassName = priorityClassName
+	})
+}
+
+// setScheduling pins the controllers to the control plane nodes, the tolerations are
+// added to the ones of the upstream manifests.
+func setScheduling(objs []unstructured.Unstructured, nodeSelector map[string]string, tolerations []corev1.Toleration) ([]unstructured.Unstructured, error) {
+	if len(nodeSelector) == 0 && len(tolerations) == 0 {
+		return objs, nil
+	}
+	return updateDeployments(objs, func(dep *appsv1.Deployment) {
+		podSpec := &dep.Spec.Template.Spec
+		if len(nodeSelector) > 0 {
+			podSpec.NodeSelector = map[string]string{}
+			for k, v := range nodeSelector {
+				podSpec.NodeSelector[k] = v
+			}
+		}
+		for _, t := range tolerations {
+			if !hasToleration(podSpec.Tolerations, t) {
+				podSpec.Tolerations = append(podSpec.Tolerations, t)
+			}
+		}
+	})
+}
+
+// hasToleration matches on key and effect only, upstream often tolerates the same taints
+// with a different operator.
+func hasToleration(tolerations []corev1.Toleration, t corev1.Toleration) bool {
+	for i := range tolerations {
+		if tolerations[i].Key == t.Key && tolerations[i].Effect == t.Effect {
+			return true
+		}
+	}
+	return false
+}
+
+// setPodAnnotations adds annotations to the pod template of every Deployment, e.g. the
+// workload partitioning one pinning the controllers to the management CPUs.
+func setPodAnnotations(objs []unstructured.Unstructured, annotations map[string]string) ([]unstructured.Unstructured, error) {
+	if len(annotations) == 0 {
+		return objs, nil
+	}
+	return updateDeployments(objs, func(dep *appsv1.Deployment) {
+		if dep.Spec.Template.Annotations == nil {
+			dep.Spec.Template.Annotations = map[string]string{}
+		}
+		for k, v := range annotations {
+			dep.Spec.Template.Annotations[k] = v
+		}
+	})
+}
+
+// addContainerEnv adds the env variables missing from the Deployment containers, the upstream
+// values are kept.
+func addContainerEnv(objs []unstructured.Unstructured, env []corev1.EnvVar) ([]unstructured.Unstructured, error) {
+	if len(env) == 0 {
+		return objs, nil
+	}
+	return updateDeployments(objs, func(dep *appsv1.Deployment) {
+		for i := range dep.Spec.Template.Spec.Containers {
+			c := &dep.Spec.Template.Spec.Containers[i]
+			for _, e := range env {
+				if !hasEnv(c.Env, e.Name) {
+					c.Env = append(c.Env, e)
+				}
+			}
+		}
+	})
+}
+
+func hasEnv(env []corev1.EnvVar, name string) bool {
+	for _, e := range env {
+		if e.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	utilyaml "sigs.k8s.io/cluster-api/util/yaml"
+)
+
+const deploymentTestComponents = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: capa-controller-manager
+spec:
+  template:
+    metadata:
+      annotations:
+        kubectl.kubernetes.io/default-container: manager
+    spec:
+      containers:
+      - name: manager
+        env:
+        - name: AWS_SHARED_CREDENTIALS_FILE
+          value: /home/.aws/credentials
+      - name: kube-rbac-proxy
+      tolerations:
+      - key: node-role.kubernetes.io/master
+        effect: NoSchedule
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: capa-webhook-service
+`
+
+// testObjects returns the objects of the components, the Deployment first.
+func testObjects(t *testing.T, components string) []unstructured.Unstructured {
+	objs, err := utilyaml.ToUnstructured([]byte(components))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return objs
+}
+
+func TestSetResources(t *testing.T) {
+	small := corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("10m")}}
+	large := corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")}}
+
+	tests := []struct {
+		name    string
+		configs []resourcesConfig
+		want    map[string]corev1.ResourceRequirements
+	}{
+		{
+			name: "off",
+			want: map[string]corev1.ResourceRequirements{"manager": {}, "kube-rbac-proxy": {}},
+		},
+		{
+			name:    "every container",
+			configs: []resourcesConfig{{ResourceRequirements: small}},
+			want:    map[string]corev1.ResourceRequirements{"manager": small, "kube-rbac-proxy": small},
+		},
+		{
+			name:    "container",
+			configs: []resourcesConfig{{ResourceRequirements: small}, {Container: "manager", ResourceRequirements: large}},
+			want:    map[string]corev1.ResourceRequirements{"manager": large, "kube-rbac-proxy": small},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objs, err := setResources(testObjects(t, deploymentTestComponents), tt.configs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, c := range testDeployment(t, objs[0]).Spec.Template.Spec.Containers {
+				if want := tt.want[c.Name]; !reflect.DeepEqual(c.Resources, want) {
+					t.Errorf("resources of %s %v, want %v", c.Name, c.Resources, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSetPriorityClassName(t *testing.T) {
+	tests := []struct {
+		name              string
+		priorityClassName string
+	}{
+		{name: "off"},
+		{name: "set", priorityClassName: "system-cluster-critical"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objs, err := setPriorityClassName(testObjects(t, deploymentTestComponents), tt.priorityClassName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := testDeployment(t, objs[0]).Spec.Template.Spec.PriorityClassName; got != tt.priorityClassName {
+				t.Errorf("priorityClassName %q, want %q", got, tt.priorityClassName)
+			}
+		})
+	}
+}
+
+func TestSetScheduling(t *testing.T) {
+	master := corev1.Toleration{Key: "node-role.kubernetes.io/master", Effect: corev1.TaintEffectNoSchedule}
+	tests := []struct {
+		name             string
+		nodeSelector     map[string]string
+		tolerations      []corev1.Toleration
+		wantNodeSelector map[string]string
+		wantTolerations  []corev1.Toleration
+	}{
+		{
+			name:            "off",
+			wantTolerations: []corev1.Toleration{master},
+		},
+		{
+			name:             "node selector",
+			nodeSelector:     map[string]string{"node-role.kubernetes.io/master": ""},
+			wantNodeSelector: map[string]string{"node-role.kubernetes.io/master": ""},
+			wantTolerations:  []corev1.Toleration{master},
+		},
+		{
+			name: "tolerations",
+			tolerations: []corev1.Toleration{
+				// tolerated upstream already, with another operator
+				{Key: "node-role.kubernetes.io/master", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoSchedule},
+				{Key: "node.kubernetes.io/not-ready", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoExecute},
+			},
+			wantTolerations: []corev1.Toleration{
+				master,
+				{Key: "node.kubernetes.io/not-ready", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoExecute},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objs, err := setScheduling(testObjects(t, deploymentTestComponents), tt.nodeSelector, tt.tolerations)
+			if err != nil {
+				t.Fatal(err)
+			}
+			podSpec := testDeployment(t, objs[0]).Spec.Template.Spec
+			if !reflect.DeepEqual(podSpec.NodeSelector, tt.wantNodeSelector) {
+				t.Errorf("nodeSelector %v, want %v", podSpec.NodeSelector, tt.wantNodeSelector)
+			}
+			if !reflect.DeepEqual(podSpec.Tolerations, tt.wantTolerations) {
+				t.Errorf("tolerations %v, want %v", podSpec.Tolerations, tt.wantTolerations)
+			}
+		})
+	}
+}
+
+func TestSetPodAnnotations(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        map[string]string
+	}{
+		{
+			name: "off",
+			want: map[string]string{"kubectl.kubernetes.io/default-container": "manager"},
+		},
+		{
+			name:        "added to the upstream ones",
+			annotations: map[string]string{"target.workload.openshift.io/management": `{"effect": "PreferredDuringScheduling"}`},
+			want: map[string]string{
+				"kubectl.kubernetes.io/default-container": "manager",
+				"target.workload.openshift.io/management": `{"effect": "PreferredDuringScheduling"}`,
+			},
+		},
+		{
+			name:        "replacing an upstream one",
+			annotations: map[string]string{"kubectl.kubernetes.io/default-container": "kube-rbac-proxy"},
+			want:        map[string]string{"kubectl.kubernetes.io/default-container": "kube-rbac-proxy"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objs, err := setPodAnnotations(testObjects(t, deploymentTestComponents), tt.annotations)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := testDeployment(t, objs[0]).Spec.Template.Annotations; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pod annotations %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddContainerEnv(t *testing.T) {
+	upstream := corev1.EnvVar{Name: "AWS_SHARED_CREDENTIALS_FILE", Value: "/home/.aws/credentials"}
+	proxy := corev1.EnvVar{Name: "HTTPS_PROXY"}
+	tests := []struct {
+		name string
+		env  []corev1.EnvVar
+		want map[string][]corev1.EnvVar
+	}{
+		{
+			name: "off",
+			want: map[string][]corev1.EnvVar{"manager": {upstream}},
+		},
+		{
+			name: "added to every container",
+			env:  []corev1.EnvVar{proxy},
+			want: map[string][]corev1.EnvVar{"manager": {upstream, proxy}, "kube-rbac-proxy": {proxy}},
+		},
+		{
+			name: "upstream value kept",
+			env:  []corev1.EnvVar{{Name: "AWS_SHARED_CREDENTIALS_FILE", Value: "/tmp/credentials"}},
+			want: map[string][]corev1.EnvVar{
+				"manager":         {upstream},
+				"kube-rbac-proxy": {{Name: "AWS_SHARED_CREDENTIALS_FILE", Value: "/tmp/credentials"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objs, err := addContainerEnv(testObjects(t, deploymentTestComponents), tt.env)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, c := range testDeployment(t, objs[0]).Spec.Template.Spec.Containers {
+				if want := tt.want[c.Name]; !reflect.DeepEqual(c.Env, want) {
+					t.Errorf("env of %s %v, want %v", c.Name, c.Env, want)
+				}
+			}
+		})
+	}
+}
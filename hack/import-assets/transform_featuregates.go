@@ -0,0 +1,102 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const featureGatesFlag = "--feature-gates"
+
+// setFeatureGates forces the feature gates of the managers, so a change of the upstream
+// defaults doesn't silently change the behavior of a provider across versions. The gates
+// are set both in the --feature-gates flag and in the matching EXP_ env variables (e.g.
+// MachinePool and EXP_MACHINE_POOL). The common gates are only set on the managers that
+// already know them, the provider specific ones (add) are added when missing.
+func setFeatureGates(objs []unstructured.Unstructured, common, add map[string]bool) ([]unstructured.Unstructured, error) {
+	if len(common) == 0 && len(add) == 0 {
+		return objs, nil
+	}
+	gates := mergeGates(common, add)
+	return updateDeployments(objs, func(dep *appsv1.Deployment) {
+		for i, c := range dep.Spec.Template.Spec.Containers {
+			for j, arg := range c.Args {
+				if argName(arg) != featureGatesFlag {
+					continue
+				}
+				value := strings.TrimPrefix(strings.TrimPrefix(arg, featureGatesFlag), "=")
+				dep.Spec.Template.Spec.Containers[i].Args[j] = formatArg(featureGatesFlag, rewriteFeatureGates(value, gates, add))
+			}
+			for j, env := range c.Env {
+				for gate, enabled := range gates {
+					if env.Name == featureGateEnvName(gate) && env.ValueFrom == nil {
+						dep.Spec.Template.Spec.Containers[i].Env[j].Value = strconv.FormatBool(enabled)
+					}
+				}
+			}
+		}
+	})
+}
+
+// rewriteFeatureGates rewrites a "Gate1=true,Gate2=false" flag value, the gates in add are
+// appended when missing.
+func rewriteFeatureGates(value string, gates, add map[string]bool) string {
+	result := []string{}
+	seen := map[string]bool{}
+	for _, gate := range strings.Split(value, ",") {
+		if gate == "" {
+			continue
+		}
+		name := strings.SplitN(gate, "=", 2)[0]
+		if enabled, ok := gates[name]; ok {
+			gate = name + "=" + strconv.FormatBool(enabled)
+		}
+		seen[name] = true
+		result = append(result, gate)
+	}
+	missing := []string{}
+	for name := range add {
+		if !seen[name] {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+	for _, name := range missing {
+		result = append(result, name+"="+strconv.FormatBool(add[name]))
+	}
+	return strings.Join(result, ",")
+}
+
+func mergeGates(common, add map[string]bool) map[string]bool {
+	gates := map[string]bool{}
+	for k, v := range common {
+		gates[k] = v
+	}
+	for k, v := range add {
+		gates[k] = v
+	}
+	return gates
+}
+
+// featureGateEnvName returns the clusterctl variable of a gate, e.g. EXP_CLUSTER_RESOURCE_SET
+// for ClusterResourceSet and EXP_EKS_ENABLE_IAM for EKSEnableIAM.
+func featureGateEnvName(gate string) string {
+	runes := []rune(gate)
+	b := strings.Builder{}
+	b.WriteString("EXP_")
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prevLower := unicode.IsLower(runes[i-1])
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if prevLower || (unicode.IsUpper(runes[i-1]) && nextLower) {
+				b.WriteRune('_')
+			}
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+const featureGatesTestComponents = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: capi-controller-manager
+spec:
+  template:
+    spec:
+      containers:
+      - name: manager
+        args:
+        - --leader-elect
+        - --feature-gates=MachinePool=${EXP_MACHINE_POOL:=false},ClusterResourceSet=${EXP_CLUSTER_RESOURCE_SET:=false}
+        env:
+        - name: EXP_MACHINE_POOL
+          value: "false"
+        - name: EXP_CLUSTER_RESOURCE_SET
+          valueFrom:
+            configMapKeyRef:
+              name: capi-feature-gates
+              key: cluster-resource-set
+`
+
+func TestSetFeatureGates(t *testing.T) {
+	tests := []struct {
+		name     string
+		common   map[string]bool
+		add      map[string]bool
+		wantArgs []string
+		wantEnv  string
+	}{
+		{
+			name:     "off",
+			wantArgs: []string{"--leader-elect", "--feature-gates=MachinePool=${EXP_MACHINE_POOL:=false},ClusterResourceSet=${EXP_CLUSTER_RESOURCE_SET:=false}"},
+			wantEnv:  "false",
+		},
+		{
+			name:     "common",
+			common:   map[string]bool{"MachinePool": true, "EKS": true},
+			wantArgs: []string{"--leader-elect", "--feature-gates=MachinePool=true,ClusterResourceSet=${EXP_CLUSTER_RESOURCE_SET:=false}"},
+			wantEnv:  "true",
+		},
+		{
+			name:     "provider",
+			common:   map[string]bool{"MachinePool": true},
+			add:      map[string]bool{"MachinePool": false, "EKS": true, "AutoControllerIdentityCreator": false},
+			wantArgs: []string{"--leader-elect", "--feature-gates=MachinePool=false,ClusterResourceSet=${EXP_CLUSTER_RESOURCE_SET:=false},AutoControllerIdentityCreator=false,EKS=true"},
+			wantEnv:  "false",
+		},
+		{
+			name:     "env from a ConfigMap",
+			common:   map[string]bool{"ClusterResourceSet": true},
+			wantArgs: []string{"--leader-elect", "--feature-gates=MachinePool=${EXP_MACHINE_POOL:=false},ClusterResourceSet=true"},
+			wantEnv:  "false",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objs, err := setFeatureGates(testObjects(t, featureGatesTestComponents), tt.common, tt.add)
+			if err != nil {
+				t.Fatal(err)
+			}
+			manager := testDeployment(t, objs[0]).Spec.Template.Spec.Containers[0]
+			if !reflect.DeepEqual(manager.Args, tt.wantArgs) {
+				t.Errorf("args %v, want %v", manager.Args, tt.wantArgs)
+			}
+			if got := manager.Env[0].Value; got != tt.wantEnv {
+				t.Errorf("EXP_MACHINE_POOL %q, want %q", got, tt.wantEnv)
+			}
+			// the variables set from a ConfigMap are left alone
+			if env := manager.Env[1]; env.Value != "" || env.ValueFrom == nil {
+				t.Errorf("EXP_CLUSTER_RESOURCE_SET %+v, want it from its ConfigMap", manager.Env[1])
+			}
+		})
+	}
+}
+
+func TestFeatureGateEnvName(t *testing.T) {
+	tests := []struct {
+		gate string
+		want string
+	}{
+		{gate: "MachinePool", want: "EXP_MACHINE_POOL"},
+		{gate: "ClusterResourceSet", want: "EXP_CLUSTER_RESOURCE_SET"},
+		{gate: "EKS", want: "EXP_EKS"},
+		{gate: "EKSEnableIAM", want: "EXP_EKS_ENABLE_IAM"},
+		{gate: "AKSResourceHealth", want: "EXP_AKS_RESOURCE_HEALTH"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.gate, func(t *testing.T) {
+			if got := featureGateEnvName(tt.gate); got != tt.want {
+				t.Errorf("featureGateEnvName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
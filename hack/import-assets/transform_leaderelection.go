@@ -0,0 +1,65 @@
+package main
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// leaderElectionFlags maps the spellings the providers use for enabling leader election to
+// the flags of the lease timings that come with them, which are empty when the providers
+// with that spelling don't have them.
+var leaderElectionFlags = map[string][]string{
+	"--leader-elect":           {"--leader-elect-lease-duration", "--leader-elect-renew-deadline", "--leader-elect-retry-period"},
+	"--enable-leader-election": nil,
+	"--leader-election":        nil,
+}
+
+// leaderElectionConfig are the lease timings set on every manager. The OpenShift defaults
+// (137s/107s/26s) let a single node cluster survive an API server rollout without losing
+// the lease, while still failing over within a couple of minutes on HA clusters.
+type leaderElectionConfig struct {
+	LeaseDuration string `json:"leaseDuration,omitempty"`
+	RenewDeadline string `json:"renewDeadline,omitempty"`
+	RetryPeriod   string `json:"retryPeriod,omitempty"`
+}
+
+func (c leaderElectionConfig) validate() error {
+	for _, d := range []string{c.LeaseDuration, c.RenewDeadline, c.RetryPeriod} {
+		if d == "" {
+			continue
+		}
+		if _, err := time.ParseDuration(d); err != nil {
+			return errors.Wrap(err, "invalid leaderElection")
+		}
+	}
+	return nil
+}
+
+// normalizeLeaderElection makes sure leader election is enabled on every manager, keeping the
+// flag spelling of the provider, and sets the same lease timings everywhere.
+func normalizeLeaderElection(objs []unstructured.Unstructured, config leaderElectionConfig) ([]unstructured.Unstructured, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	timings := []string{config.LeaseDuration, config.RenewDeadline, config.RetryPeriod}
+
+	return updateDeployments(objs, func(dep *appsv1.Deployment) {
+		for i, c := range dep.Spec.Template.Spec.Containers {
+			for enableFlag, timingFlags := range leaderElectionFlags {
+				if !hasArg(c.Args, enableFlag) {
+					continue
+				}
+				set := map[string]string{enableFlag: ""}
+				for j, f := range timingFlags {
+					if timings[j] != "" {
+						set[f] = timings[j]
+					}
+				}
+				dep.Spec.Template.Spec.Containers[i].Args = rewriteArgs(c.Args, argsConfig{Set: set})
+			}
+		}
+	})
+}
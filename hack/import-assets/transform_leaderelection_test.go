@@ -0,0 +1,76 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeLeaderElection(t *testing.T) {
+	components := func(args string) string {
+		return `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: capa-controller-manager
+spec:
+  template:
+    spec:
+      containers:
+      - name: manager
+        args: ` + args + `
+`
+	}
+	timings := leaderElectionConfig{LeaseDuration: "137s", RenewDeadline: "107s", RetryPeriod: "26s"}
+
+	tests := []struct {
+		name    string
+		args    string
+		config  leaderElectionConfig
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:   "timings",
+			args:   `["--leader-elect", "--leader-elect-lease-duration=15s", "--v=2"]`,
+			config: timings,
+			want:   []string{"--leader-elect", "--leader-elect-lease-duration=137s", "--v=2", "--leader-elect-renew-deadline=107s", "--leader-elect-retry-period=26s"},
+		},
+		{
+			name:   "enabled",
+			args:   `["--leader-elect=false"]`,
+			config: leaderElectionConfig{LeaseDuration: "137s"},
+			want:   []string{"--leader-elect", "--leader-elect-lease-duration=137s"},
+		},
+		{
+			name:   "spelling without timings",
+			args:   `["--enable-leader-election", "--v=2"]`,
+			config: timings,
+			want:   []string{"--enable-leader-election", "--v=2"},
+		},
+		{
+			name:   "without leader election",
+			args:   `["--v=2"]`,
+			config: timings,
+			want:   []string{"--v=2"},
+		},
+		{
+			name:    "invalid timing",
+			args:    `["--leader-elect"]`,
+			config:  leaderElectionConfig{LeaseDuration: "137"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objs, err := normalizeLeaderElection(testObjects(t, components(tt.args)), tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("normalizeLeaderElection() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := testDeployment(t, objs[0]).Spec.Template.Spec.Containers[0].Args; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("args %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"path/filepath"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	kubeRBACProxyContainer = "kube-rbac-proxy"
+	metricsCertVolume      = "metrics-cert"
+)
+
+// metricsFlags are the spellings of the manager flag setting the metrics address.
+var metricsFlags = []string{"--metrics-bind-addr", "--metrics-addr"}
+
+// metricsConfig sets how the managers serve their metrics once the kube-rbac-proxy sidecar
+// is removed: directly with TLS, using the certificate the service CA issues for the
+// metrics Service.
+type metricsConfig struct {
+	// BindAddress replaces the metrics address of the manager, e.g. ":8443".
+	BindAddress string `json:"bindAddress,omitempty"`
+	// CertDir is where the serving certificate secret is mounted in the manager.
+	CertDir string `json:"certDir,omitempty"`
+	// TLSArgs are the manager flags pointing it to the serving certificate in CertDir.
+	TLSArgs map[string]string `json:"tlsArgs,omitempty"`
+}
+
+// stripKubeRBACProxy removes the kube-rbac-proxy sidecars and rewires the containers that
+// serve metrics to do it on the address the proxy was listening on.
+func stripKubeRBACProxy(objs []unstructured.Unstructured, config metricsConfig) ([]unstructured.Unstructured, error) {
+	if config.BindAddress == "" {
+		return objs, nil
+	}
+
+	// the metrics Services select the pods by label and target the port of the proxy
+	type proxiedPods struct {
+		labels     labels.Set
+		ports      []corev1.ContainerPort
+		secretName string
+	}
+	proxied := []proxiedPods{}
+
+	objs, err := updateDeployments(objs, func(dep *appsv1.Deployment) {
+		podSpec := &dep.Spec.Template.Spec
+		var proxy *corev1.Container
+		containers := []corev1.Container{}
+		for i := range podSpec.Containers {
+			if podSpec.Containers[i].Name == kubeRBACProxyContainer {
+				proxy = &podSpec.Containers[i]
+				continue
+			}
+			containers = append(containers, podSpec.Containers[i])
+		}
+		if proxy == nil {
+			return
+		}
+
+		secretName := dep.Name + "-metrics-cert"
+		for i := range containers {
+			if !servesMetrics(containers[i]) {
+				continue
+			}
+			set := map[string]string{}
+			for k, v := range config.TLSArgs {
+				set[k] = v
+			}
+			for _, f := range metricsFlags {
+				if hasArg(containers[i].Args, f) {
+					set[f] = config.BindAddress
+				}
+			}
+			containers[i].Args = rewriteArgs(containers[i].Args, argsConfig{Set: set})
+			containers[i].Ports = append(containers[i].Ports, proxy.Ports...)
+			containers[i].VolumeMounts = append(containers[i].VolumeMounts, corev1.VolumeMount{
+				Name:      metricsCertVolume,
+				MountPath: filepath.Clean(config.CertDir),
+				ReadOnly:  true,
+			})
+		}
+		podSpec.Containers = containers
+		podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+			Name: metricsCertVolume,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{SecretName: secretName},
+			},
+		})
+		proxied = append(proxied, proxiedPods{labels: dep.Spec.Template.Labels, ports: proxy.Ports, secretName: secretName})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, obj := range objs {
+		if obj.GetKind() != "Service" {
+			continue
+		}
+		selector, _, _ := unstructured.NestedStringMap(obj.Object, "spec", "selector")
+		if len(selector) == 0 {
+			continue
+		}
+		for _, pp := range proxied {
+			if !labels.SelectorFromSet(selector).Matches(pp.labels) || !targetsPorts(obj, pp.ports) {
+				continue
+			}
+			anns := obj.GetAnnotations()
+			if anns == nil {
+				anns = map[string]string{}
+			}
+			anns["service.beta.openshift.io/serving-cert-secret-name"] = pp.secretName
+			obj.SetAnnotations(anns)
+		}
+	}
+	return objs, nil
+}
+
+func servesMetrics(c corev1.Container) bool {
+	for _, f := range metricsFlags {
+		if hasArg(c.Args, f) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasArg(args []string, name string) bool {
+	for _, arg := range args {
+		if argName(arg) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// targetsPorts returns whether the Service targets one of the ports, by name or number.
+func targetsPorts(svc unstructured.Unstructured, ports []corev1.ContainerPort) bool {
+	svcPorts, _, _ := unstructured.NestedSlice(svc.Object, "spec", "ports")
+	for _, sp := range svcPorts {
+		spMap, ok := sp.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for _, p := range ports {
+			switch target := spMap["targetPort"].(type) {
+			case string:
+				if target == p.Name {
+					return true
+				}
+			case int64:
+				if target == int64(p.ContainerPort) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
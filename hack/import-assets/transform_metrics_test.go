@@ -0,0 +1,196 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	clusterctlv1 "sigs.k8s.io/cluster-api/cmd/clusterctl/api/v1alpha3"
+	utilyaml "sigs.k8s.io/cluster-api/util/yaml"
+)
+
+const metricsTestComponents = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: capa-controller-manager
+spec:
+  template:
+    metadata:
+      labels:
+        control-plane: capa-controller-manager
+    spec:
+      containers:
+      - name: manager
+        args:
+        - --metrics-bind-addr=127.0.0.1:8080
+        - --leader-elect
+      - name: kube-rbac-proxy
+        args:
+        - --secure-listen-address=0.0.0.0:8443
+        - --upstream=http://127.0.0.1:8080/
+        ports:
+        - containerPort: 8443
+          name: https
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: capa-controller-manager-metrics-service
+spec:
+  selector:
+    control-plane: capa-controller-manager
+  ports:
+  - name: https
+    port: 8443
+    targetPort: https
+`
+
+func TestMetricsFor(t *testing.T) {
+	aws := &provider{name: "aws", ptype: clusterctlv1.InfrastructureProviderType}
+	gcp := &provider{name: "gcp", ptype: clusterctlv1.InfrastructureProviderType}
+	defaults := metricsConfig{BindAddress: ":8443"}
+	override := metricsConfig{BindAddress: ":9443", CertDir: "/etc/tls/private"}
+
+	tests := []struct {
+		name     string
+		certMode string
+		config   importConfig
+		provider *provider
+		want     metricsConfig
+	}{
+		{
+			name:     "off",
+			certMode: certModeServiceCA,
+			provider: aws,
+		},
+		{
+			name:     "default",
+			certMode: certModeServiceCA,
+			config:   importConfig{Metrics: defaults},
+			provider: aws,
+			want:     defaults,
+		},
+		{
+			name:     "provider",
+			certMode: certModeServiceCA,
+			config: importConfig{Providers: map[string]providerConfig{
+				"infrastructure-aws": {Metrics: &override},
+			}},
+			provider: aws,
+			want:     override,
+		},
+		{
+			name:     "other provider",
+			certMode: certModeServiceCA,
+			config: importConfig{Metrics: defaults, Providers: map[string]providerConfig{
+				"infrastructure-aws": {Metrics: &override},
+			}},
+			provider: gcp,
+			want:     defaults,
+		},
+		{
+			name:     "provider off",
+			certMode: certModeServiceCA,
+			config: importConfig{Metrics: defaults, Providers: map[string]providerConfig{
+				"infrastructure-aws": {Metrics: &metricsConfig{}},
+			}},
+			provider: aws,
+		},
+		{
+			name:     "cert-manager",
+			certMode: certModeCertManager,
+			config: importConfig{Metrics: defaults, Providers: map[string]providerConfig{
+				"infrastructure-aws": {Metrics: &override},
+			}},
+			provider: aws,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(mode string) { *certMode = mode }(*certMode)
+			*certMode = tt.certMode
+			if got := tt.config.metricsFor(tt.provider); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("metricsFor() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStripKubeRBACProxy(t *testing.T) {
+	tests := []struct {
+		name           string
+		config         metricsConfig
+		wantContainers []string
+		wantArgs       []string
+		wantSecret     string
+	}{
+		{
+			name:           "off",
+			wantContainers: []string{"manager", "kube-rbac-proxy"},
+			wantArgs:       []string{"--metrics-bind-addr=127.0.0.1:8080", "--leader-elect"},
+		},
+		{
+			name: "TLS",
+			config: metricsConfig{
+				BindAddress: ":8443",
+				CertDir:     "/etc/tls/private",
+				TLSArgs:     map[string]string{"--metrics-tls-cert-file": "/etc/tls/private/tls.crt"},
+			},
+			wantContainers: []string{"manager"},
+			wantArgs:       []string{"--metrics-bind-addr=:8443", "--leader-elect", "--metrics-tls-cert-file=/etc/tls/private/tls.crt"},
+			wantSecret:     "capa-controller-manager-metrics-cert",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objs, err := utilyaml.ToUnstructured([]byte(metricsTestComponents))
+			if err != nil {
+				t.Fatal(err)
+			}
+			objs, err = stripKubeRBACProxy(objs, tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dep := testDeployment(t, objs[0])
+			containers := []string{}
+			for _, c := range dep.Spec.Template.Spec.Containers {
+				containers = append(containers, c.Name)
+			}
+			if !reflect.DeepEqual(containers, tt.wantContainers) {
+				t.Errorf("containers %v, want %v", containers, tt.wantContainers)
+			}
+			manager := dep.Spec.Template.Spec.Containers[0]
+			if !reflect.DeepEqual(manager.Args, tt.wantArgs) {
+				t.Errorf("manager args %v, want %v", manager.Args, tt.wantArgs)
+			}
+			if got := objs[1].GetAnnotations()["service.beta.openshift.io/serving-cert-secret-name"]; got != tt.wantSecret {
+				t.Errorf("metrics Service serving cert secret %q, want %q", got, tt.wantSecret)
+			}
+			if tt.wantSecret == "" {
+				return
+			}
+			// the manager serves on the port of the proxy, with the serving certificate
+			if len(manager.Ports) != 1 || manager.Ports[0].Name != "https" {
+				t.Errorf("manager ports %v, want the https port of the proxy", manager.Ports)
+			}
+			volumes := dep.Spec.Template.Spec.Volumes
+			if len(volumes) != 1 || volumes[0].Secret == nil || volumes[0].Secret.SecretName != tt.wantSecret {
+				t.Errorf("volumes %v, want the %s secret", volumes, tt.wantSecret)
+			}
+			if len(manager.VolumeMounts) != 1 || manager.VolumeMounts[0].MountPath != tt.config.CertDir {
+				t.Errorf("manager volume mounts %v, want %s", manager.VolumeMounts, tt.config.CertDir)
+			}
+		})
+	}
+}
+
+// testDeployment returns the typed Deployment of obj.
+func testDeployment(t *testing.T, obj unstructured.Unstructured) *appsv1.Deployment {
+	dep := &appsv1.Deployment{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, dep); err != nil {
+		t.Fatal(err)
+	}
+	return dep
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/klog/v2"
+	clusterctlv1 "sigs.k8s.io/cluster-api/cmd/clusterctl/api/v1alpha3"
+)
+
+const controllerManagerSuffix = "-controller-manager"
+
+// controllerManagerName is the normalized name of the manager Deployment of the provider,
+// e.g. "aws-controller-manager" for the upstream "capa-controller-manager".
+func (p *provider) controllerManagerName() string {
+	switch p.ptype {
+	case clusterctlv1.BootstrapProviderType:
+		return p.assetName() + "-bootstrap" + controllerManagerSuffix
+	case clusterctlv1.ControlPlaneProviderType:
+		return p.assetName() + "-control-plane" + controllerManagerSuffix
+	default:
+		return p.assetName() + controllerManagerSuffix
+	}
+}
+
+// normalizeNames renames the manager Deployment of the provider to controllerManagerName, its
+// ServiceAccount to the same name and the Services sharing the prefix of the upstream
+// Deployment, e.g. capa-webhook-service becomes aws-webhook-service. The webhooks, the CRD
+// conversion webhooks, the cert-manager Certificates and the RBAC subjects are updated to the
+// new names. The providers with several managers keep the upstream names.
+func normalizeNames(p *provider, objs []unstructured.Unstructured, enabled bool) ([]unstructured.Unstructured, error) {
+	if !enabled {
+		return objs, nil
+	}
+	managers := []int{}
+	for i, obj := range objs {
+		if obj.GetKind() == "Deployment" && strings.HasSuffix(obj.GetName(), controllerManagerSuffix) {
+			managers = append(managers, i)
+		}
+	}
+	if len(managers) != 1 {
+		klog.Warningf("keeping the upstream names of %s %s, it has %d controller managers", p.ptype, p.assetName(), len(managers))
+		return objs, nil
+	}
+
+	manager := &objs[managers[0]]
+	name := p.controllerManagerName()
+	oldPrefix := strings.TrimSuffix(manager.GetName(), controllerManagerSuffix)
+	prefix := strings.TrimSuffix(name, controllerManagerSuffix)
+	klog.V(2).Infof("renaming Deployment %s to %s", manager.GetName(), name)
+	manager.SetName(name)
+
+	serviceAccounts := map[string]string{}
+	serviceAccount, _, err := unstructured.NestedString(manager.Object, "spec", "template", "spec", "serviceAccountName")
+	if err != nil {
+		return nil, err
+	}
+	if serviceAccount != "" {
+		serviceAccounts[serviceAccount] = name
+		if err := unstructured.SetNestedField(manager.Object, name, "spec", "template", "spec", "serviceAccountName"); err != nil {
+			return nil, err
+		}
+	}
+
+	services := map[string]string{}
+	for i := range objs {
+		obj := &objs[i]
+		newName := ""
+		switch obj.GetKind() {
+		case "Service":
+			if strings.HasPrefix(obj.GetName(), oldPrefix+"-") {
+				newName = prefix + strings.TrimPrefix(obj.GetName(), oldPrefix)
+				services[obj.GetName()] = newName
+			}
+		case "ServiceAccount":
+			newName = serviceAccounts[obj.GetName()]
+		}
+		if newName != "" {
+			klog.V(2).Infof("renaming %s %s to %s", obj.GetKind(), obj.GetName(), newName)
+			obj.SetName(newName)
+		}
+	}
+
+	for i := range objs {
+		obj := &objs[i]
+		var err error
+		switch obj.GetKind() {
+		case "MutatingWebhookConfiguration", "ValidatingWebhookConfiguration":
+			err = updateListItems(obj, "webhooks", func(webhook map[string]interface{}) error {
+				return renameField(webhook, services, "clientConfig", "service", "name")
+			})
+		case "CustomResourceDefinition":
+			err = renameField(obj.Object, services, "spec", "conversion", "webhook", "clientConfig", "service", "name")
+		case "Certificate":
+			err = renameDNSNames(obj, services)
+		case "RoleBinding", "ClusterRoleBinding":
+			err = updateListItems(obj, "subjects", func(subject map[string]interface{}) error {
+				if subject["kind"] != "ServiceAccount" {
+					return nil
+				}
+				return renameField(subject, serviceAccounts, "name")
+			})
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return objs, nil
+}
+
+// updateListItems runs fn on the objects of a top level list, e.g. the webhooks.
+func updateListItems(obj *unstructured.Unstructured, list string, fn func(item map[string]interface{}) error) error {
+	items, found, err := unstructured.NestedSlice(obj.Object, list)
+	if err != nil || !found {
+		return err
+	}
+	for _, item := range items {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+	return unstructured.SetNestedSlice(obj.Object, items, list)
+}
+
+func renameField(obj map[string]interface{}, names map[string]string, fields ...string) error {
+	name, found, err := unstructured.NestedString(obj, fields...)
+	if err != nil || !found {
+		return err
+	}
+	if newName, ok := names[name]; ok {
+		return unstructured.SetNestedField(obj, newName, fields...)
+	}
+	return nil
+}
+
+// renameDNSNames renames the Services in the <service>.<namespace>.svc DNS names of a
+// cert-manager Certificate, kept with --cert-mode cert-manager.
+func renameDNSNames(obj *unstructured.Unstructured, services map[string]string) error {
+	dnsNames, found, err := unstructured.NestedStringSlice(obj.Object, "spec", "dnsNames")
+	if err != nil || !found {
+		return err
+	}
+	for i, dnsName := range dnsNames {
+		parts := strings.SplitN(dnsName, ".", 2)
+		if newName, ok := services[parts[0]]; ok && len(parts) == 2 {
+			dnsNames[i] = newName + "." + parts[1]
+		}
+	}
+	return unstructured.SetNestedStringSlice(obj.Object, dnsNames, "spec", "dnsNames")
+}
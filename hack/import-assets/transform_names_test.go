@@ -0,0 +1,179 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	clusterctlv1 "sigs.k8s.io/cluster-api/cmd/clusterctl/api/v1alpha3"
+)
+
+const namesTestComponents = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: capi-kubeadm-bootstrap-controller-manager
+spec:
+  template:
+    spec:
+      serviceAccountName: capi-kubeadm-bootstrap-manager
+---
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: capi-kubeadm-bootstrap-manager
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: capi-kubeadm-bootstrap-webhook-service
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: other-service
+---
+apiVersion: admissionregistration.k8s.io/v1
+kind: ValidatingWebhookConfiguration
+metadata:
+  name: capi-kubeadm-bootstrap-validating-webhook-configuration
+webhooks:
+- clientConfig:
+    service:
+      name: capi-kubeadm-bootstrap-webhook-service
+---
+apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: kubeadmconfigs.bootstrap.cluster.x-k8s.io
+spec:
+  conversion:
+    webhook:
+      clientConfig:
+        service:
+          name: capi-kubeadm-bootstrap-webhook-service
+---
+apiVersion: cert-manager.io/v1
+kind: Certificate
+metadata:
+  name: capi-kubeadm-bootstrap-serving-cert
+spec:
+  dnsNames:
+  - capi-kubeadm-bootstrap-webhook-service.capi-kubeadm-bootstrap-system.svc
+  - other-service.capi-kubeadm-bootstrap-system.svc
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: capi-kubeadm-bootstrap-manager-rolebinding
+subjects:
+- kind: ServiceAccount
+  name: capi-kubeadm-bootstrap-manager
+- kind: User
+  name: capi-kubeadm-bootstrap-manager
+`
+
+func TestNormalizeNames(t *testing.T) {
+	kubeadm := &provider{name: "kubeadm", ptype: clusterctlv1.BootstrapProviderType}
+
+	type field struct {
+		index  int
+		fields []string
+	}
+	fields := []field{
+		{0, []string{"metadata", "name"}},
+		{0, []string{"spec", "template", "spec", "serviceAccountName"}},
+		{1, []string{"metadata", "name"}},
+		{2, []string{"metadata", "name"}},
+		{3, []string{"metadata", "name"}},
+		{5, []string{"spec", "conversion", "webhook", "clientConfig", "service", "name"}},
+	}
+	tests := []struct {
+		name    string
+		enabled bool
+		extra   string
+		want    []string
+		// the webhook service, the DNS names of the Certificate and the binding subjects
+		wantWebhook  string
+		wantDNSNames []string
+		wantSubjects []string
+	}{
+		{
+			name: "off",
+			want: []string{
+				"capi-kubeadm-bootstrap-controller-manager",
+				"capi-kubeadm-bootstrap-manager",
+				"capi-kubeadm-bootstrap-manager",
+				"capi-kubeadm-bootstrap-webhook-service",
+				"other-service",
+				"capi-kubeadm-bootstrap-webhook-service",
+			},
+			wantWebhook:  "capi-kubeadm-bootstrap-webhook-service",
+			wantDNSNames: []string{"capi-kubeadm-bootstrap-webhook-service.capi-kubeadm-bootstrap-system.svc", "other-service.capi-kubeadm-bootstrap-system.svc"},
+			wantSubjects: []string{"capi-kubeadm-bootstrap-manager", "capi-kubeadm-bootstrap-manager"},
+		},
+		{
+			name:    "renamed",
+			enabled: true,
+			want: []string{
+				"kubeadm-bootstrap-controller-manager",
+				"kubeadm-bootstrap-controller-manager",
+				"kubeadm-bootstrap-controller-manager",
+				"kubeadm-bootstrap-webhook-service",
+				"other-service",
+				"kubeadm-bootstrap-webhook-service",
+			},
+			wantWebhook:  "kubeadm-bootstrap-webhook-service",
+			wantDNSNames: []string{"kubeadm-bootstrap-webhook-service.capi-kubeadm-bootstrap-system.svc", "other-service.capi-kubeadm-bootstrap-system.svc"},
+			wantSubjects: []string{"kubeadm-bootstrap-controller-manager", "capi-kubeadm-bootstrap-manager"},
+		},
+		{
+			name:    "several managers",
+			enabled: true,
+			extra: `---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: capi-kubeadm-bootstrap-other-controller-manager
+`,
+			want: []string{
+				"capi-kubeadm-bootstrap-controller-manager",
+				"capi-kubeadm-bootstrap-manager",
+				"capi-kubeadm-bootstrap-manager",
+				"capi-kubeadm-bootstrap-webhook-service",
+				"other-service",
+				"capi-kubeadm-bootstrap-webhook-service",
+			},
+			wantWebhook:  "capi-kubeadm-bootstrap-webhook-service",
+			wantDNSNames: []string{"capi-kubeadm-bootstrap-webhook-service.capi-kubeadm-bootstrap-system.svc", "other-service.capi-kubeadm-bootstrap-system.svc"},
+			wantSubjects: []string{"capi-kubeadm-bootstrap-manager", "capi-kubeadm-bootstrap-manager"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objs, err := normalizeNames(kubeadm, testObjects(t, namesTestComponents+tt.extra), tt.enabled)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, f := range fields {
+				if got, _, _ := unstructured.NestedString(objs[f.index].Object, f.fields...); got != tt.want[i] {
+					t.Errorf("%s %v = %q, want %q", objs[f.index].GetKind(), f.fields, got, tt.want[i])
+				}
+			}
+			webhooks, _, _ := unstructured.NestedSlice(objs[4].Object, "webhooks")
+			if got, _, _ := unstructured.NestedString(webhooks[0].(map[string]interface{}), "clientConfig", "service", "name"); got != tt.wantWebhook {
+				t.Errorf("webhook service %q, want %q", got, tt.wantWebhook)
+			}
+			if got, _, _ := unstructured.NestedStringSlice(objs[6].Object, "spec", "dnsNames"); !reflect.DeepEqual(got, tt.wantDNSNames) {
+				t.Errorf("dnsNames %v, want %v", got, tt.wantDNSNames)
+			}
+			subjects, _, _ := unstructured.NestedSlice(objs[7].Object, "subjects")
+			got := []string{}
+			for _, s := range subjects {
+				got = append(got, s.(map[string]interface{})["name"].(string))
+			}
+			if !reflect.DeepEqual(got, tt.wantSubjects) {
+				t.Errorf("subjects %v, want %v", got, tt.wantSubjects)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/utils/pointer"
+)
+
+// restrictPodSecurity sets what the restricted-v2 pod security admission requires on every
+// Deployment. The upstream security contexts are kept and only hardened, a provider that
+// needs more (e.g. a writable root filesystem) can relax it with a patch.
+func restrictPodSecurity(objs []unstructured.Unstructured, restricted bool) ([]unstructured.Unstructured, error) {
+	if !restricted {
+		return objs, nil
+	}
+	return updateDeployments(objs, func(dep *appsv1.Deployment) {
+		podSpec := &dep.Spec.Template.Spec
+		if podSpec.SecurityContext == nil {
+			podSpec.SecurityContext = &corev1.PodSecurityContext{}
+		}
+		podSpec.SecurityContext.RunAsNonRoot = pointer.BoolPtr(true)
+		podSpec.SecurityContext.RunAsUser = nil
+		podSpec.SecurityContext.SeccompProfile = &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault}
+
+		for i := range podSpec.InitContainers {
+			restrictContainerSecurity(&podSpec.InitContainers[i])
+		}
+		for i := range podSpec.Containers {
+			restrictContainerSecurity(&podSpec.Containers[i])
+		}
+	})
+}
+
+func restrictContainerSecurity(c *corev1.Container) {
+	if c.SecurityContext == nil {
+		c.SecurityContext = &corev1.SecurityContext{}
+	}
+	c.SecurityContext.AllowPrivilegeEscalation = pointer.BoolPtr(false)
+	c.SecurityContext.Capabilities = &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}}
+	c.SecurityContext.ReadOnlyRootFilesystem = pointer.BoolPtr(true)
+	// the user is assigned from the namespace range by the restricted-v2 SCC
+	c.SecurityContext.RunAsUser = nil
+}
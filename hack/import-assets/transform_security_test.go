@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestRestrictPodSecurity(t *testing.T) {
+	const components = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: capm3-controller-manager
+spec:
+  template:
+    spec:
+      securityContext:
+        runAsUser: 65532
+      initContainers:
+      - name: init
+      containers:
+      - name: manager
+        securityContext:
+          runAsUser: 65532
+          capabilities:
+            add:
+            - NET_BIND_SERVICE
+`
+	tests := []struct {
+		name       string
+		restricted bool
+	}{
+		{name: "off"},
+		{name: "restricted", restricted: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objs, err := restrictPodSecurity(testObjects(t, components), tt.restricted)
+			if err != nil {
+				t.Fatal(err)
+			}
+			podSpec := testDeployment(t, objs[0]).Spec.Template.Spec
+			if !tt.restricted {
+				if podSpec.SecurityContext.RunAsUser == nil || podSpec.InitContainers[0].SecurityContext != nil {
+					t.Errorf("security contexts modified, %+v", podSpec)
+				}
+				return
+			}
+
+			psc := podSpec.SecurityContext
+			if psc.RunAsUser != nil || psc.RunAsNonRoot == nil || !*psc.RunAsNonRoot ||
+				psc.SeccompProfile == nil || psc.SeccompProfile.Type != corev1.SeccompProfileTypeRuntimeDefault {
+				t.Errorf("pod security context %+v, not restricted", psc)
+			}
+			for _, c := range append(podSpec.InitContainers, podSpec.Containers...) {
+				sc := c.SecurityContext
+				if sc == nil {
+					t.Errorf("container %s has no security context", c.Name)
+					continue
+				}
+				if sc.RunAsUser != nil || sc.AllowPrivilegeEscalation == nil || *sc.AllowPrivilegeEscalation ||
+					sc.ReadOnlyRootFilesystem == nil || !*sc.ReadOnlyRootFilesystem ||
+					sc.Capabilities == nil || len(sc.Capabilities.Add) > 0 || len(sc.Capabilities.Drop) != 1 || sc.Capabilities.Drop[0] != "ALL" {
+					t.Errorf("security context of container %s %+v, not restricted", c.Name, sc)
+				}
+			}
+		})
+	}
+}
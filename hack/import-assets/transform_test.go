@@ -0,0 +1,250 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	utilyaml "sigs.k8s.io/cluster-api/util/yaml"
+)
+
+var update = flag.Bool("update", false, "Update the golden files of the transforms with the current output.")
+
+// The transforms run over the fixture components in testdata/transforms/<type>-<name>/components.yaml,
+// with import-config.yaml and the patches in testdata/patches, and their output is compared with
+// the golden files next to them. After a deliberate change, regenerate them with:
+//
+//	go test . -run TestTransforms -update
+func TestTransforms(t *testing.T) {
+	config, err := loadImportConfig("import-config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(dir string) { *patchesDir = dir }(*patchesDir)
+	*patchesDir = filepath.Join("testdata", "patches")
+
+	dirs, err := filepath.Glob(filepath.Join("testdata", "transforms", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range dirs {
+		dir := dir
+		p := fixtureProvider(t, filepath.Base(dir))
+		t.Run(filepath.Base(dir), func(t *testing.T) {
+			// every step gets a fresh copy, the transforms modify the objects in place
+			load := func() []unstructured.Unstructured {
+				b, err := ioutil.ReadFile(filepath.Join(dir, "components.yaml"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				objs, err := utilyaml.ToUnstructured(b)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return objs
+			}
+
+			serviceCA, err := convertCertificates(load())
+			if err != nil {
+				t.Fatal(err)
+			}
+			compareGolden(t, filepath.Join(dir, "service-ca.golden.yaml"), objectsYAML(t, serviceCA))
+
+			objs, err := newProviderPipeline(config).run(p, load())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := validateObjects(objs); err != nil {
+				t.Fatal(err)
+			}
+			objs, rbacObjs := splitRBACOut(objs)
+			compareGolden(t, filepath.Join(dir, "rbac.golden.yaml"), objectsYAML(t, rbacObjs))
+			objs, crdObjs := splitCRDsOut(objs)
+			compareGolden(t, filepath.Join(dir, "crds.golden.yaml"), objectsYAML(t, crdObjs))
+
+			objs, images, err := p.setRelatedImages(objs, config.PayloadImages)
+			if err != nil {
+				t.Fatal(err)
+			}
+			compareGolden(t, filepath.Join(dir, "components.golden.yaml"), objectsYAML(t, objs))
+			b, err := canonicalYAML(&images)
+			if err != nil {
+				t.Fatal(err)
+			}
+			compareGolden(t, filepath.Join(dir, "images.golden.yaml"), b)
+		})
+	}
+}
+
+// fixtureProvider returns the provider of the fixture directory, named <type>-<name>.
+func fixtureProvider(t *testing.T, name string) *provider {
+	for i := range providers {
+		p := providers[i]
+		if p.providerTypeName()+"-"+p.assetName() == name {
+			return &p
+		}
+	}
+	t.Fatalf("no provider for the fixtures in %s", name)
+	return nil
+}
+
+func objectsYAML(t *testing.T, objs []unstructured.Unstructured) []byte {
+	b, err := canonicalObjectsYAML(objs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// compareGolden fails the test with a diff when got differs from the golden file, or
+// writes it with -update.
+func compareGolden(t *testing.T, golden string, got []byte) {
+	t.Helper()
+	if *update {
+		if err := ioutil.WriteFile(golden, got, 0600); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := ioutil.ReadFile(filepath.Clean(golden))
+	if os.IsNotExist(err) {
+		t.Fatalf("missing %s, run the test with -update to create it", golden)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(want) == string(got) {
+		return
+	}
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        splitLines(want),
+		B:        splitLines(got),
+		FromFile: golden,
+		ToFile:   "got",
+		Context:  3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Errorf("the output differs from %s, run the test with -update if the change is expected:\n%s", golden, diff)
+}
+
+func TestConvertCertificates(t *testing.T) {
+	const components = `apiVersion: v1
+kind: Namespace
+metadata:
+  name: capa-system
+---
+apiVersion: cert-manager.io/v1
+kind: Issuer
+metadata:
+  name: capa-selfsigned-issuer
+---
+apiVersion: cert-manager.io/v1
+kind: Certificate
+metadata:
+  name: capa-serving-cert
+spec:
+  secretName: capa-webhook-service-cert
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: capa-webhook-service
+---
+apiVersion: admissionregistration.k8s.io/v1
+kind: ValidatingWebhookConfiguration
+metadata:
+  name: capa-validating-webhook-configuration
+  annotations:
+    cert-manager.io/inject-ca-from: capa-system/capa-serving-cert
+webhooks:
+- name: validation.awscluster.infrastructure.cluster.x-k8s.io
+  clientConfig:
+    service:
+      name: capa-webhook-service
+`
+	tests := []struct {
+		certMode        string
+		wantKinds       []string
+		wantWebhook     map[string]string
+		wantServiceCert string
+	}{
+		{
+			certMode:        certModeServiceCA,
+			wantKinds:       []string{"Service", "ValidatingWebhookConfiguration"},
+			wantWebhook:     map[string]string{"service.beta.openshift.io/inject-cabundle": "true"},
+			wantServiceCert: "capa-webhook-service-cert",
+		},
+		{
+			certMode:    certModeCertManager,
+			wantKinds:   []string{"Issuer", "Certificate", "Service", "ValidatingWebhookConfiguration"},
+			wantWebhook: map[string]string{"cert-manager.io/inject-ca-from": "capa-system/capa-serving-cert"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.certMode, func(t *testing.T) {
+			defer func(mode string) { *certMode = mode }(*certMode)
+			*certMode = tt.certMode
+			objs, err := utilyaml.ToUnstructured([]byte(components))
+			if err != nil {
+				t.Fatal(err)
+			}
+			objs, err = convertCertificates(objs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			kinds := []string{}
+			for _, obj := range objs {
+				kinds = append(kinds, obj.GetKind())
+			}
+			if !reflect.DeepEqual(kinds, tt.wantKinds) {
+				t.Fatalf("kinds %v, want %v", kinds, tt.wantKinds)
+			}
+			webhook, service := findKind(t, objs, "ValidatingWebhookConfiguration"), findKind(t, objs, "Service")
+			if got := webhook.GetAnnotations(); !reflect.DeepEqual(got, tt.wantWebhook) {
+				t.Errorf("webhook annotations %v, want %v", got, tt.wantWebhook)
+			}
+			if got := service.GetAnnotations()["service.beta.openshift.io/serving-cert-secret-name"]; got != tt.wantServiceCert {
+				t.Errorf("Service serving cert secret %q, want %q", got, tt.wantServiceCert)
+			}
+		})
+	}
+}
+
+func TestAnnotateRBAC(t *testing.T) {
+	tests := []struct {
+		kind      string
+		annotated bool
+	}{
+		{kind: "ClusterRole", annotated: true},
+		{kind: "Role", annotated: true},
+		{kind: "ClusterRoleBinding", annotated: true},
+		{kind: "RoleBinding", annotated: true},
+		{kind: "ServiceAccount", annotated: true},
+		{kind: "Deployment"},
+		{kind: "CustomResourceDefinition"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.kind, func(t *testing.T) {
+			obj := unstructured.Unstructured{}
+			obj.SetKind(tt.kind)
+			obj.SetAnnotations(map[string]string{"upstream": "true"})
+			objs := annotateRBAC([]unstructured.Unstructured{obj})
+
+			want := map[string]string{"upstream": "true"}
+			if tt.annotated {
+				want = annotations
+			}
+			if got := objs[0].GetAnnotations(); !reflect.DeepEqual(got, want) {
+				t.Errorf("annotations %v, want %v", got, want)
+			}
+		})
+	}
+}
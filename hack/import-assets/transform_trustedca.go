@@ -0,0 +1,58 @@
+package main
+
+import (
+	"path/filepath"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/utils/pointer"
+)
+
+const (
+	trustedCAVolume = "trusted-ca"
+	// trustedCABundleKey is the key the cluster network operator injects the bundle in.
+	trustedCABundleKey  = "ca-bundle.crt"
+	trustedCABundleFile = "tls-ca-bundle.pem"
+)
+
+// trustedCAConfig is the ConfigMap the cluster trusted CA bundle is injected in, with the
+// user CAs, so the providers can talk to cloud endpoints with a custom CA.
+type trustedCAConfig struct {
+	ConfigMap string `json:"configMap,omitempty"`
+	// MountPath is the directory the bundle is mounted in, SSL_CERT_DIR points to it.
+	MountPath string `json:"mountPath,omitempty"`
+}
+
+// mountTrustedCA mounts the trusted CA bundle in every container. The volume is optional so
+// the pods still start before the bundle has been injected.
+func mountTrustedCA(objs []unstructured.Unstructured, config trustedCAConfig) ([]unstructured.Unstructured, error) {
+	if config.ConfigMap == "" {
+		return objs, nil
+	}
+	mountPath := filepath.Clean(config.MountPath)
+	return updateDeployments(objs, func(dep *appsv1.Deployment) {
+		podSpec := &dep.Spec.Template.Spec
+		podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+			Name: trustedCAVolume,
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: config.ConfigMap},
+					Items:                []corev1.KeyToPath{{Key: trustedCABundleKey, Path: trustedCABundleFile}},
+					Optional:             pointer.BoolPtr(true),
+				},
+			},
+		})
+		for i := range podSpec.Containers {
+			c := &podSpec.Containers[i]
+			c.VolumeMounts = append(c.VolumeMounts, corev1.VolumeMount{
+				Name:      trustedCAVolume,
+				MountPath: mountPath,
+				ReadOnly:  true,
+			})
+			if !hasEnv(c.Env, "SSL_CERT_DIR") {
+				c.Env = append(c.Env, corev1.EnvVar{Name: "SSL_CERT_DIR", Value: mountPath})
+			}
+		}
+	})
+}
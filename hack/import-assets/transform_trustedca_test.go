@@ -0,0 +1,75 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestMountTrustedCA(t *testing.T) {
+	const components = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: capa-controller-manager
+spec:
+  template:
+    spec:
+      containers:
+      - name: manager
+      - name: sidecar
+        env:
+        - name: SSL_CERT_DIR
+          value: /etc/ssl/certs
+`
+	tests := []struct {
+		name         string
+		config       trustedCAConfig
+		wantMount    string
+		wantCertDirs []string
+	}{
+		{
+			name: "off",
+		},
+		{
+			name:         "mounted",
+			config:       trustedCAConfig{ConfigMap: "capi-trusted-ca", MountPath: "/etc/pki/ca-trust/extracted/pem/"},
+			wantMount:    "/etc/pki/ca-trust/extracted/pem",
+			wantCertDirs: []string{"/etc/pki/ca-trust/extracted/pem", "/etc/ssl/certs"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objs, err := mountTrustedCA(testObjects(t, components), tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			podSpec := testDeployment(t, objs[0]).Spec.Template.Spec
+			if tt.config.ConfigMap == "" {
+				if len(podSpec.Volumes) > 0 || len(podSpec.Containers[0].VolumeMounts) > 0 {
+					t.Errorf("trusted CA mounted, %+v", podSpec)
+				}
+				return
+			}
+
+			if len(podSpec.Volumes) != 1 {
+				t.Fatalf("volumes %+v, want the trusted CA", podSpec.Volumes)
+			}
+			cm := podSpec.Volumes[0].ConfigMap
+			if cm == nil || cm.Name != tt.config.ConfigMap || cm.Optional == nil || !*cm.Optional ||
+				!reflect.DeepEqual(cm.Items, []corev1.KeyToPath{{Key: trustedCABundleKey, Path: trustedCABundleFile}}) {
+				t.Errorf("trusted CA volume %+v, want the optional %s bundle", podSpec.Volumes[0], tt.config.ConfigMap)
+			}
+			// the SSL_CERT_DIR of the upstream manifests is kept
+			for i, c := range podSpec.Containers {
+				want := []corev1.VolumeMount{{Name: trustedCAVolume, MountPath: tt.wantMount, ReadOnly: true}}
+				if !reflect.DeepEqual(c.VolumeMounts, want) {
+					t.Errorf("volume mounts of %s %+v, want %+v", c.Name, c.VolumeMounts, want)
+				}
+				if want := []corev1.EnvVar{{Name: "SSL_CERT_DIR", Value: tt.wantCertDirs[i]}}; !reflect.DeepEqual(c.Env, want) {
+					t.Errorf("env of %s %+v, want %+v", c.Name, c.Env, want)
+				}
+			}
+		})
+	}
+}
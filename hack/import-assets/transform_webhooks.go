@@ -0,0 +1,148 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// webhookPortFlag is the manager flag setting the port of the webhook server.
+const webhookPortFlag = "--webhook-port"
+
+// webhookPortsConfig are the ports of the webhooks of every provider, the providers disagree
+// on them upstream.
+type webhookPortsConfig struct {
+	// ServicePort is the port of the webhook Services the API server calls, 443 when unset.
+	ServicePort int32 `json:"servicePort,omitempty"`
+	// TargetPort is the non-privileged port the managers serve their webhooks on, e.g. 9443.
+	TargetPort int32 `json:"targetPort,omitempty"`
+}
+
+func (c webhookPortsConfig) servicePort() int32 {
+	if c.ServicePort == 0 {
+		return 443
+	}
+	return c.ServicePort
+}
+
+// normalizeWebhookPorts moves the webhook Services to the configured port and the webhook
+// servers behind them to the configured target port, updating the container ports, the
+// --webhook-port flags and the ports of the webhook and CRD conversion client configs. The
+// named target ports are kept, they follow the container port.
+func normalizeWebhookPorts(objs []unstructured.Unstructured, config webhookPortsConfig) ([]unstructured.Unstructured, error) {
+	if config.TargetPort == 0 {
+		return objs, nil
+	}
+	webhookPorts, err := webhookServicePorts(objs)
+	if err != nil {
+		return nil, err
+	}
+
+	// the webhook servers to move, the container ports targeted by the webhook Services
+	type webhookServer struct {
+		service *corev1.Service
+		target  interface{}
+	}
+	servers := []webhookServer{}
+	for i := range objs {
+		obj := &objs[i]
+		ports, ok := webhookPorts[obj.GetName()]
+		if obj.GetKind() != "Service" || !ok {
+			continue
+		}
+		service, err := toService(*obj)
+		if err != nil {
+			return nil, err
+		}
+		unstructuredPorts, _, err := unstructured.NestedSlice(obj.Object, "spec", "ports")
+		if err != nil {
+			return nil, err
+		}
+		for j, port := range service.Spec.Ports {
+			if !containsInt32(ports, port.Port) {
+				continue
+			}
+			target := targetPort(service, port)
+			servers = append(servers, webhookServer{service: service, target: target})
+			unstructuredPort, ok := unstructuredPorts[j].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			unstructuredPort["port"] = int64(config.servicePort())
+			if _, named := target.(string); !named {
+				unstructuredPort["targetPort"] = int64(config.TargetPort)
+			}
+		}
+		if err := unstructured.SetNestedSlice(obj.Object, unstructuredPorts, "spec", "ports"); err != nil {
+			return nil, err
+		}
+	}
+
+	var moveErr error
+	objs, err = updateDeployments(objs, func(dep *appsv1.Deployment) {
+		for _, server := range servers {
+			if !selectsPods(server.service, dep.Spec.Template.Labels) {
+				continue
+			}
+			for i := range dep.Spec.Template.Spec.Containers {
+				c := &dep.Spec.Template.Spec.Containers[i]
+				for j, port := range c.Ports {
+					if port.Name != server.target && int64(port.ContainerPort) != server.target {
+						continue
+					}
+					if port.ContainerPort == config.TargetPort {
+						continue
+					}
+					if !hasArg(c.Args, webhookPortFlag) {
+						moveErr = errors.Errorf("can't move the webhook server of %s to port %d, its container %s has no %s flag", dep.Name, config.TargetPort, c.Name, webhookPortFlag)
+						return
+					}
+					c.Ports[j].ContainerPort = config.TargetPort
+					c.Args = rewriteArgs(c.Args, argsConfig{Set: map[string]string{webhookPortFlag: strconv.Itoa(int(config.TargetPort))}})
+				}
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if moveErr != nil {
+		return nil, moveErr
+	}
+
+	for i := range objs {
+		obj := &objs[i]
+		switch obj.GetKind() {
+		case "MutatingWebhookConfiguration", "ValidatingWebhookConfiguration":
+			err = updateListItems(obj, "webhooks", func(webhook map[string]interface{}) error {
+				return setServicePort(webhook, webhookPorts, config.servicePort(), "clientConfig", "service")
+			})
+		case "CustomResourceDefinition":
+			err = setServicePort(obj.Object, webhookPorts, config.servicePort(), "spec", "conversion", "webhook", "clientConfig", "service")
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return objs, nil
+}
+
+// setServicePort sets the port of the Service reference at fields, an unset port is left
+// unset when it is the default 443.
+func setServicePort(obj map[string]interface{}, webhookPorts map[string][]int32, port int32, fields ...string) error {
+	service, found, err := unstructured.NestedMap(obj, fields...)
+	if err != nil || !found {
+		return err
+	}
+	name, _ := service["name"].(string)
+	if _, ok := webhookPorts[name]; !ok {
+		return nil
+	}
+	if _, ok := service["port"]; !ok && port == 443 {
+		return nil
+	}
+	return unstructured.SetNestedField(obj, int64(port), append(fields, "port")...)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeWebhookPorts(t *testing.T) {
+	components := func(targetPort, args string) string {
+		return `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: capa-controller-manager
+spec:
+  template:
+    metadata:
+      labels:
+        control-plane: capa-controller-manager
+    spec:
+      containers:
+      - name: manager
+        args: ` + args + `
+        ports:
+        - containerPort: 9443
+          name: webhook-server
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: capa-webhook-service
+spec:
+  selector:
+    control-plane: capa-controller-manager
+  ports:
+  - port: 9443
+    targetPort: ` + targetPort + `
+---
+apiVersion: admissionregistration.k8s.io/v1
+kind: ValidatingWebhookConfiguration
+metadata:
+  name: capa-validating-webhook-configuration
+webhooks:
+- name: validation.awscluster.infrastructure.cluster.x-k8s.io
+  clientConfig:
+    service:
+      name: capa-webhook-service
+      port: 9443
+---
+apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: awsclusters.infrastructure.cluster.x-k8s.io
+spec:
+  conversion:
+    strategy: Webhook
+    webhook:
+      clientConfig:
+        service:
+          name: capa-webhook-service
+          port: 9443
+`
+	}
+
+	tests := []struct {
+		name            string
+		targetPort      string
+		args            string
+		config          webhookPortsConfig
+		wantServicePort int32
+		wantTargetPort  interface{}
+		wantPort        int32
+		wantArgs        []string
+		wantErr         bool
+	}{
+		{
+			name:            "off",
+			targetPort:      "webhook-server",
+			args:            `["--webhook-port=9443"]`,
+			wantServicePort: 9443,
+			wantTargetPort:  "webhook-server",
+			wantPort:        9443,
+			wantArgs:        []string{"--webhook-port=9443"},
+		},
+		{
+			name:            "named target port",
+			targetPort:      "webhook-server",
+			args:            `["--webhook-port=9443"]`,
+			config:          webhookPortsConfig{TargetPort: 10443},
+			wantServicePort: 443,
+			wantTargetPort:  "webhook-server",
+			wantPort:        10443,
+			wantArgs:        []string{"--webhook-port=10443"},
+		},
+		{
+			name:            "numbered target port",
+			targetPort:      "9443",
+			args:            `["--webhook-port=9443"]`,
+			config:          webhookPortsConfig{TargetPort: 10443},
+			wantServicePort: 443,
+			wantTargetPort:  int64(10443),
+			wantPort:        10443,
+			wantArgs:        []string{"--webhook-port=10443"},
+		},
+		{
+			name:            "service port",
+			targetPort:      "webhook-server",
+			args:            `["--webhook-port=9443"]`,
+			config:          webhookPortsConfig{ServicePort: 8443, TargetPort: 9443},
+			wantServicePort: 8443,
+			wantTargetPort:  "webhook-server",
+			wantPort:        9443,
+			wantArgs:        []string{"--webhook-port=9443"},
+		},
+		{
+			name:       "without --webhook-port",
+			targetPort: "webhook-server",
+			args:       `["--v=2"]`,
+			config:     webhookPortsConfig{TargetPort: 10443},
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objs, err := normalizeWebhookPorts(testObjects(t, components(tt.targetPort, tt.args)), tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("normalizeWebhookPorts() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			manager := testDeployment(t, objs[0]).Spec.Template.Spec.Containers[0]
+			if manager.Ports[0].ContainerPort != tt.wantPort {
+				t.Errorf("container port %d, want %d", manager.Ports[0].ContainerPort, tt.wantPort)
+			}
+			if !reflect.DeepEqual(manager.Args, tt.wantArgs) {
+				t.Errorf("args %v, want %v", manager.Args, tt.wantArgs)
+			}
+			service, err := toService(objs[1])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if port := service.Spec.Ports[0]; port.Port != tt.wantServicePort || targetPort(service, port) != tt.wantTargetPort {
+				t.Errorf("Service port %+v, want port %d and target port %v", port, tt.wantServicePort, tt.wantTargetPort)
+			}
+			// the ports of the webhook and of the conversion webhook
+			webhookPorts, err := webhookServicePorts(objs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := map[string][]int32{"capa-webhook-service": {tt.wantServicePort}}; !reflect.DeepEqual(webhookPorts, want) {
+				t.Errorf("webhook ports %v, want %v", webhookPorts, want)
+			}
+		})
+	}
+}
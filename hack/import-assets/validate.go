@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	crdvalidation "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/validation"
+	apiservervalidation "k8s.io/apiextensions-apiserver/pkg/apiserver/validation"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/kube-openapi/pkg/validation/validate"
+)
+
+// validateObjects checks the transformed components before they are written, so a broken
+// upstream change or transform fails the import instead of the apply in the cluster:
+//   - the CRDs are validated as the API server does on create,
+//   - the custom resources of those CRDs against the schema of their version,
+//   - the built-in objects against their Go types, reporting the fields they don't have.
+func validateObjects(objs []unstructured.Unstructured) error {
+	errs := []string{}
+	validators := map[schema.GroupVersionKind]*validate.SchemaValidator{}
+	for _, obj := range objs {
+		if !isCRD(obj) {
+			continue
+		}
+		crd, crdErrs, err := validateCRD(obj)
+		if err != nil {
+			return err
+		}
+		errs = append(errs, objectErrors(obj, crdErrs)...)
+		for _, v := range crd.Spec.Versions {
+			if v.Schema == nil || v.Schema.OpenAPIV3Schema == nil {
+				continue
+			}
+			validation := &apiextensions.CustomResourceValidation{}
+			if err := apiextensionsv1.Convert_v1_CustomResourceValidation_To_apiextensions_CustomResourceValidation(v.Schema, validation, nil); err != nil {
+				return err
+			}
+			validator, _, err := apiservervalidation.NewSchemaValidator(validation)
+			if err != nil {
+				return fmt.Errorf("invalid schema of %s %s: %v", crd.Name, v.Name, err)
+			}
+			validators[schema.GroupVersionKind{Group: crd.Spec.Group, Version: v.Name, Kind: crd.Spec.Names.Kind}] = validator
+		}
+	}
+
+	for _, obj := range objs {
+		if isCRD(obj) {
+			continue
+		}
+		if validator, ok := validators[obj.GroupVersionKind()]; ok {
+			errs = append(errs, objectErrors(obj, apiservervalidation.ValidateCustomResource(nil, obj.Object, validator))...)
+			continue
+		}
+		if !scheme.Recognizes(obj.GroupVersionKind()) {
+			continue
+		}
+		fieldErrs, err := unknownFields(obj)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s %s: %v", obj.GetKind(), obj.GetName(), err))
+			continue
+		}
+		errs = append(errs, objectErrors(obj, fieldErrs)...)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid components:\n  %s", strings.Join(errs, "\n  "))
+	}
+	return nil
+}
+
+func validateCRD(obj unstructured.Unstructured) (*apiextensionsv1.CustomResourceDefinition, field.ErrorList, error) {
+	crd := &apiextensionsv1.CustomResourceDefinition{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, crd); err != nil {
+		return nil, nil, fmt.Errorf("failed to convert CRD %s: %v", obj.GetName(), err)
+	}
+	scheme.Default(crd)
+
+	internal := &apiextensions.CustomResourceDefinition{}
+	if err := scheme.Convert(crd, internal, nil); err != nil {
+		return nil, nil, fmt.Errorf("failed to convert CRD %s: %v", obj.GetName(), err)
+	}
+	// the stored versions are set by the API server
+	for _, v := range internal.Spec.Versions {
+		if v.Storage {
+			internal.Status.StoredVersions = append(internal.Status.StoredVersions, v.Name)
+		}
+	}
+	return crd, crdvalidation.ValidateCustomResourceDefinition(internal, apiextensionsv1.SchemeGroupVersion), nil
+}
+
+// unknownFields converts the object to its Go type and back, the fields that are lost on
+// the way are not part of the API and would be dropped (or rejected) by the API server.
+func unknownFields(obj unstructured.Unstructured) (field.ErrorList, error) {
+	typed, err := scheme.New(obj.GroupVersionKind())
+	if err != nil {
+		return nil, err
+	}
+	// the clusterctl variables, e.g. the base64 credentials of the Secrets, are only valid once substituted
+	content := dropVariables(obj.DeepCopy().Object).(map[string]interface{})
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(content, typed); err != nil {
+		return nil, err
+	}
+	roundTripped, err := runtime.DefaultUnstructuredConverter.ToUnstructured(typed)
+	if err != nil {
+		return nil, err
+	}
+	return diffFields(nil, content, roundTripped), nil
+}
+
+var variableValue = regexp.MustCompile(`^\$\{[^}]+\}$`)
+
+// dropVariables removes the fields whose whole value is a clusterctl variable.
+func dropVariables(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, value := range v {
+			if s, ok := value.(string); ok && variableValue.MatchString(s) {
+				delete(v, k)
+				continue
+			}
+			v[k] = dropVariables(value)
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = dropVariables(v[i])
+		}
+	}
+	return v
+}
+
+func diffFields(path *field.Path, orig, roundTripped interface{}) field.ErrorList {
+	errs := field.ErrorList{}
+	switch o := orig.(type) {
+	case map[string]interface{}:
+		rt, _ := roundTripped.(map[string]interface{})
+		keys := make([]string, 0, len(o))
+		for k := range o {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			rtValue, ok := rt[k]
+			if !ok {
+				// empty values are dropped by omitempty
+				if !isEmptyValue(o[k]) {
+					errs = append(errs, field.Forbidden(path.Child(k), "unknown field"))
+				}
+				continue
+			}
+			errs = append(errs, diffFields(path.Child(k), o[k], rtValue)...)
+		}
+	case []interface{}:
+		rt, _ := roundTripped.([]interface{})
+		for i := range o {
+			if i < len(rt) {
+				errs = append(errs, diffFields(path.Index(i), o[i], rt[i])...)
+			}
+		}
+	}
+	return errs
+}
+
+func isEmptyValue(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return true
+	case bool:
+		return !v
+	case int64:
+		return v == 0
+	case float64:
+		return v == 0
+	case string:
+		return v == ""
+	case map[string]interface{}:
+		return len(v) == 0
+	case []interface{}:
+		return len(v) == 0
+	}
+	return false
+}
+
+func objectErrors(obj unstructured.Unstructured, errs field.ErrorList) []string {
+	msgs := []string{}
+	for _, err := range errs {
+		msgs = append(msgs, fmt.Sprintf("%s %s: %v", obj.GetKind(), obj.GetName(), err))
+	}
+	return msgs
+}
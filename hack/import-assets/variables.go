@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/cluster-api/cmd/clusterctl/client/yamlprocessor"
+	"sigs.k8s.io/yaml"
+)
+
+// providerVariables are the values of the clusterctl variables baked into the components of
+// the providers, keyed by "<type>-<name>". The components of the other providers keep their
+// ${VAR} placeholders, processed by the upstream operator.
+type providerVariables map[string]map[string]string
+
+func loadProviderVariables(fileName string) (providerVariables, error) {
+	variables := providerVariables{}
+	b, err := ioutil.ReadFile(filepath.Clean(fileName))
+	if os.IsNotExist(err) {
+		return variables, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.UnmarshalStrict(b, &variables); err != nil {
+		return nil, errors.Wrapf(err, "invalid %s", fileName)
+	}
+	return variables, nil
+}
+
+// processVariables replaces the clusterctl variables of the components of a provider listed
+// in the --variables file. Every variable must have a value there or a default in the
+// components, e.g. ${EXP_MACHINE_POOL:=false}, the unresolved ones fail the import.
+func (p *provider) processVariables(components []byte) ([]byte, error) {
+	variables, err := loadProviderVariables(*variablesFile)
+	if err != nil {
+		return nil, err
+	}
+	values, ok := variables[p.providerTypeName()+"-"+p.assetName()]
+	if !ok {
+		return components, nil
+	}
+
+	processor := yamlprocessor.NewSimpleProcessor()
+	defaults, err := processor.GetVariableMap(components)
+	if err != nil {
+		return nil, err
+	}
+	used := []string{}
+	unresolved := []string{}
+	for name, defaultValue := range defaults {
+		used = append(used, name)
+		if _, ok := values[name]; !ok && defaultValue == nil {
+			unresolved = append(unresolved, name)
+		}
+	}
+	sort.Strings(used)
+	sort.Strings(unresolved)
+	if len(unresolved) > 0 {
+		return nil, errors.Errorf("unresolved variables %v, set them in %s", unresolved, *variablesFile)
+	}
+	unused := []string{}
+	for name := range values {
+		if _, ok := defaults[name]; !ok {
+			unused = append(unused, name)
+		}
+	}
+	sort.Strings(unused)
+	if len(unused) > 0 {
+		klog.Warningf("%s %s doesn't use the variables %v of %s", p.ptype, p.assetName(), unused, *variablesFile)
+	}
+
+	processed, err := processor.Process(components, func(name string) (string, error) {
+		if value, ok := values[name]; ok {
+			return value, nil
+		}
+		return "", errors.Errorf("no value for %s", name)
+	})
+	if err != nil {
+		return nil, err
+	}
+	klog.V(2).Infof("processed the variables %v of %s", used, p.assetName())
+	return processed, nil
+}
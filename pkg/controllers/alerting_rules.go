@@ -0,0 +1,55 @@
+package controllers
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/klog"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/openshift/cluster-capi-operator/assets"
+)
+
+// alertingRules returns the PrometheusRules of the operator, in the managed namespace, that
+// cluster monitoring evaluates.
+func (r *ClusterOperatorReconciler) alertingRules() ([]client.Object, error) {
+	objs, err := assets.UnstructuredFromDir("monitoring")
+	if err != nil {
+		return nil, err
+	}
+	for _, obj := range objs {
+		obj.SetNamespace(r.ManagedNamespace)
+	}
+	return objs, nil
+}
+
+// reconcileAlertingRules applies the alerting rules of the operator, so that they follow the
+// operator across upgrades. They are skipped while the monitoring stack, which serves the
+// PrometheusRule CRD, isn't installed.
+func (r *ClusterOperatorReconciler) reconcileAlertingRules(ctx context.Context) error {
+	objs, err := r.alertingRules()
+	if err != nil {
+		return err
+	}
+	updater := NewUpdater(objs)
+	err = updater.Apply(ctx, r.Client, r.Recorder)
+	r.drift.record(updater.Drifted())
+	if meta.IsNoMatchError(err) {
+		klog.Infof("skipping the alerting rules, the monitoring stack is not installed")
+		return nil
+	}
+	return err
+}
+
+// removeAlertingRules deletes the alerting rules of the operator, the operands they alert on
+// are torn down.
+func (r *ClusterOperatorReconciler) removeAlertingRules(ctx context.Context) error {
+	objs, err := r.alertingRules()
+	if err != nil {
+		return err
+	}
+	if _, err := NewUpdater(objs).Delete(ctx, r.Client, r.Recorder); err != nil && !meta.IsNoMatchError(err) {
+		return err
+	}
+	return nil
+}
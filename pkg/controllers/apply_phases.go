@@ -0,0 +1,222 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/openshift/cluster-capi-operator/pkg/util"
+)
+
+const (
+	ReasonCRDsNotEstablished = "CRDsNotEstablished"
+	ReasonWebhooksNotReady   = "WebhooksNotReady"
+
+	applyPollInterval = time.Second
+)
+
+// applyPhase is a group of kinds applied together, after the previous phases are ready.
+type applyPhase struct {
+	name string
+	// kinds of the phase, the last phase without kinds gets the kinds of no other phase
+	kinds []string
+	// ready, when set, is polled until it returns true, up to timeout, once the objects of
+	// the phase are applied. It is given the objects of the phase and all the objects applied.
+	ready   func(ctx context.Context, c client.Client, objs, all []*unstructured.Unstructured) (bool, error)
+	timeout time.Duration
+	// reason is the reason of the Degraded condition when the phase isn't ready in time
+	reason string
+}
+
+// applyPhases order the apply so that the controllers don't start before their CRDs exist and
+// the custom resources aren't sent to webhooks not serving yet: the CRDs first, established,
+// then the webhook configurations and Services with the configuration of the Deployments, then
+// the Deployments, once their webhooks are ready, then the rest, e.g. the provider CRs.
+var applyPhases = []applyPhase{
+	{
+		name:    "crds",
+		kinds:   []string{"Namespace", "CustomResourceDefinition"},
+		ready:   crdsEstablished,
+		timeout: time.Minute,
+		reason:  ReasonCRDsNotEstablished,
+	},
+	{
+		name: "webhooks",
+		kinds: []string{
+			"MutatingWebhookConfiguration", "ValidatingWebhookConfiguration", "Service",
+			"ServiceAccount", "ClusterRole", "Role", "ClusterRoleBinding", "RoleBinding", "ConfigMap", "Secret",
+		},
+	},
+	{
+		name:    "deployments",
+		kinds:   []string{"Deployment"},
+		ready:   webhooksReady,
+		timeout: 3 * time.Minute,
+		reason:  ReasonWebhooksNotReady,
+	},
+	{
+		name: "resources",
+	},
+}
+
+// objects returns the objects of the phase, in order.
+func (p applyPhase) objects(all []*unstructured.Unstructured) []*unstructured.Unstructured {
+	objs := []*unstructured.Unstructured{}
+	for _, obj := range all {
+		if applyPhaseOf(obj.GetKind()).name == p.name {
+			objs = append(objs, obj)
+		}
+	}
+	return objs
+}
+
+func applyPhaseOf(kind string) applyPhase {
+	for _, p := range applyPhases {
+		if util.ContainsString(p.kinds, kind) {
+			return p
+		}
+	}
+	return applyPhases[len(applyPhases)-1]
+}
+
+// waitReady waits for the objects of the phase to be ready, an applyPhaseError when they
+// aren't in time.
+func (p applyPhase) waitReady(ctx context.Context, c client.Client, objs, all []*unstructured.Unstructured) error {
+	if p.ready == nil || len(objs) == 0 {
+		return nil
+	}
+	var lastErr error
+	err := wait.PollImmediate(applyPollInterval, p.timeout, func() (bool, error) {
+		ready, err := p.ready(ctx, c, objs, all)
+		if err != nil {
+			// the objects were just applied, retry until the timeout
+			lastErr = err
+			return false, nil
+		}
+		return ready, nil
+	})
+	if errors.Is(err, wait.ErrWaitTimeout) {
+		if lastErr == nil {
+			lastErr = err
+		}
+		return &applyPhaseError{phase: p.name, reason: p.reason, err: lastErr}
+	}
+	return err
+}
+
+// applyPhaseError is returned when the objects of a phase aren't ready in time, its reason is
+// surfaced on the Degraded condition.
+type applyPhaseError struct {
+	phase  string
+	reason string
+	err    error
+}
+
+func (e *applyPhaseError) Error() string {
+	return fmt.Sprintf("the %s applied are not ready: %v", e.phase, e.err)
+}
+
+func (e *applyPhaseError) Unwrap() error {
+	return e.err
+}
+
+// crdsEstablished returns whether the CRDs are all Established.
+func crdsEstablished(ctx context.Context, c client.Client, objs, _ []*unstructured.Unstructured) (bool, error) {
+	for _, obj := range objs {
+		if obj.GetKind() != "CustomResourceDefinition" {
+			continue
+		}
+		crd := &apiextensionsv1.CustomResourceDefinition{}
+		if err := c.Get(ctx, client.ObjectKey{Name: obj.GetName()}, crd); err != nil {
+			return false, err
+		}
+		if !isCRDEstablished(crd) {
+			klog.V(2).Infof("waiting for CRD %s to be established", crd.Name)
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func isCRDEstablished(crd *apiextensionsv1.CustomResourceDefinition) bool {
+	for _, cond := range crd.Status.Conditions {
+		if cond.Type == apiextensionsv1.Established {
+			return cond.Status == apiextensionsv1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// webhooksReady returns whether the Services of the webhooks applied, and of the conversion
+// webhooks of the CRDs applied, all have a ready endpoint.
+func webhooksReady(ctx context.Context, c client.Client, _, all []*unstructured.Unstructured) (bool, error) {
+	for _, key := range webhookServices(all) {
+		endpoints := &corev1.Endpoints{}
+		if err := c.Get(ctx, key, endpoints); apierrors.IsNotFound(err) {
+			klog.V(2).Infof("waiting for the endpoints of webhook service %s", key)
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+		ready := false
+		for _, subset := range endpoints.Subsets {
+			if len(subset.Addresses) > 0 {
+				ready = true
+			}
+		}
+		if !ready {
+			klog.V(2).Infof("waiting for the endpoints of webhook service %s", key)
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// webhookServices returns the Services called by the webhook configurations and the
+// conversion webhooks of the CRDs.
+func webhookServices(objs []*unstructured.Unstructured) []client.ObjectKey {
+	keys := []client.ObjectKey{}
+	add := func(service map[string]interface{}) {
+		name, _ := service["name"].(string)
+		namespace, _ := service["namespace"].(string)
+		key := client.ObjectKey{Namespace: namespace, Name: name}
+		if name == "" {
+			return
+		}
+		for _, k := range keys {
+			if k == key {
+				return
+			}
+		}
+		keys = append(keys, key)
+	}
+	for _, obj := range objs {
+		switch obj.GetKind() {
+		case "MutatingWebhookConfiguration", "ValidatingWebhookConfiguration":
+			webhooks, _, _ := unstructured.NestedSlice(obj.Object, "webhooks")
+			for _, w := range webhooks {
+				webhook, ok := w.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				if service, found, _ := unstructured.NestedMap(webhook, "clientConfig", "service"); found {
+					add(service)
+				}
+			}
+		case "CustomResourceDefinition":
+			if service, found, _ := unstructured.NestedMap(obj.Object, "spec", "conversion", "webhook", "clientConfig", "service"); found {
+				add(service)
+			}
+		}
+	}
+	return keys
+}
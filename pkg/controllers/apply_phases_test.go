@@ -0,0 +1,70 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestApplyPhases(t *testing.T) {
+	obj := func(kind, name string) *unstructured.Unstructured {
+		u := &unstructured.Unstructured{}
+		u.SetKind(kind)
+		u.SetName(name)
+		return u
+	}
+	all := []*unstructured.Unstructured{
+		obj("InfrastructureProvider", "aws"),
+		obj("Deployment", "capi-operator-controller-manager"),
+		obj("ConfigMap", "aws-v0.7.0"),
+		obj("CustomResourceDefinition", "coreproviders.operator.cluster.x-k8s.io"),
+		obj("Service", "capi-operator-webhook-service"),
+		obj("Namespace", "openshift-cluster-api"),
+	}
+	want := map[string][]string{
+		"crds":        {"coreproviders.operator.cluster.x-k8s.io", "openshift-cluster-api"},
+		"webhooks":    {"aws-v0.7.0", "capi-operator-webhook-service"},
+		"deployments": {"capi-operator-controller-manager"},
+		"resources":   {"aws"},
+	}
+	got := map[string][]string{}
+	for _, phase := range applyPhases {
+		for _, o := range phase.objects(all) {
+			got[phase.name] = append(got[phase.name], o.GetName())
+		}
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestWebhookServices(t *testing.T) {
+	webhooks := &unstructured.Unstructured{Object: map[string]interface{}{
+		"kind": "ValidatingWebhookConfiguration",
+		"webhooks": []interface{}{
+			map[string]interface{}{"clientConfig": map[string]interface{}{
+				"service": map[string]interface{}{"name": "capi-webhook-service", "namespace": "openshift-cluster-api"},
+			}},
+			map[string]interface{}{"clientConfig": map[string]interface{}{
+				"service": map[string]interface{}{"name": "capi-webhook-service", "namespace": "openshift-cluster-api"},
+			}},
+		},
+	}}
+	crd := &unstructured.Unstructured{Object: map[string]interface{}{
+		"kind": "CustomResourceDefinition",
+		"spec": map[string]interface{}{"conversion": map[string]interface{}{"webhook": map[string]interface{}{
+			"clientConfig": map[string]interface{}{
+				"service": map[string]interface{}{"name": "capa-webhook-service", "namespace": "openshift-cluster-api"},
+			},
+		}}},
+	}}
+	want := []client.ObjectKey{
+		{Namespace: "openshift-cluster-api", Name: "capi-webhook-service"},
+		{Namespace: "openshift-cluster-api", Name: "capa-webhook-service"},
+	}
+	if diff := cmp.Diff(want, webhookServices([]*unstructured.Unstructured{webhooks, crd})); diff != "" {
+		t.Error(diff)
+	}
+}
@@ -0,0 +1,278 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	configv1 "github.com/openshift/api/config/v1"
+	capiv1alpha1 "github.com/openshift/cluster-capi-operator/api/v1alpha1"
+)
+
+const (
+	// clusterManagedBy is the value of the managed-by annotation of the InfraClusters, the
+	// providers leave the externally managed InfraClusters alone.
+	clusterManagedBy = "cluster-capi-operator"
+
+	// clusterRequeueAfter is how long to wait for the CRDs of the providers to be installed.
+	clusterRequeueAfter = time.Minute
+
+	// the cloud provider config of Azure, the only source of the location of the cluster
+	cloudProviderConfigNamespace = "openshift-config"
+	cloudProviderConfigName      = "cloud-provider-config"
+	cloudProviderConfigKey       = "config"
+)
+
+// infraClusterKinds are the InfraClusters of the platforms supported, at the API versions of
+// the imported providers.
+var infraClusterKinds = map[configv1.PlatformType]struct{ apiVersion, kind string }{
+	configv1.AWSPlatformType:       {"infrastructure.cluster.x-k8s.io/v1alpha4", "AWSCluster"},
+	configv1.AzurePlatformType:     {"infrastructure.cluster.x-k8s.io/v1alpha4", "AzureCluster"},
+	configv1.GCPPlatformType:       {"infrastructure.cluster.x-k8s.io/v1alpha4", "GCPCluster"},
+	configv1.BareMetalPlatformType: {"infrastructure.cluster.x-k8s.io/v1alpha5", "Metal3Cluster"},
+	configv1.OpenStackPlatformType: {"infrastructure.cluster.x-k8s.io/v1alpha4", "OpenStackCluster"},
+}
+
+// ClusterReconciler creates the CAPI Cluster and the InfraCluster of the OpenShift cluster
+// itself, so that Machines can be created without writing them by hand. The InfraCluster is
+// externally managed, the providers don't create any infrastructure for it.
+type ClusterReconciler struct {
+	client.Client
+	// APIReader reads the cloud provider config, outside of the managed namespace the cache
+	// is restricted to.
+	APIReader        client.Reader
+	Scheme           *runtime.Scheme
+	Recorder         record.EventRecorder
+	ManagedNamespace string
+}
+
+// SetupWithManager sets up the controller with the Manager. The Cluster CRDs aren't watched,
+// they are only installed with cluster api.
+func (r *ClusterReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("cluster").
+		For(&configv1.Infrastructure{}, builder.WithPredicates(infrastructurePredicates())).
+		Watches(
+			&source.Kind{Type: &configv1.FeatureGate{}},
+			handler.EnqueueRequestsFromMapFunc(toInfrastructure),
+			builder.WithPredicates(featureGatePredicates()),
+		).
+		// the configuration may uninstall cluster api
+		Watches(
+			&source.Kind{Type: &capiv1alpha1.ClusterAPIConfiguration{}},
+			handler.EnqueueRequestsFromMapFunc(toInfrastructure),
+			builder.WithPredicates(operatorConfigPredicates()),
+		).
+		Complete(r)
+}
+
+func toInfrastructure(client.Object) []reconcile.Request {
+	return []reconcile.Request{{
+		NamespacedName: client.ObjectKey{Name: infrastructureResourceName},
+	}}
+}
+
+// Reconcile creates or updates the Cluster and InfraCluster from the Infrastructure.
+func (r *ClusterReconciler) Reconcile(ctx context.Context, _ ctrl.Request) (ctrl.Result, error) {
+	if capiEnabled, err := isCAPIEnabled(ctx, r.Client); err != nil || !capiEnabled {
+		return ctrl.Result{}, err
+	}
+
+	infra := &configv1.Infrastructure{}
+	if err := r.Get(ctx, client.ObjectKey{Name: infrastructureResourceName}, infra); err != nil {
+		return ctrl.Result{}, err
+	}
+	platform := infraPlatform(infra)
+	if _, ok := infraClusterKinds[platform]; !ok {
+		klog.V(2).Infof("no InfraCluster for platform %q", platform)
+		return ctrl.Result{}, nil
+	}
+
+	location := ""
+	if platform == configv1.AzurePlatformType {
+		var err error
+		if location, err = r.azureLocation(ctx); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	infraCluster, err := newInfraCluster(infra, r.ManagedNamespace, location)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	err = r.createOrUpdateInfraCluster(ctx, infraCluster)
+	if err == nil {
+		err = r.createOrUpdateCluster(ctx, infraCluster)
+	}
+	if meta.IsNoMatchError(err) {
+		klog.Infof("waiting for the cluster api CRDs: %v", err)
+		return ctrl.Result{RequeueAfter: clusterRequeueAfter}, nil
+	}
+	return ctrl.Result{}, err
+}
+
+// createOrUpdateInfraCluster applies the fields of the InfraCluster the controller owns and
+// marks it ready, the provider doesn't for an externally managed one.
+func (r *ClusterReconciler) createOrUpdateInfraCluster(ctx context.Context, required *unstructured.Unstructured) error {
+	existing := &unstructured.Unstructured{}
+	existing.SetGroupVersionKind(required.GroupVersionKind())
+	existing.SetName(required.GetName())
+	existing.SetNamespace(required.GetNamespace())
+	opRes, err := controllerutil.CreateOrUpdate(ctx, r.Client, existing, func() error {
+		annotations := existing.GetAnnotations()
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[clusterv1.ManagedByAnnotation] = clusterManagedBy
+		existing.SetAnnotations(annotations)
+
+		spec, _, _ := unstructured.NestedMap(required.Object, "spec")
+		for field, value := range spec {
+			if err := unstructured.SetNestedField(existing.Object, value, "spec", field); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	r.Recorder.Eventf(existing, corev1.EventTypeNormal, string(opRes), "success")
+
+	if ready, _, _ := unstructured.NestedBool(existing.Object, "status", "ready"); ready {
+		return nil
+	}
+	if err := unstructured.SetNestedField(existing.Object, true, "status", "ready"); err != nil {
+		return err
+	}
+	return r.Status().Update(ctx, existing)
+}
+
+func (r *ClusterReconciler) createOrUpdateCluster(ctx context.Context, infraCluster *unstructured.Unstructured) error {
+	cluster := &clusterv1.Cluster{
+		ObjectMeta: ctrl.ObjectMeta{Name: infraCluster.GetName(), Namespace: infraCluster.GetNamespace()},
+	}
+	endpoint, _, _ := unstructured.NestedMap(infraCluster.Object, "spec", "controlPlaneEndpoint")
+	opRes, err := controllerutil.CreateOrUpdate(ctx, r.Client, cluster, func() error {
+		cluster.Spec.InfrastructureRef = &corev1.ObjectReference{
+			APIVersion: infraCluster.GetAPIVersion(),
+			Kind:       infraCluster.GetKind(),
+			Name:       infraCluster.GetName(),
+			Namespace:  infraCluster.GetNamespace(),
+		}
+		cluster.Spec.ControlPlaneEndpoint.Host, _ = endpoint["host"].(string)
+		port, _ := endpoint["port"].(int64)
+		cluster.Spec.ControlPlaneEndpoint.Port = int32(port)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	r.Recorder.Eventf(cluster, corev1.EventTypeNormal, string(opRes), "success")
+	return nil
+}
+
+// azureLocation returns the location of the cluster from the Azure cloud provider config,
+// the Infrastructure doesn't have it.
+func (r *ClusterReconciler) azureLocation(ctx context.Context) (string, error) {
+	cm := &corev1.ConfigMap{}
+	if err := r.APIReader.Get(ctx, client.ObjectKey{Namespace: cloudProviderConfigNamespace, Name: cloudProviderConfigName}, cm); err != nil {
+		return "", fmt.Errorf("failed to get the Azure cloud provider config: %v", err)
+	}
+	config := struct {
+		Location string `json:"location"`
+	}{}
+	if err := json.Unmarshal([]byte(cm.Data[cloudProviderConfigKey]), &config); err != nil {
+		return "", fmt.Errorf("invalid Azure cloud provider config: %v", err)
+	}
+	if config.Location == "" {
+		return "", fmt.Errorf("no location in the Azure cloud provider config")
+	}
+	return config.Location, nil
+}
+
+// newInfraCluster returns the InfraCluster of the platform of the cluster, named after its
+// infrastructure name, with the control plane endpoint of the internal API server and the
+// region or location of the cluster.
+func newInfraCluster(infra *configv1.Infrastructure, namespace, azureLocation string) (*unstructured.Unstructured, error) {
+	platform := infraPlatform(infra)
+	kind, ok := infraClusterKinds[platform]
+	if !ok {
+		return nil, fmt.Errorf("no InfraCluster for platform %q", platform)
+	}
+	if infra.Status.InfrastructureName == "" {
+		return nil, fmt.Errorf("the Infrastructure has no infrastructure name")
+	}
+	host, port, err := apiServerEndpoint(infra.Status.APIServerInternalURL)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := map[string]interface{}{
+		"controlPlaneEndpoint": map[string]interface{}{"host": host, "port": port},
+	}
+	status := infra.Status.PlatformStatus
+	switch platform {
+	case configv1.AWSPlatformType:
+		if status == nil || status.AWS == nil || status.AWS.Region == "" {
+			return nil, fmt.Errorf("the Infrastructure has no AWS region")
+		}
+		spec["region"] = status.AWS.Region
+	case configv1.AzurePlatformType:
+		spec["location"] = azureLocation
+		if status != nil && status.Azure != nil {
+			spec["resourceGroup"] = status.Azure.ResourceGroupName
+		}
+	case configv1.GCPPlatformType:
+		if status == nil || status.GCP == nil || status.GCP.Region == "" {
+			return nil, fmt.Errorf("the Infrastructure has no GCP region")
+		}
+		spec["region"] = status.GCP.Region
+		spec["project"] = status.GCP.ProjectID
+	case configv1.OpenStackPlatformType:
+		spec["cloudName"] = "openstack"
+	}
+
+	infraCluster := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+	infraCluster.SetAPIVersion(kind.apiVersion)
+	infraCluster.SetKind(kind.kind)
+	infraCluster.SetName(infra.Status.InfrastructureName)
+	infraCluster.SetNamespace(namespace)
+	return infraCluster, nil
+}
+
+// apiServerEndpoint returns the host and port of the URL of an API server.
+func apiServerEndpoint(apiServerURL string) (string, int64, error) {
+	u, err := url.Parse(apiServerURL)
+	if err != nil || u.Host == "" {
+		return "", 0, fmt.Errorf("invalid API server URL %q", apiServerURL)
+	}
+	host, port, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		return u.Host, 443, nil
+	}
+	p, err := strconv.ParseInt(port, 10, 32)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid API server URL %q", apiServerURL)
+	}
+	return host, p, nil
+}
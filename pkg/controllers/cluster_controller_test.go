@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	configv1 "github.com/openshift/api/config/v1"
+)
+
+func TestNewInfraCluster(t *testing.T) {
+	infra := func(platformStatus *configv1.PlatformStatus) *configv1.Infrastructure {
+		return &configv1.Infrastructure{Status: configv1.InfrastructureStatus{
+			InfrastructureName:   "ocp-abc12",
+			APIServerInternalURL: "https://api-int.ocp.example.com:6443",
+			PlatformStatus:       platformStatus,
+		}}
+	}
+	endpoint := map[string]interface{}{"host": "api-int.ocp.example.com", "port": int64(6443)}
+	tests := []struct {
+		name     string
+		infra    *configv1.Infrastructure
+		wantKind string
+		wantSpec map[string]interface{}
+		wantErr  bool
+	}{
+		{
+			name: "aws",
+			infra: infra(&configv1.PlatformStatus{
+				Type: configv1.AWSPlatformType,
+				AWS:  &configv1.AWSPlatformStatus{Region: "us-east-1"},
+			}),
+			wantKind: "AWSCluster",
+			wantSpec: map[string]interface{}{"controlPlaneEndpoint": endpoint, "region": "us-east-1"},
+		},
+		{
+			name: "azure",
+			infra: infra(&configv1.PlatformStatus{
+				Type:  configv1.AzurePlatformType,
+				Azure: &configv1.AzurePlatformStatus{ResourceGroupName: "ocp-abc12-rg"},
+			}),
+			wantKind: "AzureCluster",
+			wantSpec: map[string]interface{}{"controlPlaneEndpoint": endpoint, "location": "centralus", "resourceGroup": "ocp-abc12-rg"},
+		},
+		{
+			name: "gcp",
+			infra: infra(&configv1.PlatformStatus{
+				Type: configv1.GCPPlatformType,
+				GCP:  &configv1.GCPPlatformStatus{ProjectID: "openshift", Region: "us-central1"},
+			}),
+			wantKind: "GCPCluster",
+			wantSpec: map[string]interface{}{"controlPlaneEndpoint": endpoint, "project": "openshift", "region": "us-central1"},
+		},
+		{
+			name:     "baremetal",
+			infra:    infra(&configv1.PlatformStatus{Type: configv1.BareMetalPlatformType}),
+			wantKind: "Metal3Cluster",
+			wantSpec: map[string]interface{}{"controlPlaneEndpoint": endpoint},
+		},
+		{
+			name:    "aws without region",
+			infra:   infra(&configv1.PlatformStatus{Type: configv1.AWSPlatformType}),
+			wantErr: true,
+		},
+		{
+			name:    "unsupported",
+			infra:   infra(&configv1.PlatformStatus{Type: configv1.VSpherePlatformType}),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newInfraCluster(tt.infra, "openshift-cluster-api", "centralus")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newInfraCluster() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got.GetKind() != tt.wantKind || got.GetName() != "ocp-abc12" || got.GetNamespace() != "openshift-cluster-api" {
+				t.Errorf("unexpected InfraCluster %s %s/%s", got.GetKind(), got.GetNamespace(), got.GetName())
+			}
+			if diff := cmp.Diff(tt.wantSpec, got.Object["spec"]); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestAPIServerEndpoint(t *testing.T) {
+	host, port, err := apiServerEndpoint("https://api-int.ocp.example.com")
+	if err != nil || host != "api-int.ocp.example.com" || port != 443 {
+		t.Errorf("apiServerEndpoint() = %q, %d, %v", host, port, err)
+	}
+	if _, _, err := apiServerEndpoint(""); err == nil {
+		t.Error("an empty URL should be invalid")
+	}
+}
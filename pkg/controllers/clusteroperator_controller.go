@@ -2,13 +2,16 @@ package controllers
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/json"
 	"fmt"
 	"strings"
 
 	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/klog"
@@ -21,6 +24,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	configv1 "github.com/openshift/api/config/v1"
+	capiv1alpha1 "github.com/openshift/cluster-capi-operator/api/v1alpha1"
 	"github.com/openshift/cluster-capi-operator/assets"
 	"github.com/openshift/cluster-capi-operator/pkg/util"
 )
@@ -33,7 +37,18 @@ type ClusterOperatorReconciler struct {
 	ReleaseVersion   string
 	ManagedNamespace string
 	Images           map[string]string
-	PlatformType     configv1.PlatformType
+	// ProviderAssetsDir is where the provider assets are extracted from the payload images,
+	// they replace the embedded ones
+	ProviderAssetsDir string
+	// APIReader reads the nodes, which are not cached.
+	APIReader client.Reader
+
+	// drift counts the out-of-band modifications of the operands reverted
+	drift driftCorrections
+	// upgrades are the provider upgrades seen by the last reconcile
+	upgrades *providerUpgrades
+	// paused are the providers paused at the last reconcile
+	paused []string
 }
 
 // SetupWithManager sets up the controller with the Manager.
@@ -50,164 +65,418 @@ func (r *ClusterOperatorReconciler) SetupWithManager(mgr ctrl.Manager) error {
 			handler.EnqueueRequestsFromMapFunc(toClusterOperator),
 			builder.WithPredicates(featureGatePredicates()),
 		).
+		// the configuration is reconciled at runtime
+		Watches(
+			&source.Kind{Type: &capiv1alpha1.ClusterAPIConfiguration{}},
+			handler.EnqueueRequestsFromMapFunc(toClusterOperator),
+			builder.WithPredicates(operatorConfigPredicates()),
+		).
+		// the status follows the health of the deployments of the managed namespace
+		Watches(
+			&source.Kind{Type: &appsv1.Deployment{}},
+			handler.EnqueueRequestsFromMapFunc(toClusterOperator),
+			builder.WithPredicates(managedNamespacePredicates(r.ManagedNamespace)),
+		).
+		// the providers are rolled out with the trusted CA bundle changed
+		Watches(
+			&source.Kind{Type: &corev1.ConfigMap{}},
+			handler.EnqueueRequestsFromMapFunc(toClusterOperator),
+			builder.WithPredicates(managedNamespacePredicates(r.ManagedNamespace), trustedCAPredicates()),
+		).
+		// the labels and annotations of the managed namespace are restored
+		Watches(
+			&source.Kind{Type: &corev1.Namespace{}},
+			handler.EnqueueRequestsFromMapFunc(toClusterOperator),
+			builder.WithPredicates(namespacePredicates(r.ManagedNamespace)),
+		).
 		Complete(r)
 }
 
 // Reconcile will process the cluster-api clusterOperator
 func (r *ClusterOperatorReconciler) Reconcile(ctx context.Context, _ ctrl.Request) (ctrl.Result, error) {
-	// NOTE: Temporarily disable the controller until set up CRD management using CVO
-
-	// featureGate := &configv1.FeatureGate{}
-	// if err := r.Client.Get(ctx, client.ObjectKey{Name: externalFeatureGateName}, featureGate); errors.IsNotFound(err) {
-	// 	klog.Infof("FeatureGate cluster does not exist. Skipping...")
-	// 	return ctrl.Result{}, r.setStatusAvailable(ctx)
-	// } else if err != nil {
-	// 	klog.Errorf("Unable to retrive FeatureGate object: %v", err)
-	// 	return ctrl.Result{}, r.setStatusDegraded(ctx, err)
-	// }
-
-	// // Verify FeatureGate ClusterAPIEnabled is present for operator to work in TP phase
-	// capiEnabled, err := isCAPIFeatureGateEnabled(featureGate)
-	// if err != nil {
-	// 	klog.Errorf("Could not determine cluster api feature gate state: %v", err)
-	// 	return ctrl.Result{}, r.setStatusDegraded(ctx, err)
-	// }
-
-	// var result ctrl.Result
-	// if capiEnabled {
-	// 	klog.Infof("FeatureGate cluster does include cluster api. Installing...")
-	// 	result, err = r.reconcile(ctx)
-	// 	if err != nil {
-	// 		return result, r.setStatusDegraded(ctx, err)
-	// 	}
-	// }
-
-	return ctrl.Result{}, r.setStatusAvailable(ctx)
+	featureGate := &configv1.FeatureGate{}
+	if err := r.Client.Get(ctx, client.ObjectKey{Name: externalFeatureGateName}, featureGate); apierrors.IsNotFound(err) {
+		klog.Infof("FeatureGate cluster does not exist, cluster api is disabled")
+		featureGate = nil
+	} else if err != nil {
+		klog.Errorf("Unable to retrive FeatureGate object: %v", err)
+		return ctrl.Result{}, r.setStatusDegraded(ctx, err)
+	}
+
+	// Verify FeatureGate ClusterAPIEnabled is present for operator to work in TP phase
+	capiEnabled, err := isCAPIFeatureGateEnabled(featureGate)
+	if err != nil {
+		klog.Errorf("Could not determine cluster api feature gate state: %v", err)
+		return ctrl.Result{}, r.setStatusDegraded(ctx, err)
+	}
+
+	var infra *configv1.Infrastructure
+	var inactive *operandsInactive
+	if capiEnabled {
+		infra = &configv1.Infrastructure{}
+		if err := r.Client.Get(ctx, client.ObjectKey{Name: infrastructureResourceName}, infra); err != nil {
+			klog.Errorf("Unable to retrieve Infrastructure object: %v", err)
+			return ctrl.Result{}, r.setStatusDegraded(ctx, err)
+		}
+		if platform := infraPlatform(infra); platformProviderName(platform) == "" {
+			klog.Infof("Platform %q is not supported by cluster api. Skipping...", platform)
+			inactive = &operandsInactive{
+				reason:  ReasonUnsupportedPlatform,
+				message: fmt.Sprintf("Cluster API is not supported on platform %q", platform),
+			}
+		}
+	} else {
+		inactive = &operandsInactive{
+			reason:  ReasonFeatureGateDisabled,
+			message: fmt.Sprintf("Cluster API is disabled, the %s feature gate is not enabled", ClusterAPIEnabled),
+		}
+	}
+
+	config, err := r.getOperatorConfig(ctx)
+	if err != nil {
+		klog.Errorf("Unable to retrieve ClusterAPIConfiguration object: %v", err)
+		return ctrl.Result{}, r.setStatusDegraded(ctx, err)
+	}
+	if capiEnabled && config.Spec.Uninstall {
+		inactive = &operandsInactive{
+			reason:  ReasonUninstalled,
+			message: fmt.Sprintf("Cluster API is uninstalled by ClusterAPIConfiguration %s", capiv1alpha1.ClusterAPIConfigurationName),
+		}
+	}
+	if config.Spec.Paused {
+		klog.Infof("ClusterAPIConfiguration %s is paused. Skipping...", capiv1alpha1.ClusterAPIConfigurationName)
+		return ctrl.Result{}, r.setStatusFromDeployments(ctx, inactive)
+	}
+
+	var result ctrl.Result
+	switch {
+	case !capiEnabled || config.Spec.Uninstall:
+		result, err = r.teardown(ctx, config, inactive)
+	case inactive == nil:
+		klog.Infof("FeatureGate cluster does include cluster api. Installing...")
+		result, err = r.reconcile(ctx, config, infra)
+	}
+	if err != nil {
+		return result, r.setStatusDegraded(ctx, err)
+	}
+	return result, r.setStatusFromDeployments(ctx, inactive)
 }
 
-// https://github.com/kubernetes-sigs/cluster-api/blob/main/cmd/clusterctl/client/config/providers_client.go#L36-L47
-func (r *ClusterOperatorReconciler) currentProviderName() string { //nolint TODO:remove during refatoring
-	switch r.PlatformType {
-	case configv1.LibvirtPlatformType, configv1.NonePlatformType, configv1.OvirtPlatformType, configv1.EquinixMetalPlatformType:
-		return "" // no equivilent in capi
-	case configv1.BareMetalPlatformType:
-		return "metal3"
-	default:
-		return strings.ToLower(string(r.PlatformType))
+// powerVSPlatformType is the IBM Power Systems Virtual Server platform, not in the vendored
+// openshift/api yet.
+const powerVSPlatformType configv1.PlatformType = "PowerVS"
+
+// platformProviders are the infrastructure providers of the platforms supported, by
+// clusterctl name, with the flavor.
+var platformProviders = map[configv1.PlatformType]string{
+	configv1.AWSPlatformType:       "aws",
+	configv1.AzurePlatformType:     "azure",
+	configv1.GCPPlatformType:       "gcp",
+	configv1.BareMetalPlatformType: "metal3",
+	configv1.OpenStackPlatformType: "openstack",
+	configv1.VSpherePlatformType:   "vsphere",
+	configv1.IBMCloudPlatformType:  "ibmcloud",
+	powerVSPlatformType:            "ibmcloud-powervs",
+}
+
+// platformProviderName returns the name of the infrastructure provider of the platform, empty
+// when cluster api doesn't support it.
+func platformProviderName(platform configv1.PlatformType) string {
+	return platformProviders[platform]
+}
+
+// infraPlatform returns the platform of the cluster, from the deprecated status.platform on
+// the clusters installed before status.platformStatus.
+func infraPlatform(infra *configv1.Infrastructure) configv1.PlatformType {
+	if infra.Status.PlatformStatus != nil && infra.Status.PlatformStatus.Type != "" {
+		return infra.Status.PlatformStatus.Type
 	}
+	return infra.Status.Platform
 }
 
-func (r *ClusterOperatorReconciler) reconcile(ctx context.Context) (ctrl.Result, error) { //nolint TODO:remove during refatoring
+func (r *ClusterOperatorReconciler) reconcile(ctx context.Context, config *capiv1alpha1.ClusterAPIConfiguration, infra *configv1.Infrastructure) (ctrl.Result, error) {
+	if err := r.reconcileNamespace(ctx); err != nil {
+		return ctrl.Result{}, err
+	}
+
 	objs, err := assets.FromDir("capi-operator", r.Scheme)
 	if err != nil {
 		return ctrl.Result{}, err
 	}
+	workloadPartitioning, err := r.workloadPartitioningEnabled(ctx)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
 
 	updater := NewUpdater(objs).WithFilter(func(obj client.Object) bool {
-		appliedByManifest := []string{"Namespace", "ClusterRole", "Role", "ClusterRoleBinding", "RoleBinding", "ServiceAccount"}
 		// these are already applied by the manifest
 		return !util.ContainsString(appliedByManifest, obj.GetObjectKind().GroupVersionKind().Kind)
 	})
 
 	err = updater.Mutate(func(obj client.Object) (client.Object, error) {
-		dep, depOK := obj.(*appsv1.Deployment)
-		if depOK {
-			if err := r.customizeDeployment(dep); err != nil {
-				return obj, err
+		if dep, ok := obj.(*appsv1.Deployment); ok {
+			if workloadPartitioning {
+				setManagementWorkload(dep)
 			}
+			return obj, r.customizeDeployment(dep)
 		}
 		return obj, nil
 	})
 	if err != nil {
 		return ctrl.Result{}, err
 	}
-	err = updater.CreateOrUpdate(ctx, r.Client, r.Recorder)
+	err = updater.Apply(ctx, r.Client, r.Recorder)
+	r.drift.record(updater.Drifted())
 	if err != nil {
 		return ctrl.Result{}, err
 	}
+	if err := pruneSuperseded(ctx, r.Client, r.Recorder, updater.Objects(), nil, r.ManagedNamespace, capiOperatorLabel); err != nil {
+		return ctrl.Result{}, err
+	}
 
-	objs, err = assets.FromDir("providers", r.Scheme)
+	objs, err = assets.Providers(r.ProviderAssetsDir, r.Scheme)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	objs, err = unshardComponents(objs)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	r.upgrades = newProviderUpgrades()
+	topology := infra.Status.ControlPlaneTopology
+	infraProviders := []string{"infrastructure-" + platformProviderName(infraPlatform(infra))}
+	for _, name := range config.Spec.AdditionalInfrastructureProviders {
+		key := "infrastructure-" + name
+		if !util.ContainsString(providerKeys(objs, func(string) bool { return true }), key) {
+			klog.Warningf("unknown additional infrastructure provider %s", name)
+			continue
+		}
+		infraProviders = append(infraProviders, key)
+	}
+
+	// only the core provider and the infrastructure providers of the platform, and the
+	// additional ones, are installed, unless the configuration disables them
+	ofPlatform := func(key string) bool {
+		return strings.HasPrefix(key, "core-") || util.ContainsString(infraProviders, key)
+	}
+	installed := func(key string) bool {
+		return ofPlatform(key) && isProviderEnabled(config.Spec, key)
+	}
+	disabled := func(key string) bool {
+		return ofPlatform(key) && !isProviderEnabled(config.Spec, key)
+	}
+	r.paused, err = r.pausedProviders(ctx, objs, config.Spec, installed)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	trustedCAHash, err := r.ensureTrustedCA(ctx)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	rendered, err := renderedProviders(objs)
 	if err != nil {
 		return ctrl.Result{}, err
 	}
 
 	updater = NewUpdater(objs).WithFilter(func(obj client.Object) bool {
-		if obj.GetObjectKind().GroupVersionKind().Kind == "InfrastructureProvider" {
-			if !strings.HasPrefix(obj.GetName(), r.currentProviderName()) {
-				klog.Infof("skipping infra %s!=%s", obj.GetName(), r.currentProviderName())
-				return false
-			}
+		key := providerKey(obj)
+		if key == "" {
+			return true
+		}
+		if !installed(key) {
+			klog.V(2).Infof("skipping %s, not a provider of the platform or not enabled in ClusterAPIConfiguration", key)
+			return false
+		}
+		if util.ContainsString(r.paused, key) {
+			klog.Infof("skipping %s, paused", key)
+			return false
 		}
 		return true
 	})
 
 	err = updater.Mutate(func(obj client.Object) (client.Object, error) {
-		infra, ok := obj.(*operatorv1.InfrastructureProvider)
-		if ok {
-			infra.Spec.ProviderSpec.Deployment = &operatorv1.DeploymentSpec{
-				Containers: r.containerCustomizationFromProvider(infra.Kind, infra.Name),
+		if cm, ok := obj.(*corev1.ConfigMap); ok && hasProviderComponents(cm) {
+			if err := r.renderProviderComponents(ctx, cm, workloadPartitioning); err != nil {
+				providerRenderErrors.WithLabelValues(providerKey(cm)).Inc()
+				return obj, err
+			}
+		}
+		// the CRDs of the shards are applied by the operator, not through the upstream one
+		if u, ok := obj.(*unstructured.Unstructured); ok && u.GetKind() == "CustomResourceDefinition" {
+			crd, err := toCRD(u)
+			if err != nil {
+				return obj, err
+			}
+			if err := r.checkCRDUpgrade(ctx, "provider "+providerKey(obj), []*apiextensionsv1.CustomResourceDefinition{crd}); err != nil {
+				return obj, err
 			}
 		}
-		core, ok := obj.(*operatorv1.CoreProvider)
-		if ok {
-			core.Spec.ProviderSpec.Deployment = &operatorv1.DeploymentSpec{
-				Containers: r.containerCustomizationFromProvider(core.Kind, core.Name),
+		if spec := providerSpec(obj); spec != nil {
+			key := providerKey(obj)
+			selectTopologyVariant(spec, topology, objs)
+			applyTopology(spec, topology, rendered[key])
+			applyOperatorConfig(spec, key, config.Spec, rendered[key])
+			setTrustedCABundleHash(spec, trustedCAHash)
+			if err := r.orchestrateUpgrade(ctx, obj, r.upgrades); err != nil {
+				return obj, err
+			}
+			if err := r.keepDriftReverted(ctx, obj); err != nil {
+				return obj, err
 			}
 		}
-
 		return obj, nil
 	})
 	if err != nil {
 		return ctrl.Result{}, err
 	}
-	return ctrl.Result{}, updater.CreateOrUpdate(ctx, r.Client, r.Recorder)
+	err = updater.Apply(ctx, r.Client, r.Recorder)
+	r.drift.record(updater.Drifted())
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	setProviderVersions(updater.Objects())
+	setProviderUpgradesInProgress(r.upgrades.inProgress)
+	// the provider Deployments are applied by the CAPI Operator, their modifications are
+	// reverted by installing the provider again
+	drifted, err := r.revertProviderDrift(ctx, updater.Objects())
+	r.drift.record(drifted)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	// the ConfigMaps of the versions the providers may roll back to are kept as backup
+	if err := pruneSuperseded(ctx, r.Client, r.Recorder, updater.Objects(), r.upgrades.isBackup, r.ManagedNamespace, providerTypeLabel, providerNameLabel); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.reconcilePodDisruptionBudgets(ctx, providerKeys(objs, installed), rendered, topology); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.reconcileAlertingRules(ctx); err != nil {
+		return ctrl.Result{}, err
+	}
+	removing, err := r.removeDisabledProviders(ctx, objs, disabled)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	result := ctrl.Result{}
+	if len(r.upgrades.inProgress) > 0 {
+		klog.Infof("waiting for the upgrades of %s to become healthy", strings.Join(r.upgrades.inProgress, ", "))
+		result.RequeueAfter = upgradeRequeueAfter
+	}
+	if removing > 0 {
+		klog.Infof("waiting for %d disabled providers to be deleted", removing)
+		result.RequeueAfter = teardownRequeueAfter
+	}
+	status := capiv1alpha1.ClusterAPIConfigurationStatus{
+		InstalledProviders: providerKeys(objs, installed),
+		DisabledProviders:  providerKeys(objs, disabled),
+	}
+	status.Providers, err = r.providersStatus(ctx, objs, status.InstalledProviders, config.Status.Providers)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	return result, r.setOperatorConfigObserved(ctx, config, status)
 }
 
-func providerKindToTypeName(kind string) string {
-	return strings.ReplaceAll(strings.ToLower(kind), "provider", "")
+// applyTopology shapes the provider Deployments for the control plane topology: one replica
+// on single node clusters, where the single node variant of the components has the Recreate
+// strategy, and two replicas on different control plane nodes on highly available clusters,
+// rolled out with the RollingUpdate strategy of the default components. The other topologies
+// keep the shape of the components.
+func applyTopology(spec *operatorv1.ProviderSpec, topology configv1.TopologyMode, rendered renderedProvider) {
+	switch topology {
+	case configv1.SingleReplicaTopologyMode:
+		if spec.Deployment == nil {
+			spec.Deployment = &operatorv1.DeploymentSpec{}
+		}
+		spec.Deployment.Replicas = pointer.Int(1)
+	case configv1.HighlyAvailableTopologyMode:
+		if spec.Deployment == nil {
+			spec.Deployment = &operatorv1.DeploymentSpec{}
+		}
+		spec.Deployment.Replicas = pointer.Int(2)
+		if len(rendered.podLabels) == 0 {
+			break
+		}
+		affinity := &corev1.Affinity{}
+		if rendered.affinity != nil {
+			affinity = rendered.affinity.DeepCopy()
+		}
+		affinity.PodAntiAffinity = &corev1.PodAntiAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{{
+				LabelSelector: &metav1.LabelSelector{MatchLabels: rendered.podLabels},
+				TopologyKey:   corev1.LabelHostname,
+			}},
+		}
+		spec.Deployment.Affinity = affinity
+	}
 }
 
-func (r *ClusterOperatorReconciler) containerCustomizationFromProvider(kind, name string) []operatorv1.ContainerSpec {
-	image, ok := r.Images[providerKindToTypeName(kind)+"-"+name+":manager"]
-	cSpecs := []operatorv1.ContainerSpec{}
-	if !ok {
-		return cSpecs
+// selectTopologyVariant points the provider to the single node variant of its components
+// (one replica, Recreate strategy) on single node clusters, the imported providers select
+// the default components otherwise. The providers without a variant ConfigMap in objs keep
+// the default components, shaped by applyTopology.
+func selectTopologyVariant(spec *operatorv1.ProviderSpec, topology configv1.TopologyMode, objs []client.Object) {
+	if topology != configv1.SingleReplicaTopologyMode || spec.FetchConfig == nil || spec.FetchConfig.Selector == nil {
+		return
 	}
-	cSpecs = append(cSpecs, operatorv1.ContainerSpec{
-		Name:  "manager",
-		Image: newImageMeta(image),
-	})
-	if kind == "InfrastructureProvider" {
-		image, ok := r.Images["kube-rbac-proxy"]
-		if !ok {
-			return cSpecs
+	selector := spec.FetchConfig.Selector.DeepCopy()
+	expressions := []metav1.LabelSelectorRequirement{}
+	for _, e := range selector.MatchExpressions {
+		if e.Key != providerTopologyLabel {
+			expressions = append(expressions, e)
 		}
-		cSpecs = append(cSpecs, operatorv1.ContainerSpec{
-			Name:  "kube-rbac-proxy",
-			Image: newImageMeta(image),
-		})
 	}
+	selector.MatchExpressions = expressions
+	if selector.MatchLabels == nil {
+		selector.MatchLabels = map[string]string{}
+	}
+	selector.MatchLabels[providerTopologyLabel] = string(configv1.SingleReplicaTopologyMode)
 
-	return cSpecs
+	variant, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		klog.Warningf("invalid components selector: %v", err)
+		return
+	}
+	for _, obj := range objs {
+		if cm, ok := obj.(*corev1.ConfigMap); ok && variant.Matches(labels.Set(cm.Labels)) {
+			spec.FetchConfig.Selector = selector
+			return
+		}
+	}
+	klog.V(2).Infof("no %s variant of the components selected by %s, using the default ones", topology, variant)
 }
 
-func newImageMeta(imageURL string) *operatorv1.ImageMeta {
-	im := &operatorv1.ImageMeta{}
-	urlSplit := strings.Split(imageURL, ":")
-	if len(urlSplit) == 2 {
-		im.Tag = &urlSplit[1]
+// renderProviderComponents renders the components of a provider ConfigMap: they are
+// checked against the CRDs of the cluster, their images replaced with the ones of the payload
+// and their pods annotated for workload partitioning when it is enabled.
+func (r *ClusterOperatorReconciler) renderProviderComponents(ctx context.Context, cm *corev1.ConfigMap, workloadPartitioning bool) error {
+	components := cm.Data[componentsKey]
+	crds, err := componentCRDs(components)
+	if err != nil {
+		return fmt.Errorf("invalid components in ConfigMap %s: %v", cm.Name, err)
+	}
+	if err := r.checkCRDUpgrade(ctx, "provider ConfigMap "+cm.Name, crds); err != nil {
+		return err
+	}
+	// the components of the providers reference their images with placeholders
+	components, err = r.substituteImages(cm, components)
+	if err != nil {
+		return err
+	}
+	if workloadPartitioning {
+		components, err = setComponentsManagementWorkload(components)
+		if err != nil {
+			return err
+		}
 	}
-	urlSplit = strings.Split(urlSplit[0], "/")
-	im.Name = &urlSplit[len(urlSplit)-1]
-	im.Repository = pointer.StringPtr(strings.Join(urlSplit[0:len(urlSplit)-1], "/"))
-	return im
+	return setProviderComponents(cm, components)
 }
 
-func (r *ClusterOperatorReconciler) customizeDeployment(dep *appsv1.Deployment) error { //nolint TODO:remove during refatoring
-	containerToImageRef := map[string]string{
-		"manager":         "cluster-api:operator",
-		"kube-rbac-proxy": "kube-rbac-proxy",
-	}
+func (r *ClusterOperatorReconciler) customizeDeployment(dep *appsv1.Deployment) error {
+	object := "Deployment " + dep.Name
+	missing := []string{}
 	for ci, cont := range dep.Spec.Template.Spec.Containers {
 		if cont.Name == "manager" {
 			// since our RBAC is installed via /manifests we don't want the upstream operator
@@ -215,29 +484,33 @@ func (r *ClusterOperatorReconciler) customizeDeployment(dep *appsv1.Deployment)
 			dep.Spec.Template.Spec.Containers[ci].Args = append(cont.Args, "--delete-rbac-on-upgrade=false")
 		}
 
-		if imageRef, ok := containerToImageRef[cont.Name]; ok {
-			if cont.Image == r.Images[imageRef] {
-				klog.Infof("container %s image %s", cont.Name, cont.Image)
+		// the imported Deployment may still have its upstream images, e.g. controller:latest,
+		// they are mapped to the payload images named after the operator and the container.
+		if !relatedImagePlaceholder.MatchString(cont.Image) {
+			name := relatedImageName("core", "cluster-api-operator", cont.Name)
+			image, ok := r.Images[name]
+			if !ok {
+				missing = append(missing, name)
 				continue
 			}
-			klog.Infof("container %s changing image from %s to %s", cont.Name, cont.Image, r.Images[imageRef])
-			dep.Spec.Template.Spec.Containers[ci].Image = r.Images[imageRef]
-		} else {
-			klog.Warningf("container %s no image replacement found for %s", cont.Name, cont.Image)
+			if image != cont.Image {
+				klog.Infof("container %s changing image from %s to %s", cont.Name, cont.Image, image)
+				dep.Spec.Template.Spec.Containers[ci].Image = image
+			}
+			continue
 		}
-	}
-	return setSpecHashAnnotation(&dep.ObjectMeta, dep.Spec)
-}
 
-func setSpecHashAnnotation(objMeta *metav1.ObjectMeta, spec interface{}) error { //nolint TODO:remove during refatoring
-	jsonBytes, err := json.Marshal(spec)
-	if err != nil {
-		return err
+		image, err := r.substituteRelatedImages(object, cont.Image)
+		if err != nil {
+			return err
+		}
+		if image != cont.Image {
+			klog.Infof("container %s changing image from %s to %s", cont.Name, cont.Image, image)
+			dep.Spec.Template.Spec.Containers[ci].Image = image
+		}
 	}
-	specHash := fmt.Sprintf("%x", sha256.Sum256(jsonBytes))
-	if objMeta.Annotations == nil {
-		objMeta.Annotations = map[string]string{}
+	if len(missing) > 0 {
+		return &missingImagesError{object: object, images: missing}
 	}
-	objMeta.Annotations[specHashAnnotation] = specHash
 	return nil
 }
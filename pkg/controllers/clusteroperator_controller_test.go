@@ -2,122 +2,473 @@ package controllers
 
 import (
 	"encoding/json"
-	"io/ioutil"
-	"path/filepath"
+	"errors"
+	"os"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+	configv1 "github.com/openshift/api/config/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/utils/pointer"
 	operatorv1 "sigs.k8s.io/cluster-api/exp/operator/api/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/openshift/cluster-capi-operator/assets"
 )
 
-func TestNewImageMeta(t *testing.T) {
+func TestSelectTopologyVariant(t *testing.T) {
+	importedSpec := func() operatorv1.ProviderSpec {
+		return operatorv1.ProviderSpec{
+			FetchConfig: &operatorv1.FetchConfiguration{
+				Selector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{
+						"provider.cluster.x-k8s.io/name": "aws",
+						"provider.cluster.x-k8s.io/type": "infrastructure",
+					},
+					MatchExpressions: []metav1.LabelSelectorRequirement{
+						{Key: providerTopologyLabel, Operator: metav1.LabelSelectorOpDoesNotExist},
+					},
+				},
+			},
+		}
+	}
+	configMap := func(name string, labels map[string]string) client.Object {
+		return &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+	}
+	defaultComponents := configMap("aws-v0.7.0", map[string]string{
+		"provider.cluster.x-k8s.io/name": "aws",
+		"provider.cluster.x-k8s.io/type": "infrastructure",
+	})
+	variant := configMap("aws-v0.7.0-sno", map[string]string{
+		"provider.cluster.x-k8s.io/name": "aws",
+		"provider.cluster.x-k8s.io/type": "infrastructure",
+		providerTopologyLabel:            "SingleReplica",
+	})
+	otherVariant := configMap("gcp-v0.4.0-sno", map[string]string{
+		"provider.cluster.x-k8s.io/name": "gcp",
+		"provider.cluster.x-k8s.io/type": "infrastructure",
+		providerTopologyLabel:            "SingleReplica",
+	})
 	tests := []struct {
 		name     string
-		imageURL string
-		want     *operatorv1.ImageMeta
+		topology configv1.TopologyMode
+		objs     []client.Object
+		want     *metav1.LabelSelector
 	}{
 		{
-			name:     "infrastructure-aws:manager",
-			imageURL: "k8s.gcr.io/cluster-api-aws/cluster-api-aws-controller:v0.7.0",
-			want: &operatorv1.ImageMeta{
-				Name:       pointer.StringPtr("cluster-api-aws-controller"),
-				Repository: pointer.StringPtr("k8s.gcr.io/cluster-api-aws"),
-				Tag:        pointer.StringPtr("v0.7.0"),
-			},
+			name:     "highly available",
+			topology: configv1.HighlyAvailableTopologyMode,
+			objs:     []client.Object{defaultComponents, variant},
+			want:     importedSpec().FetchConfig.Selector,
 		},
 		{
-			name:     "infrastructure-azure:manager",
-			imageURL: "us.gcr.io/k8s-artifacts-prod/cluster-api-azure/cluster-api-azure-controller:v0.5.2",
-			want: &operatorv1.ImageMeta{
-				Name:       pointer.StringPtr("cluster-api-azure-controller"),
-				Repository: pointer.StringPtr("us.gcr.io/k8s-artifacts-prod/cluster-api-azure"),
-				Tag:        pointer.StringPtr("v0.5.2"),
+			name:     "single replica",
+			topology: configv1.SingleReplicaTopologyMode,
+			objs:     []client.Object{defaultComponents, variant},
+			want: &metav1.LabelSelector{
+				MatchLabels: map[string]string{
+					"provider.cluster.x-k8s.io/name": "aws",
+					"provider.cluster.x-k8s.io/type": "infrastructure",
+					providerTopologyLabel:            "SingleReplica",
+				},
+				MatchExpressions: []metav1.LabelSelectorRequirement{},
 			},
 		},
 		{
-			name:     "infrastructure-metal3:ip-address-manager",
-			imageURL: "quay.io/metal3-io/ip-address-manager:v0.1.0",
-			want: &operatorv1.ImageMeta{
-				Name:       pointer.StringPtr("ip-address-manager"),
-				Repository: pointer.StringPtr("quay.io/metal3-io"),
-				Tag:        pointer.StringPtr("v0.1.0"),
-			},
+			name:     "single replica without variant",
+			topology: configv1.SingleReplicaTopologyMode,
+			objs:     []client.Object{defaultComponents, otherVariant},
+			want:     importedSpec().FetchConfig.Selector,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := newImageMeta(tt.imageURL); !reflect.DeepEqual(got, tt.want) {
-				t.Error(cmp.Diff(got, tt.want))
+			spec := importedSpec()
+			selectTopologyVariant(&spec, tt.topology, tt.objs)
+			if !reflect.DeepEqual(spec.FetchConfig.Selector, tt.want) {
+				t.Error(cmp.Diff(spec.FetchConfig.Selector, tt.want))
 			}
 		})
 	}
 }
 
-func TestContainerCustomizationFromProvider(t *testing.T) {
-	sampleImagesFile := filepath.Clean("../../hack/sample-images.json")
-	jsonData, err := ioutil.ReadFile(sampleImagesFile)
+func TestSelectTopologyVariantOfAssets(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := operatorv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	objs, err := assets.Providers("", scheme)
 	if err != nil {
-		t.Fatal("unable to read file", sampleImagesFile, err)
+		t.Fatal(err)
+	}
+	for _, topology := range []configv1.TopologyMode{configv1.HighlyAvailableTopologyMode, configv1.SingleReplicaTopologyMode} {
+		for _, obj := range objs {
+			spec := providerSpec(obj.DeepCopyObject().(client.Object))
+			if spec == nil || spec.FetchConfig == nil || spec.FetchConfig.Selector == nil {
+				continue
+			}
+			selectTopologyVariant(spec, topology, objs)
+			selector, err := metav1.LabelSelectorAsSelector(spec.FetchConfig.Selector)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the upstream operator fails without a ConfigMap selected
+			selected := false
+			for _, o := range objs {
+				if cm, ok := o.(*corev1.ConfigMap); ok && hasProviderComponents(cm) && selector.Matches(labels.Set(cm.Labels)) {
+					selected = true
+				}
+			}
+			if !selected {
+				t.Errorf("%s: no components selected by %s on %s clusters", providerKey(obj), selector, topology)
+			}
+		}
 	}
-	containerImages := map[string]string{}
-	if err := json.Unmarshal(jsonData, &containerImages); err != nil {
-		t.Fatal("unable to unmarshal image names from file", sampleImagesFile, err)
+}
+
+func TestApplyTopology(t *testing.T) {
+	podLabels := map[string]string{"cluster.x-k8s.io/provider": "infrastructure-aws", "control-plane": "capa-controller-manager"}
+	rendered := renderedProvider{
+		podLabels: podLabels,
+		affinity:  &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{}},
 	}
 	tests := []struct {
-		name  string
-		pKind string
-		pName string
-		want  []operatorv1.ContainerSpec
+		name     string
+		topology configv1.TopologyMode
+		rendered renderedProvider
+		want     operatorv1.ProviderSpec
 	}{
 		{
-			name:  "cluster-api",
-			pKind: "CoreProvider",
-			pName: "cluster-api",
-			want: []operatorv1.ContainerSpec{
-				{
-					Name: "manager",
-					Image: &operatorv1.ImageMeta{
-						Name:       pointer.StringPtr("cluster-api-controller"),
-						Repository: pointer.StringPtr("k8s.gcr.io/cluster-api"),
-						Tag:        pointer.StringPtr("v1.0.0"),
+			name:     "single replica",
+			topology: configv1.SingleReplicaTopologyMode,
+			rendered: rendered,
+			want:     operatorv1.ProviderSpec{Deployment: &operatorv1.DeploymentSpec{Replicas: pointer.Int(1)}},
+		},
+		{
+			name:     "highly available",
+			topology: configv1.HighlyAvailableTopologyMode,
+			rendered: rendered,
+			want: operatorv1.ProviderSpec{Deployment: &operatorv1.DeploymentSpec{
+				Replicas: pointer.Int(2),
+				Affinity: &corev1.Affinity{
+					NodeAffinity: &corev1.NodeAffinity{},
+					PodAntiAffinity: &corev1.PodAntiAffinity{
+						RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{{
+							LabelSelector: &metav1.LabelSelector{MatchLabels: podLabels},
+							TopologyKey:   corev1.LabelHostname,
+						}},
 					},
 				},
+			}},
+		},
+		{
+			name:     "highly available without pod labels",
+			topology: configv1.HighlyAvailableTopologyMode,
+			want:     operatorv1.ProviderSpec{Deployment: &operatorv1.DeploymentSpec{Replicas: pointer.Int(2)}},
+		},
+		{
+			name:     "external control plane",
+			topology: configv1.ExternalTopologyMode,
+			rendered: rendered,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := operatorv1.ProviderSpec{}
+			applyTopology(&spec, tt.topology, tt.rendered)
+			if diff := cmp.Diff(tt.want, spec); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestUnshardComponents(t *testing.T) {
+	crd := func(name string) string {
+		return "apiVersion: apiextensions.k8s.io/v1\nkind: CustomResourceDefinition\nmetadata:\n  name: " + name +
+			"\n  labels:\n    cluster.x-k8s.io/provider: infrastructure-azure\n"
+	}
+	provider := func(name, shards string) *corev1.ConfigMap {
+		cm := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   name,
+				Labels: map[string]string{providerTypeLabel: "infrastructure", providerNameLabel: "azure"},
+			},
+			Data: map[string]string{componentsKey: "apiVersion: apps/v1\nkind: Deployment\n"},
+		}
+		if shards != "" {
+			cm.Annotations = map[string]string{shardsAnnotation: shards}
+		}
+		return cm
+	}
+	shard := func(name, of string, crds ...string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{shardOfLabel: of}},
+			Data:       map[string]string{crdsKey: strings.Join(crds, "---\n")},
+		}
+	}
+	tests := []struct {
+		name    string
+		objs    []client.Object
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "not sharded",
+			objs: []client.Object{provider("azure-v0.5.2", "")},
+			want: []string{"ConfigMap azure-v0.5.2"},
+		},
+		{
+			name: "sharded",
+			objs: []client.Object{
+				provider("azure-v0.5.2", "2"),
+				shard("azure-v0.5.2-crds-1", "azure-v0.5.2", crd("a"), crd("b")),
+				shard("azure-v0.5.2-crds-2", "azure-v0.5.2", crd("c")),
 			},
+			want: []string{"ConfigMap azure-v0.5.2", "CustomResourceDefinition a", "CustomResourceDefinition b", "CustomResourceDefinition c"},
 		},
 		{
-			name:  "aws",
-			pKind: "InfrastructureProvider",
-			pName: "aws",
-			want: []operatorv1.ContainerSpec{
-				{
-					Name: "manager",
-					Image: &operatorv1.ImageMeta{
-						Name:       pointer.StringPtr("cluster-api-aws-controller"),
-						Repository: pointer.StringPtr("k8s.gcr.io/cluster-api-aws"),
-						Tag:        pointer.StringPtr("v0.7.0"),
-					},
-				},
-				{
-					Name: "kube-rbac-proxy",
-					Image: &operatorv1.ImageMeta{
-						Name:       pointer.StringPtr("kube-rbac-proxy"),
-						Repository: pointer.StringPtr("gcr.io/kubebuilder"),
-						Tag:        pointer.StringPtr("v0.8.0"),
-					},
-				},
+			name: "sharded variants",
+			objs: []client.Object{
+				provider("azure-v0.5.2", "1"),
+				shard("azure-v0.5.2-crds-1", "azure-v0.5.2", crd("a")),
+				provider("azure-v0.5.2-sno", "1"),
+				shard("azure-v0.5.2-sno-crds-1", "azure-v0.5.2-sno", crd("a")),
 			},
+			want: []string{"ConfigMap azure-v0.5.2", "ConfigMap azure-v0.5.2-sno", "CustomResourceDefinition a"},
+		},
+		{
+			name: "missing shard",
+			objs: []client.Object{
+				provider("azure-v0.5.2", "2"),
+				shard("azure-v0.5.2-crds-1", "azure-v0.5.2", crd("a")),
+			},
+			wantErr: true,
+		},
+		{
+			name: "unexpected shard",
+			objs: []client.Object{
+				provider("azure-v0.5.2", ""),
+				shard("azure-v0.5.2-crds-1", "azure-v0.5.2", crd("a")),
+			},
+			wantErr: true,
+		},
+		{
+			name: "not a CRD",
+			objs: []client.Object{
+				provider("azure-v0.5.2", "1"),
+				shard("azure-v0.5.2-crds-1", "azure-v0.5.2", "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: capz-controller-manager\n"),
+			},
+			wantErr: true,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			r := &ClusterOperatorReconciler{
-				Images: containerImages,
+			objs, err := unshardComponents(tt.objs)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
 			}
-			if got := r.containerCustomizationFromProvider(tt.pKind, tt.pName); !reflect.DeepEqual(got, tt.want) {
-				t.Error(cmp.Diff(got, tt.want))
+			got := []string{}
+			for _, obj := range objs {
+				kind := obj.GetObjectKind().GroupVersionKind().Kind
+				if _, ok := obj.(*corev1.ConfigMap); ok {
+					kind = "ConfigMap"
+				}
+				got = append(got, kind+" "+obj.GetName())
+				if kind == "CustomResourceDefinition" && providerKey(obj) != "infrastructure-azure" {
+					t.Errorf("CRD %s of provider %q, want infrastructure-azure", obj.GetName(), providerKey(obj))
+				}
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Error(diff)
 			}
 		})
 	}
 }
+
+// decodeComponents returns the objects of the components.
+func decodeComponents(t *testing.T, components string) []*unstructured.Unstructured {
+	objs, err := componentObjects(components)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return objs
+}
+
+func TestSetProviderComponents(t *testing.T) {
+	tests := []struct {
+		name       string
+		cm         *corev1.ConfigMap
+		components string
+		wantErr    bool
+	}{
+		{
+			name:       "no data",
+			cm:         &corev1.ConfigMap{},
+			components: "apiVersion: apps/v1\nkind: Deployment\n",
+		},
+		{
+			name:       "data",
+			cm:         &corev1.ConfigMap{Data: map[string]string{"metadata": "apiVersion: clusterctl.cluster.x-k8s.io/v1alpha3\n"}},
+			components: "apiVersion: apps/v1\nkind: Deployment\n",
+		},
+		{
+			name:       "too big",
+			cm:         &corev1.ConfigMap{Data: map[string]string{}},
+			components: "data: " + strings.Repeat("x", maxComponentsSize) + "\n",
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := setProviderComponents(tt.cm, tt.components)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the upstream operator only reads the components of data
+			if got := tt.cm.Data[componentsKey]; got != tt.components {
+				t.Error(cmp.Diff(tt.components, got))
+			}
+		})
+	}
+}
+
+func TestInfraPlatform(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   configv1.InfrastructureStatus
+		provider string
+	}{
+		{
+			name:     "platform status",
+			status:   configv1.InfrastructureStatus{PlatformStatus: &configv1.PlatformStatus{Type: configv1.BareMetalPlatformType}},
+			provider: "metal3",
+		},
+		{
+			name:     "deprecated platform",
+			status:   configv1.InfrastructureStatus{Platform: configv1.AzurePlatformType},
+			provider: "azure",
+		},
+		{
+			name:     "vsphere",
+			status:   configv1.InfrastructureStatus{PlatformStatus: &configv1.PlatformStatus{Type: configv1.VSpherePlatformType}},
+			provider: "vsphere",
+		},
+		{
+			name:     "ibmcloud",
+			status:   configv1.InfrastructureStatus{PlatformStatus: &configv1.PlatformStatus{Type: configv1.IBMCloudPlatformType}},
+			provider: "ibmcloud",
+		},
+		{
+			name:     "powervs",
+			status:   configv1.InfrastructureStatus{PlatformStatus: &configv1.PlatformStatus{Type: powerVSPlatformType}},
+			provider: "ibmcloud-powervs",
+		},
+		{
+			name:   "unsupported",
+			status: configv1.InfrastructureStatus{PlatformStatus: &configv1.PlatformStatus{Type: configv1.LibvirtPlatformType}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			infra := &configv1.Infrastructure{Status: tt.status}
+			if got := platformProviderName(infraPlatform(infra)); got != tt.provider {
+				t.Errorf("platformProviderName() = %q, want %q", got, tt.provider)
+			}
+		})
+	}
+}
+
+// manifestImages returns the images of the images ConfigMap manifest.
+func manifestImages(t *testing.T) map[string]string {
+	f, err := os.Open("../../manifests/0000_30_cluster-api_capi-operator_01_images.configmap.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	cm := &corev1.ConfigMap{}
+	if err := utilyaml.NewYAMLOrJSONDecoder(f, 4096).Decode(cm); err != nil {
+		t.Fatal(err)
+	}
+	images := map[string]string{}
+	if err := json.Unmarshal([]byte(cm.Data["images.json"]), &images); err != nil {
+		t.Fatal(err)
+	}
+	return images
+}
+
+func TestCustomizeDeployment(t *testing.T) {
+	images := manifestImages(t)
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := apiextensionsv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	objs, err := assets.FromDir("capi-operator", scheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deps := []*appsv1.Deployment{}
+	for _, obj := range objs {
+		if dep, ok := obj.(*appsv1.Deployment); ok {
+			deps = append(deps, dep)
+		}
+	}
+	if len(deps) == 0 {
+		t.Fatal("no Deployment in the capi-operator assets")
+	}
+
+	r := &ClusterOperatorReconciler{Images: images}
+	for _, dep := range deps {
+		if err := r.customizeDeployment(dep); err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range dep.Spec.Template.Spec.Containers {
+			want := images[relatedImageName("core", "cluster-api-operator", c.Name)]
+			if c.Image != want {
+				t.Errorf("container %s of Deployment %s has image %s, want the payload image %s", c.Name, dep.Name, c.Image, want)
+			}
+		}
+	}
+
+	missing := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "capi-operator-controller-manager"},
+		Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "unknown", Image: "controller:latest"}},
+		}}},
+	}
+	var imagesErr *missingImagesError
+	if err := r.customizeDeployment(missing); !errors.As(err, &imagesErr) {
+		t.Fatalf("expected missing images, got %v", err)
+	}
+	if diff := cmp.Diff([]string{"RELATED_IMAGE_CORE_CLUSTER_API_OPERATOR_UNKNOWN"}, imagesErr.images); diff != "" {
+		t.Error(diff)
+	}
+}
@@ -1,5 +1,10 @@
 package controllers
 
+import "regexp"
+
+// relatedImagePlaceholder matches the ${RELATED_IMAGE_...} placeholders of the imported assets.
+var relatedImagePlaceholder = regexp.MustCompile(`\$\{(RELATED_IMAGE_[A-Z0-9_]+)\}`)
+
 const (
 	DefaultManagedNamespace = "openshift-cluster-api"
 
@@ -13,4 +18,17 @@ const (
 	ClusterAPIEnabled = "ClusterAPIEnabled"
 
 	specHashAnnotation = "openshift.io/spec-hash"
+
+	// providerTopologyLabel is set by the asset import on the components of the single
+	// node variant of the providers.
+	providerTopologyLabel = "provider.cluster.x-k8s.io/topology"
+
+	// the labels of the provider ConfigMaps the CAPI Operator fetches the components from
+	providerTypeLabel    = "provider.cluster.x-k8s.io/type"
+	providerNameLabel    = "provider.cluster.x-k8s.io/name"
+	providerVersionLabel = "provider.cluster.x-k8s.io/version"
 )
+
+// appliedByManifest are the kinds of the capi-operator assets applied by the CVO from
+// /manifests instead.
+var appliedByManifest = []string{"Namespace", "ClusterRole", "Role", "ClusterRoleBinding", "RoleBinding", "ServiceAccount"}
@@ -0,0 +1,203 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const ReasonUnsafeCRDUpgrade = "UnsafeCRDUpgrade"
+
+// unsafeCRDUpgradeError is returned when the CRDs of a provider ConfigMap, or of its shards,
+// can't safely replace the CRDs of the cluster, the upgrade is refused and its reason surfaced
+// on the Degraded condition.
+type unsafeCRDUpgradeError struct {
+	// source is where the CRDs come from, e.g. "provider ConfigMap aws-v0.7.0"
+	source   string
+	problems []string
+}
+
+func (e *unsafeCRDUpgradeError) Error() string {
+	return fmt.Sprintf("refusing the CRDs of %s: %s", e.source, strings.Join(e.problems, ", "))
+}
+
+// checkCRDUpgrade verifies that the CRDs of source can replace the ones of the cluster: the
+// versions objects are stored in are still served, the schema changes don't invalidate the
+// objects stored and the conversion of the versions served is kept.
+func (r *ClusterOperatorReconciler) checkCRDUpgrade(ctx context.Context, source string, crds []*apiextensionsv1.CustomResourceDefinition) error {
+	problems := []string{}
+	for _, desired := range crds {
+		live := &apiextensionsv1.CustomResourceDefinition{}
+		if err := r.Get(ctx, client.ObjectKey{Name: desired.Name}, live); apierrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		versionProblems, schemaProblems := crdUpgradeProblems(live, desired)
+		problems = append(problems, versionProblems...)
+		if len(schemaProblems) == 0 {
+			continue
+		}
+		// the schema changes only invalidate the objects there are
+		inUse, err := r.hasCustomResources(ctx, live)
+		if err != nil {
+			return err
+		}
+		if inUse {
+			problems = append(problems, schemaProblems...)
+		} else {
+			klog.V(2).Infof("CRD %s has no objects, ignoring its schema changes: %s", live.Name, strings.Join(schemaProblems, ", "))
+		}
+	}
+	if len(problems) > 0 {
+		return &unsafeCRDUpgradeError{source: source, problems: problems}
+	}
+	return nil
+}
+
+// componentCRDs returns the CRDs of the components of a provider.
+func componentCRDs(components string) ([]*apiextensionsv1.CustomResourceDefinition, error) {
+	objs, err := componentObjects(components)
+	if err != nil {
+		return nil, err
+	}
+	crds := []*apiextensionsv1.CustomResourceDefinition{}
+	for _, obj := range objs {
+		if obj.GetKind() != "CustomResourceDefinition" {
+			continue
+		}
+		crd, err := toCRD(obj)
+		if err != nil {
+			return nil, err
+		}
+		crds = append(crds, crd)
+	}
+	return crds, nil
+}
+
+func toCRD(obj *unstructured.Unstructured) (*apiextensionsv1.CustomResourceDefinition, error) {
+	crd := &apiextensionsv1.CustomResourceDefinition{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, crd); err != nil {
+		return nil, err
+	}
+	return crd, nil
+}
+
+func (r *ClusterOperatorReconciler) hasCustomResources(ctx context.Context, crd *apiextensionsv1.CustomResourceDefinition) (bool, error) {
+	served := servedVersion(crd, storageVersion(crd))
+	if served == "" {
+		return false, nil
+	}
+	list := &unstructured.UnstructuredList{}
+	list.SetAPIVersion(crd.Spec.Group + "/" + served)
+	list.SetKind(crd.Spec.Names.Kind + "List")
+	if err := r.List(ctx, list, client.Limit(1)); err != nil {
+		return false, err
+	}
+	return len(list.Items) > 0, nil
+}
+
+// crdUpgradeProblems returns why the desired CRD can't replace the live one: the versions
+// objects are stored in no longer served and the conversion transitions breaking the versions
+// served, and the schema changes that may invalidate the objects stored, fields newly
+// required or whose type changes.
+func crdUpgradeProblems(live, desired *apiextensionsv1.CustomResourceDefinition) ([]string, []string) {
+	versionProblems := []string{}
+	for _, stored := range live.Status.StoredVersions {
+		v := crdVersion(desired, stored)
+		if v == nil {
+			versionProblems = append(versionProblems, fmt.Sprintf("%s removes version %s objects are stored in", live.Name, stored))
+		} else if live := crdVersion(live, stored); !v.Served && live != nil && live.Served {
+			versionProblems = append(versionProblems, fmt.Sprintf("%s stops serving version %s objects are stored in", desired.Name, stored))
+		}
+	}
+
+	liveStrategy, desiredStrategy := conversionStrategy(live), conversionStrategy(desired)
+	switch {
+	case desiredStrategy == apiextensionsv1.WebhookConverter &&
+		(desired.Spec.Conversion.Webhook == nil || desired.Spec.Conversion.Webhook.ClientConfig == nil):
+		versionProblems = append(versionProblems, fmt.Sprintf("%s has a conversion webhook without client config", desired.Name))
+	case liveStrategy == apiextensionsv1.WebhookConverter && desiredStrategy == apiextensionsv1.NoneConverter && len(desired.Spec.Versions) > 1:
+		versionProblems = append(versionProblems, fmt.Sprintf("%s drops the conversion webhook of its %d versions", desired.Name, len(desired.Spec.Versions)))
+	}
+
+	schemaProblems := []string{}
+	for _, v := range desired.Spec.Versions {
+		lv := crdVersion(live, v.Name)
+		if lv == nil || lv.Schema == nil || lv.Schema.OpenAPIV3Schema == nil || v.Schema == nil || v.Schema.OpenAPIV3Schema == nil {
+			continue
+		}
+		for _, p := range schemaChanges(lv.Schema.OpenAPIV3Schema, v.Schema.OpenAPIV3Schema, "") {
+			schemaProblems = append(schemaProblems, fmt.Sprintf("%s %s %s", desired.Name, v.Name, p))
+		}
+	}
+	return versionProblems, schemaProblems
+}
+
+func crdVersion(crd *apiextensionsv1.CustomResourceDefinition, name string) *apiextensionsv1.CustomResourceDefinitionVersion {
+	for i := range crd.Spec.Versions {
+		if crd.Spec.Versions[i].Name == name {
+			return &crd.Spec.Versions[i]
+		}
+	}
+	return nil
+}
+
+func conversionStrategy(crd *apiextensionsv1.CustomResourceDefinition) apiextensionsv1.ConversionStrategyType {
+	if crd.Spec.Conversion == nil || crd.Spec.Conversion.Strategy == "" {
+		return apiextensionsv1.NoneConverter
+	}
+	return crd.Spec.Conversion.Strategy
+}
+
+// schemaChanges returns the fields of the desired schema newly required, under fields that
+// already existed, or whose type changed.
+func schemaChanges(live, desired *apiextensionsv1.JSONSchemaProps, path string) []string {
+	changes := []string{}
+	if live.Type != "" && desired.Type != "" && live.Type != desired.Type {
+		changes = append(changes, fmt.Sprintf("changes the type of %s from %s to %s", schemaPath(path), live.Type, desired.Type))
+		return changes
+	}
+	wasRequired := map[string]bool{}
+	for _, name := range live.Required {
+		wasRequired[name] = true
+	}
+	for _, name := range desired.Required {
+		if !wasRequired[name] {
+			changes = append(changes, fmt.Sprintf("requires %s.%s", path, name))
+		}
+	}
+
+	names := make([]string, 0, len(desired.Properties))
+	for name := range desired.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		liveProp, ok := live.Properties[name]
+		if !ok {
+			continue
+		}
+		desiredProp := desired.Properties[name]
+		changes = append(changes, schemaChanges(&liveProp, &desiredProp, path+"."+name)...)
+	}
+	if live.Items != nil && live.Items.Schema != nil && desired.Items != nil && desired.Items.Schema != nil {
+		changes = append(changes, schemaChanges(live.Items.Schema, desired.Items.Schema, path+"[]")...)
+	}
+	return changes
+}
+
+func schemaPath(path string) string {
+	if path == "" {
+		return "."
+	}
+	return path
+}
@@ -0,0 +1,145 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCRDUpgradeProblems(t *testing.T) {
+	schema := func(required ...string) *apiextensionsv1.CustomResourceValidation {
+		return &apiextensionsv1.CustomResourceValidation{OpenAPIV3Schema: &apiextensionsv1.JSONSchemaProps{
+			Type: "object",
+			Properties: map[string]apiextensionsv1.JSONSchemaProps{
+				"spec": {
+					Type:     "object",
+					Required: required,
+					Properties: map[string]apiextensionsv1.JSONSchemaProps{
+						"region": {Type: "string"},
+						"zones":  {Type: "array", Items: &apiextensionsv1.JSONSchemaPropsOrArray{Schema: &apiextensionsv1.JSONSchemaProps{Type: "string"}}},
+					},
+				},
+			},
+		}}
+	}
+	version := func(name string, served, storage bool) apiextensionsv1.CustomResourceDefinitionVersion {
+		return apiextensionsv1.CustomResourceDefinitionVersion{Name: name, Served: served, Storage: storage, Schema: schema()}
+	}
+	webhook := &apiextensionsv1.CustomResourceConversion{
+		Strategy: apiextensionsv1.WebhookConverter,
+		Webhook: &apiextensionsv1.WebhookConversion{
+			ClientConfig:             &apiextensionsv1.WebhookClientConfig{},
+			ConversionReviewVersions: []string{"v1"},
+		},
+	}
+	crd := func(conversion *apiextensionsv1.CustomResourceConversion, stored []string, versions ...apiextensionsv1.CustomResourceDefinitionVersion) *apiextensionsv1.CustomResourceDefinition {
+		c := &apiextensionsv1.CustomResourceDefinition{ObjectMeta: metav1.ObjectMeta{Name: "awsclusters.infrastructure.cluster.x-k8s.io"}}
+		c.Spec.Versions = versions
+		c.Spec.Conversion = conversion
+		c.Status.StoredVersions = stored
+		return c
+	}
+	live := crd(webhook, []string{"v1alpha3", "v1alpha4"}, version("v1alpha3", true, false), version("v1alpha4", true, true))
+
+	tests := []struct {
+		name        string
+		desired     *apiextensionsv1.CustomResourceDefinition
+		wantVersion []string
+		wantSchema  []string
+	}{
+		{
+			name:        "unchanged",
+			desired:     live,
+			wantVersion: []string{},
+			wantSchema:  []string{},
+		},
+		{
+			name:        "new storage version",
+			desired:     crd(webhook, nil, version("v1alpha3", true, false), version("v1alpha4", true, false), version("v1beta1", true, true)),
+			wantVersion: []string{},
+			wantSchema:  []string{},
+		},
+		{
+			name:        "stored version removed",
+			desired:     crd(webhook, nil, version("v1alpha4", true, false), version("v1beta1", true, true)),
+			wantVersion: []string{"awsclusters.infrastructure.cluster.x-k8s.io removes version v1alpha3 objects are stored in"},
+			wantSchema:  []string{},
+		},
+		{
+			name:        "stored version no longer served",
+			desired:     crd(webhook, nil, version("v1alpha3", false, false), version("v1alpha4", true, true)),
+			wantVersion: []string{"awsclusters.infrastructure.cluster.x-k8s.io stops serving version v1alpha3 objects are stored in"},
+			wantSchema:  []string{},
+		},
+		{
+			name:        "conversion webhook dropped",
+			desired:     crd(nil, nil, version("v1alpha3", true, false), version("v1alpha4", true, true)),
+			wantVersion: []string{"awsclusters.infrastructure.cluster.x-k8s.io drops the conversion webhook of its 2 versions"},
+			wantSchema:  []string{},
+		},
+		{
+			name: "conversion webhook without client config",
+			desired: crd(&apiextensionsv1.CustomResourceConversion{Strategy: apiextensionsv1.WebhookConverter}, nil,
+				version("v1alpha3", true, false), version("v1alpha4", true, true)),
+			wantVersion: []string{"awsclusters.infrastructure.cluster.x-k8s.io has a conversion webhook without client config"},
+			wantSchema:  []string{},
+		},
+		{
+			name: "field newly required",
+			desired: crd(webhook, nil, version("v1alpha3", true, false),
+				apiextensionsv1.CustomResourceDefinitionVersion{Name: "v1alpha4", Served: true, Storage: true, Schema: schema("region")}),
+			wantVersion: []string{},
+			wantSchema:  []string{"awsclusters.infrastructure.cluster.x-k8s.io v1alpha4 requires .spec.region"},
+		},
+		{
+			name: "field type changed",
+			desired: func() *apiextensionsv1.CustomResourceDefinition {
+				c := crd(webhook, nil, version("v1alpha3", true, false), version("v1alpha4", true, true))
+				c.Spec.Versions[1].Schema = schema()
+				c.Spec.Versions[1].Schema.OpenAPIV3Schema.Properties["spec"].Properties["zones"].Items.Schema.Type = "object"
+				return c
+			}(),
+			wantVersion: []string{},
+			wantSchema:  []string{"awsclusters.infrastructure.cluster.x-k8s.io v1alpha4 changes the type of .spec.zones[] from string to object"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotVersion, gotSchema := crdUpgradeProblems(live, tt.desired)
+			if diff := cmp.Diff(tt.wantVersion, gotVersion); diff != "" {
+				t.Errorf("version problems: %s", diff)
+			}
+			if diff := cmp.Diff(tt.wantSchema, gotSchema); diff != "" {
+				t.Errorf("schema problems: %s", diff)
+			}
+		})
+	}
+}
+
+func TestComponentCRDs(t *testing.T) {
+	components := `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: awsclusters.infrastructure.cluster.x-k8s.io
+spec:
+  group: infrastructure.cluster.x-k8s.io
+  versions:
+  - name: v1alpha4
+    served: true
+    storage: true
+---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: capa-system
+`
+	crds, err := componentCRDs(components)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(crds) != 1 || crds[0].Name != "awsclusters.infrastructure.cluster.x-k8s.io" || storageVersion(crds[0]) != "v1alpha4" {
+		t.Errorf("unexpected CRDs %+v", crds)
+	}
+}
@@ -0,0 +1,81 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/klog"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// DeletionProtectionPath is the path the deletion protection webhook is served at, from the
+// ValidatingWebhookConfiguration of the manifests.
+const DeletionProtectionPath = "/validate-deletion-protection"
+
+// DeletionProtectionWebhook rejects the deletion of the CRDs and Deployments of the providers
+// while cluster api Machines or Clusters exist: without their CRDs or controllers, the cloud
+// instances of the Machines would be orphaned. A finalizer wouldn't do, the objects of a CRD
+// are deleted as soon as the CRD is. The deletions by the service accounts of the managed
+// namespace, the operators upgrading the providers, and while cluster api is disabled or
+// uninstalled are allowed.
+type DeletionProtectionWebhook struct {
+	Client client.Client
+	// APIReader lists the Machines and Clusters of every namespace, the cache only has the
+	// managed one.
+	APIReader        client.Reader
+	ManagedNamespace string
+}
+
+// Handle allows or denies the deletion of a protected object.
+func (w *DeletionProtectionWebhook) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if req.Operation != admissionv1.Delete {
+		return admission.Allowed("")
+	}
+	if isServiceAccountOf(req.UserInfo.Username, w.ManagedNamespace) {
+		return admission.Allowed("deleted by cluster api")
+	}
+	capiEnabled, err := isCAPIEnabled(ctx, w.Client)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	if !capiEnabled {
+		return admission.Allowed("cluster api is disabled")
+	}
+
+	for _, listKind := range []string{"MachineList", "ClusterList"} {
+		objs, err := listClusterAPIObjects(ctx, w.APIReader, listKind, client.Limit(1))
+		if err != nil {
+			return admission.Errored(http.StatusInternalServerError, err)
+		}
+		if len(objs) > 0 {
+			klog.Infof("denying the deletion of %s %s by %s, cluster api %ss exist", req.Kind.Kind, req.Name, req.UserInfo.Username, strings.TrimSuffix(listKind, "List"))
+			return admission.Denied(fmt.Sprintf("%s %s is protected while cluster api Machines or Clusters exist, uninstall cluster api to remove it", req.Kind.Kind, req.Name))
+		}
+	}
+	return admission.Allowed("")
+}
+
+// isServiceAccountOf returns whether the user is a service account of the namespace.
+func isServiceAccountOf(username, namespace string) bool {
+	return strings.HasPrefix(username, "system:serviceaccount:"+namespace+":")
+}
+
+// listClusterAPIObjects lists the cluster api objects of the kind, none when the CRD is gone.
+func listClusterAPIObjects(ctx context.Context, c client.Reader, listKind string, opts ...client.ListOption) ([]unstructured.Unstructured, error) {
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(clusterv1.GroupVersion.WithKind(listKind))
+	if err := c.List(ctx, list, opts...); meta.IsNoMatchError(err) || apierrors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
@@ -0,0 +1,23 @@
+package controllers
+
+import "testing"
+
+func TestIsServiceAccountOf(t *testing.T) {
+	tests := []struct {
+		username string
+		want     bool
+	}{
+		{username: "system:serviceaccount:openshift-cluster-api:capi-operator-manager", want: true},
+		{username: "system:serviceaccount:openshift-cluster-api:cluster-capi-operator", want: true},
+		{username: "system:serviceaccount:openshift-cluster-api-other:default"},
+		{username: "system:serviceaccount:kube-system:namespace-controller"},
+		{username: "kube:admin"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.username, func(t *testing.T) {
+			if got := isServiceAccountOf(tt.username, "openshift-cluster-api"); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
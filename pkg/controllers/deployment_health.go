@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+
+	configv1 "github.com/openshift/api/config/v1"
+)
+
+const (
+	ReasonDeploymentsUnavailable = "DeploymentsUnavailable"
+	ReasonDeploymentsProgressing = "DeploymentsProgressing"
+	ReasonDeploymentsDegraded    = "DeploymentsDegraded"
+)
+
+// deploymentsHealth sorts the Deployments of the managed namespace, the upstream operator and
+// the providers it installs, by the ClusterOperator condition they affect.
+type deploymentsHealth struct {
+	// unavailable have no available replica
+	unavailable []string
+	// progressing are rolling out
+	progressing []string
+	// degraded are rolled out with fewer available replicas than desired
+	degraded []string
+}
+
+func newDeploymentsHealth(deps []appsv1.Deployment) deploymentsHealth {
+	h := deploymentsHealth{}
+	for _, dep := range deps {
+		desired := int32(1)
+		if dep.Spec.Replicas != nil {
+			desired = *dep.Spec.Replicas
+		}
+		if desired == 0 {
+			continue
+		}
+		rollingOut := isRollingOut(dep, desired)
+		if dep.Status.AvailableReplicas == 0 {
+			h.unavailable = append(h.unavailable, dep.Name)
+		}
+		if rollingOut {
+			h.progressing = append(h.progressing, dep.Name)
+		} else if dep.Status.AvailableReplicas < desired {
+			h.degraded = append(h.degraded, dep.Name)
+		}
+	}
+	return h
+}
+
+// isRollingOut returns whether the Deployment hasn't rolled out its latest spec to all its
+// replicas yet, or still has replicas of the previous one.
+func isRollingOut(dep appsv1.Deployment, desired int32) bool {
+	return dep.Generation > dep.Status.ObservedGeneration ||
+		dep.Status.UpdatedReplicas < desired ||
+		dep.Status.Replicas > dep.Status.UpdatedReplicas
+}
+
+// conditions returns the ClusterOperator conditions for the health of the Deployments. The
+// operator isn't Upgradeable while a Deployment is unavailable or degraded.
+func (h deploymentsHealth) conditions(releaseVersion string) []configv1.ClusterOperatorStatusCondition {
+	available := newClusterOperatorStatusCondition(configv1.OperatorAvailable, configv1.ConditionTrue, ReasonAsExpected,
+		fmt.Sprintf("Cluster CAPI Operator is available at %s", releaseVersion))
+	if len(h.unavailable) > 0 {
+		available = newClusterOperatorStatusCondition(configv1.OperatorAvailable, configv1.ConditionFalse, ReasonDeploymentsUnavailable,
+			fmt.Sprintf("Deployments without available replicas: %s", strings.Join(h.unavailable, ", ")))
+	}
+
+	progressing := newClusterOperatorStatusCondition(configv1.OperatorProgressing, configv1.ConditionFalse, ReasonAsExpected, "")
+	if len(h.progressing) > 0 {
+		progressing = newClusterOperatorStatusCondition(configv1.OperatorProgressing, configv1.ConditionTrue, ReasonDeploymentsProgressing,
+			fmt.Sprintf("Progressing towards %s, rolling out Deployments: %s", releaseVersion, strings.Join(h.progressing, ", ")))
+	}
+
+	degraded := newClusterOperatorStatusCondition(configv1.OperatorDegraded, configv1.ConditionFalse, ReasonAsExpected, "")
+	if len(h.degraded) > 0 {
+		degraded = newClusterOperatorStatusCondition(configv1.OperatorDegraded, configv1.ConditionTrue, ReasonDeploymentsDegraded,
+			fmt.Sprintf("Deployments with missing replicas: %s", strings.Join(h.degraded, ", ")))
+	}
+
+	upgradeable := newClusterOperatorStatusCondition(configv1.OperatorUpgradeable, configv1.ConditionTrue, ReasonAsExpected, "")
+	switch {
+	case len(h.degraded) > 0:
+		upgradeable = newClusterOperatorStatusCondition(configv1.OperatorUpgradeable, configv1.ConditionFalse, ReasonDeploymentsDegraded, "")
+	case len(h.unavailable) > 0:
+		upgradeable = newClusterOperatorStatusCondition(configv1.OperatorUpgradeable, configv1.ConditionFalse, ReasonDeploymentsUnavailable, "")
+	}
+	return []configv1.ClusterOperatorStatusCondition{available, progressing, degraded, upgradeable}
+}
+
+// settled is true once the Deployments are all available and rolled out, when the
+// ClusterOperator reports the new version.
+func (h deploymentsHealth) settled() bool {
+	return len(h.unavailable) == 0 && len(h.progressing) == 0
+}
@@ -0,0 +1,136 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	configv1 "github.com/openshift/api/config/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDeploymentsHealth(t *testing.T) {
+	deployment := func(name string, replicas, updated, available int32, generation, observed int64) appsv1.Deployment {
+		return appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Generation: generation},
+			Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+			Status: appsv1.DeploymentStatus{
+				ObservedGeneration: observed,
+				Replicas:           replicas,
+				UpdatedReplicas:    updated,
+				AvailableReplicas:  available,
+			},
+		}
+	}
+	tests := []struct {
+		name string
+		deps []appsv1.Deployment
+		want map[configv1.ClusterStatusConditionType]configv1.ConditionStatus
+	}{
+		{
+			name: "no deployments",
+			want: map[configv1.ClusterStatusConditionType]configv1.ConditionStatus{
+				configv1.OperatorAvailable:   configv1.ConditionTrue,
+				configv1.OperatorProgressing: configv1.ConditionFalse,
+				configv1.OperatorDegraded:    configv1.ConditionFalse,
+				configv1.OperatorUpgradeable: configv1.ConditionTrue,
+			},
+		},
+		{
+			name: "rolled out",
+			deps: []appsv1.Deployment{deployment("capa-controller-manager", 2, 2, 2, 3, 3)},
+			want: map[configv1.ClusterStatusConditionType]configv1.ConditionStatus{
+				configv1.OperatorAvailable:   configv1.ConditionTrue,
+				configv1.OperatorProgressing: configv1.ConditionFalse,
+				configv1.OperatorDegraded:    configv1.ConditionFalse,
+				configv1.OperatorUpgradeable: configv1.ConditionTrue,
+			},
+		},
+		{
+			name: "first rollout",
+			deps: []appsv1.Deployment{deployment("capa-controller-manager", 1, 0, 0, 1, 0)},
+			want: map[configv1.ClusterStatusConditionType]configv1.ConditionStatus{
+				configv1.OperatorAvailable:   configv1.ConditionFalse,
+				configv1.OperatorProgressing: configv1.ConditionTrue,
+				configv1.OperatorDegraded:    configv1.ConditionFalse,
+				configv1.OperatorUpgradeable: configv1.ConditionFalse,
+			},
+		},
+		{
+			name: "rolling update",
+			deps: []appsv1.Deployment{deployment("capa-controller-manager", 2, 1, 2, 4, 4)},
+			want: map[configv1.ClusterStatusConditionType]configv1.ConditionStatus{
+				configv1.OperatorAvailable:   configv1.ConditionTrue,
+				configv1.OperatorProgressing: configv1.ConditionTrue,
+				configv1.OperatorDegraded:    configv1.ConditionFalse,
+				configv1.OperatorUpgradeable: configv1.ConditionTrue,
+			},
+		},
+		{
+			name: "missing replicas",
+			deps: []appsv1.Deployment{deployment("capa-controller-manager", 2, 2, 1, 3, 3)},
+			want: map[configv1.ClusterStatusConditionType]configv1.ConditionStatus{
+				configv1.OperatorAvailable:   configv1.ConditionTrue,
+				configv1.OperatorProgressing: configv1.ConditionFalse,
+				configv1.OperatorDegraded:    configv1.ConditionTrue,
+				configv1.OperatorUpgradeable: configv1.ConditionFalse,
+			},
+		},
+		{
+			name: "crashing",
+			deps: []appsv1.Deployment{
+				deployment("capi-controller-manager", 1, 1, 1, 1, 1),
+				deployment("capa-controller-manager", 1, 1, 0, 2, 2),
+			},
+			want: map[configv1.ClusterStatusConditionType]configv1.ConditionStatus{
+				configv1.OperatorAvailable:   configv1.ConditionFalse,
+				configv1.OperatorProgressing: configv1.ConditionFalse,
+				configv1.OperatorDegraded:    configv1.ConditionTrue,
+				configv1.OperatorUpgradeable: configv1.ConditionFalse,
+			},
+		},
+		{
+			name: "scaled down",
+			deps: []appsv1.Deployment{deployment("capa-controller-manager", 0, 0, 0, 2, 2)},
+			want: map[configv1.ClusterStatusConditionType]configv1.ConditionStatus{
+				configv1.OperatorAvailable:   configv1.ConditionTrue,
+				configv1.OperatorProgressing: configv1.ConditionFalse,
+				configv1.OperatorDegraded:    configv1.ConditionFalse,
+				configv1.OperatorUpgradeable: configv1.ConditionTrue,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := map[configv1.ClusterStatusConditionType]configv1.ConditionStatus{}
+			for _, c := range newDeploymentsHealth(tt.deps).conditions("4.10.0") {
+				got[c.Type] = c.Status
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestOperandsInactive(t *testing.T) {
+	inactive := operandsInactive{reason: ReasonFeatureGateDisabled, message: "disabled"}
+	for _, c := range inactive.setAvailable(newDeploymentsHealth(nil).conditions("4.10.0")) {
+		if c.Type == configv1.OperatorAvailable && (c.Status != configv1.ConditionTrue || c.Reason != ReasonFeatureGateDisabled) {
+			t.Errorf("unexpected Available condition %+v", c)
+		}
+	}
+
+	for _, c := range inactive.setAvailable(newDeploymentsHealth([]appsv1.Deployment{{}}).conditions("4.10.0")) {
+		if c.Type == configv1.OperatorAvailable && c.Reason != ReasonDeploymentsUnavailable {
+			t.Errorf("an unavailable deployment should still be reported, got %+v", c)
+		}
+	}
+
+	uninstalling := operandsInactive{reason: ReasonUninstalled, message: "uninstalled", progress: "Deleting 2 providers"}
+	for _, c := range uninstalling.setAvailable(newDeploymentsHealth(nil).conditions("4.10.0")) {
+		if c.Type == configv1.OperatorProgressing && (c.Status != configv1.ConditionTrue || c.Reason != ReasonUninstalling || c.Message != "Deleting 2 providers") {
+			t.Errorf("unexpected Progressing condition %+v", c)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	configv1 "github.com/openshift/api/config/v1"
+)
+
+const (
+	// DriftCorrected is the condition of the ClusterOperator counting the out-of-band
+	// modifications of the operands reverted since the operator started.
+	DriftCorrected       configv1.ClusterStatusConditionType = "DriftCorrected"
+	ReasonDriftCorrected                                     = "OutOfBandModificationsReverted"
+)
+
+// driftCorrections counts the operands modified out-of-band whose modifications were
+// reverted, they are re-applied on every change and resync of the operator.
+type driftCorrections struct {
+	count int
+	last  []string
+	at    time.Time
+}
+
+func (d *driftCorrections) record(drifted []string) {
+	if len(drifted) == 0 {
+		return
+	}
+	recordDriftCorrections(drifted)
+	d.count += len(drifted)
+	d.last = drifted
+	d.at = time.Now()
+}
+
+func (d driftCorrections) condition() configv1.ClusterOperatorStatusCondition {
+	if d.count == 0 {
+		return newClusterOperatorStatusCondition(DriftCorrected, configv1.ConditionFalse, ReasonAsExpected, "")
+	}
+	return newClusterOperatorStatusCondition(DriftCorrected, configv1.ConditionTrue, ReasonDriftCorrected,
+		fmt.Sprintf("Reverted %d out-of-band modifications of the operands, last of %s at %s",
+			d.count, strings.Join(d.last, ", "), d.at.UTC().Format(time.RFC3339)))
+}
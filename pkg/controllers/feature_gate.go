@@ -1,13 +1,38 @@
 package controllers
 
 import (
+	"context"
 	"fmt"
 
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	configv1 "github.com/openshift/api/config/v1"
+	capiv1alpha1 "github.com/openshift/cluster-capi-operator/api/v1alpha1"
 )
 
+// isCAPIEnabled determines whether the cluster FeatureGate enables cluster api, it doesn't
+// when there is none or the ClusterAPIConfiguration uninstalls it.
+func isCAPIEnabled(ctx context.Context, c client.Reader) (bool, error) {
+	featureGate := &configv1.FeatureGate{}
+	if err := c.Get(ctx, client.ObjectKey{Name: externalFeatureGateName}, featureGate); apierrors.IsNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if enabled, err := isCAPIFeatureGateEnabled(featureGate); err != nil || !enabled {
+		return false, err
+	}
+	config := &capiv1alpha1.ClusterAPIConfiguration{}
+	if err := c.Get(ctx, client.ObjectKey{Name: capiv1alpha1.ClusterAPIConfigurationName}, config); apierrors.IsNotFound(err) {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	return !config.Spec.Uninstall, nil
+}
+
 // isCAPIFeatureGateEnabled determines whether the ClusterAPIEnabled feature gate is present in the current
 // feature set.
 func isCAPIFeatureGateEnabled(featureGate *configv1.FeatureGate) (bool, error) {
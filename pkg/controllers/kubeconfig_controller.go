@@ -0,0 +1,233 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	clientcmdv1 "k8s.io/client-go/tools/clientcmd/api/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	configv1 "github.com/openshift/api/config/v1"
+	capiv1alpha1 "github.com/openshift/cluster-capi-operator/api/v1alpha1"
+	"github.com/openshift/library-go/pkg/config/clusteroperator/v1helpers"
+)
+
+const (
+	// kubeconfigServiceAccount is the service account, from the manifests, whose bound tokens
+	// the CAPI controllers use to access the cluster as a workload cluster.
+	kubeconfigServiceAccount = "cluster-capi-workload"
+	// kubeconfigTokenExpiry is the lifetime requested for the tokens, they are rotated once
+	// 80% of it has passed.
+	kubeconfigTokenExpiry = 24 * time.Hour
+	// kubeconfigExpiryAnnotation records the expiry of the token of a kubeconfig Secret.
+	kubeconfigExpiryAnnotation = "capi.openshift.io/token-expiry"
+	// kubeconfigKey is the key of the kubeconfig in the Secret, as the CAPI controllers read it.
+	kubeconfigKey = "value"
+	// kubeRootCAConfigMap is the ConfigMap of every namespace with the CA of the API server.
+	kubeRootCAConfigMap = "kube-root-ca.crt"
+	kubeRootCAKey       = "ca.crt"
+
+	// KubeconfigDegraded is the condition of the ClusterOperator set when the kubeconfig
+	// can't be generated, it is folded into Degraded.
+	KubeconfigDegraded     configv1.ClusterStatusConditionType = "KubeconfigDegraded"
+	ReasonKubeconfigFailed                                     = "KubeconfigFailed"
+)
+
+// KubeconfigReconciler generates the <cluster>-kubeconfig Secret the CAPI controllers read to
+// access the cluster, the management cluster being its own workload cluster. The kubeconfig
+// has a bound service account token, rotated before it expires.
+type KubeconfigReconciler struct {
+	client.Client
+	// KubeClient requests the service account tokens.
+	KubeClient       kubernetes.Interface
+	Recorder         record.EventRecorder
+	ManagedNamespace string
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *KubeconfigReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("kubeconfig").
+		For(&configv1.Infrastructure{}, builder.WithPredicates(infrastructurePredicates())).
+		Watches(
+			&source.Kind{Type: &configv1.FeatureGate{}},
+			handler.EnqueueRequestsFromMapFunc(toInfrastructure),
+			builder.WithPredicates(featureGatePredicates()),
+		).
+		// the configuration may uninstall cluster api
+		Watches(
+			&source.Kind{Type: &capiv1alpha1.ClusterAPIConfiguration{}},
+			handler.EnqueueRequestsFromMapFunc(toInfrastructure),
+			builder.WithPredicates(operatorConfigPredicates()),
+		).
+		// regenerate the kubeconfig when it is edited or deleted
+		Watches(
+			&source.Kind{Type: &corev1.Secret{}},
+			handler.EnqueueRequestsFromMapFunc(toInfrastructure),
+			builder.WithPredicates(managedNamespacePredicates(r.ManagedNamespace), kubeconfigSecretPredicates()),
+		).
+		Complete(r)
+}
+
+func kubeconfigSecretPredicates() predicate.Funcs {
+	return predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		return strings.HasSuffix(obj.GetName(), "-kubeconfig")
+	})
+}
+
+// Reconcile generates the kubeconfig Secret when it is missing or its token is due for
+// rotation, and requeues for the next rotation.
+func (r *KubeconfigReconciler) Reconcile(ctx context.Context, _ ctrl.Request) (ctrl.Result, error) {
+	if capiEnabled, err := isCAPIEnabled(ctx, r.Client); err != nil || !capiEnabled {
+		return ctrl.Result{}, err
+	}
+
+	result, err := r.reconcile(ctx)
+	if err != nil {
+		klog.Errorf("Unable to generate the kubeconfig: %v", err)
+		return result, r.setDegraded(ctx, err)
+	}
+	return result, r.setDegraded(ctx, nil)
+}
+
+func (r *KubeconfigReconciler) reconcile(ctx context.Context) (ctrl.Result, error) {
+	infra := &configv1.Infrastructure{}
+	if err := r.Get(ctx, client.ObjectKey{Name: infrastructureResourceName}, infra); err != nil {
+		return ctrl.Result{}, err
+	}
+	clusterName := infra.Status.InfrastructureName
+	if clusterName == "" || infra.Status.APIServerInternalURL == "" {
+		return ctrl.Result{}, fmt.Errorf("the Infrastructure has no infrastructure name or internal API server URL")
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: clusterName + "-kubeconfig", Namespace: r.ManagedNamespace},
+	}
+	err := r.Get(ctx, client.ObjectKeyFromObject(secret), secret)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return ctrl.Result{}, err
+	}
+	if err == nil {
+		if rotateIn := kubeconfigRotateIn(secret, time.Now()); rotateIn > 0 {
+			return ctrl.Result{RequeueAfter: rotateIn}, nil
+		}
+	}
+
+	caCM := &corev1.ConfigMap{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: r.ManagedNamespace, Name: kubeRootCAConfigMap}, caCM); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to get the CA of the API server: %v", err)
+	}
+
+	expirySeconds := int64(kubeconfigTokenExpiry.Seconds())
+	token, err := r.KubeClient.CoreV1().ServiceAccounts(r.ManagedNamespace).CreateToken(ctx, kubeconfigServiceAccount,
+		&authenticationv1.TokenRequest{Spec: authenticationv1.TokenRequestSpec{ExpirationSeconds: &expirySeconds}},
+		metav1.CreateOptions{})
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to request a token for %s: %v", kubeconfigServiceAccount, err)
+	}
+
+	kubeconfig, err := newKubeconfig(clusterName, infra.Status.APIServerInternalURL, []byte(caCM.Data[kubeRootCAKey]), token.Status.Token)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	opRes, err := controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+		if secret.Labels == nil {
+			secret.Labels = map[string]string{}
+		}
+		secret.Labels[clusterv1.ClusterLabelName] = clusterName
+		if secret.Annotations == nil {
+			secret.Annotations = map[string]string{}
+		}
+		secret.Annotations[kubeconfigExpiryAnnotation] = token.Status.ExpirationTimestamp.UTC().Format(time.RFC3339)
+		secret.Type = clusterv1.ClusterSecretType
+		secret.Data = map[string][]byte{kubeconfigKey: kubeconfig}
+		return nil
+	})
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	klog.Infof("kubeconfig %s %s, the token expires at %s", secret.Name, opRes, token.Status.ExpirationTimestamp)
+	r.Recorder.Eventf(secret, corev1.EventTypeNormal, string(opRes), "token expires at %s", token.Status.ExpirationTimestamp)
+	return ctrl.Result{RequeueAfter: kubeconfigRotateIn(secret, time.Now())}, nil
+}
+
+// kubeconfigRotateIn returns how long until the token of the kubeconfig Secret is due for
+// rotation, once 80% of its lifetime has passed, or 0 when it is already or can't tell.
+func kubeconfigRotateIn(secret *corev1.Secret, now time.Time) time.Duration {
+	if len(secret.Data[kubeconfigKey]) == 0 {
+		return 0
+	}
+	expiry, err := time.Parse(time.RFC3339, secret.Annotations[kubeconfigExpiryAnnotation])
+	if err != nil {
+		return 0
+	}
+	rotateAt := expiry.Add(-kubeconfigTokenExpiry / 5)
+	if !rotateAt.After(now) {
+		return 0
+	}
+	return rotateAt.Sub(now)
+}
+
+// newKubeconfig returns a kubeconfig authenticating with the token, in JSON, which the
+// kubeconfig loaders read as YAML.
+func newKubeconfig(clusterName, server string, ca []byte, token string) ([]byte, error) {
+	user := kubeconfigServiceAccount
+	config := clientcmdv1.Config{
+		APIVersion: "v1",
+		Kind:       "Config",
+		Clusters: []clientcmdv1.NamedCluster{
+			{Name: clusterName, Cluster: clientcmdv1.Cluster{Server: server, CertificateAuthorityData: ca}},
+		},
+		AuthInfos: []clientcmdv1.NamedAuthInfo{
+			{Name: user, AuthInfo: clientcmdv1.AuthInfo{Token: token}},
+		},
+		Contexts: []clientcmdv1.NamedContext{
+			{Name: clusterName, Context: clientcmdv1.Context{Cluster: clusterName, AuthInfo: user}},
+		},
+		CurrentContext: clusterName,
+	}
+	return json.Marshal(config)
+}
+
+// setDegraded sets the KubeconfigDegraded condition of the ClusterOperator, False when err
+// is nil.
+func (r *KubeconfigReconciler) setDegraded(ctx context.Context, err error) error {
+	co := &configv1.ClusterOperator{}
+	if getErr := r.Get(ctx, client.ObjectKey{Name: clusterOperatorName}, co); getErr != nil {
+		if err != nil {
+			return err
+		}
+		return client.IgnoreNotFound(getErr)
+	}
+
+	cond := newClusterOperatorStatusCondition(KubeconfigDegraded, configv1.ConditionFalse, ReasonAsExpected, "")
+	if err != nil {
+		cond = newClusterOperatorStatusCondition(KubeconfigDegraded, configv1.ConditionTrue, ReasonKubeconfigFailed, err.Error())
+	}
+	if existing := v1helpers.FindStatusCondition(co.Status.Conditions, KubeconfigDegraded); existing != nil &&
+		existing.Status == cond.Status && existing.Message == cond.Message {
+		return err
+	}
+	v1helpers.SetStatusCondition(&co.Status.Conditions, cond)
+	if updateErr := r.Status().Update(ctx, co); updateErr != nil {
+		klog.Errorf("Unable to set the %s condition: %v", KubeconfigDegraded, updateErr)
+	}
+	return err
+}
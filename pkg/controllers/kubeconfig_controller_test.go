@@ -0,0 +1,88 @@
+package controllers
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	configv1 "github.com/openshift/api/config/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientcmdv1 "k8s.io/client-go/tools/clientcmd/api/v1"
+)
+
+func TestKubeconfigRotateIn(t *testing.T) {
+	now := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+	secret := func(expiry string) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{kubeconfigExpiryAnnotation: expiry}},
+			Data:       map[string][]byte{kubeconfigKey: []byte("kubeconfig")},
+		}
+	}
+	tests := []struct {
+		name   string
+		secret *corev1.Secret
+		want   time.Duration
+	}{
+		{
+			name:   "fresh token",
+			secret: secret("2021-10-02T12:00:00Z"),
+			want:   24*time.Hour - kubeconfigTokenExpiry/5,
+		},
+		{
+			name:   "due for rotation",
+			secret: secret("2021-10-01T16:00:00Z"),
+		},
+		{
+			name:   "expired",
+			secret: secret("2021-10-01T11:00:00Z"),
+		},
+		{
+			name:   "no expiry",
+			secret: secret(""),
+		},
+		{
+			name:   "no kubeconfig",
+			secret: &corev1.Secret{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := kubeconfigRotateIn(tt.secret, now); got != tt.want {
+				t.Errorf("kubeconfigRotateIn() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewKubeconfig(t *testing.T) {
+	b, err := newKubeconfig("ocp-abc12", "https://api-int.ocp.example.com:6443", []byte("ca"), "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := clientcmdv1.Config{}
+	if err := json.Unmarshal(b, &config); err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Contexts) != 1 || config.Contexts[0].Name != config.CurrentContext {
+		t.Fatalf("unexpected contexts %+v", config.Contexts)
+	}
+	if len(config.Clusters) != 1 || config.Clusters[0].Cluster.Server != "https://api-int.ocp.example.com:6443" ||
+		string(config.Clusters[0].Cluster.CertificateAuthorityData) != "ca" {
+		t.Errorf("unexpected clusters %+v", config.Clusters)
+	}
+	if len(config.AuthInfos) != 1 || config.AuthInfos[0].AuthInfo.Token != "token" {
+		t.Errorf("unexpected users %+v", config.AuthInfos)
+	}
+}
+
+func TestFoldDegraded(t *testing.T) {
+	existing := []configv1.ClusterOperatorStatusCondition{
+		newClusterOperatorStatusCondition(KubeconfigDegraded, configv1.ConditionTrue, ReasonKubeconfigFailed, "no token"),
+	}
+	for _, c := range foldDegraded(existing, newDeploymentsHealth(nil).conditions("4.10.0")) {
+		if c.Type == configv1.OperatorDegraded && (c.Status != configv1.ConditionTrue || c.Reason != ReasonKubeconfigFailed) {
+			t.Errorf("unexpected Degraded condition %+v", c)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// The metrics of the operator, served with the controller-runtime ones on the metrics endpoint
+// of the manager.
+var (
+	providerApplyDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "capi_operator_provider_apply_duration_seconds",
+		Help:    "Time taken to apply the objects of a provider, its ConfigMap and CR.",
+		Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"provider"})
+	providerRenderErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "capi_operator_provider_render_errors_total",
+		Help: "Number of failures to render the components of a provider, e.g. a missing image or an unsafe CRD upgrade.",
+	}, []string{"provider"})
+	driftCorrectionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "capi_operator_drift_corrections_total",
+		Help: "Number of out-of-band modifications of the operands reverted, by kind.",
+	}, []string{"kind"})
+	providerUpgradesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "capi_operator_provider_upgrades_total",
+		Help: "Number of provider upgrades started, completed and rolled back.",
+	}, []string{"provider", "result"})
+	providerVersionInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "capi_operator_provider_version_info",
+		Help: "The version of the providers installed, always 1.",
+	}, []string{"provider", "version"})
+	providerConditionGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "capi_operator_provider_condition",
+		Help: "Whether the providers installed are Ready, Installing and Degraded, 1 when they are, 0 otherwise.",
+	}, []string{"provider", "condition"})
+	providerUpgradeInProgress = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "capi_operator_provider_upgrade_in_progress",
+		Help: "1 while the upgrade of a provider waits for it to become healthy.",
+	}, []string{"provider"})
+)
+
+// the results of the provider upgrades
+const (
+	upgradeStarted    = "started"
+	upgradeCompleted  = "completed"
+	upgradeRolledBack = "rolled_back"
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		providerApplyDuration,
+		providerRenderErrors,
+		driftCorrectionsTotal,
+		providerUpgradesTotal,
+		providerVersionInfo,
+		providerConditionGauge,
+		providerUpgradeInProgress,
+	)
+}
+
+// applyDurations sums the time taken to apply the objects of each provider.
+type applyDurations map[string]time.Duration
+
+func (d applyDurations) add(obj client.Object, duration time.Duration) {
+	if key := providerKey(obj); key != "" {
+		d[key] += duration
+	}
+}
+
+func (d applyDurations) observe() {
+	for key, duration := range d {
+		providerApplyDuration.WithLabelValues(key).Observe(duration.Seconds())
+	}
+}
+
+// recordDriftCorrections counts the drifted objects, "<kind> <name>", by kind.
+func recordDriftCorrections(drifted []string) {
+	for _, obj := range drifted {
+		driftCorrectionsTotal.WithLabelValues(strings.SplitN(obj, " ", 2)[0]).Inc()
+	}
+}
+
+// setProviderVersions reports the versions of the provider CRs applied, the providers no
+// longer installed are dropped.
+func setProviderVersions(objs []client.Object) {
+	providerVersionInfo.Reset()
+	for _, obj := range objs {
+		if providerSpec(obj) != nil {
+			providerVersionInfo.WithLabelValues(providerKey(obj), providerVersion(obj)).Set(1)
+		}
+	}
+}
+
+// setProviderHealth reports the health of the provider, by condition.
+func setProviderHealth(key string, health map[string]bool) {
+	for condition, status := range health {
+		value := 0.0
+		if status {
+			value = 1
+		}
+		providerConditionGauge.WithLabelValues(key, condition).Set(value)
+	}
+}
+
+// setProviderUpgradesInProgress reports the providers whose upgrade is in progress.
+func setProviderUpgradesInProgress(keys []string) {
+	providerUpgradeInProgress.Reset()
+	for _, key := range keys {
+		providerUpgradeInProgress.WithLabelValues(key).Set(1)
+	}
+}
@@ -0,0 +1,64 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
+	operatorv1 "sigs.k8s.io/cluster-api/exp/operator/api/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// gather returns the values of the metrics of the collector, by their label values.
+func gather(t *testing.T, collector prometheus.Collector) map[string]float64 {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := map[string]float64{}
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			key := ""
+			for _, label := range metric.GetLabel() {
+				key += "/" + label.GetValue()
+			}
+			values[key] = metric.GetGauge().GetValue() + metric.GetCounter().GetValue()
+		}
+	}
+	return values
+}
+
+func TestSetProviderVersions(t *testing.T) {
+	setProviderVersions([]client.Object{
+		&operatorv1.CoreProvider{
+			ObjectMeta: metav1.ObjectMeta{Name: "cluster-api"},
+			Spec:       operatorv1.CoreProviderSpec{ProviderSpec: operatorv1.ProviderSpec{Version: pointer.String("v1.0.0")}},
+		},
+		&operatorv1.InfrastructureProvider{
+			ObjectMeta: metav1.ObjectMeta{Name: "aws"},
+			Spec:       operatorv1.InfrastructureProviderSpec{ProviderSpec: operatorv1.ProviderSpec{Version: pointer.String("v1.1.0")}},
+		},
+	})
+	want := map[string]float64{"/core-cluster-api/v1.0.0": 1, "/infrastructure-aws/v1.1.0": 1}
+	if diff := cmp.Diff(want, gather(t, providerVersionInfo)); diff != "" {
+		t.Error(diff)
+	}
+
+	setProviderVersions(nil)
+	if diff := cmp.Diff(map[string]float64{}, gather(t, providerVersionInfo)); diff != "" {
+		t.Errorf("the providers removed should be dropped: %s", diff)
+	}
+}
+
+func TestRecordDriftCorrections(t *testing.T) {
+	driftCorrectionsTotal.Reset()
+	recordDriftCorrections([]string{"ConfigMap cluster-api", "ConfigMap aws", "InfrastructureProvider aws"})
+	want := map[string]float64{"/ConfigMap": 2, "/InfrastructureProvider": 1}
+	if diff := cmp.Diff(want, gather(t, driftCorrectionsTotal)); diff != "" {
+		t.Error(diff)
+	}
+}
@@ -0,0 +1,69 @@
+package controllers
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+var (
+	// managedNamespaceLabels are the labels of the managed namespace: the violations of the
+	// restricted pod security admission profile are audited and warned about, and the
+	// providers are scraped by the cluster monitoring. The profile is not enforced, the
+	// imported assets are not all hardened for it yet (e.g. the privileged NMI DaemonSet of
+	// Azure), the label syncer is enabled to set the labels the operator doesn't own, e.g.
+	// enforce, from the SCCs of the service accounts. It skips the openshift- namespaces
+	// unless they opt in.
+	managedNamespaceLabels = map[string]string{
+		"openshift.io/cluster-monitoring":                "true",
+		"openshift.io/run-level":                         "0",
+		"pod-security.kubernetes.io/audit":               "restricted",
+		"pod-security.kubernetes.io/warn":                "restricted",
+		"security.openshift.io/scc.podSecurityLabelSync": "true",
+	}
+	// managedNamespaceAnnotations are the annotations of the managed namespace: its pods may
+	// be scheduled on any node, the control plane ones included, and run on the management
+	// CPUs of the partitioned nodes.
+	managedNamespaceAnnotations = map[string]string{
+		"openshift.io/node-selector":    "",
+		"workload.openshift.io/allowed": "management",
+	}
+)
+
+func namespacePredicates(name string) predicate.Funcs {
+	return predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		return obj.GetName() == name
+	})
+}
+
+// desiredNamespace returns the managed namespace with the labels and annotations the
+// operator owns, the other ones are left alone.
+func desiredNamespace(name string) *corev1.Namespace {
+	ns := &corev1.Namespace{
+		TypeMeta: metav1.TypeMeta{APIVersion: corev1.SchemeGroupVersion.String(), Kind: "Namespace"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Labels:      map[string]string{},
+			Annotations: map[string]string{},
+		},
+	}
+	for k, v := range managedNamespaceLabels {
+		ns.Labels[k] = v
+	}
+	for k, v := range managedNamespaceAnnotations {
+		ns.Annotations[k] = v
+	}
+	return ns
+}
+
+// reconcileNamespace creates the managed namespace, or restores the labels and annotations
+// the operator owns on it, before the operands are applied in it.
+func (r *ClusterOperatorReconciler) reconcileNamespace(ctx context.Context) error {
+	updater := NewUpdater([]client.Object{desiredNamespace(r.ManagedNamespace)})
+	err := updater.Apply(ctx, r.Client, r.Recorder)
+	r.drift.record(updater.Drifted())
+	return err
+}
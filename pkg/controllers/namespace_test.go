@@ -0,0 +1,32 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDesiredNamespace(t *testing.T) {
+	ns := desiredNamespace(DefaultManagedNamespace)
+	if ns.Name != DefaultManagedNamespace {
+		t.Errorf("unexpected namespace %s", ns.Name)
+	}
+	if diff := cmp.Diff(managedNamespaceLabels, ns.Labels); diff != "" {
+		t.Error(diff)
+	}
+	if diff := cmp.Diff(managedNamespaceAnnotations, ns.Annotations); diff != "" {
+		t.Error(diff)
+	}
+
+	if _, ok := ns.Labels["pod-security.kubernetes.io/enforce"]; ok {
+		t.Error("the restricted pod security profile should not be enforced")
+	}
+	if ns.Labels["security.openshift.io/scc.podSecurityLabelSync"] != "true" {
+		t.Error("the pod security label syncer should be enabled")
+	}
+
+	ns.Labels["pod-security.kubernetes.io/audit"] = "privileged"
+	if managedNamespaceLabels["pod-security.kubernetes.io/audit"] != "restricted" {
+		t.Error("the labels of the namespace should be copied")
+	}
+}
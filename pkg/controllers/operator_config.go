@@ -0,0 +1,414 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/klog"
+	operatorv1 "sigs.k8s.io/cluster-api/exp/operator/api/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	capiv1alpha1 "github.com/openshift/cluster-capi-operator/api/v1alpha1"
+	"github.com/openshift/cluster-capi-operator/pkg/util"
+)
+
+// providerPausedAnnotation pauses a provider, set to "true" on its CR.
+const providerPausedAnnotation = "capi.openshift.io/paused"
+
+var (
+	// allowedContainerArgs are the arguments of the provider managers a container override can
+	// set, with the "--<kind>-concurrency" ones. The verbosity is set by the log level.
+	allowedContainerArgs = []string{"--sync-period", "--service-endpoints"}
+	// allowedContainerEnv are the environment variables a container override can set, with
+	// the ones of allowedContainerEnvPrefixes.
+	allowedContainerEnv         = []string{"HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY", "GOMAXPROCS"}
+	allowedContainerEnvPrefixes = []string{"AWS_", "AZURE_", "GCP_", "OS_"}
+)
+
+// logLevelVerbosity maps the log levels of the configuration to the verbosity of the
+// provider managers.
+var logLevelVerbosity = map[capiv1alpha1.LogLevel]int{
+	capiv1alpha1.Normal:   2,
+	capiv1alpha1.Debug:    4,
+	capiv1alpha1.Trace:    6,
+	capiv1alpha1.TraceAll: 8,
+}
+
+// getOperatorConfig returns the ClusterAPIConfiguration, the defaults when there is none.
+func (r *ClusterOperatorReconciler) getOperatorConfig(ctx context.Context) (*capiv1alpha1.ClusterAPIConfiguration, error) {
+	config := &capiv1alpha1.ClusterAPIConfiguration{}
+	err := r.Get(ctx, client.ObjectKey{Name: capiv1alpha1.ClusterAPIConfigurationName}, config)
+	if apierrors.IsNotFound(err) {
+		return &capiv1alpha1.ClusterAPIConfiguration{}, nil
+	}
+	return config, err
+}
+
+// providerKey returns the "<type>-<name>" of a provider, or of the ConfigMap of its
+// components, e.g. "infrastructure-aws", the providers are referred to by it in the
+// configuration.
+func providerKey(obj client.Object) string {
+	switch obj.(type) {
+	case *corev1.ConfigMap:
+		labels := obj.GetLabels()
+		if labels[providerTypeLabel] == "" || labels[providerNameLabel] == "" {
+			return ""
+		}
+		return labels[providerTypeLabel] + "-" + labels[providerNameLabel]
+	case *operatorv1.CoreProvider:
+		return "core-" + obj.GetName()
+	case *operatorv1.InfrastructureProvider:
+		return "infrastructure-" + obj.GetName()
+	case *operatorv1.BootstrapProvider:
+		return "bootstrap-" + obj.GetName()
+	case *operatorv1.ControlPlaneProvider:
+		return "control-plane-" + obj.GetName()
+	case *unstructured.Unstructured:
+		// the CRDs of the shards of the provider ConfigMaps, see unshardComponents
+		return obj.GetLabels()[providerLabel]
+	}
+	return ""
+}
+
+// isProviderEnabled returns whether the configuration enables the provider, they all are
+// when it doesn't list any, but the ones it disables.
+func isProviderEnabled(config capiv1alpha1.ClusterAPIConfigurationSpec, key string) bool {
+	if util.ContainsString(config.DisabledProviders, key) {
+		return false
+	}
+	return len(config.EnabledProviders) == 0 || util.ContainsString(config.EnabledProviders, key)
+}
+
+// providerKeys returns the keys of the provider CRs include returns true for, sorted.
+func providerKeys(objs []client.Object, include func(key string) bool) []string {
+	keys := []string{}
+	for _, obj := range objs {
+		if key := providerKey(obj); providerSpec(obj) != nil && include(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// removeDisabledProviders deletes the providers the configuration disables, their CRs first,
+// so that the CAPI Operator removes their components, and their ConfigMaps once the CRs are
+// gone. It returns how many providers are still being deleted.
+func (r *ClusterOperatorReconciler) removeDisabledProviders(ctx context.Context, objs []client.Object, disabled func(key string) bool) (int, error) {
+	remaining, err := NewUpdater(objs).WithFilter(func(obj client.Object) bool {
+		return providerSpec(obj) != nil && disabled(providerKey(obj))
+	}).Delete(ctx, r.Client, r.Recorder)
+	if err != nil || remaining > 0 {
+		return remaining, err
+	}
+
+	// the previous versions of the ConfigMaps too, they are no longer pruned
+	cms := &corev1.ConfigMapList{}
+	if err := r.List(ctx, cms, client.InNamespace(r.ManagedNamespace), client.HasLabels{providerTypeLabel, providerNameLabel}); err != nil {
+		return 0, err
+	}
+	for i := range cms.Items {
+		cm := &cms.Items[i]
+		key := providerKey(cm)
+		if !disabled(key) || cm.DeletionTimestamp != nil {
+			continue
+		}
+		klog.Infof("deleting ConfigMap %s of disabled provider %s", cm.Name, key)
+		if err := r.Delete(ctx, cm); err != nil && !apierrors.IsNotFound(err) {
+			return 0, err
+		}
+		r.Recorder.Eventf(cm, corev1.EventTypeNormal, "Deleted", "provider %s is disabled", key)
+	}
+	return 0, nil
+}
+
+// isProviderPaused returns whether the configuration, or the paused annotation of the live
+// provider CR, pauses the provider.
+func isProviderPaused(config capiv1alpha1.ClusterAPIConfigurationSpec, key string, live client.Object) bool {
+	if util.ContainsString(config.PausedProviders, key) {
+		return true
+	}
+	return live != nil && live.GetAnnotations()[providerPausedAnnotation] == "true"
+}
+
+// pausedProviders returns the keys of the providers installed that are paused, sorted.
+func (r *ClusterOperatorReconciler) pausedProviders(ctx context.Context, objs []client.Object, config capiv1alpha1.ClusterAPIConfigurationSpec, installed func(key string) bool) ([]string, error) {
+	paused := []string{}
+	for _, obj := range objs {
+		key := providerKey(obj)
+		if providerSpec(obj) == nil || !installed(key) {
+			continue
+		}
+		live, ok := obj.DeepCopyObject().(client.Object)
+		if !ok {
+			return nil, fmt.Errorf("unexpected provider %s", key)
+		}
+		if err := r.Get(ctx, client.ObjectKeyFromObject(obj), live); apierrors.IsNotFound(err) {
+			live = nil
+		} else if err != nil {
+			return nil, err
+		}
+		if isProviderPaused(config, key, live) {
+			paused = append(paused, key)
+		}
+	}
+	sort.Strings(paused)
+	return paused, nil
+}
+
+// applyOperatorConfig sets the log level, the resource and the scheduling overrides of the
+// configuration on the spec of the provider. The overrides are set over the rendering of its
+// components, the CAPI Operator replaces the fields of the Deployments set on the spec.
+func applyOperatorConfig(spec *operatorv1.ProviderSpec, key string, config capiv1alpha1.ClusterAPIConfigurationSpec, rendered renderedProvider) {
+	if verbosity, ok := logLevelVerbosity[config.LogLevel]; ok {
+		if spec.Manager == nil {
+			spec.Manager = &operatorv1.ManagerSpec{}
+		}
+		spec.Manager.Verbosity = verbosity
+	}
+
+	for _, override := range config.ResourceOverrides {
+		if override.Provider != key {
+			continue
+		}
+		container := override.Container
+		if container == "" {
+			container = managerContainer
+		}
+		base, ok := rendered.resources[container]
+		if !ok && rendered.resources != nil {
+			klog.Warningf("resource override of %s: no container %s in the components", key, container)
+		}
+		containerSpec(spec, container).Resources = overrideResources(base, override.Resources)
+	}
+
+	for _, override := range config.ContainerOverrides {
+		if override.Provider != key {
+			continue
+		}
+		container := override.Container
+		if container == "" {
+			container = managerContainer
+		}
+		overrideContainer(containerSpec(spec, container), key, override)
+	}
+
+	for _, override := range config.SchedulingOverrides {
+		if override.Provider != key {
+			continue
+		}
+		if spec.Deployment == nil {
+			spec.Deployment = &operatorv1.DeploymentSpec{}
+		}
+		overrideScheduling(spec.Deployment, rendered, override)
+	}
+}
+
+// overrideScheduling sets the scheduling constraints of the rendered pods with the ones of
+// override added on the Deployment spec.
+func overrideScheduling(dep *operatorv1.DeploymentSpec, rendered renderedProvider, override capiv1alpha1.SchedulingOverride) {
+	if len(override.NodeSelector) > 0 {
+		nodeSelector := map[string]string{}
+		for k, v := range rendered.nodeSelector {
+			nodeSelector[k] = v
+		}
+		for k, v := range override.NodeSelector {
+			nodeSelector[k] = v
+		}
+		dep.NodeSelector = nodeSelector
+	}
+
+	if len(override.Tolerations) > 0 {
+		tolerations := append([]corev1.Toleration{}, rendered.tolerations...)
+		for _, toleration := range override.Tolerations {
+			found := false
+			for _, t := range tolerations {
+				if equality.Semantic.DeepEqual(t, toleration) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				tolerations = append(tolerations, toleration)
+			}
+		}
+		dep.Tolerations = tolerations
+	}
+
+	if override.Affinity != nil {
+		// over the affinity of the topology, when set
+		affinity := &corev1.Affinity{}
+		if dep.Affinity != nil {
+			affinity = dep.Affinity.DeepCopy()
+		} else if rendered.affinity != nil {
+			affinity = rendered.affinity.DeepCopy()
+		}
+		if override.Affinity.NodeAffinity != nil {
+			affinity.NodeAffinity = override.Affinity.NodeAffinity.DeepCopy()
+		}
+		if override.Affinity.PodAffinity != nil {
+			affinity.PodAffinity = override.Affinity.PodAffinity.DeepCopy()
+		}
+		if override.Affinity.PodAntiAffinity != nil {
+			affinity.PodAntiAffinity = override.Affinity.PodAntiAffinity.DeepCopy()
+		}
+		dep.Affinity = affinity
+	}
+}
+
+// containerSpec returns the spec of the container of the provider Deployment, added when
+// there is none.
+func containerSpec(spec *operatorv1.ProviderSpec, name string) *operatorv1.ContainerSpec {
+	if spec.Deployment == nil {
+		spec.Deployment = &operatorv1.DeploymentSpec{}
+	}
+	for i := range spec.Deployment.Containers {
+		if spec.Deployment.Containers[i].Name == name {
+			return &spec.Deployment.Containers[i]
+		}
+	}
+	spec.Deployment.Containers = append(spec.Deployment.Containers, operatorv1.ContainerSpec{Name: name})
+	return &spec.Deployment.Containers[len(spec.Deployment.Containers)-1]
+}
+
+// overrideResources returns the base resources with the requests and limits of override,
+// the CAPI Operator replaces the resources of the container with them.
+func overrideResources(base, override corev1.ResourceRequirements) *corev1.ResourceRequirements {
+	resources := base.DeepCopy()
+	for name, quantity := range override.Requests {
+		if resources.Requests == nil {
+			resources.Requests = corev1.ResourceList{}
+		}
+		resources.Requests[name] = quantity
+	}
+	for name, quantity := range override.Limits {
+		if resources.Limits == nil {
+			resources.Limits = corev1.ResourceList{}
+		}
+		resources.Limits[name] = quantity
+	}
+	return resources
+}
+
+// isAllowedContainerArg returns whether the argument can be set by a container override, the
+// tuning ones, not the ones the operator or the CAPI Operator set.
+func isAllowedContainerArg(arg string) bool {
+	return util.ContainsString(allowedContainerArgs, arg) || strings.HasSuffix(arg, "-concurrency")
+}
+
+// isAllowedContainerEnv returns whether the environment variable can be set by a container
+// override, the proxy and cloud endpoint ones.
+func isAllowedContainerEnv(name string) bool {
+	if util.ContainsString(allowedContainerEnv, name) {
+		return true
+	}
+	for _, prefix := range allowedContainerEnvPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// overrideContainer sets the arguments and environment variables of the override allowed on
+// the container, the others are ignored.
+func overrideContainer(container *operatorv1.ContainerSpec, key string, override capiv1alpha1.ContainerOverride) {
+	for arg, value := range override.Args {
+		if !isAllowedContainerArg(arg) {
+			klog.Warningf("container override of %s: ignoring argument %s, not allowed", key, arg)
+			continue
+		}
+		if container.Args == nil {
+			container.Args = map[string]string{}
+		}
+		container.Args[arg] = value
+	}
+
+	names := make([]string, 0, len(override.Env))
+	for name := range override.Env {
+		names = append(names, name)
+	}
+	// the environment is a list, sorted so that the spec applied is stable
+	sort.Strings(names)
+	for _, name := range names {
+		if !isAllowedContainerEnv(name) {
+			klog.Warningf("container override of %s: ignoring environment variable %s, not allowed", key, name)
+			continue
+		}
+		env := corev1.EnvVar{Name: name, Value: override.Env[name]}
+		found := false
+		for i := range container.Env {
+			if container.Env[i].Name == name {
+				container.Env[i] = env
+				found = true
+			}
+		}
+		if !found {
+			container.Env = append(container.Env, env)
+		}
+	}
+}
+
+// renderedProvider is what the operator config is applied over, from the Deployments of the
+// components of a provider.
+type renderedProvider struct {
+	// resources of the containers, by container name
+	resources map[string]corev1.ResourceRequirements
+	// the scheduling constraints of the pods of the manager Deployment
+	nodeSelector map[string]string
+	tolerations  []corev1.Toleration
+	affinity     *corev1.Affinity
+	// the labels of the pods of the manager Deployment
+	podLabels map[string]string
+}
+
+// renderedProviders returns the rendering of the components of the providers, by provider key.
+// It is read from the default components, the topology variants only change the replicas and
+// strategy.
+func renderedProviders(objs []client.Object) (map[string]renderedProvider, error) {
+	rendered := map[string]renderedProvider{}
+	for _, obj := range objs {
+		cm, ok := obj.(*corev1.ConfigMap)
+		if !ok || !hasProviderComponents(cm) || cm.Labels[providerTopologyLabel] != "" {
+			continue
+		}
+		deps, err := componentDeployments(cm.Data[componentsKey])
+		if err != nil {
+			return nil, fmt.Errorf("invalid components in ConfigMap %s: %v", cm.Name, err)
+		}
+		provider := renderedProvider{resources: map[string]corev1.ResourceRequirements{}}
+		manager := false
+		for _, dep := range deps {
+			pod := dep.Spec.Template.Spec
+			for _, c := range pod.Containers {
+				provider.resources[c.Name] = c.Resources
+				if c.Name == managerContainer && !manager {
+					manager = true
+					provider.nodeSelector = pod.NodeSelector
+					provider.tolerations = pod.Tolerations
+					provider.affinity = pod.Affinity
+					provider.podLabels = dep.Spec.Template.Labels
+				}
+			}
+		}
+		rendered[providerKey(cm)] = provider
+	}
+	return rendered, nil
+}
+
+// setOperatorConfigObserved records the generation of the configuration applied, with the
+// status of the providers.
+func (r *ClusterOperatorReconciler) setOperatorConfigObserved(ctx context.Context, config *capiv1alpha1.ClusterAPIConfiguration, status capiv1alpha1.ClusterAPIConfigurationStatus) error {
+	status.ObservedGeneration = config.Generation
+	if config.Name == "" || equality.Semantic.DeepEqual(config.Status, status) {
+		return nil
+	}
+	config.Status = status
+	return r.Status().Update(ctx, config)
+}
@@ -0,0 +1,208 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	operatorv1 "sigs.k8s.io/cluster-api/exp/operator/api/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	capiv1alpha1 "github.com/openshift/cluster-capi-operator/api/v1alpha1"
+)
+
+func TestApplyOperatorConfig(t *testing.T) {
+	resources := corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("512Mi")},
+	}
+	tests := []struct {
+		name     string
+		spec     operatorv1.ProviderSpec
+		config   capiv1alpha1.ClusterAPIConfigurationSpec
+		rendered renderedProvider
+		want     operatorv1.ProviderSpec
+	}{
+		{
+			name: "defaults",
+		},
+		{
+			name:   "log level",
+			config: capiv1alpha1.ClusterAPIConfigurationSpec{LogLevel: capiv1alpha1.Debug},
+			want:   operatorv1.ProviderSpec{Manager: &operatorv1.ManagerSpec{Verbosity: 4}},
+		},
+		{
+			name: "resource override",
+			spec: operatorv1.ProviderSpec{Deployment: &operatorv1.DeploymentSpec{
+				Containers: []operatorv1.ContainerSpec{{Name: "manager", Args: map[string]string{"--v": "2"}}},
+			}},
+			config: capiv1alpha1.ClusterAPIConfigurationSpec{ResourceOverrides: []capiv1alpha1.ResourceOverride{
+				{Provider: "infrastructure-aws", Container: "manager", Resources: resources},
+				{Provider: "infrastructure-aws", Container: "kube-rbac-proxy", Resources: resources},
+				{Provider: "infrastructure-azure", Container: "manager"},
+			}},
+			want: operatorv1.ProviderSpec{Deployment: &operatorv1.DeploymentSpec{
+				Containers: []operatorv1.ContainerSpec{
+					{Name: "manager", Args: map[string]string{"--v": "2"}, Resources: &resources},
+					{Name: "kube-rbac-proxy", Resources: &resources},
+				},
+			}},
+		},
+		{
+			name: "resource override over the components",
+			config: capiv1alpha1.ClusterAPIConfigurationSpec{ResourceOverrides: []capiv1alpha1.ResourceOverride{
+				{Provider: "infrastructure-aws", Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("512Mi")},
+					Limits:   corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("1Gi")},
+				}},
+			}},
+			rendered: renderedProvider{resources: map[string]corev1.ResourceRequirements{
+				"manager": {Requests: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("10m"),
+					corev1.ResourceMemory: resource.MustParse("50Mi"),
+				}},
+			}},
+			want: operatorv1.ProviderSpec{Deployment: &operatorv1.DeploymentSpec{
+				Containers: []operatorv1.ContainerSpec{{Name: "manager", Resources: &corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{
+						corev1.ResourceCPU:    resource.MustParse("10m"),
+						corev1.ResourceMemory: resource.MustParse("512Mi"),
+					},
+					Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("1Gi")},
+				}}},
+			}},
+		},
+		{
+			name: "scheduling override over the components",
+			config: capiv1alpha1.ClusterAPIConfigurationSpec{SchedulingOverrides: []capiv1alpha1.SchedulingOverride{
+				{
+					Provider:     "infrastructure-aws",
+					NodeSelector: map[string]string{"node-role.kubernetes.io/infra": ""},
+					Tolerations: []corev1.Toleration{
+						{Key: "node-role.kubernetes.io/master", Effect: corev1.TaintEffectNoSchedule},
+						{Key: "node-role.kubernetes.io/infra", Effect: corev1.TaintEffectNoSchedule},
+					},
+					Affinity: &corev1.Affinity{PodAntiAffinity: &corev1.PodAntiAffinity{}},
+				},
+				{Provider: "infrastructure-azure", NodeSelector: map[string]string{"azure": ""}},
+			}},
+			rendered: renderedProvider{
+				nodeSelector: map[string]string{"node-role.kubernetes.io/master": ""},
+				tolerations:  []corev1.Toleration{{Key: "node-role.kubernetes.io/master", Effect: corev1.TaintEffectNoSchedule}},
+				affinity:     &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{}},
+			},
+			want: operatorv1.ProviderSpec{Deployment: &operatorv1.DeploymentSpec{
+				NodeSelector: map[string]string{"node-role.kubernetes.io/master": "", "node-role.kubernetes.io/infra": ""},
+				Tolerations: []corev1.Toleration{
+					{Key: "node-role.kubernetes.io/master", Effect: corev1.TaintEffectNoSchedule},
+					{Key: "node-role.kubernetes.io/infra", Effect: corev1.TaintEffectNoSchedule},
+				},
+				Affinity: &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{}, PodAntiAffinity: &corev1.PodAntiAffinity{}},
+			}},
+		},
+		{
+			name: "container override",
+			spec: operatorv1.ProviderSpec{Deployment: &operatorv1.DeploymentSpec{
+				Containers: []operatorv1.ContainerSpec{{Name: "manager", Env: []corev1.EnvVar{{Name: "HTTP_PROXY", Value: "http://old"}}}},
+			}},
+			config: capiv1alpha1.ClusterAPIConfigurationSpec{ContainerOverrides: []capiv1alpha1.ContainerOverride{
+				{
+					Provider: "infrastructure-aws",
+					Args:     map[string]string{"--sync-period": "5m", "--awsmachine-concurrency": "20", "--metrics-bind-addr": ":8081"},
+					Env:      map[string]string{"HTTP_PROXY": "http://proxy", "AWS_ENDPOINT_URL": "https://ec2.example.com", "PATH": "/tmp"},
+				},
+				{Provider: "infrastructure-aws", Container: "kube-rbac-proxy", Env: map[string]string{"GOMAXPROCS": "1"}},
+			}},
+			want: operatorv1.ProviderSpec{Deployment: &operatorv1.DeploymentSpec{
+				Containers: []operatorv1.ContainerSpec{
+					{
+						Name: "manager",
+						Args: map[string]string{"--sync-period": "5m", "--awsmachine-concurrency": "20"},
+						Env: []corev1.EnvVar{
+							{Name: "HTTP_PROXY", Value: "http://proxy"},
+							{Name: "AWS_ENDPOINT_URL", Value: "https://ec2.example.com"},
+						},
+					},
+					{Name: "kube-rbac-proxy", Env: []corev1.EnvVar{{Name: "GOMAXPROCS", Value: "1"}}},
+				},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			applyOperatorConfig(&tt.spec, "infrastructure-aws", tt.config, tt.rendered)
+			if diff := cmp.Diff(tt.want, tt.spec); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestIsProviderEnabled(t *testing.T) {
+	all := capiv1alpha1.ClusterAPIConfigurationSpec{}
+	if !isProviderEnabled(all, "infrastructure-aws") {
+		t.Error("providers should be enabled by default")
+	}
+	aws := capiv1alpha1.ClusterAPIConfigurationSpec{EnabledProviders: []string{"core-cluster-api", "infrastructure-aws"}}
+	if !isProviderEnabled(aws, "infrastructure-aws") || isProviderEnabled(aws, "infrastructure-azure") {
+		t.Error("only the listed providers should be enabled")
+	}
+	core := capiv1alpha1.ClusterAPIConfigurationSpec{DisabledProviders: []string{"infrastructure-aws"}}
+	if isProviderEnabled(core, "infrastructure-aws") || !isProviderEnabled(core, "core-cluster-api") {
+		t.Error("only the providers not disabled should be enabled")
+	}
+	objs := []client.Object{
+		&operatorv1.CoreProvider{ObjectMeta: metav1.ObjectMeta{Name: "cluster-api"}},
+		&operatorv1.InfrastructureProvider{ObjectMeta: metav1.ObjectMeta{Name: "aws"}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "aws-v0.7.0", Labels: map[string]string{
+			providerTypeLabel: "infrastructure",
+			providerNameLabel: "aws",
+		}}},
+	}
+	if diff := cmp.Diff([]string{"infrastructure-aws"}, providerKeys(objs, func(key string) bool { return !isProviderEnabled(core, key) })); diff != "" {
+		t.Errorf("unexpected disabled providers: %s", diff)
+	}
+	if got := providerKey(&operatorv1.ControlPlaneProvider{ObjectMeta: metav1.ObjectMeta{Name: "kubeadm"}}); got != "control-plane-kubeadm" {
+		t.Errorf("unexpected provider key %q", got)
+	}
+}
+
+func TestIsProviderPaused(t *testing.T) {
+	annotated := &operatorv1.InfrastructureProvider{ObjectMeta: metav1.ObjectMeta{
+		Name:        "aws",
+		Annotations: map[string]string{providerPausedAnnotation: "true"},
+	}}
+	tests := []struct {
+		name   string
+		config capiv1alpha1.ClusterAPIConfigurationSpec
+		live   client.Object
+		want   bool
+	}{
+		{
+			name: "not paused",
+			live: &operatorv1.InfrastructureProvider{ObjectMeta: metav1.ObjectMeta{Name: "aws"}},
+		},
+		{
+			name:   "paused by the configuration",
+			config: capiv1alpha1.ClusterAPIConfigurationSpec{PausedProviders: []string{"infrastructure-aws"}},
+			want:   true,
+		},
+		{
+			name:   "another provider paused",
+			config: capiv1alpha1.ClusterAPIConfigurationSpec{PausedProviders: []string{"core-cluster-api"}},
+		},
+		{
+			name: "paused by the annotation",
+			live: annotated,
+			want: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isProviderPaused(tt.config, "infrastructure-aws", tt.live); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,18 +2,21 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"reflect"
 	"strings"
 
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/equality"
-	"k8s.io/apimachinery/pkg/api/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/klog/v2"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	configv1 "github.com/openshift/api/config/v1"
+	capiv1alpha1 "github.com/openshift/cluster-capi-operator/api/v1alpha1"
 	"github.com/openshift/library-go/pkg/config/clusteroperator/v1helpers"
 )
 
@@ -22,34 +25,105 @@ const (
 	ReasonInitializing = "Initializing"
 	ReasonSyncing      = "SyncingResources"
 	ReasonSyncFailed   = "SyncingFailed"
+	// ReasonFeatureGateDisabled is set on Available while the feature gate doesn't enable cluster api.
+	ReasonFeatureGateDisabled = "FeatureGateDisabled"
+	// ReasonUnsupportedPlatform is set on Available when cluster api doesn't support the platform.
+	ReasonUnsupportedPlatform = "UnsupportedPlatform"
+	// ReasonProvidersPaused is set on Upgradeable while providers are paused.
+	ReasonProvidersPaused = "ProvidersPaused"
+	// ReasonUninstalled is set on Available while the configuration uninstalls cluster api.
+	ReasonUninstalled = "Uninstalled"
+	// ReasonUninstalling is set on Progressing while cluster api is being removed.
+	ReasonUninstalling = "Uninstalling"
+	// ReasonMissingImages is set on Degraded when the payload has no image for a provider.
+	ReasonMissingImages = "MissingImages"
 )
 
-// setStatusAvailable sets the Available condition to True, with the given reason
-// and message, and sets both the Progressing and Degraded conditions to False.
-func (r *ClusterOperatorReconciler) setStatusAvailable(ctx context.Context) error {
+// setStatusFromDeployments sets the conditions of the ClusterOperator from the health of the
+// Deployments of the managed namespace. The operator version is reported once they are all
+// available and rolled out, so the CVO knows when an upgrade of CAPI is complete. Available
+// tells why, when cluster api isn't installed.
+func (r *ClusterOperatorReconciler) setStatusFromDeployments(ctx context.Context, inactive *operandsInactive) error {
 	co, err := r.getOrCreateClusterOperator(ctx)
 	if err != nil {
-		klog.Errorf("Unable to set cluster operator status available: %v", err)
+		klog.Errorf("Unable to set cluster operator status: %v", err)
 		return err
 	}
 
-	conds := []configv1.ClusterOperatorStatusCondition{
-		newClusterOperatorStatusCondition(configv1.OperatorAvailable, configv1.ConditionTrue, ReasonAsExpected,
-			fmt.Sprintf("Cluster CAPI Operator is available at %s", r.ReleaseVersion)),
-		newClusterOperatorStatusCondition(configv1.OperatorProgressing, configv1.ConditionFalse, ReasonAsExpected, ""),
-		newClusterOperatorStatusCondition(configv1.OperatorDegraded, configv1.ConditionFalse, ReasonAsExpected, ""),
-		newClusterOperatorStatusCondition(configv1.OperatorUpgradeable, configv1.ConditionTrue, ReasonAsExpected, ""),
+	deps := &appsv1.DeploymentList{}
+	if err := r.Client.List(ctx, deps, client.InNamespace(r.ManagedNamespace)); err != nil {
+		return fmt.Errorf("failed to list the deployments of %s: %v", r.ManagedNamespace, err)
 	}
+	health := newDeploymentsHealth(deps.Items)
 
-	co.Status.Versions = []configv1.OperandVersion{{Name: operatorVersionKey, Version: r.ReleaseVersion}}
-	klog.V(2).Info("Syncing status: available")
+	if health.settled() {
+		co.Status.Versions = []configv1.OperandVersion{{Name: operatorVersionKey, Version: r.ReleaseVersion}}
+	}
+	conds := health.conditions(r.ReleaseVersion)
+	if inactive != nil {
+		conds = inactive.setAvailable(conds)
+	}
+	conds = foldDegraded(co.Status.Conditions, conds)
+	if c := r.upgrades.degradedCondition(); c != nil {
+		v1helpers.SetStatusCondition(&conds, *c)
+	}
+	if len(r.paused) > 0 {
+		v1helpers.SetStatusCondition(&conds, newClusterOperatorStatusCondition(configv1.OperatorUpgradeable, configv1.ConditionFalse,
+			ReasonProvidersPaused, fmt.Sprintf("Providers paused: %s", strings.Join(r.paused, ", "))))
+	}
+	conds = append(conds, r.drift.condition())
+	klog.V(2).Infof("Syncing status: unavailable %v, progressing %v, degraded %v", health.unavailable, health.progressing, health.degraded)
 	return r.syncStatus(ctx, co, conds)
 }
 
+// controllerDegradedConditions are the conditions of the ClusterOperator set by the other
+// controllers of the operator when they fail, folded into Degraded.
+var controllerDegradedConditions = []configv1.ClusterStatusConditionType{KubeconfigDegraded}
+
+// foldDegraded sets Degraded to True, when it isn't already, if one of the controllers of the
+// operator is degraded.
+func foldDegraded(existing, conds []configv1.ClusterOperatorStatusCondition) []configv1.ClusterOperatorStatusCondition {
+	for _, condType := range controllerDegradedConditions {
+		c := v1helpers.FindStatusCondition(existing, condType)
+		if c == nil || c.Status != configv1.ConditionTrue {
+			continue
+		}
+		for i := range conds {
+			if conds[i].Type == configv1.OperatorDegraded && conds[i].Status != configv1.ConditionTrue {
+				conds[i] = newClusterOperatorStatusCondition(configv1.OperatorDegraded, configv1.ConditionTrue, c.Reason, c.Message)
+			}
+		}
+	}
+	return conds
+}
+
+// operandsInactive tells why cluster api isn't installed, e.g. disabled by the feature gate.
+type operandsInactive struct {
+	reason  string
+	message string
+	// progress is the step of the removal of cluster api in progress, empty once removed
+	progress string
+}
+
+// setAvailable reports on the Available condition, when True, why cluster api isn't installed,
+// and on Progressing the removal in progress.
+func (i operandsInactive) setAvailable(conds []configv1.ClusterOperatorStatusCondition) []configv1.ClusterOperatorStatusCondition {
+	for j := range conds {
+		if conds[j].Type == configv1.OperatorAvailable && conds[j].Status == configv1.ConditionTrue {
+			conds[j].Reason = i.reason
+			conds[j].Message = i.message
+		}
+		if conds[j].Type == configv1.OperatorProgressing && i.progress != "" {
+			conds[j] = newClusterOperatorStatusCondition(configv1.OperatorProgressing, configv1.ConditionTrue, ReasonUninstalling, i.progress)
+		}
+	}
+	return conds
+}
+
 // setStatusDegraded sets the Degraded condition to True, with the given reason and
 // message, and sets the upgradeable condition.  It does not modify any existing
 // Available or Progressing conditions.
-func (r *ClusterOperatorReconciler) setStatusDegraded(ctx context.Context, reconcileErr error) error { //nolint TODO:remove during refatoring
+func (r *ClusterOperatorReconciler) setStatusDegraded(ctx context.Context, reconcileErr error) error {
 	co, err := r.getOrCreateClusterOperator(ctx)
 	if err != nil {
 		klog.Errorf("Unable to set cluster operator status degraded: %v", err)
@@ -61,14 +135,29 @@ func (r *ClusterOperatorReconciler) setStatusDegraded(ctx context.Context, recon
 
 	var message string
 	if !reflect.DeepEqual(desiredVersions, currentVersions) {
-		message = fmt.Sprintf("Failed when progressing towards %s because %e", printOperandVersions(desiredVersions), reconcileErr)
+		message = fmt.Sprintf("Failed when progressing towards %s because %v", printOperandVersions(desiredVersions), reconcileErr)
 	} else {
-		message = fmt.Sprintf("Failed to resync for %s because %e", printOperandVersions(desiredVersions), reconcileErr)
+		message = fmt.Sprintf("Failed to resync for %s because %v", printOperandVersions(desiredVersions), reconcileErr)
+	}
+
+	// the objects applied not ready in time, the CRD upgrades refused and the images missing
+	// have their own reason
+	reason := ReasonSyncFailed
+	var phaseErr *applyPhaseError
+	var crdErr *unsafeCRDUpgradeError
+	var imagesErr *missingImagesError
+	switch {
+	case errors.As(reconcileErr, &phaseErr):
+		reason = phaseErr.reason
+	case errors.As(reconcileErr, &crdErr):
+		reason = ReasonUnsafeCRDUpgrade
+	case errors.As(reconcileErr, &imagesErr):
+		reason = ReasonMissingImages
 	}
 
 	conds := []configv1.ClusterOperatorStatusCondition{
 		newClusterOperatorStatusCondition(configv1.OperatorDegraded, configv1.ConditionTrue,
-			ReasonSyncFailed, message),
+			reason, message),
 		newClusterOperatorStatusCondition(configv1.OperatorUpgradeable, configv1.ConditionFalse, ReasonAsExpected, ""),
 	}
 
@@ -85,7 +174,7 @@ func (r *ClusterOperatorReconciler) getOrCreateClusterOperator(ctx context.Conte
 		Status: configv1.ClusterOperatorStatus{},
 	}
 	err := r.Client.Get(ctx, client.ObjectKey{Name: clusterOperatorName}, co)
-	if errors.IsNotFound(err) {
+	if apierrors.IsNotFound(err) {
 		klog.Infof("ClusterOperator does not exist, creating a new one.")
 
 		err = r.Client.Create(ctx, co)
@@ -123,6 +212,7 @@ func (r *ClusterOperatorReconciler) relatedObjects() []configv1.ObjectReference
 		{Group: "", Resource: "serviceaccounts", Name: "cluster-capi-operator"},
 		{Group: "", Resource: "configmaps", Name: "cluster-capi-operator-images"},
 		{Group: "apps", Resource: "deployments", Name: "cluster-capi-operator"},
+		{Group: capiv1alpha1.GroupVersion.Group, Resource: "clusterapiconfigurations", Name: capiv1alpha1.ClusterAPIConfigurationName},
 	}
 }
 
@@ -138,7 +228,7 @@ func newClusterOperatorStatusCondition(conditionType configv1.ClusterStatusCondi
 	}
 }
 
-func printOperandVersions(versions []configv1.OperandVersion) string { //nolint TODO:remove during refatoring
+func printOperandVersions(versions []configv1.OperandVersion) string {
 	versionsOutput := []string{}
 	for _, operand := range versions {
 		versionsOutput = append(versionsOutput, fmt.Sprintf("%s: %s", operand.Name, operand.Version))
@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/klog"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	configv1 "github.com/openshift/api/config/v1"
+	"github.com/openshift/cluster-capi-operator/pkg/util"
+)
+
+// providerPodDisruptionBudgetLabel is set on the PodDisruptionBudgets of the providers to
+// their "<type>-<name>".
+const providerPodDisruptionBudgetLabel = "capi.openshift.io/provider-pdb"
+
+// providerPodDisruptionBudgets returns the PodDisruptionBudgets of the providers, so that the
+// drains of the nodes, e.g. during upgrades, evict one replica of a provider at a time. Only
+// the providers of highly available clusters run several replicas, there is no budget on the
+// other topologies, it would block the drains of single node clusters.
+func providerPodDisruptionBudgets(namespace string, keys []string, rendered map[string]renderedProvider, topology configv1.TopologyMode) []client.Object {
+	pdbs := []client.Object{}
+	if topology != configv1.HighlyAvailableTopologyMode {
+		return pdbs
+	}
+	maxUnavailable := intstr.FromInt(1)
+	for _, key := range keys {
+		podLabels := rendered[key].podLabels
+		if len(podLabels) == 0 {
+			continue
+		}
+		pdbs = append(pdbs, &policyv1.PodDisruptionBudget{
+			TypeMeta: metav1.TypeMeta{APIVersion: policyv1.SchemeGroupVersion.String(), Kind: "PodDisruptionBudget"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      key,
+				Namespace: namespace,
+				Labels:    map[string]string{providerPodDisruptionBudgetLabel: key},
+			},
+			Spec: policyv1.PodDisruptionBudgetSpec{
+				MaxUnavailable: &maxUnavailable,
+				Selector:       &metav1.LabelSelector{MatchLabels: podLabels},
+			},
+		})
+	}
+	return pdbs
+}
+
+// reconcilePodDisruptionBudgets applies the PodDisruptionBudgets of the providers installed
+// and removes the ones of the providers no longer installed or replicated.
+func (r *ClusterOperatorReconciler) reconcilePodDisruptionBudgets(ctx context.Context, keys []string, rendered map[string]renderedProvider, topology configv1.TopologyMode) error {
+	pdbs := providerPodDisruptionBudgets(r.ManagedNamespace, keys, rendered, topology)
+	names := []string{}
+	for _, pdb := range pdbs {
+		names = append(names, pdb.GetName())
+	}
+	if len(pdbs) > 0 {
+		updater := NewUpdater(pdbs)
+		err := updater.Apply(ctx, r.Client, r.Recorder)
+		r.drift.record(updater.Drifted())
+		if err != nil {
+			return err
+		}
+	}
+	return r.removePodDisruptionBudgets(ctx, names)
+}
+
+// removePodDisruptionBudgets deletes the PodDisruptionBudgets of the providers but the ones
+// named in keep.
+func (r *ClusterOperatorReconciler) removePodDisruptionBudgets(ctx context.Context, keep []string) error {
+	pdbs := &policyv1.PodDisruptionBudgetList{}
+	if err := r.List(ctx, pdbs, client.InNamespace(r.ManagedNamespace), client.HasLabels{providerPodDisruptionBudgetLabel}); err != nil {
+		return err
+	}
+	for i := range pdbs.Items {
+		pdb := &pdbs.Items[i]
+		if util.ContainsString(keep, pdb.Name) || pdb.DeletionTimestamp != nil {
+			continue
+		}
+		klog.Infof("deleting PodDisruptionBudget %s", pdb.Name)
+		if err := r.Delete(ctx, pdb); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		r.Recorder.Eventf(pdb, corev1.EventTypeNormal, "Deleted", "provider %s is not replicated", pdb.Labels[providerPodDisruptionBudgetLabel])
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	configv1 "github.com/openshift/api/config/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestProviderPodDisruptionBudgets(t *testing.T) {
+	rendered := map[string]renderedProvider{
+		"core-cluster-api":   {podLabels: map[string]string{"cluster.x-k8s.io/provider": "cluster-api"}},
+		"infrastructure-aws": {podLabels: map[string]string{"cluster.x-k8s.io/provider": "infrastructure-aws"}},
+		"infrastructure-gcp": {},
+	}
+	keys := []string{"core-cluster-api", "infrastructure-aws", "infrastructure-gcp"}
+
+	if pdbs := providerPodDisruptionBudgets("openshift-cluster-api", keys, rendered, configv1.SingleReplicaTopologyMode); len(pdbs) != 0 {
+		t.Errorf("expected no PodDisruptionBudget on single node clusters, got %d", len(pdbs))
+	}
+
+	pdbs := providerPodDisruptionBudgets("openshift-cluster-api", keys, rendered, configv1.HighlyAvailableTopologyMode)
+	got := map[string]map[string]string{}
+	for _, obj := range pdbs {
+		pdb := obj.(*policyv1.PodDisruptionBudget)
+		if pdb.Namespace != "openshift-cluster-api" || pdb.Labels[providerPodDisruptionBudgetLabel] != pdb.Name {
+			t.Errorf("unexpected metadata %+v", pdb.ObjectMeta)
+		}
+		if pdb.Spec.MaxUnavailable == nil || *pdb.Spec.MaxUnavailable != intstr.FromInt(1) {
+			t.Errorf("expected one unavailable pod at a time, got %v", pdb.Spec.MaxUnavailable)
+		}
+		got[pdb.Name] = pdb.Spec.Selector.MatchLabels
+	}
+	want := map[string]map[string]string{
+		"core-cluster-api":   {"cluster.x-k8s.io/provider": "cluster-api"},
+		"infrastructure-aws": {"cluster.x-k8s.io/provider": "infrastructure-aws"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Error(diff)
+	}
+}
@@ -0,0 +1,151 @@
+package controllers
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	componentsKey = "components"
+	// crdsKey holds the CRDs of a shard ConfigMap.
+	crdsKey = "crds"
+	// shardsAnnotation is set by the asset import on the provider ConfigMaps too big for etcd
+	// to the number of shard ConfigMaps their CRDs were moved to, <name>-crds-<n> from 1.
+	shardsAnnotation = "provider.cluster.x-k8s.io/shards"
+	// shardOfLabel is set on the shard ConfigMaps to the name of their provider ConfigMap.
+	shardOfLabel = "provider.cluster.x-k8s.io/shard-of"
+	// maxComponentsSize is the size of the components the ConfigMaps are applied with, below
+	// the 1MiB etcd object limit to leave room for the rest of the ConfigMap.
+	maxComponentsSize = 900 * 1024
+)
+
+// componentDocuments splits the components in their yaml or JSON documents.
+func componentDocuments(components string) ([][]byte, error) {
+	docs := [][]byte{}
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(strings.NewReader(components)))
+	for {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			return docs, nil
+		} else if err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+}
+
+// decodeDocument decodes the object of a document of the components, nil when it is empty.
+func decodeDocument(doc []byte) (*unstructured.Unstructured, error) {
+	obj := &unstructured.Unstructured{}
+	if err := utilyaml.NewYAMLOrJSONDecoder(bytes.NewReader(doc), 4096).Decode(&obj.Object); err == io.EOF {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if len(obj.Object) == 0 {
+		return nil, nil
+	}
+	return obj, nil
+}
+
+// componentObjects decodes the objects of the components.
+func componentObjects(components string) ([]*unstructured.Unstructured, error) {
+	docs, err := componentDocuments(components)
+	if err != nil {
+		return nil, err
+	}
+	objs := []*unstructured.Unstructured{}
+	for _, doc := range docs {
+		obj, err := decodeDocument(doc)
+		if err != nil {
+			return nil, err
+		}
+		if obj != nil {
+			objs = append(objs, obj)
+		}
+	}
+	return objs, nil
+}
+
+func hasProviderComponents(cm *corev1.ConfigMap) bool {
+	_, ok := cm.Data[componentsKey]
+	return ok
+}
+
+// setProviderComponents sets the components of a provider ConfigMap, in data where the
+// upstream operator reads them. It fails when they are too big for etcd, the asset import
+// shards the CRDs of those, see unshardComponents.
+func setProviderComponents(cm *corev1.ConfigMap, components string) error {
+	if len(components) > maxComponentsSize {
+		return fmt.Errorf("components of ConfigMap %s are over %d bytes", cm.Name, maxComponentsSize)
+	}
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	cm.Data[componentsKey] = components
+	return nil
+}
+
+// unshardComponents replaces the shard ConfigMaps of the provider ConfigMaps too big for etcd
+// with their CRDs. The upstream operator only reads the components of the ConfigMaps it
+// selects, the operator applies the CRDs of the shards itself, they have the provider label
+// clusterctl sets on the components. The CRDs shared by the topology variants of a provider
+// are returned once. It fails when the shards of a provider ConfigMap don't match its
+// shardsAnnotation.
+func unshardComponents(objs []client.Object) ([]client.Object, error) {
+	shards := map[string]*corev1.ConfigMap{}
+	result := []client.Object{}
+	for _, obj := range objs {
+		if cm, ok := obj.(*corev1.ConfigMap); ok && cm.Labels[shardOfLabel] != "" {
+			shards[cm.Name] = cm
+			continue
+		}
+		result = append(result, obj)
+	}
+
+	crds := map[string]bool{}
+	for _, obj := range objs {
+		cm, ok := obj.(*corev1.ConfigMap)
+		if !ok || cm.Annotations[shardsAnnotation] == "" {
+			continue
+		}
+		count, err := strconv.Atoi(cm.Annotations[shardsAnnotation])
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s annotation of ConfigMap %s: %v", shardsAnnotation, cm.Name, err)
+		}
+		for i := 1; i <= count; i++ {
+			name := fmt.Sprintf("%s-crds-%d", cm.Name, i)
+			shard, ok := shards[name]
+			if !ok || shard.Labels[shardOfLabel] != cm.Name {
+				return nil, fmt.Errorf("ConfigMap %s has %d shards, %s is missing", cm.Name, count, name)
+			}
+			delete(shards, name)
+			shardObjs, err := componentObjects(shard.Data[crdsKey])
+			if err != nil {
+				return nil, fmt.Errorf("invalid CRDs in ConfigMap %s: %v", name, err)
+			}
+			for _, crd := range shardObjs {
+				if crd.GetKind() != "CustomResourceDefinition" || crd.GetLabels()[providerLabel] == "" {
+					return nil, fmt.Errorf("unexpected %s %s in ConfigMap %s", crd.GetKind(), crd.GetName(), name)
+				}
+				if !crds[crd.GetName()] {
+					crds[crd.GetName()] = true
+					result = append(result, crd)
+				}
+			}
+		}
+	}
+	for name, shard := range shards {
+		return nil, fmt.Errorf("ConfigMap %s is a shard of %s, which doesn't have it", name, shard.Labels[shardOfLabel])
+	}
+	return result, nil
+}
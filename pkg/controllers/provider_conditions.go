@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	capiv1alpha1 "github.com/openshift/cluster-capi-operator/api/v1alpha1"
+	"github.com/openshift/cluster-capi-operator/pkg/util"
+)
+
+const (
+	// the conditions of the providers in the status of the ClusterAPIConfiguration
+	ProviderAvailable   = "Available"
+	ProviderProgressing = "Progressing"
+
+	ReasonProviderNotInstalled = "NotInstalled"
+
+	// the health of the providers reported in their metrics
+	ProviderReady      = "Ready"
+	ProviderInstalling = "Installing"
+	ProviderDegraded   = "Degraded"
+)
+
+// providersStatus returns the status of the providers installed, by key, from the health of
+// their CRs and Deployments. The transition times of the conditions of existing are kept.
+func (r *ClusterOperatorReconciler) providersStatus(ctx context.Context, objs []client.Object, installed []string, existing []capiv1alpha1.ProviderStatus) ([]capiv1alpha1.ProviderStatus, error) {
+	statuses := []capiv1alpha1.ProviderStatus{}
+	// the providers no longer installed are dropped from the metrics
+	providerConditionGauge.Reset()
+	for _, obj := range objs {
+		key := providerKey(obj)
+		if providerSpec(obj) == nil || !util.ContainsString(installed, key) {
+			continue
+		}
+		live, ok := obj.DeepCopyObject().(client.Object)
+		if !ok {
+			return nil, fmt.Errorf("unexpected provider %s", key)
+		}
+		if err := r.Get(ctx, client.ObjectKeyFromObject(obj), live); apierrors.IsNotFound(err) {
+			live = nil
+		} else if err != nil {
+			return nil, err
+		}
+		deps := &appsv1.DeploymentList{}
+		if err := r.List(ctx, deps, client.InNamespace(r.ManagedNamespace), client.MatchingLabels{providerLabel: providerManifestLabel(obj)}); err != nil {
+			return nil, err
+		}
+
+		status := capiv1alpha1.ProviderStatus{Name: key}
+		for _, s := range existing {
+			if s.Name == key {
+				status.Conditions = s.Conditions
+			}
+		}
+		conditions := providerConditions(live, deps.Items)
+		for _, c := range conditions {
+			meta.SetStatusCondition(&status.Conditions, c)
+		}
+		setProviderHealth(key, providerHealth(live, conditions))
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// providerConditions returns the Available and Progressing conditions of a provider: it is
+// available once the CAPI Operator installed it and its Deployments all have available
+// replicas, progressing while they roll out.
+func providerConditions(live client.Object, deps []appsv1.Deployment) []metav1.Condition {
+	health := newDeploymentsHealth(deps)
+	available := metav1.Condition{Type: ProviderAvailable, Status: metav1.ConditionTrue, Reason: ReasonAsExpected}
+	switch {
+	case live == nil || !isProviderInstalled(live) || len(deps) == 0:
+		available.Status, available.Reason = metav1.ConditionFalse, ReasonProviderNotInstalled
+		available.Message = "The CAPI Operator hasn't installed the provider yet"
+	case len(health.unavailable) > 0:
+		available.Status, available.Reason = metav1.ConditionFalse, ReasonDeploymentsUnavailable
+		available.Message = fmt.Sprintf("Deployments without available replicas: %s", strings.Join(health.unavailable, ", "))
+	}
+	progressing := metav1.Condition{Type: ProviderProgressing, Status: metav1.ConditionFalse, Reason: ReasonAsExpected}
+	if len(health.progressing) > 0 {
+		progressing.Status, progressing.Reason = metav1.ConditionTrue, ReasonDeploymentsProgressing
+		progressing.Message = fmt.Sprintf("Rolling out Deployments: %s", strings.Join(health.progressing, ", "))
+	}
+	return []metav1.Condition{available, progressing}
+}
+
+// providerHealth returns whether the provider is Ready, Installing and Degraded: it is ready
+// while available, installing until the CAPI Operator installed it and while its Deployments
+// roll out, and degraded when it is unavailable otherwise or its CR has a failed condition of
+// Error severity, e.g. a failed preflight check.
+func providerHealth(live client.Object, conditions []metav1.Condition) map[string]bool {
+	health := map[string]bool{}
+	available := meta.FindStatusCondition(conditions, ProviderAvailable)
+	health[ProviderReady] = available != nil && available.Status == metav1.ConditionTrue
+	health[ProviderInstalling] = (available != nil && available.Reason == ReasonProviderNotInstalled) ||
+		meta.IsStatusConditionTrue(conditions, ProviderProgressing)
+	health[ProviderDegraded] = !health[ProviderReady] && !health[ProviderInstalling]
+	if status := providerStatus(live); status != nil {
+		for _, c := range status.Conditions {
+			if c.Status == corev1.ConditionFalse && c.Severity == clusterv1.ConditionSeverityError {
+				health[ProviderDegraded] = true
+			}
+		}
+	}
+	return health
+}
@@ -0,0 +1,137 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	operatorv1 "sigs.k8s.io/cluster-api/exp/operator/api/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestProviderConditions(t *testing.T) {
+	installed := &operatorv1.InfrastructureProvider{ObjectMeta: metav1.ObjectMeta{Name: "metal3", Generation: 1}}
+	installed.Status.ObservedGeneration = 1
+	installed.Status.Conditions = clusterv1.Conditions{{Type: clusterv1.ReadyCondition, Status: corev1.ConditionTrue}}
+	deployment := func(replicas, updated, available int32) appsv1.Deployment {
+		return appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "capm3-controller-manager", Generation: 1},
+			Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+			Status: appsv1.DeploymentStatus{
+				ObservedGeneration: 1,
+				Replicas:           replicas,
+				UpdatedReplicas:    updated,
+				AvailableReplicas:  available,
+			},
+		}
+	}
+	tests := []struct {
+		name string
+		live client.Object
+		deps []appsv1.Deployment
+		want map[string]string
+	}{
+		{
+			name: "not installed",
+			want: map[string]string{ProviderAvailable: ReasonProviderNotInstalled, ProviderProgressing: ReasonAsExpected},
+		},
+		{
+			name: "no deployments yet",
+			live: installed,
+			want: map[string]string{ProviderAvailable: ReasonProviderNotInstalled, ProviderProgressing: ReasonAsExpected},
+		},
+		{
+			name: "available",
+			live: installed,
+			deps: []appsv1.Deployment{deployment(1, 1, 1)},
+			want: map[string]string{ProviderAvailable: ReasonAsExpected, ProviderProgressing: ReasonAsExpected},
+		},
+		{
+			name: "rolling out",
+			live: installed,
+			deps: []appsv1.Deployment{deployment(1, 0, 0)},
+			want: map[string]string{ProviderAvailable: ReasonDeploymentsUnavailable, ProviderProgressing: ReasonDeploymentsProgressing},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := map[string]string{}
+			for _, c := range providerConditions(tt.live, tt.deps) {
+				got[c.Type] = c.Reason
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestProviderHealth(t *testing.T) {
+	failed := &operatorv1.InfrastructureProvider{ObjectMeta: metav1.ObjectMeta{Name: "metal3"}}
+	failed.Status.Conditions = clusterv1.Conditions{{
+		Type:     operatorv1.PreflightCheckCondition,
+		Status:   corev1.ConditionFalse,
+		Severity: clusterv1.ConditionSeverityError,
+	}}
+	condition := func(conditionType, reason string, status metav1.ConditionStatus) metav1.Condition {
+		return metav1.Condition{Type: conditionType, Status: status, Reason: reason}
+	}
+	tests := []struct {
+		name       string
+		live       client.Object
+		conditions []metav1.Condition
+		want       map[string]bool
+	}{
+		{
+			name: "ready",
+			conditions: []metav1.Condition{
+				condition(ProviderAvailable, ReasonAsExpected, metav1.ConditionTrue),
+				condition(ProviderProgressing, ReasonAsExpected, metav1.ConditionFalse),
+			},
+			want: map[string]bool{ProviderReady: true, ProviderInstalling: false, ProviderDegraded: false},
+		},
+		{
+			name: "not installed",
+			conditions: []metav1.Condition{
+				condition(ProviderAvailable, ReasonProviderNotInstalled, metav1.ConditionFalse),
+				condition(ProviderProgressing, ReasonAsExpected, metav1.ConditionFalse),
+			},
+			want: map[string]bool{ProviderReady: false, ProviderInstalling: true, ProviderDegraded: false},
+		},
+		{
+			name: "rolling out",
+			conditions: []metav1.Condition{
+				condition(ProviderAvailable, ReasonDeploymentsUnavailable, metav1.ConditionFalse),
+				condition(ProviderProgressing, ReasonDeploymentsProgressing, metav1.ConditionTrue),
+			},
+			want: map[string]bool{ProviderReady: false, ProviderInstalling: true, ProviderDegraded: false},
+		},
+		{
+			name: "unavailable",
+			conditions: []metav1.Condition{
+				condition(ProviderAvailable, ReasonDeploymentsUnavailable, metav1.ConditionFalse),
+				condition(ProviderProgressing, ReasonAsExpected, metav1.ConditionFalse),
+			},
+			want: map[string]bool{ProviderReady: false, ProviderInstalling: false, ProviderDegraded: true},
+		},
+		{
+			name: "failed preflight check",
+			live: failed,
+			conditions: []metav1.Condition{
+				condition(ProviderAvailable, ReasonProviderNotInstalled, metav1.ConditionFalse),
+				condition(ProviderProgressing, ReasonAsExpected, metav1.ConditionFalse),
+			},
+			want: map[string]bool{ProviderReady: false, ProviderInstalling: true, ProviderDegraded: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := cmp.Diff(tt.want, providerHealth(tt.live, tt.conditions)); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,268 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog"
+	operatorv1 "sigs.k8s.io/cluster-api/exp/operator/api/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// driftRevertedEnv is set on the manager container of the providers to the time their
+	// Deployments were last found modified out-of-band, changing the spec of the provider CR
+	// so that the CAPI Operator installs the components again.
+	driftRevertedEnv = "CAPI_OPERATOR_DRIFT_REVERTED"
+	// driftRevertBackoff is how long a provider isn't installed again after it was, for
+	// modifications its installation doesn't revert.
+	driftRevertBackoff = 5 * time.Minute
+)
+
+// keepDriftReverted carries the time the live provider was last installed again over to the
+// desired one, applying the provider doesn't install it again.
+func (r *ClusterOperatorReconciler) keepDriftReverted(ctx context.Context, desired client.Object) error {
+	live, ok := desired.DeepCopyObject().(client.Object)
+	if !ok {
+		return fmt.Errorf("unexpected provider %s", providerKey(desired))
+	}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(desired), live); apierrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if reverted := driftReverted(providerSpec(live)); reverted != "" {
+		setDriftReverted(providerSpec(desired), reverted)
+	}
+	return nil
+}
+
+// revertProviderDrift compares the Deployments of the providers installed with the ones of
+// their components, and installs again the providers whose Deployments were modified
+// out-of-band. The CAPI Operator applies the components, not the operator, and only installs
+// them again when the spec of the provider CR changes. It returns the Deployments reverted.
+func (r *ClusterOperatorReconciler) revertProviderDrift(ctx context.Context, objs []client.Object) ([]string, error) {
+	reverted := []string{}
+	for _, obj := range objs {
+		if providerSpec(obj) == nil {
+			continue
+		}
+		key := providerKey(obj)
+		live, ok := obj.DeepCopyObject().(client.Object)
+		if !ok {
+			return reverted, fmt.Errorf("unexpected provider %s", key)
+		}
+		if err := r.Get(ctx, client.ObjectKeyFromObject(obj), live); apierrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return reverted, err
+		}
+		// the Deployments of the providers being installed aren't settled yet
+		if !isProviderInstalled(live) {
+			continue
+		}
+		spec := providerSpec(live)
+		if last, err := time.Parse(time.RFC3339, driftReverted(spec)); err == nil && time.Since(last) < driftRevertBackoff {
+			continue
+		}
+		expected, err := providerDeployments(objs, spec)
+		if err != nil {
+			return reverted, fmt.Errorf("invalid components of %s: %v", key, err)
+		}
+		if len(expected) == 0 {
+			continue
+		}
+
+		deps := &appsv1.DeploymentList{}
+		if err := r.List(ctx, deps, client.InNamespace(r.ManagedNamespace), client.MatchingLabels{providerLabel: providerManifestLabel(live)}); err != nil {
+			return reverted, err
+		}
+		drifted := []string{}
+		for i := range deps.Items {
+			dep := &deps.Items[i]
+			want, ok := expected[dep.Name]
+			if !ok {
+				continue
+			}
+			if diff := deploymentDrift(want, dep, spec); len(diff) > 0 {
+				klog.Infof("Deployment %s of %s modified out-of-band: %s", dep.Name, key, strings.Join(diff, ", "))
+				drifted = append(drifted, "Deployment "+dep.Name)
+			}
+		}
+		if len(drifted) == 0 {
+			continue
+		}
+
+		patch := client.MergeFrom(live.DeepCopyObject().(client.Object))
+		setDriftReverted(spec, time.Now().UTC().Format(time.RFC3339))
+		if err := r.Patch(ctx, live, patch); err != nil {
+			return reverted, err
+		}
+		r.Recorder.Eventf(live, corev1.EventTypeNormal, "DriftReverted", "installed again, %s modified out-of-band", strings.Join(drifted, ", "))
+		reverted = append(reverted, drifted...)
+	}
+	return reverted, nil
+}
+
+// providerDeployments returns the Deployments of the components the provider is installed
+// from, by name: the ones of the provider ConfigMap of its version its selector matches.
+func providerDeployments(objs []client.Object, spec *operatorv1.ProviderSpec) (map[string]*appsv1.Deployment, error) {
+	if spec.FetchConfig == nil || spec.FetchConfig.Selector == nil || spec.Version == nil {
+		return nil, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(spec.FetchConfig.Selector)
+	if err != nil {
+		return nil, err
+	}
+	for _, obj := range objs {
+		cm, ok := obj.(*corev1.ConfigMap)
+		if !ok || !selector.Matches(labels.Set(cm.Labels)) || cm.Labels[providerVersionLabel] != *spec.Version {
+			continue
+		}
+		components, err := componentObjects(cm.Data[componentsKey])
+		if err != nil {
+			return nil, err
+		}
+		deps := map[string]*appsv1.Deployment{}
+		for _, u := range components {
+			if u.GetKind() != "Deployment" {
+				continue
+			}
+			dep := &appsv1.Deployment{}
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, dep); err != nil {
+				return nil, err
+			}
+			deps[dep.Name] = dep
+		}
+		return deps, nil
+	}
+	return nil, nil
+}
+
+// deploymentDrift returns the differences of the containers of the live Deployment of a
+// provider with the ones of its components: their images, commands, arguments and
+// environment. What the spec of the provider customizes is left to the CAPI Operator, as are
+// the values of the clusterctl variables of the components, only their presence is compared.
+func deploymentDrift(want, live *appsv1.Deployment, spec *operatorv1.ProviderSpec) []string {
+	diff := []string{}
+	liveContainers := map[string]corev1.Container{}
+	for _, c := range live.Spec.Template.Spec.Containers {
+		liveContainers[c.Name] = c
+	}
+	for _, c := range want.Spec.Template.Spec.Containers {
+		lc, ok := liveContainers[c.Name]
+		if !ok {
+			diff = append(diff, fmt.Sprintf("container %s removed", c.Name))
+			continue
+		}
+		delete(liveContainers, c.Name)
+
+		customized := customizedContainer(spec, c.Name)
+		if (customized == nil || customized.Image == nil) && lc.Image != c.Image {
+			diff = append(diff, fmt.Sprintf("image of container %s", c.Name))
+		}
+		if strings.Join(lc.Command, " ") != strings.Join(c.Command, " ") {
+			diff = append(diff, fmt.Sprintf("command of container %s", c.Name))
+		}
+		argsCustomized := customized != nil && len(customized.Args) > 0 || c.Name == managerContainer && spec.Manager != nil
+		for _, arg := range c.Args {
+			flag := strings.SplitN(arg, "=", 2)[0]
+			if !hasArg(lc.Args, arg, argsCustomized || strings.Contains(arg, "${")) {
+				diff = append(diff, fmt.Sprintf("argument %s of container %s", flag, c.Name))
+			}
+		}
+		for _, env := range c.Env {
+			if customized != nil && hasEnv(customized.Env, env.Name) {
+				continue
+			}
+			if !hasEnvVar(lc.Env, env) {
+				diff = append(diff, fmt.Sprintf("environment variable %s of container %s", env.Name, c.Name))
+			}
+		}
+	}
+	for name := range liveContainers {
+		diff = append(diff, fmt.Sprintf("container %s added", name))
+	}
+	return diff
+}
+
+// customizedContainer returns the customization of the container in the spec of the
+// provider, nil when there is none.
+func customizedContainer(spec *operatorv1.ProviderSpec, name string) *operatorv1.ContainerSpec {
+	if spec.Deployment == nil {
+		return nil
+	}
+	for i := range spec.Deployment.Containers {
+		if spec.Deployment.Containers[i].Name == name {
+			return &spec.Deployment.Containers[i]
+		}
+	}
+	return nil
+}
+
+// hasArg returns whether the argument is in args, or only its flag when the value isn't
+// compared.
+func hasArg(args []string, arg string, flagOnly bool) bool {
+	flag := strings.SplitN(arg, "=", 2)[0]
+	for _, a := range args {
+		if a == arg || flagOnly && strings.SplitN(a, "=", 2)[0] == flag {
+			return true
+		}
+	}
+	return false
+}
+
+func hasEnv(envs []corev1.EnvVar, name string) bool {
+	for _, e := range envs {
+		if e.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// hasEnvVar returns whether the environment variable is in envs, or only its name when its
+// value has clusterctl variables.
+func hasEnvVar(envs []corev1.EnvVar, env corev1.EnvVar) bool {
+	for _, e := range envs {
+		if e.Name != env.Name {
+			continue
+		}
+		return strings.Contains(env.Value, "${") || e.Value == env.Value &&
+			(e.ValueFrom == nil) == (env.ValueFrom == nil)
+	}
+	return false
+}
+
+// driftReverted returns the time the provider was last installed again, empty when it
+// wasn't.
+func driftReverted(spec *operatorv1.ProviderSpec) string {
+	if c := customizedContainer(spec, managerContainer); c != nil {
+		for _, env := range c.Env {
+			if env.Name == driftRevertedEnv {
+				return env.Value
+			}
+		}
+	}
+	return ""
+}
+
+func setDriftReverted(spec *operatorv1.ProviderSpec, reverted string) {
+	container := containerSpec(spec, managerContainer)
+	env := corev1.EnvVar{Name: driftRevertedEnv, Value: reverted}
+	for i := range container.Env {
+		if container.Env[i].Name == driftRevertedEnv {
+			container.Env[i] = env
+			return
+		}
+	}
+	container.Env = append(container.Env, env)
+}
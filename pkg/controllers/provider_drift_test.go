@@ -0,0 +1,227 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
+	operatorv1 "sigs.k8s.io/cluster-api/exp/operator/api/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestDeploymentDrift(t *testing.T) {
+	deployment := func(containers ...corev1.Container) *appsv1.Deployment {
+		dep := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "capa-controller-manager"}}
+		dep.Spec.Template.Spec.Containers = containers
+		return dep
+	}
+	manager := corev1.Container{
+		Name:    "manager",
+		Image:   "quay.io/openshift/aws-cluster-api-controllers:4.10",
+		Command: []string{"/manager"},
+		Args:    []string{"--leader-elect", "--feature-gates=EKS=${CAPA_EKS:=true}", "--v=2"},
+		Env:     []corev1.EnvVar{{Name: "AWS_SHARED_CREDENTIALS_FILE", Value: "/home/.aws/credentials"}},
+	}
+	modified := func(modify func(c *corev1.Container)) corev1.Container {
+		c := *manager.DeepCopy()
+		modify(&c)
+		return c
+	}
+	tests := []struct {
+		name string
+		live *appsv1.Deployment
+		spec operatorv1.ProviderSpec
+		want []string
+	}{
+		{
+			name: "unchanged",
+			live: deployment(manager),
+			want: []string{},
+		},
+		{
+			name: "variable resolved",
+			live: deployment(modified(func(c *corev1.Container) { c.Args[1] = "--feature-gates=EKS=true" })),
+			want: []string{},
+		},
+		{
+			name: "added by the CAPI Operator",
+			live: deployment(modified(func(c *corev1.Container) {
+				c.Args = append(c.Args, "--namespace=")
+				c.Env = append(c.Env, corev1.EnvVar{Name: trustedCABundleHashEnv, Value: "abc"})
+			})),
+			want: []string{},
+		},
+		{
+			name: "image modified",
+			live: deployment(modified(func(c *corev1.Container) { c.Image = "quay.io/example/capa:debug" })),
+			want: []string{"image of container manager"},
+		},
+		{
+			name: "image customized",
+			live: deployment(modified(func(c *corev1.Container) { c.Image = "quay.io/example/capa:debug" })),
+			spec: operatorv1.ProviderSpec{Deployment: &operatorv1.DeploymentSpec{Containers: []operatorv1.ContainerSpec{{
+				Name:  "manager",
+				Image: &operatorv1.ImageMeta{Repository: pointer.String("quay.io/example"), Name: pointer.String("capa"), Tag: pointer.String("debug")},
+			}}}},
+			want: []string{},
+		},
+		{
+			name: "argument modified",
+			live: deployment(modified(func(c *corev1.Container) { c.Args[2] = "--v=6" })),
+			want: []string{"argument --v of container manager"},
+		},
+		{
+			name: "argument customized",
+			live: deployment(modified(func(c *corev1.Container) { c.Args[2] = "--v=6" })),
+			spec: operatorv1.ProviderSpec{Manager: &operatorv1.ManagerSpec{Verbosity: 6}},
+			want: []string{},
+		},
+		{
+			name: "argument removed",
+			live: deployment(modified(func(c *corev1.Container) { c.Args = c.Args[1:] })),
+			spec: operatorv1.ProviderSpec{Manager: &operatorv1.ManagerSpec{Verbosity: 2}},
+			want: []string{"argument --leader-elect of container manager"},
+		},
+		{
+			name: "environment variable modified",
+			live: deployment(modified(func(c *corev1.Container) { c.Env[0].Value = "/tmp/credentials" })),
+			want: []string{"environment variable AWS_SHARED_CREDENTIALS_FILE of container manager"},
+		},
+		{
+			name: "environment variable customized",
+			live: deployment(modified(func(c *corev1.Container) { c.Env[0].Value = "/tmp/credentials" })),
+			spec: operatorv1.ProviderSpec{Deployment: &operatorv1.DeploymentSpec{Containers: []operatorv1.ContainerSpec{{
+				Name: "manager",
+				Env:  []corev1.EnvVar{{Name: "AWS_SHARED_CREDENTIALS_FILE", Value: "/tmp/credentials"}},
+			}}}},
+			want: []string{},
+		},
+		{
+			name: "command modified",
+			live: deployment(modified(func(c *corev1.Container) { c.Command = []string{"/bin/sh"} })),
+			want: []string{"command of container manager"},
+		},
+		{
+			name: "containers modified",
+			live: deployment(corev1.Container{Name: "debug", Image: "busybox"}),
+			want: []string{"container manager removed", "container debug added"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := deploymentDrift(deployment(manager), tt.live, &tt.spec)
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("unexpected drift (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestProviderDeployments(t *testing.T) {
+	configMap := func(name, version, components string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{
+				providerTypeLabel:    "infrastructure",
+				providerNameLabel:    "aws",
+				providerVersionLabel: version,
+			}},
+			Data: map[string]string{componentsKey: components},
+		}
+	}
+	components := func(image string) string {
+		return `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: capa-controller-manager
+spec:
+  template:
+    spec:
+      containers:
+      - name: manager
+        image: ` + image + `
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: capa-webhook-service
+`
+	}
+	objs := []client.Object{
+		configMap("v1.0.0", "v1.0.0", components("capa:v1.0.0")),
+		configMap("v1.1.0", "v1.1.0", components("capa:v1.1.0")),
+	}
+	spec := func(version string) *operatorv1.ProviderSpec {
+		return &operatorv1.ProviderSpec{
+			Version: pointer.String(version),
+			FetchConfig: &operatorv1.FetchConfiguration{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{
+				providerTypeLabel: "infrastructure",
+				providerNameLabel: "aws",
+			}}},
+		}
+	}
+	tests := []struct {
+		name string
+		spec *operatorv1.ProviderSpec
+		want string
+	}{
+		{
+			name: "version",
+			spec: spec("v1.1.0"),
+			want: "capa:v1.1.0",
+		},
+		{
+			name: "previous version",
+			spec: spec("v1.0.0"),
+			want: "capa:v1.0.0",
+		},
+		{
+			name: "backup version",
+			spec: spec("v0.9.0"),
+		},
+		{
+			name: "no selector",
+			spec: &operatorv1.ProviderSpec{Version: pointer.String("v1.1.0")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deps, err := providerDeployments(objs, tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := ""
+			if dep, ok := deps["capa-controller-manager"]; ok {
+				got = dep.Spec.Template.Spec.Containers[0].Image
+			}
+			if got != tt.want {
+				t.Errorf("image of the Deployment %q, want %q", got, tt.want)
+			}
+			if len(deps) > 1 {
+				t.Errorf("unexpected Deployments %v", deps)
+			}
+		})
+	}
+}
+
+func TestKeepDriftReverted(t *testing.T) {
+	spec := &operatorv1.ProviderSpec{}
+	if got := driftReverted(spec); got != "" {
+		t.Errorf("driftReverted() = %q, want none", got)
+	}
+	setTrustedCABundleHash(spec, "abc")
+	setDriftReverted(spec, "2021-10-05T10:00:00Z")
+	setDriftReverted(spec, "2021-10-05T11:00:00Z")
+	if got := driftReverted(spec); got != "2021-10-05T11:00:00Z" {
+		t.Errorf("driftReverted() = %q, want the last one", got)
+	}
+	want := []corev1.EnvVar{
+		{Name: trustedCABundleHashEnv, Value: "abc"},
+		{Name: driftRevertedEnv, Value: "2021-10-05T11:00:00Z"},
+	}
+	if diff := cmp.Diff(want, spec.Deployment.Containers[0].Env); diff != "" {
+		t.Errorf("unexpected environment of the manager (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,265 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	operatorv1 "sigs.k8s.io/cluster-api/exp/operator/api/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	configv1 "github.com/openshift/api/config/v1"
+)
+
+const (
+	// the upgrade state of a provider, on its CR
+	previousVersionAnnotation = "capi.openshift.io/previous-version"
+	upgradeStartedAnnotation  = "capi.openshift.io/upgrade-started"
+	rolledBackFromAnnotation  = "capi.openshift.io/rolled-back-from"
+
+	// upgradeHealthWindow is how long an upgraded provider has to become healthy before it is
+	// rolled back.
+	upgradeHealthWindow = 10 * time.Minute
+	// upgradeRequeueAfter is how often the health of an upgrade is checked.
+	upgradeRequeueAfter = 30 * time.Second
+
+	// providerLabel is set by clusterctl on the components of the providers.
+	providerLabel = "cluster.x-k8s.io/provider"
+
+	ReasonProviderUpgradeRolledBack = "ProviderUpgradeRolledBack"
+)
+
+// providerUpgrades is the state of the provider upgrades seen by a reconcile.
+type providerUpgrades struct {
+	// inProgress are the providers upgraded not healthy yet
+	inProgress []string
+	// rolledBack are the messages of the upgrades rolled back
+	rolledBack []string
+	// backups are the versions of the providers kept as backup, by provider key
+	backups map[string]string
+}
+
+func newProviderUpgrades() *providerUpgrades {
+	return &providerUpgrades{backups: map[string]string{}}
+}
+
+// providerSpec returns the spec of a provider CR, nil for the other objects.
+func providerSpec(obj client.Object) *operatorv1.ProviderSpec {
+	switch o := obj.(type) {
+	case *operatorv1.CoreProvider:
+		return &o.Spec.ProviderSpec
+	case *operatorv1.InfrastructureProvider:
+		return &o.Spec.ProviderSpec
+	case *operatorv1.BootstrapProvider:
+		return &o.Spec.ProviderSpec
+	case *operatorv1.ControlPlaneProvider:
+		return &o.Spec.ProviderSpec
+	}
+	return nil
+}
+
+// providerStatus returns the status of a provider CR, nil for the other objects.
+func providerStatus(obj client.Object) *operatorv1.ProviderStatus {
+	switch o := obj.(type) {
+	case *operatorv1.CoreProvider:
+		return &o.Status.ProviderStatus
+	case *operatorv1.InfrastructureProvider:
+		return &o.Status.ProviderStatus
+	case *operatorv1.BootstrapProvider:
+		return &o.Status.ProviderStatus
+	case *operatorv1.ControlPlaneProvider:
+		return &o.Status.ProviderStatus
+	}
+	return nil
+}
+
+func providerVersion(obj client.Object) string {
+	if spec := providerSpec(obj); spec != nil && spec.Version != nil {
+		return *spec.Version
+	}
+	return ""
+}
+
+// orchestrateUpgrade gates the upgrade of the provider to the version of the payload on its
+// health. The components of the previous version are kept as backup, the provider is rolled
+// back to them when it isn't healthy within upgradeHealthWindow, and the version rolled back
+// from isn't tried again.
+func (r *ClusterOperatorReconciler) orchestrateUpgrade(ctx context.Context, desired client.Object, upgrades *providerUpgrades) error {
+	key := providerKey(desired)
+	live, ok := desired.DeepCopyObject().(client.Object)
+	if !ok {
+		return fmt.Errorf("unexpected provider %s", key)
+	}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(desired), live); apierrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	desiredVersion, liveVersion := providerVersion(desired), providerVersion(live)
+	state := live.GetAnnotations()
+	now := time.Now()
+	switch {
+	case state[rolledBackFromAnnotation] == desiredVersion:
+		// stay on the version rolled back to until the payload has another one
+		setProviderUpgradeState(desired, liveVersion, map[string]string{rolledBackFromAnnotation: desiredVersion})
+		upgrades.backups[key] = liveVersion
+		upgrades.rolledBack = append(upgrades.rolledBack,
+			fmt.Sprintf("the upgrade of %s to %s failed, rolled back to %s", key, desiredVersion, liveVersion))
+
+	case liveVersion != desiredVersion:
+		klog.Infof("upgrading %s from %s to %s", key, liveVersion, desiredVersion)
+		providerUpgradesTotal.WithLabelValues(key, upgradeStarted).Inc()
+		setProviderUpgradeState(desired, desiredVersion, map[string]string{
+			previousVersionAnnotation: liveVersion,
+			upgradeStartedAnnotation:  now.UTC().Format(time.RFC3339),
+		})
+		upgrades.backups[key] = liveVersion
+		upgrades.inProgress = append(upgrades.inProgress, key)
+
+	case state[previousVersionAnnotation] != "":
+		previous := state[previousVersionAnnotation]
+		healthy, err := r.isProviderHealthy(ctx, live)
+		if err != nil {
+			return err
+		}
+		started, _ := time.Parse(time.RFC3339, state[upgradeStartedAnnotation])
+		switch {
+		case healthy:
+			klog.Infof("upgraded %s from %s to %s", key, previous, desiredVersion)
+			providerUpgradesTotal.WithLabelValues(key, upgradeCompleted).Inc()
+		case now.Sub(started) > upgradeHealthWindow:
+			klog.Warningf("%s isn't healthy %s after its upgrade to %s, rolling back to %s", key, upgradeHealthWindow, desiredVersion, previous)
+			providerUpgradesTotal.WithLabelValues(key, upgradeRolledBack).Inc()
+			r.Recorder.Eventf(live, corev1.EventTypeWarning, "UpgradeRolledBack", "not healthy after the upgrade to %s, rolled back to %s", desiredVersion, previous)
+			setProviderUpgradeState(desired, previous, map[string]string{rolledBackFromAnnotation: desiredVersion})
+			upgrades.backups[key] = previous
+			upgrades.rolledBack = append(upgrades.rolledBack,
+				fmt.Sprintf("the upgrade of %s to %s failed, rolled back to %s", key, desiredVersion, previous))
+		default:
+			setProviderUpgradeState(desired, desiredVersion, map[string]string{
+				previousVersionAnnotation: previous,
+				upgradeStartedAnnotation:  state[upgradeStartedAnnotation],
+			})
+			upgrades.backups[key] = previous
+			upgrades.inProgress = append(upgrades.inProgress, key)
+		}
+	}
+	return nil
+}
+
+// setProviderUpgradeState sets the version of the provider and the annotations of its
+// upgrade state.
+func setProviderUpgradeState(obj client.Object, version string, state map[string]string) {
+	providerSpec(obj).Version = &version
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	for k, v := range state {
+		annotations[k] = v
+	}
+	obj.SetAnnotations(annotations)
+}
+
+// isProviderHealthy returns whether the upstream operator installed the version of the
+// provider, its Deployments are rolled out and available, and one of their new pods leads.
+func (r *ClusterOperatorReconciler) isProviderHealthy(ctx context.Context, provider client.Object) (bool, error) {
+	if !isProviderInstalled(provider) {
+		return false, nil
+	}
+	deps := &appsv1.DeploymentList{}
+	if err := r.List(ctx, deps, client.InNamespace(r.ManagedNamespace), client.MatchingLabels{providerLabel: providerManifestLabel(provider)}); err != nil {
+		return false, err
+	}
+	if len(deps.Items) == 0 || !newDeploymentsHealth(deps.Items).settled() {
+		return false, nil
+	}
+
+	leases := &coordinationv1.LeaseList{}
+	if err := r.List(ctx, leases, client.InNamespace(r.ManagedNamespace)); err != nil {
+		return false, err
+	}
+	for _, dep := range deps.Items {
+		selector, err := metav1.LabelSelectorAsSelector(dep.Spec.Selector)
+		if err != nil {
+			return false, err
+		}
+		pods := &corev1.PodList{}
+		if err := r.List(ctx, pods, client.InNamespace(r.ManagedNamespace), client.MatchingLabelsSelector{Selector: selector}); err != nil {
+			return false, err
+		}
+		if leads(pods.Items, leases.Items) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// isProviderInstalled returns whether the upstream operator reconciled the latest spec of the
+// provider CR, its version, and reports it Ready.
+func isProviderInstalled(provider client.Object) bool {
+	status := providerStatus(provider)
+	if status == nil || status.ObservedGeneration < provider.GetGeneration() {
+		return false
+	}
+	for _, c := range status.Conditions {
+		if c.Type == clusterv1.ReadyCondition {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// leads returns whether one of the pods holds one of the leases, the controllers identify
+// as <pod name>_<uuid>.
+func leads(pods []corev1.Pod, leases []coordinationv1.Lease) bool {
+	for _, lease := range leases {
+		if lease.Spec.HolderIdentity == nil {
+			continue
+		}
+		for _, pod := range pods {
+			if pod.DeletionTimestamp == nil && strings.HasPrefix(*lease.Spec.HolderIdentity, pod.Name+"_") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// providerManifestLabel returns the value of the cluster.x-k8s.io/provider label of the
+// components of the provider, "cluster-api" for the core provider as clusterctl names it.
+func providerManifestLabel(provider client.Object) string {
+	if _, ok := provider.(*operatorv1.CoreProvider); ok {
+		return "cluster-api"
+	}
+	return providerKey(provider)
+}
+
+// degradedCondition returns the Degraded condition for the upgrades rolled back, nil when
+// there is none.
+func (u *providerUpgrades) degradedCondition() *configv1.ClusterOperatorStatusCondition {
+	if u == nil || len(u.rolledBack) == 0 {
+		return nil
+	}
+	c := newClusterOperatorStatusCondition(configv1.OperatorDegraded, configv1.ConditionTrue, ReasonProviderUpgradeRolledBack,
+		strings.Join(u.rolledBack, ", "))
+	return &c
+}
+
+// isBackup returns whether the ConfigMap has the components of a provider version kept as
+// backup.
+func (u *providerUpgrades) isBackup(obj client.Object) bool {
+	labels := obj.GetLabels()
+	key := labels[providerTypeLabel] + "-" + labels[providerNameLabel]
+	version, ok := u.backups[key]
+	return ok && labels[providerVersionLabel] == version
+}
@@ -0,0 +1,119 @@
+package controllers
+
+import (
+	"testing"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	operatorv1 "sigs.k8s.io/cluster-api/exp/operator/api/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestLeads(t *testing.T) {
+	lease := func(holder string) coordinationv1.Lease {
+		return coordinationv1.Lease{Spec: coordinationv1.LeaseSpec{HolderIdentity: &holder}}
+	}
+	pod := func(name string, deleting bool) corev1.Pod {
+		p := corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name}}
+		if deleting {
+			p.DeletionTimestamp = &metav1.Time{}
+		}
+		return p
+	}
+	tests := []struct {
+		name   string
+		pods   []corev1.Pod
+		leases []coordinationv1.Lease
+		want   bool
+	}{
+		{
+			name:   "new pod leads",
+			pods:   []corev1.Pod{pod("capa-controller-manager-7d9f-x2k4z", false)},
+			leases: []coordinationv1.Lease{lease("capa-controller-manager-7d9f-x2k4z_0c5e4a1e-56a4-4b1c-9d1a-0d2a1b3c4d5e")},
+			want:   true,
+		},
+		{
+			name:   "previous pod leads",
+			pods:   []corev1.Pod{pod("capa-controller-manager-7d9f-x2k4z", false)},
+			leases: []coordinationv1.Lease{lease("capa-controller-manager-5b8c-q9r2s_6e1f2a3b-7c8d-4e9f-a0b1-c2d3e4f5a6b7")},
+		},
+		{
+			name:   "leading pod deleted",
+			pods:   []corev1.Pod{pod("capa-controller-manager-7d9f-x2k4z", true)},
+			leases: []coordinationv1.Lease{lease("capa-controller-manager-7d9f-x2k4z_0c5e4a1e-56a4-4b1c-9d1a-0d2a1b3c4d5e")},
+		},
+		{
+			name:   "no leader",
+			pods:   []corev1.Pod{pod("capa-controller-manager-7d9f-x2k4z", false)},
+			leases: []coordinationv1.Lease{{}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := leads(tt.pods, tt.leases); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsProviderInstalled(t *testing.T) {
+	provider := func(generation, observed int64, ready corev1.ConditionStatus) client.Object {
+		p := &operatorv1.InfrastructureProvider{ObjectMeta: metav1.ObjectMeta{Name: "aws", Generation: generation}}
+		p.Status.ObservedGeneration = observed
+		if ready != "" {
+			p.Status.Conditions = clusterv1.Conditions{{Type: clusterv1.ReadyCondition, Status: ready}}
+		}
+		return p
+	}
+	tests := []struct {
+		name     string
+		provider client.Object
+		want     bool
+	}{
+		{name: "installed", provider: provider(2, 2, corev1.ConditionTrue), want: true},
+		{name: "new version not observed", provider: provider(3, 2, corev1.ConditionTrue)},
+		{name: "not ready", provider: provider(2, 2, corev1.ConditionFalse)},
+		{name: "no condition", provider: provider(2, 2, "")},
+		{name: "not a provider", provider: &corev1.ConfigMap{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isProviderInstalled(tt.provider); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProviderUpgradesBackup(t *testing.T) {
+	configMap := func(name, version string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{
+			providerTypeLabel:    "infrastructure",
+			providerNameLabel:    "aws",
+			providerVersionLabel: version,
+		}}}
+	}
+	upgrades := newProviderUpgrades()
+	if upgrades.isBackup(configMap("aws-v0.7.0", "v0.7.0")) {
+		t.Error("no ConfigMap should be kept without an upgrade")
+	}
+	if upgrades.degradedCondition() != nil {
+		t.Error("no Degraded condition expected without a rollback")
+	}
+
+	upgrades.backups["infrastructure-aws"] = "v0.7.0"
+	if !upgrades.isBackup(configMap("aws-v0.7.0", "v0.7.0")) {
+		t.Error("the ConfigMap of the previous version should be kept")
+	}
+	if upgrades.isBackup(configMap("aws-v0.6.8", "v0.6.8")) {
+		t.Error("only the ConfigMap of the previous version should be kept")
+	}
+
+	upgrades.rolledBack = append(upgrades.rolledBack, "the upgrade of infrastructure-aws to v0.8.0 failed, rolled back to v0.7.0")
+	if c := upgrades.degradedCondition(); c == nil || c.Reason != ReasonProviderUpgradeRolledBack {
+		t.Errorf("unexpected Degraded condition %+v", c)
+	}
+}
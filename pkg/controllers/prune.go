@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// capiOperatorLabel is set on the operands of the capi-operator assets.
+const capiOperatorLabel = "clusterctl.cluster.x-k8s.io/core"
+
+// prunedClusterKinds are the cluster scoped kinds pruned with the objects of the namespace.
+// The CustomResourceDefinitions aren't, deleting one deletes its objects.
+var prunedClusterKinds = sets.NewString("ClusterRole", "ClusterRoleBinding", "ValidatingWebhookConfiguration", "MutatingWebhookConfiguration")
+
+// supersededIndex indexes the objects applied by kind, value of the key labels and name, to
+// tell the objects superseded by them: the previous version of a provider ConfigMap, named
+// after its version, or an operand renamed. The objects of the namespace and the ones of
+// prunedClusterKinds are indexed.
+type supersededIndex struct {
+	keyLabels []string
+	// names of the objects applied by kind and value of the key labels
+	names map[schema.GroupVersionKind]map[string]sets.String
+}
+
+func newSupersededIndex(applied []client.Object, namespace string, keyLabels ...string) supersededIndex {
+	idx := supersededIndex{keyLabels: keyLabels, names: map[schema.GroupVersionKind]map[string]sets.String{}}
+	for _, obj := range applied {
+		key, ok := idx.key(obj)
+		if !ok || obj.GetNamespace() != namespace && !isPrunedClusterObject(obj) {
+			continue
+		}
+		gvk := obj.GetObjectKind().GroupVersionKind()
+		if idx.names[gvk] == nil {
+			idx.names[gvk] = map[string]sets.String{}
+		}
+		if idx.names[gvk][key] == nil {
+			idx.names[gvk][key] = sets.NewString()
+		}
+		idx.names[gvk][key].Insert(obj.GetName())
+	}
+	return idx
+}
+
+// isPrunedClusterObject returns whether the object is cluster scoped and of a kind pruned.
+func isPrunedClusterObject(obj client.Object) bool {
+	return obj.GetNamespace() == "" && prunedClusterKinds.Has(obj.GetObjectKind().GroupVersionKind().Kind)
+}
+
+// key returns the values of the key labels of the object, false when one is missing.
+func (idx supersededIndex) key(obj client.Object) (string, bool) {
+	values := []string{}
+	for _, label := range idx.keyLabels {
+		value := obj.GetLabels()[label]
+		if value == "" {
+			return "", false
+		}
+		values = append(values, value)
+	}
+	return strings.Join(values, "/"), true
+}
+
+// superseded returns whether the live object has the same kind and key labels as objects
+// applied without being one of them. The objects of other providers are left alone.
+func (idx supersededIndex) superseded(obj client.Object) bool {
+	key, ok := idx.key(obj)
+	if !ok {
+		return false
+	}
+	names, ok := idx.names[obj.GetObjectKind().GroupVersionKind()][key]
+	return ok && !names.Has(obj.GetName())
+}
+
+// pruneSuperseded deletes the objects of the namespace and the cluster scoped RBAC and
+// webhook configurations superseded by the objects applied, keyed on keyLabels, so that the
+// ConfigMaps of the previous versions of the providers and the operands renamed don't linger
+// after an upgrade. The objects keep returns true for, when
+// set, are left alone.
+func pruneSuperseded(ctx context.Context, c client.Client, r record.EventRecorder, applied []client.Object, keep ObjectFilterFn, namespace string, keyLabels ...string) error {
+	idx := newSupersededIndex(applied, namespace, keyLabels...)
+	for gvk := range idx.names {
+		list := &unstructured.UnstructuredList{}
+		list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+		opts := []client.ListOption{client.HasLabels(keyLabels)}
+		if !prunedClusterKinds.Has(gvk.Kind) {
+			opts = append(opts, client.InNamespace(namespace))
+		}
+		if err := c.List(ctx, list, opts...); err != nil {
+			return err
+		}
+		for i := range list.Items {
+			obj := &list.Items[i]
+			if !idx.superseded(obj) || obj.GetDeletionTimestamp() != nil {
+				continue
+			}
+			if keep != nil && keep(obj) {
+				klog.V(2).Infof("keeping superseded %s %s", obj.GetKind(), obj.GetName())
+				continue
+			}
+			klog.Infof("pruning superseded %s %s", obj.GetKind(), obj.GetName())
+			if err := c.Delete(ctx, obj); err != nil && !apierrors.IsNotFound(err) {
+				r.Eventf(obj, corev1.EventTypeWarning, "PruneFailed", "Failed to prune:%v", err)
+				return err
+			}
+			r.Eventf(obj, corev1.EventTypeNormal, "Pruned", "superseded")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package controllers
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestSupersededIndex(t *testing.T) {
+	configMap := func(name, providerName string) *corev1.ConfigMap {
+		labels := map[string]string{providerTypeLabel: "infrastructure"}
+		if providerName != "" {
+			labels[providerNameLabel] = providerName
+		}
+		return &corev1.ConfigMap{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "openshift-cluster-api", Labels: labels},
+		}
+	}
+	clusterRole := func(name, providerName string) *rbacv1.ClusterRole {
+		return &rbacv1.ClusterRole{
+			TypeMeta: metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRole"},
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{
+				providerTypeLabel: "infrastructure",
+				providerNameLabel: providerName,
+			}},
+		}
+	}
+	idx := newSupersededIndex([]client.Object{
+		configMap("aws-v0.7.0", "aws"),
+		clusterRole("capa-manager-role", "aws"),
+	}, "openshift-cluster-api", providerTypeLabel, providerNameLabel)
+
+	tests := []struct {
+		name string
+		obj  client.Object
+		want bool
+	}{
+		{
+			name: "applied",
+			obj:  configMap("aws-v0.7.0", "aws"),
+		},
+		{
+			name: "previous version",
+			obj:  configMap("aws-v0.6.8", "aws"),
+			want: true,
+		},
+		{
+			name: "other provider",
+			obj:  configMap("azure-v0.5.2", "azure"),
+		},
+		{
+			name: "without provider labels",
+			obj:  configMap("aws-v0.6.8", ""),
+		},
+		{
+			name: "cluster scoped applied",
+			obj:  clusterRole("capa-manager-role", "aws"),
+		},
+		{
+			name: "cluster scoped renamed",
+			obj:  clusterRole("capa-manager", "aws"),
+			want: true,
+		},
+		{
+			name: "cluster scoped of other provider",
+			obj:  clusterRole("capz-manager", "azure"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := idx.superseded(tt.obj); got != tt.want {
+				t.Errorf("superseded() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package controllers
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog"
+	"sigs.k8s.io/yaml"
+
+	"github.com/openshift/cluster-capi-operator/pkg/util"
+)
+
+const (
+	relatedImagePrefix = "RELATED_IMAGE_"
+	// asoImagePrefix tells the containers of the Azure Service Operator bundled with the Azure
+	// provider from the ones of its manager, as the asset import does.
+	asoImagePrefix = "aso-"
+)
+
+// missingImagesError is returned when the payload has no image for containers of the
+// components, they can't be applied with their upstream images.
+type missingImagesError struct {
+	object string
+	images []string
+}
+
+func (e *missingImagesError) Error() string {
+	return fmt.Sprintf("no image in the payload for %s of %s", strings.Join(e.images, ", "), e.object)
+}
+
+// substituteImages replaces the images of the components of a provider ConfigMap with the
+// images of the payload: the ${RELATED_IMAGE_...} placeholders, and the upstream images of the
+// components imported or extracted without them.
+func (r *ClusterOperatorReconciler) substituteImages(cm *corev1.ConfigMap, components string) (string, error) {
+	object := "ConfigMap " + cm.Name
+	components, err := r.substituteUpstreamImages(object, cm.Labels[providerTypeLabel], cm.Labels[providerNameLabel], components)
+	if err != nil {
+		return "", err
+	}
+	return r.substituteRelatedImages(object, components)
+}
+
+// substituteRelatedImages replaces the ${RELATED_IMAGE_...} placeholders the asset import puts
+// in place of the upstream images with the images of the payload. It fails when the payload
+// has no image for a placeholder of object.
+func (r *ClusterOperatorReconciler) substituteRelatedImages(object, s string) (string, error) {
+	missing := []string{}
+	substituted := relatedImagePlaceholder.ReplaceAllStringFunc(s, func(placeholder string) string {
+		name := relatedImagePlaceholder.FindStringSubmatch(placeholder)[1]
+		image, ok := r.Images[name]
+		if !ok {
+			if !util.ContainsString(missing, name) {
+				missing = append(missing, name)
+			}
+			return placeholder
+		}
+		return image
+	})
+	if len(missing) > 0 {
+		return "", &missingImagesError{object: object, images: missing}
+	}
+	return substituted, nil
+}
+
+// substituteUpstreamImages replaces the upstream images of the containers of the Deployments
+// of the components with the payload images named after the provider and the container, e.g.
+// RELATED_IMAGE_INFRASTRUCTURE_AWS_MANAGER. Only the Deployments with images replaced are
+// written again, as yaml. It fails when the payload has no image for a container.
+func (r *ClusterOperatorReconciler) substituteUpstreamImages(object, providerType, providerName, components string) (string, error) {
+	docs, err := componentDocuments(components)
+	if err != nil {
+		return "", fmt.Errorf("invalid components in %s: %v", object, err)
+	}
+
+	substituted := false
+	missing := []string{}
+	for i, doc := range docs {
+		obj, err := decodeDocument(doc)
+		if err != nil {
+			return "", fmt.Errorf("invalid components in %s: %v", object, err)
+		}
+		if obj == nil || obj.GetKind() != "Deployment" {
+			continue
+		}
+		replaced := false
+		for _, field := range []string{"initContainers", "containers"} {
+			containers, _, err := unstructured.NestedSlice(obj.Object, "spec", "template", "spec", field)
+			if err != nil {
+				return "", fmt.Errorf("invalid Deployment %s in %s: %v", obj.GetName(), object, err)
+			}
+			for _, c := range containers {
+				container, ok := c.(map[string]interface{})
+				if !ok {
+					return "", fmt.Errorf("invalid Deployment %s in %s: %s is not a container", obj.GetName(), object, field)
+				}
+				upstream, _ := container["image"].(string)
+				if relatedImagePlaceholder.MatchString(upstream) {
+					continue
+				}
+				containerName, _ := container["name"].(string)
+				if strings.HasPrefix(obj.GetName(), "azureserviceoperator-") || strings.HasPrefix(obj.GetName(), asoImagePrefix) {
+					containerName = asoImagePrefix + containerName
+				}
+				name := relatedImageName(providerType, providerName, containerName)
+				image, ok := r.Images[name]
+				if !ok {
+					if !util.ContainsString(missing, name) {
+						missing = append(missing, name)
+					}
+					continue
+				}
+				if image != upstream {
+					klog.V(2).Infof("%s: replacing image %s with %s", object, upstream, image)
+					container["image"] = image
+					replaced = true
+				}
+			}
+			if replaced {
+				if err := unstructured.SetNestedSlice(obj.Object, containers, "spec", "template", "spec", field); err != nil {
+					return "", err
+				}
+			}
+		}
+		if !replaced {
+			continue
+		}
+		if docs[i], err = yaml.Marshal(obj.Object); err != nil {
+			return "", err
+		}
+		substituted = true
+	}
+	if len(missing) > 0 {
+		return "", &missingImagesError{object: object, images: missing}
+	}
+	if !substituted {
+		return components, nil
+	}
+
+	var b bytes.Buffer
+	for i, doc := range docs {
+		if i > 0 {
+			b.WriteString("---\n")
+		}
+		b.Write(doc)
+		if !bytes.HasSuffix(doc, []byte("\n")) {
+			b.WriteString("\n")
+		}
+	}
+	return b.String(), nil
+}
+
+// relatedImageName returns the name of the payload image of a provider container, as the
+// asset import names it, e.g. RELATED_IMAGE_INFRASTRUCTURE_AWS_MANAGER.
+func relatedImageName(providerType, providerName, container string) string {
+	name := providerType + "_" + providerName + "_" + container
+	return relatedImagePrefix + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(name))
+}
+
+func componentDeployments(components string) ([]*appsv1.Deployment, error) {
+	objs, err := componentObjects(components)
+	if err != nil {
+		return nil, err
+	}
+	deps := []*appsv1.Deployment{}
+	for _, obj := range objs {
+		if obj.GetKind() != "Deployment" {
+			continue
+		}
+		dep := &appsv1.Deployment{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, dep); err != nil {
+			return nil, err
+		}
+		deps = append(deps, dep)
+	}
+	return deps, nil
+}
@@ -0,0 +1,193 @@
+package controllers
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	operatorv1 "sigs.k8s.io/cluster-api/exp/operator/api/v1alpha1"
+
+	"github.com/openshift/cluster-capi-operator/assets"
+)
+
+func TestSubstituteRelatedImages(t *testing.T) {
+	images := map[string]string{
+		"RELATED_IMAGE_INFRASTRUCTURE_AWS_MANAGER":   "k8s.gcr.io/cluster-api-aws/cluster-api-aws-controller:v0.7.0",
+		"RELATED_IMAGE_CORE_CLUSTER_API_MANAGER":     "k8s.gcr.io/cluster-api/cluster-api-controller:v1.0.0",
+		"RELATED_IMAGE_INFRASTRUCTURE_METAL3_IPAM_1": "quay.io/metal3-io/ip-address-manager:v0.1.1",
+	}
+	tests := []struct {
+		name    string
+		in      string
+		want    string
+		missing []string
+	}{
+		{
+			name: "image",
+			in:   "${RELATED_IMAGE_INFRASTRUCTURE_AWS_MANAGER}",
+			want: "k8s.gcr.io/cluster-api-aws/cluster-api-aws-controller:v0.7.0",
+		},
+		{
+			name: "components",
+			in:   "containers:\n- image: ${RELATED_IMAGE_CORE_CLUSTER_API_MANAGER}\n- image: ${RELATED_IMAGE_INFRASTRUCTURE_METAL3_IPAM_1}\n",
+			want: "containers:\n- image: k8s.gcr.io/cluster-api/cluster-api-controller:v1.0.0\n- image: quay.io/metal3-io/ip-address-manager:v0.1.1\n",
+		},
+		{
+			name:    "unknown image",
+			in:      "${RELATED_IMAGE_INFRASTRUCTURE_UNKNOWN_MANAGER}",
+			missing: []string{"RELATED_IMAGE_INFRASTRUCTURE_UNKNOWN_MANAGER"},
+		},
+		{
+			name:    "unknown images among known ones",
+			in:      "- image: ${RELATED_IMAGE_CORE_CLUSTER_API_MANAGER}\n- image: ${RELATED_IMAGE_UNKNOWN}\n- image: ${RELATED_IMAGE_UNKNOWN}\n",
+			missing: []string{"RELATED_IMAGE_UNKNOWN"},
+		},
+		{
+			name: "no placeholder",
+			in:   "quay.io/asalkeld/cluster-api-operator-amd64:dev",
+			want: "quay.io/asalkeld/cluster-api-operator-amd64:dev",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &ClusterOperatorReconciler{
+				Images: images,
+			}
+			got, err := r.substituteRelatedImages("ConfigMap test", tt.in)
+			var imagesErr *missingImagesError
+			if errors.As(err, &imagesErr) {
+				if diff := cmp.Diff(tt.missing, imagesErr.images); diff != "" {
+					t.Error(diff)
+				}
+				return
+			}
+			if err != nil || tt.missing != nil {
+				t.Fatalf("expected missing images %v, got %v", tt.missing, err)
+			}
+			if got != tt.want {
+				t.Error(cmp.Diff(got, tt.want))
+			}
+		})
+	}
+}
+
+func TestSubstituteImages(t *testing.T) {
+	images := map[string]string{
+		"RELATED_IMAGE_INFRASTRUCTURE_AZURE_MANAGER":         "quay.io/openshift/azure-cluster-api-controllers@sha256:1",
+		"RELATED_IMAGE_INFRASTRUCTURE_AZURE_KUBE_RBAC_PROXY": "quay.io/openshift/kube-rbac-proxy@sha256:2",
+		"RELATED_IMAGE_INFRASTRUCTURE_AZURE_ASO_MANAGER":     "quay.io/openshift/azure-service-operator@sha256:3",
+	}
+	deployment := func(name string, containers ...string) string {
+		s := "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: " + name + "\nspec:\n  template:\n    spec:\n      containers:\n"
+		for _, c := range containers {
+			s += "      - " + c + "\n"
+		}
+		return s
+	}
+	tests := []struct {
+		name       string
+		components string
+		want       string
+		missing    []string
+	}{
+		{
+			name: "upstream images",
+			components: deployment("capz-controller-manager",
+				"name: manager\n        image: us.gcr.io/cluster-api-azure-controller:v0.5.2",
+				"name: kube-rbac-proxy\n        image: \"gcr.io/kubebuilder/kube-rbac-proxy:v0.8.0\""),
+			want: deployment("capz-controller-manager",
+				"name: manager\n        image: quay.io/openshift/azure-cluster-api-controllers@sha256:1",
+				"name: kube-rbac-proxy\n        image: \"quay.io/openshift/kube-rbac-proxy@sha256:2\""),
+		},
+		{
+			name: "placeholders and the Azure Service Operator",
+			components: deployment("capz-controller-manager", "name: manager\n        image: ${RELATED_IMAGE_INFRASTRUCTURE_AZURE_MANAGER}") + "---\n" +
+				deployment("azureserviceoperator-controller-manager", "name: manager\n        image: mcr.microsoft.com/k8s/azureserviceoperator:v2.0.0"),
+			want: deployment("capz-controller-manager", "name: manager\n        image: quay.io/openshift/azure-cluster-api-controllers@sha256:1") + "---\n" +
+				deployment("azureserviceoperator-controller-manager", "name: manager\n        image: quay.io/openshift/azure-service-operator@sha256:3"),
+		},
+		{
+			name:       "compact JSON",
+			components: `{"apiVersion":"apps/v1","kind":"Deployment","metadata":{"name":"capz-controller-manager"},"spec":{"template":{"spec":{"containers":[{"name":"manager","image":"us.gcr.io/cluster-api-azure-controller:v0.5.2"}]}}}}`,
+			want:       deployment("capz-controller-manager", "name: manager\n        image: quay.io/openshift/azure-cluster-api-controllers@sha256:1"),
+		},
+		{
+			name:       "no Deployment",
+			components: "apiVersion: v1\nkind: Service\nmetadata:\n  name: capz-webhook-service\n",
+			want:       "apiVersion: v1\nkind: Service\nmetadata:\n  name: capz-webhook-service\n",
+		},
+		{
+			name:       "unknown container",
+			components: deployment("capz-controller-manager", "name: sidecar\n        image: quay.io/sidecar:v1"),
+			missing:    []string{"RELATED_IMAGE_INFRASTRUCTURE_AZURE_SIDECAR"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &ClusterOperatorReconciler{Images: images}
+			cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+				Name:   "azure-v0.5.2",
+				Labels: map[string]string{providerTypeLabel: "infrastructure", providerNameLabel: "azure"},
+			}}
+			got, err := r.substituteImages(cm, tt.components)
+			var imagesErr *missingImagesError
+			if errors.As(err, &imagesErr) {
+				if diff := cmp.Diff(tt.missing, imagesErr.images); diff != "" {
+					t.Error(diff)
+				}
+				return
+			}
+			if err != nil || tt.missing != nil {
+				t.Fatalf("expected missing images %v, got %v", tt.missing, err)
+			}
+			// the Deployments with images replaced are written again
+			if diff := cmp.Diff(decodeComponents(t, tt.want), decodeComponents(t, got)); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestSubstituteImagesOfAssets(t *testing.T) {
+	// the image-references tag of the kube-rbac-proxy of the payload
+	const kubeRBACProxyImage = "registry.ci.openshift.org/openshift:kube-rbac-proxy"
+	images := manifestImages(t)
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := operatorv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	objs, err := assets.Providers("", scheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &ClusterOperatorReconciler{Images: images}
+	for _, obj := range objs {
+		cm, ok := obj.(*corev1.ConfigMap)
+		if !ok || !hasProviderComponents(cm) {
+			continue
+		}
+		components, err := r.substituteImages(cm, cm.Data[componentsKey])
+		if err != nil {
+			t.Fatal(err)
+		}
+		deps, err := componentDeployments(components)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, dep := range deps {
+			for _, c := range dep.Spec.Template.Spec.Containers {
+				if c.Name == "kube-rbac-proxy" && c.Image != kubeRBACProxyImage {
+					t.Errorf("container %s of Deployment %s in ConfigMap %s has image %s, want %s", c.Name, dep.Name, cm.Name, c.Image, kubeRBACProxyImage)
+				}
+			}
+		}
+	}
+}
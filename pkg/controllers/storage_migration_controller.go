@@ -0,0 +1,167 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+const (
+	// storageMigrationPageSize is the number of objects listed at once by the migration.
+	storageMigrationPageSize = 500
+	// storageMigrationRequeueAfter is how long to wait for a CRD to be established, or to
+	// settle on its storage version, before migrating it.
+	storageMigrationRequeueAfter = 30 * time.Second
+)
+
+// StorageVersionMigrationReconciler migrates the objects of the cluster api CRDs to their
+// storage version once an upgrade changes it, and then trims the status.storedVersions of the
+// CRD to it. A version can only be removed from a CRD once it is no longer stored, the next
+// upgrade dropping the previous versions would be rejected otherwise.
+type StorageVersionMigrationReconciler struct {
+	client.Client
+	// APIReader lists the objects to migrate, they aren't cached.
+	APIReader client.Reader
+	Recorder  record.EventRecorder
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *StorageVersionMigrationReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("storage-version-migration").
+		For(&apiextensionsv1.CustomResourceDefinition{}, builder.WithPredicates(clusterAPICRDPredicates())).
+		Complete(r)
+}
+
+// clusterAPICRDPredicates select the CRDs of the providers and of the CAPI Operator.
+func clusterAPICRDPredicates() predicate.Funcs {
+	return predicate.NewPredicateFuncs(isClusterAPIObject)
+}
+
+// isClusterAPIObject returns whether the object is installed by a provider or the operator.
+func isClusterAPIObject(obj client.Object) bool {
+	labels := obj.GetLabels()
+	return labels[providerLabel] != "" || labels[capiOperatorLabel] != ""
+}
+
+// Reconcile migrates the objects of the CRD when it has stored versions other than its
+// storage version.
+func (r *StorageVersionMigrationReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	crd := &apiextensionsv1.CustomResourceDefinition{}
+	if err := r.Get(ctx, req.NamespacedName, crd); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	storage := storageVersion(crd)
+	if !needsStorageMigration(crd, storage) {
+		return ctrl.Result{}, nil
+	}
+	served := servedVersion(crd, storage)
+	if !isCRDEstablished(crd) || served == "" {
+		klog.Infof("waiting for CRD %s to be established before migrating it", crd.Name)
+		return ctrl.Result{RequeueAfter: storageMigrationRequeueAfter}, nil
+	}
+
+	klog.Infof("migrating the objects of CRD %s from the stored versions %v to %s", crd.Name, crd.Status.StoredVersions, storage)
+	gvk := schema.GroupVersionKind{Group: crd.Spec.Group, Version: served, Kind: crd.Spec.Names.Kind + "List"}
+	migrated, err := r.migrate(ctx, gvk)
+	if err != nil {
+		r.Recorder.Eventf(crd, corev1.EventTypeWarning, "StorageMigrationFailed", "Failed to migrate to %s:%v", storage, err)
+		return ctrl.Result{}, fmt.Errorf("failed to migrate the objects of CRD %s: %v", crd.Name, err)
+	}
+
+	// the storage version may have changed again meanwhile, the objects written since are
+	// stored in the new one
+	latest := &apiextensionsv1.CustomResourceDefinition{}
+	if err := r.APIReader.Get(ctx, req.NamespacedName, latest); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if storageVersion(latest) != storage {
+		klog.Infof("the storage version of CRD %s changed during its migration, migrating it again", crd.Name)
+		return ctrl.Result{Requeue: true}, nil
+	}
+	latest.Status.StoredVersions = []string{storage}
+	if err := r.Status().Update(ctx, latest); err != nil {
+		return ctrl.Result{}, err
+	}
+	klog.Infof("migrated %d objects of CRD %s to %s", migrated, crd.Name, storage)
+	r.Recorder.Eventf(latest, corev1.EventTypeNormal, "StorageMigrated", "migrated %d objects to %s", migrated, storage)
+	return ctrl.Result{}, nil
+}
+
+// migrate rewrites every object of the kind unchanged, which the API server stores in the
+// storage version of the CRD, and returns how many it rewrote. The objects deleted or updated
+// meanwhile are already stored in it.
+func (r *StorageVersionMigrationReconciler) migrate(ctx context.Context, listGVK schema.GroupVersionKind) (int, error) {
+	migrated := 0
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(listGVK)
+	for {
+		if err := r.APIReader.List(ctx, list, client.Limit(storageMigrationPageSize), client.Continue(list.GetContinue())); err != nil {
+			return migrated, err
+		}
+		for i := range list.Items {
+			obj := &list.Items[i]
+			if err := r.Update(ctx, obj); err != nil && !apierrors.IsNotFound(err) && !apierrors.IsConflict(err) {
+				return migrated, fmt.Errorf("failed to migrate %s %s/%s: %v", obj.GetKind(), obj.GetNamespace(), obj.GetName(), err)
+			}
+			migrated++
+		}
+		if list.GetContinue() == "" {
+			return migrated, nil
+		}
+	}
+}
+
+// storageVersion returns the version the objects of the CRD are stored in.
+func storageVersion(crd *apiextensionsv1.CustomResourceDefinition) string {
+	for _, v := range crd.Spec.Versions {
+		if v.Storage {
+			return v.Name
+		}
+	}
+	return ""
+}
+
+// needsStorageMigration returns whether objects of the CRD may be stored in versions other
+// than the storage version.
+func needsStorageMigration(crd *apiextensionsv1.CustomResourceDefinition, storage string) bool {
+	if storage == "" {
+		return false
+	}
+	for _, v := range crd.Status.StoredVersions {
+		if v != storage {
+			return true
+		}
+	}
+	return false
+}
+
+// servedVersion returns the version to read the objects of the CRD in, the storage version
+// when it is served, empty when the CRD serves none.
+func servedVersion(crd *apiextensionsv1.CustomResourceDefinition, storage string) string {
+	served := ""
+	for _, v := range crd.Spec.Versions {
+		if !v.Served {
+			continue
+		}
+		if v.Name == storage {
+			return v.Name
+		}
+		if served == "" {
+			served = v.Name
+		}
+	}
+	return served
+}
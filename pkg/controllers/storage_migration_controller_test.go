@@ -0,0 +1,65 @@
+package controllers
+
+import (
+	"testing"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+)
+
+func TestStorageMigration(t *testing.T) {
+	crd := func(stored []string, versions ...apiextensionsv1.CustomResourceDefinitionVersion) *apiextensionsv1.CustomResourceDefinition {
+		c := &apiextensionsv1.CustomResourceDefinition{}
+		c.Spec.Versions = versions
+		c.Status.StoredVersions = stored
+		return c
+	}
+	v1alpha4 := apiextensionsv1.CustomResourceDefinitionVersion{Name: "v1alpha4", Served: true}
+	v1beta1 := apiextensionsv1.CustomResourceDefinitionVersion{Name: "v1beta1", Served: true, Storage: true}
+	tests := []struct {
+		name        string
+		crd         *apiextensionsv1.CustomResourceDefinition
+		wantStorage string
+		wantMigrate bool
+		wantServed  string
+	}{
+		{
+			name:        "migrated",
+			crd:         crd([]string{"v1beta1"}, v1alpha4, v1beta1),
+			wantStorage: "v1beta1",
+			wantServed:  "v1beta1",
+		},
+		{
+			name:        "storage version changed",
+			crd:         crd([]string{"v1alpha4", "v1beta1"}, v1alpha4, v1beta1),
+			wantStorage: "v1beta1",
+			wantMigrate: true,
+			wantServed:  "v1beta1",
+		},
+		{
+			name: "storage version not served",
+			crd: crd([]string{"v1alpha4", "v1beta1"}, v1alpha4,
+				apiextensionsv1.CustomResourceDefinitionVersion{Name: "v1beta1", Storage: true}),
+			wantStorage: "v1beta1",
+			wantMigrate: true,
+			wantServed:  "v1alpha4",
+		},
+		{
+			name: "no storage version",
+			crd:  crd([]string{"v1alpha4"}),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := storageVersion(tt.crd)
+			if storage != tt.wantStorage {
+				t.Errorf("storage version %q, want %q", storage, tt.wantStorage)
+			}
+			if got := needsStorageMigration(tt.crd, storage); got != tt.wantMigrate {
+				t.Errorf("needs migration %v, want %v", got, tt.wantMigrate)
+			}
+			if got := servedVersion(tt.crd, storage); got != tt.wantServed {
+				t.Errorf("served version %q, want %q", got, tt.wantServed)
+			}
+		})
+	}
+}
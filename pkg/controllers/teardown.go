@@ -0,0 +1,214 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/klog"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	configv1 "github.com/openshift/api/config/v1"
+	capiv1alpha1 "github.com/openshift/cluster-capi-operator/api/v1alpha1"
+	"github.com/openshift/cluster-capi-operator/assets"
+	"github.com/openshift/cluster-capi-operator/pkg/util"
+)
+
+// teardownRequeueAfter is how long to wait for the objects deleted to be gone.
+const teardownRequeueAfter = 10 * time.Second
+
+// keptOnTeardown are the kinds of the assets left in place when cluster api is disabled: the
+// CRDs, deleting them would delete the cluster api objects of the cluster with them, and the
+// ones applied by the manifest.
+var keptOnTeardown = append([]string{"CustomResourceDefinition"}, appliedByManifest...)
+
+// teardown removes what reconcile installed once the feature gate no longer enables cluster
+// api, or the configuration uninstalls it. The providers are scaled down, so that they stop
+// acting on the cluster, and deleted first, and the CAPI Operator only once they are gone, it
+// removes their components before releasing their finalizers. The CRDs are removed last, when
+// the configuration asks for it and no cluster api object is left. The step in progress is
+// reported on inactive.
+func (r *ClusterOperatorReconciler) teardown(ctx context.Context, config *capiv1alpha1.ClusterAPIConfiguration, inactive *operandsInactive) (ctrl.Result, error) {
+	// the upgrades and pauses of the providers removed no longer matter
+	r.upgrades = nil
+	r.paused = nil
+	setProviderVersions(nil)
+	providerConditionGauge.Reset()
+	setProviderUpgradesInProgress(nil)
+
+	objs, err := assets.Providers(r.ProviderAssetsDir, r.Scheme)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	providers := NewUpdater(objs).WithFilter(func(obj client.Object) bool {
+		return providerKey(obj) != ""
+	})
+	if err := r.scaleDownProviders(ctx); err != nil {
+		return ctrl.Result{}, err
+	}
+	remaining, err := providers.Delete(ctx, r.Client, r.Recorder)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if remaining > 0 {
+		klog.Infof("waiting for %d providers to be deleted", remaining)
+		inactive.progress = fmt.Sprintf("Deleting %d providers", remaining)
+		return ctrl.Result{RequeueAfter: teardownRequeueAfter}, nil
+	}
+	if _, err := NewUpdater(objs).Delete(ctx, r.Client, r.Recorder); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.removePodDisruptionBudgets(ctx, nil); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.removeAlertingRules(ctx); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	objs, err = assets.FromDir("capi-operator", r.Scheme)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	remaining, err = NewUpdater(objs).WithFilter(func(obj client.Object) bool {
+		return !util.ContainsString(keptOnTeardown, obj.GetObjectKind().GroupVersionKind().Kind)
+	}).Delete(ctx, r.Client, r.Recorder)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if remaining > 0 {
+		klog.Infof("waiting for %d CAPI Operator objects to be deleted", remaining)
+		inactive.progress = "Deleting the CAPI Operator"
+		return ctrl.Result{RequeueAfter: teardownRequeueAfter}, nil
+	}
+
+	infra := &configv1.Infrastructure{}
+	if err := r.Get(ctx, client.ObjectKey{Name: infrastructureResourceName}, infra); err != nil && !apierrors.IsNotFound(err) {
+		return ctrl.Result{}, err
+	}
+	clusterName := infra.Status.InfrastructureName
+	if clusterName != "" {
+		kubeconfig := &corev1.Secret{}
+		kubeconfig.Name, kubeconfig.Namespace = clusterName+"-kubeconfig", r.ManagedNamespace
+		if err := r.Delete(ctx, kubeconfig); err != nil && !apierrors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+	}
+
+	if !config.Spec.RemoveCRDsOnUninstall {
+		return ctrl.Result{}, nil
+	}
+	return r.removeCRDs(ctx, clusterName, inactive)
+}
+
+// scaleDownProviders scales the Deployments of the providers down to 0 replicas.
+func (r *ClusterOperatorReconciler) scaleDownProviders(ctx context.Context) error {
+	deps := &appsv1.DeploymentList{}
+	if err := r.List(ctx, deps, client.InNamespace(r.ManagedNamespace), client.HasLabels{providerLabel}); err != nil {
+		return err
+	}
+	for i := range deps.Items {
+		dep := &deps.Items[i]
+		if dep.Spec.Replicas != nil && *dep.Spec.Replicas == 0 {
+			continue
+		}
+		klog.Infof("scaling down Deployment %s", dep.Name)
+		patch := client.MergeFrom(dep.DeepCopy())
+		replicas := int32(0)
+		dep.Spec.Replicas = &replicas
+		if err := r.Patch(ctx, dep, patch); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		r.Recorder.Eventf(dep, corev1.EventTypeNormal, "ScaledDown", "cluster api is uninstalled")
+	}
+	return nil
+}
+
+// removeCRDs deletes the CRDs of the providers and of the CAPI Operator when there is no
+// Machine and no Cluster but the one of the OpenShift cluster, which is deleted first. The
+// providers are gone, the finalizers of the Cluster and of its InfraCluster are released.
+func (r *ClusterOperatorReconciler) removeCRDs(ctx context.Context, clusterName string, inactive *operandsInactive) (ctrl.Result, error) {
+	machines, err := listClusterAPIObjects(ctx, r.Client, "MachineList")
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	clusters, err := listClusterAPIObjects(ctx, r.Client, "ClusterList")
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	others := []unstructured.Unstructured{}
+	for _, cluster := range clusters {
+		if cluster.GetName() != clusterName || cluster.GetNamespace() != r.ManagedNamespace {
+			others = append(others, cluster)
+		}
+	}
+	if len(machines) > 0 || len(others) > 0 {
+		klog.Infof("keeping the cluster api CRDs, %d Machines and %d Clusters are left", len(machines), len(others))
+		inactive.message = fmt.Sprintf("%s, the CRDs are kept while %d Machines and %d Clusters are left", inactive.message, len(machines), len(others))
+		return ctrl.Result{}, nil
+	}
+
+	for i := range clusters {
+		if err := r.releaseAndDelete(ctx, &clusters[i]); err != nil {
+			return ctrl.Result{}, err
+		}
+		ref, _, _ := unstructured.NestedMap(clusters[i].Object, "spec", "infrastructureRef")
+		infraCluster := &unstructured.Unstructured{}
+		infraCluster.SetAPIVersion(fmt.Sprint(ref["apiVersion"]))
+		infraCluster.SetKind(fmt.Sprint(ref["kind"]))
+		if err := r.Get(ctx, client.ObjectKey{Namespace: clusters[i].GetNamespace(), Name: fmt.Sprint(ref["name"])}, infraCluster); err == nil {
+			if err := r.releaseAndDelete(ctx, infraCluster); err != nil {
+				return ctrl.Result{}, err
+			}
+		} else if !apierrors.IsNotFound(err) && !meta.IsNoMatchError(err) {
+			return ctrl.Result{}, err
+		}
+	}
+
+	remaining := 0
+	for _, label := range []string{providerLabel, capiOperatorLabel} {
+		crds := &apiextensionsv1.CustomResourceDefinitionList{}
+		if err := r.List(ctx, crds, client.HasLabels{label}); err != nil {
+			return ctrl.Result{}, err
+		}
+		for i := range crds.Items {
+			crd := &crds.Items[i]
+			remaining++
+			if crd.DeletionTimestamp != nil {
+				continue
+			}
+			klog.Infof("deleting CRD %s", crd.Name)
+			if err := r.Delete(ctx, crd); err != nil && !apierrors.IsNotFound(err) {
+				return ctrl.Result{}, err
+			}
+			r.Recorder.Eventf(crd, corev1.EventTypeNormal, "Deleted", "cluster api is uninstalled")
+		}
+	}
+	if remaining > 0 {
+		inactive.progress = fmt.Sprintf("Deleting %d CRDs", remaining)
+		return ctrl.Result{RequeueAfter: teardownRequeueAfter}, nil
+	}
+	return ctrl.Result{}, nil
+}
+
+// releaseAndDelete removes the finalizers of the object, its controller is gone, and deletes it.
+func (r *ClusterOperatorReconciler) releaseAndDelete(ctx context.Context, obj *unstructured.Unstructured) error {
+	if len(obj.GetFinalizers()) > 0 {
+		patch := client.MergeFrom(obj.DeepCopy())
+		obj.SetFinalizers(nil)
+		if err := r.Patch(ctx, obj, patch); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	klog.Infof("deleting %s %s", obj.GetKind(), obj.GetName())
+	if err := r.Delete(ctx, obj); err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
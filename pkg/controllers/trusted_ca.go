@@ -0,0 +1,94 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+	operatorv1 "sigs.k8s.io/cluster-api/exp/operator/api/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+const (
+	// trustedCAConfigMapName is the ConfigMap the cluster network operator injects the trusted
+	// CA bundle in, with the user CAs, the asset import mounts it in the provider containers.
+	trustedCAConfigMapName     = "cluster-api-trusted-ca"
+	injectTrustedCABundleLabel = "config.openshift.io/inject-trusted-cabundle"
+	trustedCABundleKey         = "ca-bundle.crt"
+	// trustedCABundleHashEnv is set on the manager container of the providers to the hash of
+	// the bundle, so that they are rolled out with the bundle changed.
+	trustedCABundleHashEnv = "TRUSTED_CA_BUNDLE_HASH"
+	managerContainer       = "manager"
+)
+
+func trustedCAPredicates() predicate.Funcs {
+	return predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		return obj.GetName() == trustedCAConfigMapName
+	})
+}
+
+// ensureTrustedCA creates the trusted CA ConfigMap of the managed namespace, or labels it
+// again for the injection, and returns the hash of the bundle injected, empty until it is.
+func (r *ClusterOperatorReconciler) ensureTrustedCA(ctx context.Context) (string, error) {
+	cm := &corev1.ConfigMap{}
+	err := r.Get(ctx, client.ObjectKey{Namespace: r.ManagedNamespace, Name: trustedCAConfigMapName}, cm)
+	if apierrors.IsNotFound(err) {
+		cm = &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+			Namespace: r.ManagedNamespace,
+			Name:      trustedCAConfigMapName,
+			Labels:    map[string]string{injectTrustedCABundleLabel: "true"},
+		}}
+		klog.Infof("creating ConfigMap %s", trustedCAConfigMapName)
+		if err := r.Create(ctx, cm); err != nil {
+			return "", err
+		}
+		r.Recorder.Eventf(cm, corev1.EventTypeNormal, "Created", "trusted CA bundle ConfigMap created")
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	if cm.Labels[injectTrustedCABundleLabel] != "true" {
+		patch := client.MergeFrom(cm.DeepCopy())
+		if cm.Labels == nil {
+			cm.Labels = map[string]string{}
+		}
+		cm.Labels[injectTrustedCABundleLabel] = "true"
+		klog.Infof("labelling ConfigMap %s for the trusted CA bundle injection", trustedCAConfigMapName)
+		if err := r.Patch(ctx, cm, patch); err != nil {
+			return "", err
+		}
+	}
+	return trustedCABundleHash(cm), nil
+}
+
+func trustedCABundleHash(cm *corev1.ConfigMap) string {
+	bundle, ok := cm.Data[trustedCABundleKey]
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(bundle)))
+}
+
+// setTrustedCABundleHash sets the hash of the trusted CA bundle on the manager container of
+// the provider, changing the pod template of its Deployment when the bundle changes, the
+// bundle mounted is only read at startup.
+func setTrustedCABundleHash(spec *operatorv1.ProviderSpec, hash string) {
+	if hash == "" {
+		return
+	}
+	container := containerSpec(spec, managerContainer)
+	env := corev1.EnvVar{Name: trustedCABundleHashEnv, Value: hash}
+	for i := range container.Env {
+		if container.Env[i].Name == trustedCABundleHashEnv {
+			container.Env[i] = env
+			return
+		}
+	}
+	container.Env = append(container.Env, env)
+}
@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	operatorv1 "sigs.k8s.io/cluster-api/exp/operator/api/v1alpha1"
+)
+
+func TestSetTrustedCABundleHash(t *testing.T) {
+	hashEnv := func(hash string) corev1.EnvVar {
+		return corev1.EnvVar{Name: trustedCABundleHashEnv, Value: hash}
+	}
+	tests := []struct {
+		name string
+		spec operatorv1.ProviderSpec
+		hash string
+		want operatorv1.ProviderSpec
+	}{
+		{
+			name: "no bundle injected yet",
+		},
+		{
+			name: "no deployment spec",
+			hash: "abc",
+			want: operatorv1.ProviderSpec{Deployment: &operatorv1.DeploymentSpec{
+				Containers: []operatorv1.ContainerSpec{{Name: managerContainer, Env: []corev1.EnvVar{hashEnv("abc")}}},
+			}},
+		},
+		{
+			name: "resource overrides kept",
+			spec: operatorv1.ProviderSpec{Deployment: &operatorv1.DeploymentSpec{
+				Containers: []operatorv1.ContainerSpec{
+					{Name: "kube-rbac-proxy", Resources: &corev1.ResourceRequirements{}},
+					{Name: managerContainer, Resources: &corev1.ResourceRequirements{}},
+				},
+			}},
+			hash: "abc",
+			want: operatorv1.ProviderSpec{Deployment: &operatorv1.DeploymentSpec{
+				Containers: []operatorv1.ContainerSpec{
+					{Name: "kube-rbac-proxy", Resources: &corev1.ResourceRequirements{}},
+					{Name: managerContainer, Resources: &corev1.ResourceRequirements{}, Env: []corev1.EnvVar{hashEnv("abc")}},
+				},
+			}},
+		},
+		{
+			name: "bundle changed",
+			spec: operatorv1.ProviderSpec{Deployment: &operatorv1.DeploymentSpec{
+				Containers: []operatorv1.ContainerSpec{{Name: managerContainer, Env: []corev1.EnvVar{{Name: "FOO", Value: "bar"}, hashEnv("abc")}}},
+			}},
+			hash: "def",
+			want: operatorv1.ProviderSpec{Deployment: &operatorv1.DeploymentSpec{
+				Containers: []operatorv1.ContainerSpec{{Name: managerContainer, Env: []corev1.EnvVar{{Name: "FOO", Value: "bar"}, hashEnv("def")}}},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTrustedCABundleHash(&tt.spec, tt.hash)
+			if diff := cmp.Diff(tt.want, tt.spec); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestTrustedCABundleHash(t *testing.T) {
+	if hash := trustedCABundleHash(&corev1.ConfigMap{}); hash != "" {
+		t.Errorf("expected no hash before the injection, got %s", hash)
+	}
+	injected := &corev1.ConfigMap{Data: map[string]string{trustedCABundleKey: "bundle"}}
+	rotated := &corev1.ConfigMap{Data: map[string]string{trustedCABundleKey: "bundle with a user CA"}}
+	if trustedCABundleHash(injected) == "" || trustedCABundleHash(injected) == trustedCABundleHash(rotated) {
+		t.Error("expected the hash to change with the bundle")
+	}
+}
@@ -2,17 +2,25 @@ package controllers
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"time"
 
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/klog"
-	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+// fieldManager owns the fields of the objects applied.
+const fieldManager = "cluster-capi-operator"
+
 type updater struct {
-	objs []client.Object
+	objs    []client.Object
+	drifted []string
 }
 
 type ObjectMutateFn func(client.Object) (client.Object, error)
@@ -23,8 +31,14 @@ type Updater interface {
 	WithFilter(objectFilterFn ObjectFilterFn) Updater
 	// Mutate will call the ObjectMutateFn for each object.
 	Mutate(objectMutateFn ObjectMutateFn) error
-	// CreateOrUpdate will create or update all objects.
-	CreateOrUpdate(ctx context.Context, c client.Client, r record.EventRecorder) error
+	// Apply will server-side apply all objects, phase by phase, see applyPhases.
+	Apply(ctx context.Context, c client.Client, r record.EventRecorder) error
+	// Objects returns the objects kept by the filters.
+	Objects() []client.Object
+	// Drifted returns the objects modified out-of-band whose modifications Apply reverted.
+	Drifted() []string
+	// Delete will delete all objects and return how many of them are still being deleted.
+	Delete(ctx context.Context, c client.Client, r record.EventRecorder) (int, error)
 }
 
 func NewUpdater(objs []client.Object) Updater {
@@ -53,37 +67,138 @@ func (u *updater) Mutate(objectMutateFn ObjectMutateFn) error {
 	return nil
 }
 
-func (u *updater) CreateOrUpdate(ctx context.Context, c client.Client, r record.EventRecorder) error {
+func (u *updater) Apply(ctx context.Context, c client.Client, r record.EventRecorder) error {
+	required := make([]*unstructured.Unstructured, 0, len(u.objs))
+	typed := map[*unstructured.Unstructured]client.Object{}
 	for i := range u.objs {
-		required, err := toUnstructured(u.objs[i])
+		obj, err := toUnstructured(u.objs[i])
 		if err != nil {
 			return err
 		}
-		existing, err := toUnstructured(u.objs[i].DeepCopyObject())
-		if err != nil {
+		required = append(required, obj)
+		typed[obj] = u.objs[i]
+	}
+
+	durations := applyDurations{}
+	defer durations.observe()
+	for _, phase := range applyPhases {
+		objs := phase.objects(required)
+		for _, obj := range objs {
+			start := time.Now()
+			drifted, err := apply(ctx, c, r, obj)
+			durations.add(typed[obj], time.Since(start))
+			if err != nil {
+				return err
+			}
+			if drifted {
+				u.drifted = append(u.drifted, fmt.Sprintf("%s %s", obj.GetKind(), obj.GetName()))
+			}
+		}
+		if err := phase.waitReady(ctx, c, objs, required); err != nil {
 			return err
 		}
+	}
+	return nil
+}
+
+// apply server-side applies the object, forcing the ownership of its fields. The manual
+// edits of the fields set are reverted, the fields set by others are left alone. It returns
+// whether the object applied had drifted: it was modified out-of-band since it was last
+// applied, with the same spec hash.
+func apply(ctx context.Context, c client.Client, r record.EventRecorder, required *unstructured.Unstructured) (bool, error) {
+	obj := applyConfiguration(required)
+	if err := setSpecHash(obj); err != nil {
+		return false, err
+	}
 
-		klog.Infof("createOrUpdating %s %s", existing.GetKind(), existing.GetName())
-		opRes, err := ctrl.CreateOrUpdate(ctx, c, existing, func() error {
-			rv := existing.GetResourceVersion()
-			required.DeepCopyInto(existing)
-			existing.SetResourceVersion(rv)
+	live := &unstructured.Unstructured{}
+	live.SetGroupVersionKind(obj.GroupVersionKind())
+	if err := c.Get(ctx, client.ObjectKeyFromObject(obj), live); err != nil && !apierrors.IsNotFound(err) {
+		return false, err
+	}
 
-			return nil
-		})
+	klog.Infof("applying %s %s", obj.GetKind(), obj.GetName())
+	if err := c.Patch(ctx, obj, client.Apply, client.FieldOwner(fieldManager), client.ForceOwnership); err != nil {
+		r.Eventf(obj, "Warning", "ApplyFailed", "Failed to Apply:%v", err)
+		return false, err
+	}
+
+	drifted := live.GetResourceVersion() != "" && live.GetResourceVersion() != obj.GetResourceVersion() &&
+		live.GetAnnotations()[specHashAnnotation] == obj.GetAnnotations()[specHashAnnotation]
+	if drifted {
+		klog.Warningf("reverted the out-of-band modifications of %s %s", obj.GetKind(), obj.GetName())
+		r.Eventf(obj, "Warning", "DriftCorrected", "reverted out-of-band modifications")
+		return true, nil
+	}
+	r.Eventf(obj, "Normal", "Applied", "success")
+	return false, nil
+}
+
+// setSpecHash sets the hash of the object to apply on it, telling the modifications of the
+// operator from the out-of-band ones.
+func setSpecHash(obj *unstructured.Unstructured) error {
+	annotations := obj.GetAnnotations()
+	delete(annotations, specHashAnnotation)
+	if len(annotations) == 0 {
+		annotations = nil
+	}
+	obj.SetAnnotations(annotations)
+	jsonBytes, err := json.Marshal(obj.Object)
+	if err != nil {
+		return err
+	}
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[specHashAnnotation] = fmt.Sprintf("%x", sha256.Sum256(jsonBytes))
+	obj.SetAnnotations(annotations)
+	return nil
+}
+
+// applyConfiguration returns the object to apply, without the fields the server sets, which
+// would otherwise be owned, e.g. the null creationTimestamp of the typed objects converted.
+func applyConfiguration(required *unstructured.Unstructured) *unstructured.Unstructured {
+	obj := required.DeepCopy()
+	unstructured.RemoveNestedField(obj.Object, "metadata", "creationTimestamp")
+	unstructured.RemoveNestedField(obj.Object, "metadata", "resourceVersion")
+	unstructured.RemoveNestedField(obj.Object, "metadata", "managedFields")
+	unstructured.RemoveNestedField(obj.Object, "status")
+	return obj
+}
+
+func (u *updater) Objects() []client.Object {
+	return u.objs
+}
+
+func (u *updater) Drifted() []string {
+	return u.drifted
+}
+
+func (u *updater) Delete(ctx context.Context, c client.Client, r record.EventRecorder) (int, error) {
+	remaining := 0
+	for i := range u.objs {
+		existing, err := toUnstructured(u.objs[i])
 		if err != nil {
-			return err
+			return 0, err
+		}
+		if err := c.Get(ctx, client.ObjectKeyFromObject(existing), existing); apierrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return 0, err
+		}
+		remaining++
+		if existing.GetDeletionTimestamp() != nil {
+			continue
 		}
 
-		if err == nil {
-			r.Eventf(existing, "Normal", string(opRes), "success")
-		} else {
-			r.Eventf(existing, "Warning", "CreateOrUpdateFailed", "Failed to CreateOrUpdate:%v", err)
+		klog.Infof("deleting %s %s", existing.GetKind(), existing.GetName())
+		if err := c.Delete(ctx, existing); err != nil && !apierrors.IsNotFound(err) {
+			r.Eventf(existing, "Warning", "DeleteFailed", "Failed to Delete:%v", err)
+			return 0, err
 		}
+		r.Eventf(existing, "Normal", "Deleted", "success")
 	}
-
-	return nil
+	return remaining, nil
 }
 
 func toUnstructured(obj runtime.Object) (*unstructured.Unstructured, error) {
@@ -0,0 +1,80 @@
+package controllers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	configv1 "github.com/openshift/api/config/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestApplyConfiguration(t *testing.T) {
+	cm := &corev1.ConfigMap{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+		ObjectMeta: metav1.ObjectMeta{Name: "aws-v0.7.0", Namespace: "openshift-cluster-api", ResourceVersion: "42"},
+		Data:       map[string]string{"components": "---"},
+	}
+	required, err := toUnstructured(cm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata":   map[string]interface{}{"name": "aws-v0.7.0", "namespace": "openshift-cluster-api"},
+		"data":       map[string]interface{}{"components": "---"},
+	}
+	if diff := cmp.Diff(want, applyConfiguration(required).Object); diff != "" {
+		t.Error(diff)
+	}
+	if required.GetResourceVersion() != "42" {
+		t.Error("the required object should be left untouched")
+	}
+}
+
+func TestSetSpecHash(t *testing.T) {
+	obj, err := toUnstructured(&corev1.ConfigMap{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+		ObjectMeta: metav1.ObjectMeta{Name: "aws-v0.7.0"},
+		Data:       map[string]string{"components": "---"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := setSpecHash(obj); err != nil {
+		t.Fatal(err)
+	}
+	hash := obj.GetAnnotations()[specHashAnnotation]
+	if hash == "" {
+		t.Fatal("no spec hash set")
+	}
+	// the hash of an object applied again doesn't change
+	if err := setSpecHash(obj); err != nil {
+		t.Fatal(err)
+	}
+	if obj.GetAnnotations()[specHashAnnotation] != hash {
+		t.Error("the spec hash should not depend on the previous one")
+	}
+	obj.Object["data"] = map[string]interface{}{"components": "--- "}
+	if err := setSpecHash(obj); err != nil {
+		t.Fatal(err)
+	}
+	if obj.GetAnnotations()[specHashAnnotation] == hash {
+		t.Error("the spec hash should change with the object")
+	}
+}
+
+func TestDriftCorrections(t *testing.T) {
+	d := driftCorrections{}
+	if c := d.condition(); c.Status != configv1.ConditionFalse {
+		t.Errorf("unexpected condition %+v", c)
+	}
+	d.record(nil)
+	d.record([]string{"Deployment capi-operator-controller-manager"})
+	d.record([]string{"Service capi-operator-webhook-service", "ConfigMap aws-v0.7.0"})
+	if c := d.condition(); c.Status != configv1.ConditionTrue || !strings.HasPrefix(c.Message, "Reverted 3 out-of-band modifications") {
+		t.Errorf("unexpected condition %+v", c)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	configv1 "github.com/openshift/api/config/v1"
+	capiv1alpha1 "github.com/openshift/cluster-capi-operator/api/v1alpha1"
 )
 
 func toClusterOperator(client.Object) []reconcile.Request {
@@ -57,3 +58,15 @@ func featureGatePredicates() predicate.Funcs {
 		DeleteFunc:  func(e event.DeleteEvent) bool { return isFeatureGateCluster(e.Object) },
 	}
 }
+
+func operatorConfigPredicates() predicate.Funcs {
+	return predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		return obj.GetName() == capiv1alpha1.ClusterAPIConfigurationName
+	})
+}
+
+func managedNamespacePredicates(namespace string) predicate.Funcs {
+	return predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		return obj.GetNamespace() == namespace
+	})
+}
@@ -0,0 +1,301 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"time"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	configv1 "github.com/openshift/api/config/v1"
+)
+
+const (
+	// injectCABundleAnnotation asks the service-ca operator to inject its CA bundle in the
+	// webhooks of the object.
+	injectCABundleAnnotation = "service.beta.openshift.io/inject-cabundle"
+	// servingCertSecretAnnotation is set by the service-ca operator on the serving cert
+	// Secrets it generates.
+	servingCertSecretAnnotation = "service.beta.openshift.io/originating-service-name"
+	// servingCertHashAnnotation records the hash of the serving certs of a provider Deployment
+	// on it, and on its pod template once they were rotated, to roll it out.
+	servingCertHashAnnotation = "capi.openshift.io/serving-cert-hash"
+
+	// the CA bundle the service-ca operator publishes
+	serviceCANamespace     = "openshift-config-managed"
+	serviceCAConfigMapName = "service-ca"
+	serviceCABundleKey     = "ca-bundle.crt"
+
+	// webhookCABundleRequeueAfter is how often the CA bundles are verified, and how long to
+	// wait for the service CA bundle to be published.
+	webhookCABundleRequeueAfter = 10 * time.Minute
+)
+
+// WebhookCABundleReconciler verifies that the CA bundle of the service-ca operator is injected
+// in the conversion webhooks of the cluster api CRDs and in the webhook configurations of the
+// providers, patching the ones left stale by a rotation of the service CA. The provider
+// Deployments are rolled out when their serving cert Secrets are rotated.
+type WebhookCABundleReconciler struct {
+	client.Client
+	// APIReader reads the service CA bundle, out of the namespace cached.
+	APIReader        client.Reader
+	Recorder         record.EventRecorder
+	ManagedNamespace string
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *WebhookCABundleReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("webhook-ca-bundle").
+		For(&configv1.ClusterOperator{}, builder.WithPredicates(clusterOperatorPredicates())).
+		Watches(
+			&source.Kind{Type: &apiextensionsv1.CustomResourceDefinition{}},
+			handler.EnqueueRequestsFromMapFunc(toClusterOperator),
+			builder.WithPredicates(clusterAPICRDPredicates()),
+		).
+		Watches(
+			&source.Kind{Type: &admissionregistrationv1.ValidatingWebhookConfiguration{}},
+			handler.EnqueueRequestsFromMapFunc(toClusterOperator),
+			builder.WithPredicates(clusterAPICRDPredicates()),
+		).
+		Watches(
+			&source.Kind{Type: &admissionregistrationv1.MutatingWebhookConfiguration{}},
+			handler.EnqueueRequestsFromMapFunc(toClusterOperator),
+			builder.WithPredicates(clusterAPICRDPredicates()),
+		).
+		// the serving certs are rotated with the service CA
+		Watches(
+			&source.Kind{Type: &corev1.Secret{}},
+			handler.EnqueueRequestsFromMapFunc(toClusterOperator),
+			builder.WithPredicates(managedNamespacePredicates(r.ManagedNamespace), servingCertSecretPredicates()),
+		).
+		Complete(r)
+}
+
+func servingCertSecretPredicates() predicate.Funcs {
+	return predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		return obj.GetAnnotations()[servingCertSecretAnnotation] != ""
+	})
+}
+
+// Reconcile patches the CA bundles that don't match the service CA bundle, and rolls out the
+// provider Deployments whose serving certs were rotated.
+func (r *WebhookCABundleReconciler) Reconcile(ctx context.Context, _ ctrl.Request) (ctrl.Result, error) {
+	cm := &corev1.ConfigMap{}
+	err := r.APIReader.Get(ctx, client.ObjectKey{Namespace: serviceCANamespace, Name: serviceCAConfigMapName}, cm)
+	if apierrors.IsNotFound(err) || (err == nil && cm.Data[serviceCABundleKey] == "") {
+		klog.Infof("waiting for the service CA bundle to be published")
+		return ctrl.Result{RequeueAfter: webhookCABundleRequeueAfter}, nil
+	} else if err != nil {
+		return ctrl.Result{}, err
+	}
+	caBundle := []byte(cm.Data[serviceCABundleKey])
+
+	if err := r.reconcileCRDs(ctx, caBundle); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.reconcileWebhookConfigurations(ctx, caBundle); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.rolloutRotatedServingCerts(ctx); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: webhookCABundleRequeueAfter}, nil
+}
+
+func (r *WebhookCABundleReconciler) reconcileCRDs(ctx context.Context, caBundle []byte) error {
+	crds := &apiextensionsv1.CustomResourceDefinitionList{}
+	if err := r.List(ctx, crds); err != nil {
+		return err
+	}
+	for i := range crds.Items {
+		crd := &crds.Items[i]
+		if !isClusterAPIObject(crd) || crd.Annotations[injectCABundleAnnotation] != "true" {
+			continue
+		}
+		conversion := crd.Spec.Conversion
+		if conversion == nil || conversion.Webhook == nil || conversion.Webhook.ClientConfig == nil ||
+			bytes.Equal(conversion.Webhook.ClientConfig.CABundle, caBundle) {
+			continue
+		}
+		patch := client.MergeFrom(crd.DeepCopy())
+		conversion.Webhook.ClientConfig.CABundle = caBundle
+		if err := r.patchCABundle(ctx, crd, patch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *WebhookCABundleReconciler) reconcileWebhookConfigurations(ctx context.Context, caBundle []byte) error {
+	validating := &admissionregistrationv1.ValidatingWebhookConfigurationList{}
+	if err := r.List(ctx, validating); err != nil {
+		return err
+	}
+	for i := range validating.Items {
+		config := &validating.Items[i]
+		if !needsCABundle(config, caBundle, validatingClientConfigs(config)) {
+			continue
+		}
+		patch := client.MergeFrom(config.DeepCopy())
+		for _, clientConfig := range validatingClientConfigs(config) {
+			clientConfig.CABundle = caBundle
+		}
+		if err := r.patchCABundle(ctx, config, patch); err != nil {
+			return err
+		}
+	}
+
+	mutating := &admissionregistrationv1.MutatingWebhookConfigurationList{}
+	if err := r.List(ctx, mutating); err != nil {
+		return err
+	}
+	for i := range mutating.Items {
+		config := &mutating.Items[i]
+		if !needsCABundle(config, caBundle, mutatingClientConfigs(config)) {
+			continue
+		}
+		patch := client.MergeFrom(config.DeepCopy())
+		for _, clientConfig := range mutatingClientConfigs(config) {
+			clientConfig.CABundle = caBundle
+		}
+		if err := r.patchCABundle(ctx, config, patch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validatingClientConfigs(config *admissionregistrationv1.ValidatingWebhookConfiguration) []*admissionregistrationv1.WebhookClientConfig {
+	configs := []*admissionregistrationv1.WebhookClientConfig{}
+	for i := range config.Webhooks {
+		configs = append(configs, &config.Webhooks[i].ClientConfig)
+	}
+	return configs
+}
+
+func mutatingClientConfigs(config *admissionregistrationv1.MutatingWebhookConfiguration) []*admissionregistrationv1.WebhookClientConfig {
+	configs := []*admissionregistrationv1.WebhookClientConfig{}
+	for i := range config.Webhooks {
+		configs = append(configs, &config.Webhooks[i].ClientConfig)
+	}
+	return configs
+}
+
+// needsCABundle returns whether the webhook configuration is one of cluster api the service CA
+// bundle is injected in, and a webhook has another bundle.
+func needsCABundle(obj client.Object, caBundle []byte, clientConfigs []*admissionregistrationv1.WebhookClientConfig) bool {
+	if !isClusterAPIObject(obj) || obj.GetAnnotations()[injectCABundleAnnotation] != "true" {
+		return false
+	}
+	for _, clientConfig := range clientConfigs {
+		if !bytes.Equal(clientConfig.CABundle, caBundle) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *WebhookCABundleReconciler) patchCABundle(ctx context.Context, obj client.Object, patch client.Patch) error {
+	kind := fmt.Sprintf("%T", obj)
+	klog.Warningf("the service CA bundle is stale in %s %s, patching it", kind, obj.GetName())
+	if err := r.Patch(ctx, obj, patch); err != nil && !apierrors.IsNotFound(err) {
+		r.Recorder.Eventf(obj, corev1.EventTypeWarning, "CABundlePatchFailed", "Failed to patch the CA bundle:%v", err)
+		return err
+	}
+	r.Recorder.Eventf(obj, corev1.EventTypeNormal, "CABundlePatched", "the service CA bundle was stale")
+	return nil
+}
+
+// rolloutRotatedServingCerts rolls out the provider Deployments mounting serving cert Secrets
+// rotated since they were last seen. The hash of the certs is first recorded on a Deployment
+// without rolling it out.
+func (r *WebhookCABundleReconciler) rolloutRotatedServingCerts(ctx context.Context) error {
+	secrets := &corev1.SecretList{}
+	if err := r.List(ctx, secrets, client.InNamespace(r.ManagedNamespace)); err != nil {
+		return err
+	}
+	servingCerts := map[string]*corev1.Secret{}
+	for i := range secrets.Items {
+		if secrets.Items[i].Annotations[servingCertSecretAnnotation] != "" {
+			servingCerts[secrets.Items[i].Name] = &secrets.Items[i]
+		}
+	}
+
+	deps := &appsv1.DeploymentList{}
+	if err := r.List(ctx, deps, client.InNamespace(r.ManagedNamespace), client.HasLabels{providerLabel}); err != nil {
+		return err
+	}
+	for i := range deps.Items {
+		dep := &deps.Items[i]
+		hash := servingCertsHash(dep, servingCerts)
+		recorded := dep.Annotations[servingCertHashAnnotation]
+		if hash == "" || hash == recorded {
+			continue
+		}
+		patch := client.MergeFrom(dep.DeepCopy())
+		if dep.Annotations == nil {
+			dep.Annotations = map[string]string{}
+		}
+		dep.Annotations[servingCertHashAnnotation] = hash
+		if recorded != "" {
+			klog.Infof("the serving certs of Deployment %s were rotated, rolling it out", dep.Name)
+			if dep.Spec.Template.Annotations == nil {
+				dep.Spec.Template.Annotations = map[string]string{}
+			}
+			dep.Spec.Template.Annotations[servingCertHashAnnotation] = hash
+		}
+		if err := r.Patch(ctx, dep, patch); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		if recorded != "" {
+			r.Recorder.Eventf(dep, corev1.EventTypeNormal, "ServingCertRotated", "rolled out with the rotated serving certs")
+		}
+	}
+	return nil
+}
+
+// servingCertsHash returns the hash of the serving cert Secrets mounted by the Deployment,
+// empty when it mounts none.
+func servingCertsHash(dep *appsv1.Deployment, servingCerts map[string]*corev1.Secret) string {
+	names := []string{}
+	for _, volume := range dep.Spec.Template.Spec.Volumes {
+		if volume.Secret != nil && servingCerts[volume.Secret.SecretName] != nil {
+			names = append(names, volume.Secret.SecretName)
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	for _, name := range names {
+		secret := servingCerts[name]
+		keys := []string{}
+		for key := range secret.Data {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		fmt.Fprintf(h, "%s\n", name)
+		for _, key := range keys {
+			fmt.Fprintf(h, "%s\n", key)
+			h.Write(secret.Data[key])
+		}
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
@@ -0,0 +1,108 @@
+package controllers
+
+import (
+	"testing"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNeedsCABundle(t *testing.T) {
+	caBundle := []byte("service-ca")
+	tests := []struct {
+		name        string
+		labels      map[string]string
+		annotations map[string]string
+		bundles     [][]byte
+		want        bool
+	}{
+		{
+			name:        "stale bundle",
+			labels:      map[string]string{providerLabel: "infrastructure-aws"},
+			annotations: map[string]string{injectCABundleAnnotation: "true"},
+			bundles:     [][]byte{caBundle, []byte("rotated")},
+			want:        true,
+		},
+		{
+			name:        "missing bundle",
+			labels:      map[string]string{capiOperatorLabel: "cluster-api"},
+			annotations: map[string]string{injectCABundleAnnotation: "true"},
+			bundles:     [][]byte{nil},
+			want:        true,
+		},
+		{
+			name:        "up to date",
+			labels:      map[string]string{providerLabel: "infrastructure-aws"},
+			annotations: map[string]string{injectCABundleAnnotation: "true"},
+			bundles:     [][]byte{caBundle},
+		},
+		{
+			name:    "not injected",
+			labels:  map[string]string{providerLabel: "infrastructure-aws"},
+			bundles: [][]byte{[]byte("rotated")},
+		},
+		{
+			name:        "not cluster api",
+			annotations: map[string]string{injectCABundleAnnotation: "true"},
+			bundles:     [][]byte{[]byte("rotated")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &admissionregistrationv1.ValidatingWebhookConfiguration{
+				ObjectMeta: metav1.ObjectMeta{Labels: tt.labels, Annotations: tt.annotations},
+			}
+			for _, bundle := range tt.bundles {
+				config.Webhooks = append(config.Webhooks, admissionregistrationv1.ValidatingWebhook{
+					ClientConfig: admissionregistrationv1.WebhookClientConfig{CABundle: bundle},
+				})
+			}
+			if got := needsCABundle(config, caBundle, validatingClientConfigs(config)); got != tt.want {
+				t.Errorf("needsCABundle() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServingCertsHash(t *testing.T) {
+	secret := func(name, cert string) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Data:       map[string][]byte{"tls.crt": []byte(cert), "tls.key": []byte("key")},
+		}
+	}
+	deployment := func(secrets ...string) *appsv1.Deployment {
+		dep := &appsv1.Deployment{}
+		for _, name := range secrets {
+			dep.Spec.Template.Spec.Volumes = append(dep.Spec.Template.Spec.Volumes, corev1.Volume{
+				Name:         name,
+				VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: name}},
+			})
+		}
+		return dep
+	}
+	servingCerts := map[string]*corev1.Secret{
+		"capa-webhook-service-cert": secret("capa-webhook-service-cert", "cert"),
+		"capa-metrics-cert":         secret("capa-metrics-cert", "cert"),
+	}
+	rotated := map[string]*corev1.Secret{
+		"capa-webhook-service-cert": secret("capa-webhook-service-cert", "rotated"),
+		"capa-metrics-cert":         secret("capa-metrics-cert", "cert"),
+	}
+
+	if hash := servingCertsHash(deployment("capa-credentials"), servingCerts); hash != "" {
+		t.Errorf("expected no hash without serving certs, got %q", hash)
+	}
+	hash := servingCertsHash(deployment("capa-webhook-service-cert", "capa-metrics-cert"), servingCerts)
+	if hash == "" {
+		t.Fatal("expected a hash of the serving certs")
+	}
+	if reordered := servingCertsHash(deployment("capa-metrics-cert", "capa-webhook-service-cert"), servingCerts); reordered != hash {
+		t.Errorf("expected the hash not to depend on the order of the volumes")
+	}
+	if rotatedHash := servingCertsHash(deployment("capa-webhook-service-cert", "capa-metrics-cert"), rotated); rotatedHash == hash {
+		t.Errorf("expected the hash to change with the rotated certs")
+	}
+}
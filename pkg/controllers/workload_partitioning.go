@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/serializer/json"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+)
+
+const (
+	// managementWorkloadAnnotation pins the pods to the management CPUs of the nodes when
+	// workload partitioning is enabled, the managed namespace allows it.
+	managementWorkloadAnnotation      = "target.workload.openshift.io/management"
+	managementWorkloadAnnotationValue = `{"effect": "PreferredDuringScheduling"}`
+	// managementCoresResource is advertised by the nodes of the clusters installed with
+	// workload partitioning, e.g. the single node telco profiles.
+	managementCoresResource = corev1.ResourceName("management.workload.openshift.io/cores")
+)
+
+// yamlDocumentSeparator splits the components in their yaml documents.
+var yamlDocumentSeparator = regexp.MustCompile(`(?m)^---[ \t]*$`)
+
+// workloadPartitioningEnabled returns whether the cluster was installed with workload
+// partitioning, from the management cores the nodes advertise.
+func (r *ClusterOperatorReconciler) workloadPartitioningEnabled(ctx context.Context) (bool, error) {
+	nodes := &corev1.NodeList{}
+	if err := r.APIReader.List(ctx, nodes); err != nil {
+		return false, err
+	}
+	for _, node := range nodes.Items {
+		if _, ok := node.Status.Capacity[managementCoresResource]; ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// setManagementWorkload annotates the pod template of the Deployment for workload
+// partitioning.
+func setManagementWorkload(dep *appsv1.Deployment) {
+	if dep.Spec.Template.Annotations == nil {
+		dep.Spec.Template.Annotations = map[string]string{}
+	}
+	dep.Spec.Template.Annotations[managementWorkloadAnnotation] = managementWorkloadAnnotationValue
+}
+
+// setComponentsManagementWorkload annotates the pod templates of the Deployments of the
+// components for workload partitioning. The components extracted from the payload images
+// or rendered upstream may lack the annotation the asset import sets, the documents that
+// don't are left as they are.
+func setComponentsManagementWorkload(components string) (string, error) {
+	docs := yamlDocumentSeparator.Split(components, -1)
+	for i, doc := range docs {
+		if strings.TrimSpace(doc) == "" {
+			continue
+		}
+		obj := &unstructured.Unstructured{}
+		if err := utilyaml.NewYAMLOrJSONDecoder(strings.NewReader(doc), 4096).Decode(&obj.Object); err != nil {
+			return "", err
+		}
+		if obj.GetKind() != "Deployment" {
+			continue
+		}
+		annotations, _, err := unstructured.NestedStringMap(obj.Object, "spec", "template", "metadata", "annotations")
+		if err != nil {
+			return "", err
+		}
+		if annotations[managementWorkloadAnnotation] == managementWorkloadAnnotationValue {
+			continue
+		}
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[managementWorkloadAnnotation] = managementWorkloadAnnotationValue
+		if err := unstructured.SetNestedStringMap(obj.Object, annotations, "spec", "template", "metadata", "annotations"); err != nil {
+			return "", err
+		}
+		var buf bytes.Buffer
+		serializer := json.NewSerializerWithOptions(json.DefaultMetaFactory, nil, nil, json.SerializerOptions{Yaml: true})
+		if err := serializer.Encode(obj, &buf); err != nil {
+			return "", err
+		}
+		docs[i] = "\n" + buf.String()
+	}
+	return strings.Join(docs, "---"), nil
+}
@@ -0,0 +1,63 @@
+package controllers
+
+import "testing"
+
+func TestSetComponentsManagementWorkload(t *testing.T) {
+	components := `apiVersion: v1
+kind: Namespace
+metadata:
+  name: capa-system
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: capa-controller-manager
+  namespace: capa-system
+spec:
+  template:
+    metadata:
+      annotations:
+        kubectl.kubernetes.io/default-container: manager
+    spec:
+      containers:
+      - name: manager
+        image: registry.ci.openshift.org/openshift:aws-cluster-api-controllers
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: capa-annotated
+spec:
+  template:
+    metadata:
+      annotations:
+        target.workload.openshift.io/management: '{"effect": "PreferredDuringScheduling"}'
+`
+	got, err := setComponentsManagementWorkload(components)
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs, want := yamlDocumentSeparator.Split(got, -1), yamlDocumentSeparator.Split(components, -1)
+	if len(docs) != len(want) {
+		t.Fatalf("expected %d documents, got %d:\n%s", len(want), len(docs), got)
+	}
+	// only the Deployment lacking the annotation is serialized again
+	if docs[0] != want[0] || docs[2] != want[2] {
+		t.Errorf("the other documents should be left as is, got:\n%s", got)
+	}
+	deps, err := componentDeployments(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deps) != 2 {
+		t.Fatalf("expected 2 Deployments, got %d", len(deps))
+	}
+	for _, dep := range deps {
+		if dep.Spec.Template.Annotations[managementWorkloadAnnotation] != managementWorkloadAnnotationValue {
+			t.Errorf("Deployment %s is not annotated for workload partitioning", dep.Name)
+		}
+	}
+	if deps[0].Spec.Template.Annotations["kubectl.kubernetes.io/default-container"] != "manager" {
+		t.Errorf("the annotations of Deployment %s should be kept", deps[0].Name)
+	}
+}
@@ -0,0 +1,145 @@
+// Package metrics serves the metrics of the operator over TLS.
+package metrics
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog"
+	"sigs.k8s.io/controller-runtime/pkg/certwatcher"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// Path is where the metrics are served.
+const Path = "/metrics"
+
+// Server serves the metrics of the controller-runtime registry, the ones of the operator
+// included, over TLS with the certificate the service CA issues for the metrics Service. Like
+// kube-rbac-proxy, it only serves the clients whose token is allowed to get the /metrics
+// non-resource URL, e.g. the prometheus of cluster monitoring.
+type Server struct {
+	BindAddress string
+	CertFile    string
+	KeyFile     string
+	// KubeClient reviews the tokens and the access of the clients.
+	KubeClient kubernetes.Interface
+}
+
+// NeedLeaderElection lets the metrics be served by the replicas not leading too.
+func (s *Server) NeedLeaderElection() bool {
+	return false
+}
+
+// Start serves the metrics until the context is done, reloading the certificate when the
+// service CA rotates it.
+func (s *Server) Start(ctx context.Context) error {
+	watcher, err := certwatcher.New(s.CertFile, s.KeyFile)
+	if err != nil {
+		return err
+	}
+	go func() {
+		if err := watcher.Start(ctx); err != nil {
+			klog.Errorf("failed to watch the metrics certificate: %v", err)
+		}
+	}()
+
+	listener, err := tls.Listen("tcp", s.BindAddress, &tls.Config{
+		GetCertificate: watcher.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+	})
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle(Path, s.authorized(promhttp.HandlerFor(ctrlmetrics.Registry, promhttp.HandlerOpts{})))
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		if err := server.Shutdown(context.Background()); err != nil {
+			klog.Errorf("failed to stop the metrics server: %v", err)
+		}
+	}()
+	klog.Infof("serving metrics on https://%s%s", s.BindAddress, Path)
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) && !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+	return nil
+}
+
+// authorized only passes the requests of the clients allowed to get the metrics to next.
+func (s *Server) authorized(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if err := s.authorize(req.Context(), bearerToken(req)); err != nil {
+			klog.V(2).Infof("metrics request of %s denied: %v", req.RemoteAddr, err)
+			status := http.StatusForbidden
+			if errors.Is(err, errUnauthenticated) {
+				status = http.StatusUnauthorized
+			}
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+var errUnauthenticated = errors.New("unauthenticated")
+
+// authorize reviews the token, and whether its user may get the metrics.
+func (s *Server) authorize(ctx context.Context, token string) error {
+	if token == "" {
+		return errUnauthenticated
+	}
+	review, err := s.KubeClient.AuthenticationV1().TokenReviews().Create(ctx, &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return err
+	}
+	if !review.Status.Authenticated {
+		return errUnauthenticated
+	}
+
+	user := review.Status.User
+	extra := map[string]authorizationv1.ExtraValue{}
+	for k, v := range user.Extra {
+		extra[k] = authorizationv1.ExtraValue(v)
+	}
+	access, err := s.KubeClient.AuthorizationV1().SubjectAccessReviews().Create(ctx, &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:                  user.Username,
+			UID:                   user.UID,
+			Groups:                user.Groups,
+			Extra:                 extra,
+			NonResourceAttributes: &authorizationv1.NonResourceAttributes{Path: Path, Verb: "get"},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return err
+	}
+	if !access.Status.Allowed {
+		return fmt.Errorf("%s may not get %s", user.Username, Path)
+	}
+	return nil
+}
+
+// bearerToken returns the token of the Authorization header of the request, empty without.
+func bearerToken(req *http.Request) string {
+	auth := strings.TrimSpace(req.Header.Get("Authorization"))
+	parts := strings.SplitN(auth, " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "bearer") {
+		return ""
+	}
+	return strings.TrimSpace(parts[1])
+}
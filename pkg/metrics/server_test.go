@@ -0,0 +1,35 @@
+package metrics
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestBearerToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{name: "bearer", header: "Bearer abc.def", want: "abc.def"},
+		{name: "lower case", header: "bearer abc.def", want: "abc.def"},
+		{name: "padded", header: "  Bearer   abc.def ", want: "abc.def"},
+		{name: "basic", header: "Basic dXNlcjpwYXNz"},
+		{name: "no token", header: "Bearer"},
+		{name: "no header"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, Path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			if got := bearerToken(req); got != tt.want {
+				t.Errorf("bearerToken() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}